@@ -21,24 +21,67 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"runtime"
 	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/ssmall/nocco-video-extractor/pkg/drive"
+	"github.com/ssmall/nocco-video-extractor/pkg/health"
 	noccohttp "github.com/ssmall/nocco-video-extractor/pkg/http"
+	"github.com/ssmall/nocco-video-extractor/pkg/logging"
+	"github.com/ssmall/nocco-video-extractor/pkg/marker"
+	"github.com/ssmall/nocco-video-extractor/pkg/tracing"
 	"github.com/ssmall/nocco-video-extractor/pkg/video"
+	"github.com/ssmall/nocco-video-extractor/pkg/webhook"
+	"github.com/ssmall/nocco-video-extractor/pkg/workspace"
 )
 
 const defaultPort = 8080
 const terminationWait = 10 * time.Second
+const readinessTimeout = 5 * time.Second
 
 var readTimeout = flag.Duration("readtimeout", 15*time.Second, "Sets the read timeout for incoming HTTP requests")
 var writeTimeout = flag.Duration("writetimeout", 15*time.Second, "Sets the write timeout for HTTP responses")
 var idleTimeout = flag.Duration("idletimeout", 60*time.Second, "Sets the idle timeout for HTTP keepalive")
+var workDir = flag.String("workdir", filepath.Join(os.TempDir(), "nocco-video-extractor"), "Sets the directory in which temporary files are created while processing jobs")
+var diskBudget = flag.Int64("diskbudget", 0, "Sets the maximum disk space in bytes that concurrent jobs may reserve in the work directory, or 0 for no limit")
+var sweepInterval = flag.Duration("sweepinterval", 10*time.Minute, "Sets how often stale files are removed from the work directory")
+var sweepMaxAge = flag.Duration("sweepmaxage", 3*time.Hour, "Sets how long a file not belonging to an active job may remain in the work directory")
+var minTempFree = flag.Uint64("mintempfree", 1<<30, "Sets the minimum free space in bytes in the work directory for the service to be ready")
+var maxJobs = flag.Int("maxjobs", runtime.NumCPU(), "Sets the maximum number of clips extracted concurrently, or 0 for no limit")
+var maxQueue = flag.Int("maxqueue", 10, "Sets the maximum number of jobs waiting for a free worker before requests are rejected")
+var maxDeliveries = flag.Int("deliveries", 1000, "Sets how many of the most recent callback deliveries are kept for the delivery endpoint")
+var cacheDir = flag.String("cachedir", filepath.Join(os.TempDir(), "nocco-video-extractor-cache"), "Sets the directory in which files downloaded from Drive for reuse, such as logos, are kept")
+var cacheSize = flag.Int64("cachesize", 1<<30, "Sets the maximum size in bytes of the files kept in the cache directory, or 0 for no limit")
+var logoPath = flag.String("logo", "", "Sets the local path of the default logo drawn over watermarked clips")
+var logoFileID = flag.String("logofileid", "", "Sets the Drive file ID of the default logo drawn over watermarked clips, if -logo is not set")
+var introPath = flag.String("intro", "", "Sets the local path of the intro bumper that requests may prepend to clips")
+var introFileID = flag.String("introfileid", "", "Sets the Drive file ID of the intro bumper, if -intro is not set")
+var outroPath = flag.String("outro", "", "Sets the local path of the outro bumper that requests may append to clips")
+var outroFileID = flag.String("outrofileid", "", "Sets the Drive file ID of the outro bumper, if -outro is not set")
+var fontDir = flag.String("fontdir", video.DefaultFontDir, "Sets the directory containing the bundled fonts used to draw text")
+var presetsFile = flag.String("presets", "", "Sets the path of a JSON file defining output presets in addition to the builtin presets")
+var pipelinesFile = flag.String("pipelines", "", "Sets the path of a JSON file defining the audition pipelines of the current audition cycles")
+var markersFile = flag.String("markers", "", "Sets the path of the local database in which the markers of source files are kept, enabling the marker endpoints")
+var logLevel = flag.String("loglevel", "info", "Sets the minimum level of log entries: debug, info, warning or error")
+var traceExporter = flag.String("traceexporter", tracing.ExporterNone, "Sets where traces are exported to: none, stdout or otlp")
+var otlpEndpoint = flag.String("otlpendpoint", "localhost:55680", "Sets the address of the OpenTelemetry collector used by the otlp trace exporter")
 
 func main() {
 	flag.Parse()
+
+	level, err := logging.ParseLevel(*logLevel)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	logger := logging.New(os.Stderr, level)
+	logging.SetDefault(logger)
+	log.SetFlags(0)
+	log.SetOutput(logger.Writer(logging.Info))
+
 	log.Println("Read timeout is:", readTimeout)
 	log.Println("Write timeout is:", writeTimeout)
 	log.Println("Idle timeout is:", idleTimeout)
@@ -56,14 +99,129 @@ func main() {
 
 	ctx := context.Background()
 
+	shutdownTracing, err := tracing.Setup(*traceExporter, *otlpEndpoint)
+	if err != nil {
+		log.Fatalln("Error initializing tracing:", err)
+	}
+
 	d, err := drive.NewClient(ctx)
 
 	if err != nil {
 		log.Fatalln("Error initializing Google Drive client:", err)
 	}
 
+	credentialsCheck, err := drive.CredentialsCheck(ctx)
+	if err != nil {
+		log.Fatalln("Error initializing Google Drive credentials check:", err)
+	}
+
+	ws, err := workspace.New(*workDir, *diskBudget)
+	if err != nil {
+		log.Fatalln("Error initializing work directory:", err)
+	}
+	if n, err := ws.Sweep(ctx, 0); err != nil {
+		log.Println("Error removing files left by previous runs:", err)
+	} else {
+		log.Printf("Removed %d files left by previous runs from %s", n, ws.Root())
+	}
+	ws.StartSweeper(ctx, *sweepInterval, *sweepMaxAge)
+
+	opts := []noccohttp.HandlerOption{noccohttp.WithWorkspace(ws)}
+	var deliveries *webhook.Store
+	if secret, ok := os.LookupEnv("WEBHOOK_SECRET"); ok && secret != "" {
+		log.Println("Completion callbacks are enabled")
+		deliveries = webhook.NewStore(*maxDeliveries)
+		opts = append(opts, noccohttp.WithCallbacks(webhook.NewSender([]byte(secret))), noccohttp.WithDeliveries(deliveries))
+	}
+
+	assets, err := drive.NewFileCache(d, *cacheDir, *cacheSize)
+	if err != nil {
+		log.Fatalln("Error initializing cache directory:", err)
+	}
+	if *logoPath != "" {
+		if _, err := os.Stat(*logoPath); err != nil {
+			log.Fatalln("Error reading logo:", err)
+		}
+	}
+	for _, p := range []string{*introPath, *outroPath} {
+		if p == "" {
+			continue
+		}
+		if _, err := os.Stat(p); err != nil {
+			log.Fatalln("Error reading bumper:", err)
+		}
+	}
+	presets := video.BuiltinPresets()
+	if *presetsFile != "" {
+		f, err := os.Open(*presetsFile)
+		if err != nil {
+			log.Fatalln("Error opening presets file:", err)
+		}
+		presets, err = video.LoadPresets(f)
+		f.Close()
+		if err != nil {
+			log.Fatalln("Error loading presets:", err)
+		}
+		log.Printf("Loaded %d presets from %s", len(presets), *presetsFile)
+		opts = append(opts, noccohttp.WithPresets(presets))
+	}
+	if *pipelinesFile != "" {
+		f, err := os.Open(*pipelinesFile)
+		if err != nil {
+			log.Fatalln("Error opening pipelines file:", err)
+		}
+		pipelines, err := noccohttp.LoadPipelines(f, presets)
+		f.Close()
+		if err != nil {
+			log.Fatalln("Error loading pipelines:", err)
+		}
+		log.Printf("Loaded %d audition pipelines from %s", len(pipelines), *pipelinesFile)
+		opts = append(opts, noccohttp.WithPipelines(pipelines))
+	}
+	var markers *marker.Store
+	if *markersFile != "" {
+		if markers, err = marker.Open(*markersFile); err != nil {
+			log.Fatalln("Error opening markers database:", err)
+		}
+		log.Println("Markers are kept in", *markersFile)
+		opts = append(opts, noccohttp.WithMarkers(markers))
+	}
+	opts = append(opts, noccohttp.WithWatermarks(*logoPath, *logoFileID, assets))
+	opts = append(opts, noccohttp.WithBumpers(*introPath, *introFileID, *outroPath, *outroFileID, assets))
+
+	extractor := video.NewExtractor(video.WithFontDir(*fontDir))
+	if *maxJobs > 0 {
+		log.Printf("Extracting at most %d clips concurrently with up to %d queued", *maxJobs, *maxQueue)
+		extractor = video.NewLimitedExtractor(extractor, *maxJobs, *maxQueue)
+	}
+
 	r := mux.NewRouter()
-	r.Handle("/extract", noccohttp.ClipExtractionHandler(d, video.NewExtractor()))
+	r.Handle("/extract", noccohttp.Instrument("extract", noccohttp.Trace("extract", noccohttp.Log("extract", noccohttp.ClipExtractionHandler(d, extractor, opts...)))))
+	r.Handle("/compile", noccohttp.Instrument("compile", noccohttp.Trace("compile", noccohttp.Log("compile", noccohttp.CompilationHandler(d, extractor, opts...)))))
+	r.Handle("/compose", noccohttp.Instrument("compose", noccohttp.Trace("compose", noccohttp.Log("compose", noccohttp.CompositionHandler(d, extractor, opts...)))))
+	r.Handle("/mixdown", noccohttp.Instrument("mixdown", noccohttp.Trace("mixdown", noccohttp.Log("mixdown", noccohttp.MixdownHandler(d, extractor, opts...)))))
+	r.Handle("/split", noccohttp.Instrument("split", noccohttp.Trace("split", noccohttp.Log("split", noccohttp.SplitHandler(d, extractor, opts...)))))
+	r.Handle("/classify", noccohttp.Instrument("classify", noccohttp.Trace("classify", noccohttp.Log("classify", noccohttp.ClassificationHandler(d, extractor, opts...)))))
+	r.Handle("/scenes", noccohttp.Instrument("scenes", noccohttp.Trace("scenes", noccohttp.Log("scenes", noccohttp.SceneHandler(d, extractor, opts...)))))
+	r.Handle("/audition", noccohttp.Instrument("audition", noccohttp.Trace("audition", noccohttp.Log("audition", noccohttp.AuditionHandler(d, extractor, opts...)))))
+	if markers != nil {
+		markerHandler := noccohttp.Instrument("markers", noccohttp.Trace("markers", noccohttp.Log("markers", noccohttp.MarkerHandler(markers))))
+		r.Handle("/markers/{sourceFileId}", markerHandler).Methods(http.MethodGet, http.MethodPost)
+		r.Handle("/markers/{sourceFileId}/{name}", markerHandler).Methods(http.MethodGet, http.MethodPut, http.MethodDelete)
+	}
+	if deliveries != nil {
+		r.Handle("/jobs/{jobId}/delivery", noccohttp.Instrument("delivery", noccohttp.Trace("delivery", noccohttp.Log("delivery", noccohttp.DeliveryHandler(deliveries))))).Methods(http.MethodGet)
+	}
+	r.Handle("/align", noccohttp.Instrument("align", noccohttp.Trace("align", noccohttp.Log("align", noccohttp.AlignmentHandler(d, extractor, opts...)))))
+	r.Handle("/metrics", promhttp.Handler())
+	r.Handle("/healthz", noccohttp.LivenessHandler())
+	r.Handle("/readyz", noccohttp.ReadinessHandler(readinessTimeout,
+		health.Check{Name: "ffmpeg", Func: func(ctx context.Context) error {
+			return video.CheckFFmpeg(ctx, video.DefaultRequirements)
+		}},
+		health.DirCheck("workdir", ws.Root(), *minTempFree),
+		health.Check{Name: "drive-credentials", Func: credentialsCheck},
+	))
 
 	log.Println("Starting server on port", port)
 	srv := &http.Server{
@@ -88,6 +246,7 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), terminationWait)
 	defer cancel()
 	srv.Shutdown(ctx)
+	shutdownTracing(ctx)
 	log.Println("Shutting down")
 	os.Exit(0)
 }
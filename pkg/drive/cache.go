@@ -0,0 +1,216 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package drive
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ssmall/nocco-video-extractor/pkg/logging"
+	"github.com/ssmall/nocco-video-extractor/pkg/tracing"
+)
+
+// revalidateInterval is how long a cached file is used before its version is compared with Drive's again
+const revalidateInterval = time.Minute
+
+// downloadTimeout bounds a download into the cache, which is not bounded by the contexts of the requests for the file
+const downloadTimeout = 10 * time.Minute
+
+// FileCache downloads Drive files to a local directory and reuses the local copies on later requests.
+// It is intended for small files that rarely change, such as logos. A file is downloaded again when its
+// version in Drive changes, which is checked at most once every revalidateInterval.
+type FileCache struct {
+	c        Client
+	dir      string
+	maxBytes int64
+
+	mu        sync.Mutex
+	files     map[string]*cachedFile
+	size      int64
+	downloads map[string]*download
+}
+
+// cachedFile is the local copy of a Drive file
+type cachedFile struct {
+	path    string
+	size    int64
+	version int64
+	// checked is when version was last compared with the version in Drive
+	checked time.Time
+	// used is when the file was last requested, for evicting the least recently used files
+	used time.Time
+}
+
+// download is a download of a Drive file into the cache, whose outcome is shared by all requests for the file
+// made while it is in progress
+type download struct {
+	done chan struct{}
+	path string
+	err  error
+}
+
+// NewFileCache creates a FileCache that downloads files with c into dir, creating dir if necessary.
+// Once the cached files take up more than maxBytes, the least recently used are deleted; 0 means no limit.
+// Deleted files may still be in use by jobs that requested them earlier, so maxBytes should leave room
+// for every file that running jobs may use.
+func NewFileCache(c Client, dir string, maxBytes int64) (*FileCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileCache{c: c, dir: dir, maxBytes: maxBytes, files: make(map[string]*cachedFile), downloads: make(map[string]*download)}, nil
+}
+
+// Path returns the local path of the Drive file with the given id, downloading it if it is not cached
+// or has changed. Concurrent requests for a file wait for a single check and download, which continues
+// if the request that started it is cancelled; requests for other files are not held up by it.
+func (fc *FileCache) Path(ctx context.Context, id string) (string, error) {
+	fc.mu.Lock()
+	if f, ok := fc.files[id]; ok && time.Since(f.checked) < revalidateInterval && exists(f.path) {
+		f.used = time.Now()
+		fc.mu.Unlock()
+		return f.path, nil
+	}
+	dl, inProgress := fc.downloads[id]
+	if !inProgress {
+		dl = &download{done: make(chan struct{})}
+		fc.downloads[id] = dl
+		go fc.refresh(logging.NewContext(tracing.Detach(ctx), logging.FromContext(ctx)), id, dl)
+	}
+	fc.mu.Unlock()
+
+	select {
+	case <-dl.done:
+		return dl.path, dl.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Stat gets the metadata of the Drive file with the given id, as the cache's Client does
+func (fc *FileCache) Stat(ctx context.Context, id string) (*FileInfo, error) {
+	return fc.c.Stat(ctx, id)
+}
+
+// refresh completes dl by checking the version of the Drive file with the given id and downloading it
+// if the cached copy is missing or out of date
+func (fc *FileCache) refresh(ctx context.Context, id string, dl *download) {
+	ctx, cancel := context.WithTimeout(ctx, downloadTimeout)
+	defer cancel()
+
+	dl.path, dl.err = fc.update(ctx, id)
+	fc.mu.Lock()
+	delete(fc.downloads, id)
+	fc.mu.Unlock()
+	close(dl.done)
+}
+
+// update returns the path of an up to date local copy of the Drive file with the given id
+func (fc *FileCache) update(ctx context.Context, id string) (string, error) {
+	info, err := fc.c.Stat(ctx, id)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	fc.mu.Lock()
+	if f, ok := fc.files[id]; ok && f.version == info.Version && exists(f.path) {
+		f.checked, f.used = now, now
+		fc.mu.Unlock()
+		return f.path, nil
+	}
+	fc.mu.Unlock()
+
+	p, size, err := fc.download(ctx, id)
+	if err != nil {
+		return "", err
+	}
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if old, ok := fc.files[id]; ok {
+		fc.size -= old.size
+		if old.path != p {
+			os.Remove(old.path)
+		}
+	}
+	fc.files[id] = &cachedFile{path: p, size: size, version: info.Version, checked: now, used: now}
+	fc.size += size
+	fc.evictLocked(ctx, id)
+	return p, nil
+}
+
+// evictLocked deletes the least recently used files other than keep until the cache is within its limit.
+// fc.mu must be held.
+func (fc *FileCache) evictLocked(ctx context.Context, keep string) {
+	for fc.maxBytes > 0 && fc.size > fc.maxBytes {
+		var oldest string
+		for id, f := range fc.files {
+			if id != keep && (oldest == "" || f.used.Before(fc.files[oldest].used)) {
+				oldest = id
+			}
+		}
+		if oldest == "" {
+			return
+		}
+		f := fc.files[oldest]
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			logging.FromContext(ctx).WithError(err).Warnf("Error deleting cached Drive file %s", f.path)
+		}
+		delete(fc.files, oldest)
+		fc.size -= f.size
+		logging.FromContext(ctx).Infof("Evicted Drive file %s from the cache", oldest)
+	}
+}
+
+// download downloads the Drive file with the given id into the cache directory and returns its path and size
+func (fc *FileCache) download(ctx context.Context, id string) (string, int64, error) {
+	name, contents, err := fc.c.GetFile(ctx, id)
+	if err != nil {
+		return "", 0, err
+	}
+	defer contents.Close()
+
+	f, err := ioutil.TempFile(fc.dir, "download-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(f.Name())
+	size, err := io.Copy(f, contents)
+	if err != nil {
+		f.Close()
+		return "", 0, err
+	}
+	if err := f.Close(); err != nil {
+		return "", 0, err
+	}
+
+	// Keep the file's extension, which ffmpeg may use to identify its format
+	p := filepath.Join(fc.dir, id+filepath.Ext(name))
+	if err := os.Rename(f.Name(), p); err != nil {
+		return "", 0, err
+	}
+	logging.FromContext(ctx).Infof("Cached Drive file %s (%q) at %s", id, name, p)
+	return p, size, nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
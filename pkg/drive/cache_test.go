@@ -0,0 +1,209 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package drive
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingClient serves a single file and counts how often it is downloaded
+type countingClient struct {
+	Client
+	version   int64
+	downloads int
+}
+
+func (c *countingClient) Stat(ctx context.Context, id string) (*FileInfo, error) {
+	return &FileInfo{Name: "logo.png", Version: c.version}, nil
+}
+
+func (c *countingClient) GetFile(ctx context.Context, id string) (string, io.ReadCloser, error) {
+	c.downloads++
+	return "logo.png", ioutil.NopCloser(strings.NewReader("png data")), nil
+}
+
+func TestFileCache(t *testing.T) {
+	dir := t.TempDir()
+	c := &countingClient{}
+	fc, err := NewFileCache(c, dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := fc.Path(context.Background(), "logoId")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != filepath.Join(dir, "logoId.png") {
+		t.Errorf("got path %s, want %s", p, filepath.Join(dir, "logoId.png"))
+	}
+	if b, err := ioutil.ReadFile(p); err != nil || string(b) != "png data" {
+		t.Errorf("got cached contents %q (error %v), want %q", b, err, "png data")
+	}
+
+	if _, err := fc.Path(context.Background(), "logoId"); err != nil {
+		t.Fatal(err)
+	}
+	if c.downloads != 1 {
+		t.Errorf("got %d downloads, want 1", c.downloads)
+	}
+
+	// A cached file that has been deleted is downloaded again
+	os.Remove(p)
+	if _, err := fc.Path(context.Background(), "logoId"); err != nil {
+		t.Fatal(err)
+	}
+	if c.downloads != 2 {
+		t.Errorf("got %d downloads, want 2", c.downloads)
+	}
+
+	// Once the cached copy is due to be checked, it is downloaded again only if the file has changed
+	fc.files["logoId"].checked = time.Time{}
+	if _, err := fc.Path(context.Background(), "logoId"); err != nil {
+		t.Fatal(err)
+	}
+	if c.downloads != 2 {
+		t.Errorf("got %d downloads of an unchanged file, want 2", c.downloads)
+	}
+	c.version++
+	fc.files["logoId"].checked = time.Time{}
+	if _, err := fc.Path(context.Background(), "logoId"); err != nil {
+		t.Fatal(err)
+	}
+	if c.downloads != 3 {
+		t.Errorf("got %d downloads of a changed file, want 3", c.downloads)
+	}
+}
+
+// blockingClient serves files named after their IDs, holding up downloads of the file "slow" until release is closed
+type blockingClient struct {
+	Client
+	release chan struct{}
+
+	mu        sync.Mutex
+	downloads map[string]int
+}
+
+func (c *blockingClient) Stat(ctx context.Context, id string) (*FileInfo, error) {
+	return &FileInfo{Name: id + ".png"}, nil
+}
+
+func (c *blockingClient) GetFile(ctx context.Context, id string) (string, io.ReadCloser, error) {
+	c.mu.Lock()
+	c.downloads[id]++
+	c.mu.Unlock()
+	if id == "slow" {
+		<-c.release
+	}
+	return id + ".png", ioutil.NopCloser(strings.NewReader(id)), nil
+}
+
+func TestFileCache_Concurrent(t *testing.T) {
+	c := &blockingClient{release: make(chan struct{}), downloads: make(map[string]int)}
+	fc, err := NewFileCache(c, t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The download continues for other requests if the request that started it is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan error)
+	go func() {
+		_, err := fc.Path(ctx, "slow")
+		cancelled <- err
+	}()
+	for {
+		c.mu.Lock()
+		started := c.downloads["slow"] > 0
+		c.mu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-cancelled; err != context.Canceled {
+		t.Errorf("got error %v from cancelled request, want %v", err, context.Canceled)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := fc.Path(context.Background(), "slow")
+			errs <- err
+		}()
+	}
+
+	// A file requested while another is downloading is not held up by it
+	done := make(chan error)
+	go func() {
+		_, err := fc.Path(context.Background(), "fast")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Download of a file was held up by the download of another")
+	}
+
+	close(c.release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if c.downloads["slow"] != 1 {
+		t.Errorf("got %d downloads of a file requested concurrently, want 1", c.downloads["slow"])
+	}
+}
+
+func TestFileCache_Evicts(t *testing.T) {
+	c := &blockingClient{release: make(chan struct{}), downloads: make(map[string]int)}
+	// Each file is as long as its ID, so the cache holds two of these
+	fc, err := NewFileCache(c, t.TempDir(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := make(map[string]string)
+	for _, id := range []string{"logo", "intro", "logo", "outro"} {
+		if paths[id], err = fc.Path(context.Background(), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// intro was used least recently
+	for id, want := range map[string]bool{"logo": true, "intro": false, "outro": true} {
+		if got := exists(paths[id]); got != want {
+			t.Errorf("%s cached = %t, want %t", id, got, want)
+		}
+	}
+}
@@ -17,11 +17,17 @@ package drive
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
+	"time"
 
+	"github.com/ssmall/nocco-video-extractor/pkg/logging"
+	"github.com/ssmall/nocco-video-extractor/pkg/tracing"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/drive/v3"
 	"google.golang.org/api/googleapi"
@@ -37,8 +43,27 @@ type Client interface {
 	// UploadFile uploads a file with the given name and contents to the specified folder.
 	// Returns the URL of the uploaded file.
 	UploadFile(ctx context.Context, name, folder string, contents io.Reader) (string, error)
+
+	// Stat gets the metadata of the file with the given id without downloading its contents.
+	// It returns an error wrapping ErrNotFound if there is no such file.
+	Stat(ctx context.Context, id string) (*FileInfo, error)
 }
 
+// ErrNotFound is returned for a file that does not exist or cannot be accessed with the client's credentials
+var ErrNotFound = errors.New("file not found")
+
+// FileInfo describes a file stored in Google Drive
+type FileInfo struct {
+	Name     string
+	MimeType string
+	// Size is the size of the file's contents in bytes. It is zero for Google Docs editor files.
+	Size int64
+	// Version increases whenever the file is changed
+	Version int64
+}
+
+var tracer = tracing.Tracer("drive")
+
 type driveClient struct {
 	srv *drive.Service
 }
@@ -74,28 +99,91 @@ func NewClient(ctx context.Context) (Client, error) {
 	return &driveClient{srv}, nil
 }
 
-func (c *driveClient) GetFile(ctx context.Context, id string) (string, io.ReadCloser, error) {
+func (c *driveClient) GetFile(ctx context.Context, id string) (name string, body io.ReadCloser, err error) {
+	ctx, span := tracer.Start(ctx, "drive.GetFile", trace.WithAttributes(label.String("drive.file_id", id)))
+	defer func() {
+		tracing.End(ctx, span, err)
+	}()
+
 	f, err := c.srv.Files.Get(id).SupportsAllDrives(true).Context(ctx).Fields(googleapi.Field("name")).Do()
 	if err != nil {
+		observeError("get", err)
 		return "", nil, fmt.Errorf("error getting filename: %w", err)
 	}
-	log.Printf("File %s has name %q", id, f.Name)
+	logger := logging.FromContext(ctx).With("fileId", id)
+	logger.Debugf("File %s has name %q", id, f.Name)
+	span.SetAttributes(label.String("drive.file_name", f.Name))
 	r, err := c.srv.Files.Get(id).SupportsAllDrives(true).Context(ctx).Download()
 	if err != nil {
+		observeError("download", err)
 		return "", nil, err
 	}
-	log.Printf("<--- %s %s, ContentLength: %d bytes", r.Status, r.Request.URL, r.ContentLength)
-	return f.Name, r.Body, nil
+	logger.WithFields(logging.Fields{
+		"status":        r.StatusCode,
+		"contentLength": r.ContentLength,
+	}).Infof("<--- %s %s, ContentLength: %d bytes", r.Status, r.Request.URL, r.ContentLength)
+	span.SetAttributes(label.Int64("drive.content_length", r.ContentLength))
+	return f.Name, newInstrumentedDownload(r.Body), nil
 }
 
-func (c *driveClient) UploadFile(ctx context.Context, name, folder string, contents io.Reader) (string, error) {
+func (c *driveClient) Stat(ctx context.Context, id string) (info *FileInfo, err error) {
+	ctx, span := tracer.Start(ctx, "drive.Stat", trace.WithAttributes(label.String("drive.file_id", id)))
+	defer func() {
+		tracing.End(ctx, span, err)
+	}()
+
+	f, err := c.srv.Files.Get(id).SupportsAllDrives(true).Context(ctx).Fields("name", "mimeType", "size", "version").Do()
+	if err != nil {
+		observeError("stat", err)
+		var gerr *googleapi.Error
+		if errors.As(err, &gerr) && gerr.Code == http.StatusNotFound {
+			return nil, fmt.Errorf("error getting file metadata: %w: %v", ErrNotFound, err)
+		}
+		return nil, fmt.Errorf("error getting file metadata: %w", err)
+	}
+	span.SetAttributes(label.String("drive.file_name", f.Name), label.Int64("drive.file_size", f.Size))
+	return &FileInfo{Name: f.Name, MimeType: f.MimeType, Size: f.Size, Version: f.Version}, nil
+}
+
+func (c *driveClient) UploadFile(ctx context.Context, name, folder string, contents io.Reader) (url string, err error) {
+	ctx, span := tracer.Start(ctx, "drive.UploadFile", trace.WithAttributes(label.String("drive.file_name", name), label.String("drive.folder_id", folder)))
+	defer func() {
+		tracing.End(ctx, span, err)
+	}()
+
+	start := time.Now()
 	f, err := c.srv.Files.Create(&drive.File{
 		Name:    name,
 		Parents: []string{folder},
-	}).SupportsAllDrives(true).Context(ctx).Media(contents).Fields("name", "id", "webViewLink").Do()
+	}).SupportsAllDrives(true).Context(ctx).Media(&countingReader{r: contents, counter: uploadBytes}).Fields("name", "id", "webViewLink").Do()
 	if err != nil {
+		observeError("upload", err)
 		return "", err
 	}
-	log.Printf("File uploaded as %q (id: %s) to folder %q", f.Name, f.Id, folder)
+	uploadDuration.Observe(time.Since(start).Seconds())
+	logging.FromContext(ctx).WithFields(logging.Fields{
+		"fileId":   f.Id,
+		"folderId": folder,
+	}).Infof("File uploaded as %q (id: %s) to folder %q", f.Name, f.Id, folder)
 	return f.WebViewLink, nil
 }
+
+// CredentialsCheck returns a function that verifies that the default credentials
+// used by NewClient can mint an access token. Tokens are cached until they expire.
+func CredentialsCheck(ctx context.Context) (func(context.Context) error, error) {
+	creds, err := google.FindDefaultCredentials(ctx, drive.DriveScope)
+	if err != nil {
+		return nil, err
+	}
+	ts := oauth2.ReuseTokenSource(nil, creds.TokenSource)
+	return func(context.Context) error {
+		t, err := ts.Token()
+		if err != nil {
+			return fmt.Errorf("error minting access token: %w", err)
+		}
+		if !t.Valid() {
+			return errors.New("minted access token is not valid")
+		}
+		return nil
+	}, nil
+}
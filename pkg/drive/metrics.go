@@ -0,0 +1,110 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package drive
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/api/googleapi"
+)
+
+var transferBuckets = prometheus.ExponentialBuckets(1, 4, 10)
+
+var (
+	downloadBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "nocco",
+		Subsystem: "drive",
+		Name:      "download_bytes_total",
+		Help:      "Total number of bytes downloaded from Google Drive.",
+	})
+	downloadDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "nocco",
+		Subsystem: "drive",
+		Name:      "download_duration_seconds",
+		Help:      "Time taken to download a file from Google Drive.",
+		Buckets:   transferBuckets,
+	})
+	uploadBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "nocco",
+		Subsystem: "drive",
+		Name:      "upload_bytes_total",
+		Help:      "Total number of bytes uploaded to Google Drive.",
+	})
+	uploadDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "nocco",
+		Subsystem: "drive",
+		Name:      "upload_duration_seconds",
+		Help:      "Time taken to upload a file to Google Drive.",
+		Buckets:   transferBuckets,
+	})
+	apiErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nocco",
+		Subsystem: "drive",
+		Name:      "api_errors_total",
+		Help:      "Number of errors returned by the Google Drive API, by HTTP status code.",
+	}, []string{"operation", "code"})
+)
+
+// observeError records err against the Drive API error counter
+func observeError(operation string, err error) {
+	code := "unknown"
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		code = strconv.Itoa(gerr.Code)
+	}
+	apiErrors.WithLabelValues(operation, code).Inc()
+}
+
+// countingReader counts the bytes read through it into a counter
+type countingReader struct {
+	r       io.Reader
+	counter prometheus.Counter
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.counter.Add(float64(n))
+	return n, err
+}
+
+// instrumentedDownload records download metrics for a file body, observing the
+// download duration once the body is closed
+type instrumentedDownload struct {
+	countingReader
+	closer io.Closer
+	start  time.Time
+	once   sync.Once
+}
+
+func newInstrumentedDownload(body io.ReadCloser) *instrumentedDownload {
+	return &instrumentedDownload{
+		countingReader: countingReader{r: body, counter: downloadBytes},
+		closer:         body,
+		start:          time.Now(),
+	}
+}
+
+func (d *instrumentedDownload) Close() error {
+	d.once.Do(func() {
+		downloadDuration.Observe(time.Since(d.start).Seconds())
+	})
+	return d.closer.Close()
+}
@@ -0,0 +1,129 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package health runs diagnostic checks against the service's dependencies
+package health
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ssmall/nocco-video-extractor/pkg/workspace"
+)
+
+const (
+	// StatusOK indicates that a check, or all checks, passed
+	StatusOK = "ok"
+	// StatusFailed indicates that a check, or at least one check, failed
+	StatusFailed = "failed"
+)
+
+// Check is a named diagnostic check. Func returns nil if the check passed.
+type Check struct {
+	Name string
+	Func func(ctx context.Context) error
+}
+
+// Result is the outcome of running a single Check
+type Result struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"durationSeconds"`
+}
+
+// Report is the outcome of running a set of checks
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+// Run runs all checks concurrently, giving each at most timeout to complete
+func Run(ctx context.Context, timeout time.Duration, checks ...Check) Report {
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c Check) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := runCheck(ctx, c)
+			results[i] = Result{
+				Name:     c.Name,
+				Status:   StatusOK,
+				Duration: time.Since(start).Seconds(),
+			}
+			if err != nil {
+				results[i].Status = StatusFailed
+				results[i].Error = err.Error()
+			}
+		}(i, c)
+	}
+	wg.Wait()
+
+	r := Report{Status: StatusOK, Checks: results}
+	for _, result := range results {
+		if result.Status != StatusOK {
+			r.Status = StatusFailed
+		}
+	}
+	return r
+}
+
+// runCheck runs c, returning early if ctx is done before c completes
+func runCheck(ctx context.Context, c Check) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.Func(ctx)
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// DirCheck creates a Check that verifies dir is writable and has at least minFree bytes of free space
+func DirCheck(name, dir string, minFree uint64) Check {
+	return Check{
+		Name: name,
+		Func: func(ctx context.Context) error {
+			f, err := ioutil.TempFile(dir, "healthcheck-*")
+			if err != nil {
+				return fmt.Errorf("%s is not writable: %w", dir, err)
+			}
+			f.Close()
+			if err := os.Remove(f.Name()); err != nil {
+				return err
+			}
+
+			free, err := workspace.FreeSpace(dir)
+			if err != nil {
+				return err
+			}
+			if free < minFree {
+				return fmt.Errorf("%s has %d bytes free, need at least %d", dir, free, minFree)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"context"
+	"errors"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestRun(t *testing.T) {
+	report := Run(context.Background(), 50*time.Millisecond,
+		Check{Name: "ok", Func: func(context.Context) error { return nil }},
+		Check{Name: "error", Func: func(context.Context) error { return errors.New("expected error") }},
+		Check{Name: "timeout", Func: func(ctx context.Context) error {
+			time.Sleep(time.Second)
+			return nil
+		}},
+	)
+
+	expected := Report{
+		Status: StatusFailed,
+		Checks: []Result{
+			{Name: "ok", Status: StatusOK},
+			{Name: "error", Status: StatusFailed, Error: "expected error"},
+			{Name: "timeout", Status: StatusFailed, Error: context.DeadlineExceeded.Error()},
+		},
+	}
+
+	if diff := cmp.Diff(expected, report, cmpopts.IgnoreFields(Result{}, "Duration")); diff != "" {
+		t.Error("Report different than expected (-want +got):", diff)
+	}
+}
+
+func TestRun_AllPassing(t *testing.T) {
+	report := Run(context.Background(), time.Second, Check{Name: "ok", Func: func(context.Context) error { return nil }})
+
+	if diff := cmp.Diff(StatusOK, report.Status); diff != "" {
+		t.Error("Status different than expected (-want +got):", diff)
+	}
+}
+
+func TestDirCheck(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := DirCheck("dir", dir, 0).Func(context.Background()); err != nil {
+		t.Errorf("Expected writable directory to pass, got %v", err)
+	}
+
+	if err := DirCheck("dir", filepath.Join(dir, "missing"), 0).Func(context.Background()); err == nil {
+		t.Error("Expected missing directory to fail")
+	}
+
+	if err := DirCheck("dir", dir, math.MaxUint64).Func(context.Background()); err == nil {
+		t.Error("Expected insufficient free space to fail")
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ssmall/nocco-video-extractor/pkg/drive"
+	"github.com/ssmall/nocco-video-extractor/pkg/logging"
+	"github.com/ssmall/nocco-video-extractor/pkg/video"
+	"github.com/ssmall/nocco-video-extractor/pkg/workspace"
+)
+
+// lowAlignmentConfidence is the confidence below which automatic alignments are logged as doubtful
+const lowAlignmentConfidence = 0.5
+
+// AlignmentHandler creates a http.HandlerFunc that handles requests to find the offsets between
+// Google Drive recordings of the same performance by analysing their audio
+func AlignmentHandler(d drive.Client, e video.Extractor, opts ...HandlerOption) http.HandlerFunc {
+	cfg := newHandlerConfig(opts)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		var body AlignmentRequest
+		if !decodeRequest(w, r, &body) {
+			return
+		}
+
+		logger := logging.FromContext(r.Context())
+		logger.With("sourceFileIds", body.SourceFileIDs).Infof("Alignment of %d recordings", len(body.SourceFileIDs))
+
+		if len(body.SourceFileIDs) < 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("at least two sourceFileIds are required"))
+			return
+		}
+		opts := body.options()
+		if err := opts.Validate(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		cfg.serveJob(w, r, e, jobRequest{
+			space: func(ctx context.Context) (int64, error) {
+				return totalSize(ctx, d, distinct(body.SourceFileIDs))
+			},
+			status: http.StatusOK,
+			run: func(ctx context.Context, job *workspace.Job, result *CallbackResult) (interface{}, error) {
+				alignments, err := align(ctx, d, e, job, body.SourceFileIDs, opts)
+				if err != nil {
+					return nil, err
+				}
+				return &AlignmentResponse{Alignments: alignments}, nil
+			},
+		})
+	}
+}
+
+// autoAlign returns the offsets of the given files relative to the first, logging any found with low confidence
+func autoAlign(ctx context.Context, e video.Extractor, filenames []string, opts AlignOptions) ([]time.Duration, error) {
+	alignments, err := e.Align(ctx, filenames, opts.options())
+	if err != nil {
+		return nil, err
+	}
+	if len(alignments) != len(filenames) {
+		return nil, errors.New("extractor returned the wrong number of alignments")
+	}
+	offsets := make([]time.Duration, len(alignments))
+	for i, a := range alignments {
+		offsets[i] = a.Offset
+		if a.Confidence < lowAlignmentConfidence {
+			logging.FromContext(ctx).Warnf("Source %d aligned at %s with low confidence %.2f", i+1, a.Offset, a.Confidence)
+		}
+	}
+	return offsets, nil
+}
+
+func (o AlignOptions) options() video.AlignOptions {
+	return video.AlignOptions{
+		Method:    video.AlignMethod(o.Method),
+		Window:    fromSeconds(o.WindowSeconds),
+		MaxOffset: fromSeconds(o.MaxOffsetSeconds),
+	}
+}
+
+// align downloads the Drive files with the given ids into the job directory and aligns them
+func align(ctx context.Context, d drive.Client, e video.Extractor, job *workspace.Job, ids []string, opts video.AlignOptions) (alignments []Alignment, err error) {
+
+	files, err := downloadFiles(ctx, d, job, distinct(ids))
+	if err != nil {
+		return nil, err
+	}
+	filenames := make([]string, len(ids))
+	for i, id := range ids {
+		filenames[i] = files[id]
+	}
+
+	results, err := e.Align(ctx, filenames, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) != len(ids) {
+		return nil, errors.New("extractor returned the wrong number of alignments")
+	}
+
+	alignments = make([]Alignment, len(ids))
+	for i, a := range results {
+		alignments[i] = Alignment{SourceFileID: ids[i], OffsetSeconds: a.Offset.Seconds(), Confidence: a.Confidence}
+	}
+	return alignments, nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ssmall/nocco-video-extractor/pkg/video"
+)
+
+func TestAlignmentHandler_HappyPath(t *testing.T) {
+	drive := &fakeDriveClient{
+		filename:     "part.mov",
+		fileContents: closingBuffer{bytes.NewBufferString("part contents")},
+	}
+	extractor := &fakeExtractor{
+		alignments: []video.Alignment{{Confidence: 1}, {Offset: -1250 * time.Millisecond, Confidence: 0.8}},
+	}
+	handler := AlignmentHandler(drive, extractor)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, createRequest(t, `{"sourceFileIds": ["violin", "cello"], "method": "clap", "windowSeconds": 30}`))
+
+	if diff := cmp.Diff(http.StatusOK, rr.Code); diff != "" {
+		t.Fatal("Different response code than expected (+got -want):", diff, rr.Body)
+	}
+
+	if diff := cmp.Diff(video.AlignOptions{Method: video.AlignClap, Window: 30 * time.Second}, extractor.alignOpts); diff != "" {
+		t.Error("Align options different than expected (-want +got):", diff)
+	}
+	if len(extractor.alignInput) != 2 {
+		t.Errorf("got Align of %v, want 2 downloaded files", extractor.alignInput)
+	}
+
+	expected := AlignmentResponse{Alignments: []Alignment{
+		{SourceFileID: "violin", Confidence: 1},
+		{SourceFileID: "cello", OffsetSeconds: -1.25, Confidence: 0.8},
+	}}
+	var actual AlignmentResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &actual); err != nil {
+		t.Fatalf("Invalid response %q: %v", rr.Body, err)
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Error("Different response than expected (-want +got):", diff)
+	}
+}
+
+func TestAlignmentHandler_BadRequest(t *testing.T) {
+	cases := map[string]string{
+		"OneSource":     `{"sourceFileIds": ["violin"]}`,
+		"UnknownMethod": `{"sourceFileIds": ["violin", "cello"], "method": "telepathy"}`,
+		"OffsetTooLong": `{"sourceFileIds": ["violin", "cello"], "windowSeconds": 10, "maxOffsetSeconds": 20}`,
+		"WindowTooLong": `{"sourceFileIds": ["violin", "cello"], "windowSeconds": 10800}`,
+	}
+	for name, requestJSON := range cases {
+		t.Run(name, func(t *testing.T) {
+			extractor := &fakeExtractor{}
+			handler := AlignmentHandler(&fakeDriveClient{}, extractor)
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, createRequest(t, requestJSON))
+
+			if diff := cmp.Diff(http.StatusBadRequest, rr.Code); diff != "" {
+				t.Error("Different response code than expected (+got -want):", diff)
+			}
+			if extractor.alignInput != nil {
+				t.Error("Expected no alignment to be attempted")
+			}
+		})
+	}
+}
+
+func TestCompositionHandler_AutoAlign(t *testing.T) {
+	drive := &fakeDriveClient{
+		filename:     "part.mov",
+		fileContents: closingBuffer{bytes.NewBufferString("part contents")},
+	}
+	extractor := &fakeExtractor{
+		contents:   closingBuffer{bytes.NewBufferString("composition contents")},
+		alignments: []video.Alignment{{Confidence: 1}, {Offset: 2 * time.Second, Confidence: 0.9}},
+	}
+	handler := CompositionHandler(drive, extractor)
+
+	requestJSON := `{
+		"parts": [{"sourceFileId": "violin"}, {"sourceFileId": "cello", "offsetSeconds": -0.1}],
+		"autoAlign": {}
+		}`
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, createRequest(t, requestJSON))
+
+	if diff := cmp.Diff(http.StatusCreated, rr.Code); diff != "" {
+		t.Fatal("Different response code than expected (+got -want):", diff, rr.Body)
+	}
+	if diff := cmp.Diff(video.AlignOptions{}, extractor.alignOpts); diff != "" {
+		t.Error("Align options different than expected (-want +got):", diff)
+	}
+	offsets := []time.Duration{extractor.parts[0].Offset, extractor.parts[1].Offset}
+	if diff := cmp.Diff([]time.Duration{0, 1900 * time.Millisecond}, offsets); diff != "" {
+		t.Error("Offsets different than expected (-want +got):", diff)
+	}
+}
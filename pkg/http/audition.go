@@ -0,0 +1,408 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ssmall/nocco-video-extractor/pkg/drive"
+	"github.com/ssmall/nocco-video-extractor/pkg/logging"
+	"github.com/ssmall/nocco-video-extractor/pkg/video"
+	"github.com/ssmall/nocco-video-extractor/pkg/workspace"
+)
+
+// Pipeline is the processing applied to the submissions of one audition cycle. Each required excerpt
+// of a submission is clipped, normalised and stripped of identifying information, then uploaded to the
+// review folder under the candidate's anonymous ID. The sheet mapping candidates to their submissions
+// is uploaded to a separate folder that reviewers cannot access.
+type Pipeline struct {
+	// Excerpts name the excerpts that every submission must contain, in the order they are processed.
+	// Names may contain only letters, digits, hyphens and underscores.
+	Excerpts []string `json:"excerpts"`
+	// ReviewFolderID is the Drive folder to which the anonymised excerpts are uploaded
+	ReviewFolderID string `json:"reviewFolderId"`
+	// MappingFolderID is the restricted Drive folder to which the mapping sheets are uploaded
+	MappingFolderID string `json:"mappingFolderId"`
+	// LoudnessLUFS, if set, is the integrated loudness to which each excerpt is normalised, e.g. -16
+	LoudnessLUFS float64 `json:"loudnessLufs,omitempty"`
+	// Preset, if set, names the output preset with which each excerpt is encoded
+	Preset string `json:"preset,omitempty"`
+	// MuteChannels are the audio channels, numbered from 1, that are silenced, e.g. one carrying a teacher's voice
+	MuteChannels []int `json:"muteChannels,omitempty"`
+	// CandidatePrefix is prepended to the candidate IDs generated for submissions, e.g. "2020-"
+	CandidatePrefix string `json:"candidatePrefix,omitempty"`
+}
+
+// LoadPipelines reads audition pipelines from a JSON object mapping pipeline names to pipelines,
+// each of which may select one of the given output presets
+func LoadPipelines(r io.Reader, presets map[string]video.Preset) (map[string]Pipeline, error) {
+	var pipelines map[string]Pipeline
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&pipelines); err != nil {
+		return nil, fmt.Errorf("error parsing pipelines: %w", err)
+	}
+	for name, p := range pipelines {
+		if err := p.Validate(presets); err != nil {
+			return nil, fmt.Errorf("invalid pipeline %q: %w", name, err)
+		}
+	}
+	return pipelines, nil
+}
+
+// Validate returns an error if p is not a valid pipeline using one of the given output presets
+func (p *Pipeline) Validate(presets map[string]video.Preset) error {
+	if len(p.Excerpts) == 0 {
+		return errors.New("at least one excerpt is required")
+	}
+	seen := make(map[string]bool)
+	for _, name := range p.Excerpts {
+		if name == "" || !anonymousIDPattern.MatchString(name) {
+			return fmt.Errorf("excerpt name %q may contain only letters, digits, hyphens and underscores", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("excerpt %q is listed more than once", name)
+		}
+		seen[name] = true
+	}
+	if p.ReviewFolderID == "" || p.MappingFolderID == "" {
+		return errors.New("reviewFolderId and mappingFolderId are required")
+	}
+	if p.ReviewFolderID == p.MappingFolderID {
+		return errors.New("the mapping folder must not be the review folder")
+	}
+	if !anonymousIDPattern.MatchString(p.CandidatePrefix) {
+		return errors.New("candidatePrefix may contain only letters, digits, hyphens and underscores")
+	}
+	cfg := handlerConfig{presets: presets}
+	if _, err := cfg.clipOptions(p.excerptRequest("", AuditionExcerpt{}, ""), 0); err != nil {
+		return err
+	}
+	return nil
+}
+
+// excerptRequest returns the ExtractionRequest of an excerpt of a submission, anonymised under anonymousID
+func (p *Pipeline) excerptRequest(sourceFileID string, x AuditionExcerpt, anonymousID string) ExtractionRequest {
+	return ExtractionRequest{
+		SourceFileID:        sourceFileID,
+		ClipStartTime:       x.ClipStartTime,
+		ClipEndTime:         x.ClipEndTime,
+		DestinationFolderID: p.ReviewFolderID,
+		Preset:              p.Preset,
+		LoudnessLUFS:        p.LoudnessLUFS,
+		Privacy:             &PrivacyOptions{MuteChannels: p.MuteChannels, AnonymousID: anonymousID},
+	}
+}
+
+// WithPipelines sets the audition pipelines that requests to the AuditionHandler may select
+func WithPipelines(pipelines map[string]Pipeline) HandlerOption {
+	return func(c *handlerConfig) {
+		c.pipelines = pipelines
+	}
+}
+
+// AuditionHandler creates a http.HandlerFunc that handles requests to process an audition submission
+// stored in Google Drive with a configured Pipeline, for review without knowing who submitted it
+func AuditionHandler(d drive.Client, e video.Extractor, opts ...HandlerOption) http.HandlerFunc {
+	cfg := newHandlerConfig(opts)
+	candidates := &candidateIDs{used: make(map[string]bool), random: newCandidateID}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		var body AuditionRequest
+		if !decodeRequest(w, r, &body) {
+			return
+		}
+
+		logger := logging.FromContext(r.Context())
+		logger.WithFields(logging.Fields{
+			"pipeline":     body.Pipeline,
+			"sourceFileId": body.SourceFileID,
+		}).Infof("Audition submission %s for pipeline %q", body.SourceFileID, body.Pipeline)
+
+		pipeline, ok := cfg.pipelines[body.Pipeline]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("unknown pipeline %q", body.Pipeline)))
+			return
+		}
+
+		explicitID := body.CandidateID != ""
+		if !explicitID {
+			id, err := candidates.generate(pipeline.CandidatePrefix)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			body.CandidateID = id
+		} else if candidates.taken(body.CandidateID) {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(fmt.Sprintf("%v: %s", errCandidateInUse, body.CandidateID)))
+			return
+		}
+
+		clips, err := cfg.auditionClips(pipeline, body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		var info *drive.FileInfo
+		cfg.serveJob(w, r, e, jobRequest{
+			callbackURL: body.CallbackURL,
+			priority:    body.Priority,
+			result:      CallbackResult{Audition: &body},
+			space: func(ctx context.Context) (int64, error) {
+				var err error
+				if info, err = d.Stat(ctx, body.SourceFileID); err != nil {
+					return 0, err
+				}
+				return requiredSpace(info.Size), nil
+			},
+			status: http.StatusCreated,
+			run: func(ctx context.Context, job *workspace.Job, result *CallbackResult) (interface{}, error) {
+				// An explicit ID is only claimed once the job runs, so that a request rejected before then may be retried
+				if explicitID {
+					if err := candidates.claim(body.CandidateID); err != nil {
+						return nil, err
+					}
+				}
+				resp, err := audition(ctx, d, e, job, info.Name, pipeline, body, clips)
+				if err != nil {
+					// Let the submission be processed again under the same ID
+					candidates.release(body.CandidateID)
+					return nil, err
+				}
+				result.Submission = resp
+				return resp, nil
+			},
+		})
+	}
+}
+
+// auditionClip is an excerpt of a submission to be clipped with the options of its pipeline
+type auditionClip struct {
+	excerpt    AuditionExcerpt
+	start, end time.Duration
+	opts       video.ClipOptions
+	// anonymousID names the uploaded clip
+	anonymousID string
+}
+
+// auditionClips returns the clips of the pipeline's excerpts located by the request, in the pipeline's order.
+// Each clip has the options of an ExtractionRequest that anonymises it under the request's candidate ID.
+func (cfg *handlerConfig) auditionClips(p Pipeline, body AuditionRequest) ([]auditionClip, error) {
+	if body.SourceFileID == "" {
+		return nil, errors.New("sourceFileId is required")
+	}
+	if body.CandidateID == "" || !anonymousIDPattern.MatchString(body.CandidateID) {
+		return nil, errors.New("candidateId may contain only letters, digits, hyphens and underscores")
+	}
+
+	required := make(map[string]bool)
+	for _, name := range p.Excerpts {
+		required[name] = true
+	}
+	located := make(map[string]AuditionExcerpt)
+	for _, x := range body.Excerpts {
+		if !required[x.Name] {
+			return nil, fmt.Errorf("pipeline %q has no excerpt %q", body.Pipeline, x.Name)
+		}
+		if _, ok := located[x.Name]; ok {
+			return nil, fmt.Errorf("excerpt %q is located more than once", x.Name)
+		}
+		located[x.Name] = x
+	}
+
+	var clips []auditionClip
+	for _, name := range p.Excerpts {
+		x, ok := located[name]
+		if !ok {
+			return nil, fmt.Errorf("excerpt %q is required", name)
+		}
+		start, err := parseDuration(x.ClipStartTime)
+		if err != nil {
+			return nil, fmt.Errorf("excerpt %q: %w", name, err)
+		}
+		end, err := parseDuration(x.ClipEndTime)
+		if err != nil {
+			return nil, fmt.Errorf("excerpt %q: %w", name, err)
+		}
+		if end <= start {
+			return nil, fmt.Errorf("excerpt %q must end after it starts", name)
+		}
+
+		id := body.CandidateID + "_" + name
+		opts, err := cfg.clipOptions(p.excerptRequest(body.SourceFileID, x, id), end-start)
+		if err != nil {
+			return nil, fmt.Errorf("excerpt %q: %w", name, err)
+		}
+		clips = append(clips, auditionClip{excerpt: x, start: start, end: end, opts: opts, anonymousID: id})
+	}
+	return clips, nil
+}
+
+// audition downloads the submission, the Drive file named filename, into the job directory once,
+// uploads each of its clips to the pipeline's review folder, and then uploads the sheet mapping
+// the candidate to the submission to the pipeline's mapping folder
+func audition(ctx context.Context, d drive.Client, e video.Extractor, job *workspace.Job, filename string, p Pipeline, body AuditionRequest, clips []auditionClip) (resp *AuditionResponse, err error) {
+	logger := logging.FromContext(ctx)
+
+	source, err := downloadFile(ctx, d, job, body.SourceFileID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp = &AuditionResponse{CandidateID: body.CandidateID}
+	for _, c := range clips {
+		url, err := auditionExcerpt(ctx, d, e, source, filename, p.ReviewFolderID, c)
+		if err != nil {
+			return nil, err
+		}
+		resp.Clips = append(resp.Clips, AuditionClip{Excerpt: c.excerpt.Name, FileURL: url})
+	}
+
+	sheet, err := mappingSheet(body, filename, resp.Clips)
+	if err != nil {
+		return nil, err
+	}
+	name := body.CandidateID + ".csv"
+	logger.Infof("Uploading mapping sheet as %q", name)
+	if resp.MappingFileURL, err = d.UploadFile(ctx, name, p.MappingFolderID, bytes.NewReader(sheet)); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// auditionExcerpt clips an excerpt of the local file source, the submission named filename, and uploads it
+// under its anonymous ID
+func auditionExcerpt(ctx context.Context, d drive.Client, e video.Extractor, source, filename, folderID string, c auditionClip) (string, error) {
+	clip, err := e.Clip(ctx, source, c.start, c.end, c.opts)
+	if err != nil {
+		return "", err
+	}
+	defer clip.Close()
+
+	name, err := anonymousName(clipOutputName(filename, c.opts), c.anonymousID)
+	if err != nil {
+		return "", err
+	}
+	logging.FromContext(ctx).Infof("Uploading excerpt %q as %q", c.excerpt.Name, name)
+
+	return d.UploadFile(ctx, name, folderID, clip)
+}
+
+// mappingSheet returns a CSV sheet mapping the candidate of an audition request to its submission,
+// the Drive file named filename, with a row for each of the uploaded clips
+func mappingSheet(body AuditionRequest, filename string, clips []AuditionClip) ([]byte, error) {
+	excerpts := make(map[string]AuditionExcerpt)
+	for _, x := range body.Excerpts {
+		excerpts[x.Name] = x
+	}
+
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Write([]string{"candidateId", "excerpt", "clipUrl", "sourceFileId", "sourceFileName", "clipStartTime", "clipEndTime"})
+	for _, c := range clips {
+		x := excerpts[c.Excerpt]
+		w.Write([]string{body.CandidateID, c.Excerpt, c.FileURL, body.SourceFileID, filename, x.ClipStartTime, x.ClipEndTime})
+	}
+	w.Flush()
+	return b.Bytes(), w.Error()
+}
+
+// maxCandidateAttempts is the number of random candidate IDs tried before giving up on finding an unused one
+const maxCandidateAttempts = 100
+
+// errCandidateInUse is returned for a request that chooses a candidate ID already given to another submission
+var errCandidateInUse = errors.New("candidate ID is already in use")
+
+// candidateIDs keeps the candidate IDs used by audition requests since the server started,
+// so that an ID is not given to more than one candidate. Other instances of the server do not share it,
+// which is why generated IDs are long enough that they are not expected to collide with each other.
+type candidateIDs struct {
+	mu   sync.Mutex
+	used map[string]bool
+	// random returns a random candidate ID following a prefix
+	random func(prefix string) (string, error)
+}
+
+// taken reports whether id has been used
+func (c *candidateIDs) taken(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.used[id]
+}
+
+// claim records an ID chosen by a request, returning an error wrapping errCandidateInUse if it has been used
+func (c *candidateIDs) claim(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.used[id] {
+		return fmt.Errorf("%w: %s", errCandidateInUse, id)
+	}
+	c.used[id] = true
+	return nil
+}
+
+// release forgets id, so that it may be used again
+func (c *candidateIDs) release(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.used, id)
+}
+
+// generate returns a random candidate ID following prefix that has not been used before
+func (c *candidateIDs) generate(prefix string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := 0; i < maxCandidateAttempts; i++ {
+		id, err := c.random(prefix)
+		if err != nil {
+			return "", err
+		}
+		if !c.used[id] {
+			c.used[id] = true
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("no unused candidate ID found after %d attempts", maxCandidateAttempts)
+}
+
+// candidateDigits is the length of generated candidate numbers. With a trillion of them,
+// a collision between the IDs generated by separate instances is unlikely even over many thousands of submissions.
+const candidateDigits = 12
+
+// newCandidateID returns a random candidate number of candidateDigits digits following prefix
+func newCandidateID(prefix string) (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%0*d", prefix, candidateDigits, n.Int64()), nil
+}
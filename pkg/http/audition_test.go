@@ -0,0 +1,281 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ssmall/nocco-video-extractor/pkg/video"
+)
+
+var violinPipeline = Pipeline{
+	Excerpts:        []string{"mozart", "brahms"},
+	ReviewFolderID:  "review",
+	MappingFolderID: "restricted",
+	LoudnessLUFS:    -16,
+	MuteChannels:    []int{2},
+	CandidatePrefix: "V-",
+}
+
+func TestAuditionHandler(t *testing.T) {
+	drive := &fakeDriveClient{
+		filename:       "Jane Doe violin.mp4",
+		fileContents:   closingBuffer{bytes.NewBufferString("submission contents")},
+		createdFileURL: "https://drive.google.com/file",
+	}
+	extractor := &fakeExtractor{
+		contents: closingBuffer{bytes.NewBufferString("excerpt contents")},
+	}
+	handler := AuditionHandler(drive, extractor, WithPipelines(map[string]Pipeline{"violin-2020": violinPipeline}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, createRequest(t, `{
+		"pipeline": "violin-2020",
+		"sourceFileId": "submission",
+		"candidateId": "V-017",
+		"excerpts": [
+			{"name": "brahms", "clipStartTime": "00:04:10", "clipEndTime": "00:06:00"},
+			{"name": "mozart", "clipStartTime": "00:00:30", "clipEndTime": "00:03:45"}
+		]
+		}`))
+
+	if diff := cmp.Diff(http.StatusCreated, rr.Code); diff != "" {
+		t.Fatal("Different response code than expected (+got -want):", diff, rr.Body)
+	}
+
+	expected := AuditionResponse{
+		CandidateID: "V-017",
+		Clips: []AuditionClip{
+			{Excerpt: "mozart", FileURL: "https://drive.google.com/file"},
+			{Excerpt: "brahms", FileURL: "https://drive.google.com/file"},
+		},
+		MappingFileURL: "https://drive.google.com/file",
+	}
+	var actual AuditionResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &actual); err != nil {
+		t.Fatalf("Invalid response %q: %v", rr.Body, err)
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Error("Different response than expected (-want +got):", diff)
+	}
+
+	// The last clip is the pipeline's last excerpt
+	if diff := cmp.Diff(250*time.Second, extractor.clipStart); diff != "" {
+		t.Error("Clip start different than expected (-want +got):", diff)
+	}
+	if diff := cmp.Diff(&video.Privacy{MuteChannels: []int{1}}, extractor.clipOptions.Privacy); diff != "" {
+		t.Error("Privacy options different than expected (-want +got):", diff)
+	}
+	if diff := cmp.Diff(-16.0, extractor.clipOptions.Loudness); diff != "" {
+		t.Error("Loudness different than expected (-want +got):", diff)
+	}
+
+	// The last upload is the mapping sheet
+	if diff := cmp.Diff("V-017.csv", drive.uploadFileName); diff != "" {
+		t.Error("Mapping sheet name different than expected (-want +got):", diff)
+	}
+	if diff := cmp.Diff("restricted", drive.uploadFileFolder); diff != "" {
+		t.Error("Mapping sheet folder different than expected (-want +got):", diff)
+	}
+	expectedSheet := "candidateId,excerpt,clipUrl,sourceFileId,sourceFileName,clipStartTime,clipEndTime\n" +
+		"V-017,mozart,https://drive.google.com/file,submission,Jane Doe violin.mp4,00:00:30,00:03:45\n" +
+		"V-017,brahms,https://drive.google.com/file,submission,Jane Doe violin.mp4,00:04:10,00:06:00\n"
+	if diff := cmp.Diff(expectedSheet, string(drive.uploadFileContents)); diff != "" {
+		t.Error("Mapping sheet different than expected (-want +got):", diff)
+	}
+}
+
+func TestAuditionHandler_GeneratedCandidateID(t *testing.T) {
+	drive := &fakeDriveClient{
+		filename:     "submission.mov",
+		fileContents: closingBuffer{bytes.NewBufferString("submission contents")},
+	}
+	extractor := &fakeExtractor{
+		contents: closingBuffer{bytes.NewBufferString("excerpt contents")},
+	}
+	handler := AuditionHandler(drive, extractor, WithPipelines(map[string]Pipeline{"violin-2020": violinPipeline}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, createRequest(t, `{
+		"pipeline": "violin-2020",
+		"sourceFileId": "submission",
+		"excerpts": [
+			{"name": "mozart", "clipStartTime": "00:00:30", "clipEndTime": "00:03:45"},
+			{"name": "brahms", "clipStartTime": "00:04:10", "clipEndTime": "00:06:00"}
+		]
+		}`))
+
+	if diff := cmp.Diff(http.StatusCreated, rr.Code); diff != "" {
+		t.Fatal("Different response code than expected (+got -want):", diff, rr.Body)
+	}
+	var actual AuditionResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &actual); err != nil {
+		t.Fatalf("Invalid response %q: %v", rr.Body, err)
+	}
+	if !regexp.MustCompile(`^V-[0-9]{12}$`).MatchString(actual.CandidateID) {
+		t.Errorf("Candidate ID %q is not a prefixed 12-digit number", actual.CandidateID)
+	}
+	if !strings.HasPrefix(string(drive.uploadFileContents), "candidateId,") {
+		t.Errorf("Expected the last upload to be the mapping sheet, got %q", drive.uploadFileContents)
+	}
+}
+
+func TestAuditionHandler_CandidateIDInUse(t *testing.T) {
+	drive := &fakeDriveClient{
+		filename:     "submission.mov",
+		fileContents: closingBuffer{bytes.NewBufferString("submission contents")},
+	}
+	extractor := &fakeExtractor{
+		contents: closingBuffer{bytes.NewBufferString("excerpt contents")},
+	}
+	handler := AuditionHandler(drive, extractor, WithPipelines(map[string]Pipeline{"violin-2020": violinPipeline}))
+	requestJSON := `{
+		"pipeline": "violin-2020",
+		"sourceFileId": "submission",
+		"candidateId": "V-017",
+		"excerpts": [
+			{"name": "mozart", "clipStartTime": "00:00:30", "clipEndTime": "00:03:45"},
+			{"name": "brahms", "clipStartTime": "00:04:10", "clipEndTime": "00:06:00"}
+		]
+		}`
+
+	for _, expectedCode := range []int{http.StatusCreated, http.StatusConflict} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, createRequest(t, requestJSON))
+
+		if diff := cmp.Diff(expectedCode, rr.Code); diff != "" {
+			t.Fatal("Different response code than expected (-want +got):", diff, rr.Body)
+		}
+	}
+}
+
+func TestAuditionHandler_BadRequest(t *testing.T) {
+	cases := map[string]string{
+		"UnknownPipeline":  `{"pipeline": "cello-2020", "sourceFileId": "submission"}`,
+		"NoSource":         `{"pipeline": "violin-2020", "excerpts": [{"name": "mozart", "clipStartTime": "00:00:00", "clipEndTime": "00:01:00"}, {"name": "brahms", "clipStartTime": "00:01:00", "clipEndTime": "00:02:00"}]}`,
+		"MissingExcerpt":   `{"pipeline": "violin-2020", "sourceFileId": "submission", "excerpts": [{"name": "mozart", "clipStartTime": "00:00:00", "clipEndTime": "00:01:00"}]}`,
+		"UnknownExcerpt":   `{"pipeline": "violin-2020", "sourceFileId": "submission", "excerpts": [{"name": "mozart", "clipStartTime": "00:00:00", "clipEndTime": "00:01:00"}, {"name": "brahms", "clipStartTime": "00:01:00", "clipEndTime": "00:02:00"}, {"name": "bach", "clipStartTime": "00:02:00", "clipEndTime": "00:03:00"}]}`,
+		"DuplicateExcerpt": `{"pipeline": "violin-2020", "sourceFileId": "submission", "excerpts": [{"name": "mozart", "clipStartTime": "00:00:00", "clipEndTime": "00:01:00"}, {"name": "mozart", "clipStartTime": "00:01:00", "clipEndTime": "00:02:00"}]}`,
+		"EndBeforeStart":   `{"pipeline": "violin-2020", "sourceFileId": "submission", "excerpts": [{"name": "mozart", "clipStartTime": "00:01:00", "clipEndTime": "00:00:30"}, {"name": "brahms", "clipStartTime": "00:01:00", "clipEndTime": "00:02:00"}]}`,
+		"InvalidCandidate": `{"pipeline": "violin-2020", "sourceFileId": "submission", "candidateId": "Jane Doe", "excerpts": [{"name": "mozart", "clipStartTime": "00:00:00", "clipEndTime": "00:01:00"}, {"name": "brahms", "clipStartTime": "00:01:00", "clipEndTime": "00:02:00"}]}`,
+	}
+	for name, body := range cases {
+		t.Run(name, func(t *testing.T) {
+			handler := AuditionHandler(&fakeDriveClient{}, &fakeExtractor{}, WithPipelines(map[string]Pipeline{"violin-2020": violinPipeline}))
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, createRequest(t, body))
+
+			if diff := cmp.Diff(http.StatusBadRequest, rr.Code); diff != "" {
+				t.Error("Different response code than expected (+got -want):", diff, rr.Body)
+			}
+		})
+	}
+}
+
+func TestLoadPipelines(t *testing.T) {
+	pipelines, err := LoadPipelines(strings.NewReader(`{
+		"violin-2020": {
+			"excerpts": ["mozart", "brahms"],
+			"reviewFolderId": "review",
+			"mappingFolderId": "restricted",
+			"loudnessLufs": -16,
+			"muteChannels": [2],
+			"candidatePrefix": "V-"
+		}
+	}`), video.BuiltinPresets())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(map[string]Pipeline{"violin-2020": violinPipeline}, pipelines); diff != "" {
+		t.Error("Pipelines different than expected (-want +got):", diff)
+	}
+
+	invalid := map[string]string{
+		"UnknownField":  `{"p": {"excerpts": ["a"], "reviewFolderId": "r", "mappingFolderId": "m", "folder": "x"}}`,
+		"NoExcerpts":    `{"p": {"reviewFolderId": "r", "mappingFolderId": "m"}}`,
+		"DuplicateName": `{"p": {"excerpts": ["a", "a"], "reviewFolderId": "r", "mappingFolderId": "m"}}`,
+		"InvalidName":   `{"p": {"excerpts": ["a/b"], "reviewFolderId": "r", "mappingFolderId": "m"}}`,
+		"NoMapping":     `{"p": {"excerpts": ["a"], "reviewFolderId": "r"}}`,
+		"SharedFolder":  `{"p": {"excerpts": ["a"], "reviewFolderId": "r", "mappingFolderId": "r"}}`,
+		"InvalidPrefix": `{"p": {"excerpts": ["a"], "reviewFolderId": "r", "mappingFolderId": "m", "candidatePrefix": "2020 "}}`,
+		"UnknownPreset": `{"p": {"excerpts": ["a"], "reviewFolderId": "r", "mappingFolderId": "m", "preset": "cinema"}}`,
+		"LoudLoudness":  `{"p": {"excerpts": ["a"], "reviewFolderId": "r", "mappingFolderId": "m", "loudnessLufs": 3}}`,
+		"ZeroChannel":   `{"p": {"excerpts": ["a"], "reviewFolderId": "r", "mappingFolderId": "m", "muteChannels": [0]}}`,
+	}
+	for name, file := range invalid {
+		t.Run(name, func(t *testing.T) {
+			if _, err := LoadPipelines(strings.NewReader(file), video.BuiltinPresets()); err == nil {
+				t.Error("Expected error loading pipelines")
+			}
+		})
+	}
+}
+
+func TestCandidateIDs_Generate(t *testing.T) {
+	sequence := []string{"V-000001", "V-000002", "V-000001", "V-000003"}
+	candidates := &candidateIDs{used: make(map[string]bool), random: func(prefix string) (string, error) {
+		id := sequence[0]
+		sequence = sequence[1:]
+		return id, nil
+	}}
+	if err := candidates.claim("V-000002"); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for i := 0; i < 2; i++ {
+		id, err := candidates.generate("V-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, id)
+	}
+	if diff := cmp.Diff([]string{"V-000001", "V-000003"}, got); diff != "" {
+		t.Error("Different candidate IDs than expected (-want +got):", diff)
+	}
+}
+
+func TestCandidateIDs_Claim(t *testing.T) {
+	candidates := &candidateIDs{used: make(map[string]bool)}
+	if err := candidates.claim("V-017"); err != nil {
+		t.Fatal(err)
+	}
+	if err := candidates.claim("V-017"); !errors.Is(err, errCandidateInUse) {
+		t.Errorf("got error %v claiming an ID twice, want %v", err, errCandidateInUse)
+	}
+	candidates.release("V-017")
+	if err := candidates.claim("V-017"); err != nil {
+		t.Errorf("got error %v claiming a released ID", err)
+	}
+}
+
+func TestCandidateIDs_GenerateExhausted(t *testing.T) {
+	candidates := &candidateIDs{used: map[string]bool{"V-000001": true}, random: func(prefix string) (string, error) {
+		return "V-000001", nil
+	}}
+	if _, err := candidates.generate("V-"); err == nil {
+		t.Error("Expected error when every generated ID is used")
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ssmall/nocco-video-extractor/pkg/logging"
+	"github.com/ssmall/nocco-video-extractor/pkg/tracing"
+	"github.com/ssmall/nocco-video-extractor/pkg/webhook"
+)
+
+// asyncJobTimeout bounds how long a job accepted for asynchronous processing may run
+var asyncJobTimeout = 2 * time.Hour
+
+// callbackTimeout bounds how long the result of a job may take to be delivered, including retries.
+// It starts when the job ends, so that the failure of a job that timed out can still be reported.
+const callbackTimeout = 5 * time.Minute
+
+const (
+	// JobStatusSucceeded indicates that the clip or compilation was created and uploaded
+	JobStatusSucceeded = "succeeded"
+	// JobStatusFailed indicates that an error occurred while processing the job
+	JobStatusFailed = "failed"
+)
+
+func validateCallbackURL(s webhook.Sender, callbackURL string) error {
+	if s == nil {
+		return errors.New("callbacks are not enabled on this server")
+	}
+	u, err := url.Parse(callbackURL)
+	if err != nil {
+		return fmt.Errorf("invalid callback URL: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid callback URL %q: must be an absolute http(s) URL", callbackURL)
+	}
+	// Host names are checked by the Sender once resolved, when each delivery is attempted
+	if ip := net.ParseIP(u.Hostname()); ip != nil {
+		if err := webhook.CheckDestination(ip); err != nil {
+			return fmt.Errorf("invalid callback URL %q: %w", callbackURL, err)
+		}
+	} else if strings.EqualFold(u.Hostname(), "localhost") {
+		return fmt.Errorf("invalid callback URL %q: %w: localhost", callbackURL, webhook.ErrForbiddenDestination)
+	}
+	return nil
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// notifyCallback delivers the outcome of an asynchronous job to callbackURL.
+// result identifies the job and its request; its status is set from jobErr.
+// The delivery is not bounded by the deadline of ctx, the job's context, but by callbackTimeout.
+// If deliveries is not nil, the delivery is recorded in it under the ID of the job.
+func notifyCallback(ctx context.Context, s webhook.Sender, deliveries *webhook.Store, callbackURL string, result CallbackResult, jobErr error) {
+	logger := logging.FromContext(ctx)
+	ctx, cancel := context.WithTimeout(logging.NewContext(tracing.Detach(ctx), logger), callbackTimeout)
+	defer cancel()
+
+	jobID := result.JobID
+	result.Status = JobStatusSucceeded
+	if jobErr != nil {
+		logger.WithError(jobErr).Errorf("Job %s failed", jobID)
+		result.Status = JobStatusFailed
+		result.FileURL = ""
+		result.Error = jobErr.Error()
+	}
+
+	payload, err := json.Marshal(&result)
+	if err != nil {
+		logger.WithError(err).Errorf("Error encoding result of job %s", jobID)
+		return
+	}
+
+	delivery, err := s.Send(ctx, callbackURL, payload)
+	if err != nil {
+		logger.WithError(err).Errorf("Error delivering result of job %s", jobID)
+	}
+	if delivery != nil {
+		if deliveries != nil {
+			deliveries.Record(jobID, *delivery)
+		}
+		logger.With("delivery", delivery).Infof("Job %s result delivery %s to %s: succeeded=%t after %d attempt(s)", jobID, delivery.ID, delivery.URL, delivery.Succeeded, len(delivery.Attempts))
+	}
+}
+
+// DeliveryHandler creates a http.HandlerFunc that reports the attempts made to deliver
+// the result of the asynchronous job identified by the jobId route variable, as recorded in s.
+// Only the most recent deliveries of this server are kept.
+func DeliveryHandler(s *webhook.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		jobID := mux.Vars(r)["jobId"]
+
+		d, ok := s.Get(jobID)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(fmt.Sprintf("no delivery of job %q has been recorded", jobID)))
+			return
+		}
+
+		b, err := json.Marshal(d)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/gorilla/mux"
+	"github.com/ssmall/nocco-video-extractor/pkg/webhook"
+)
+
+func TestDeliveryHandler(t *testing.T) {
+	deliveries := webhook.NewStore(10)
+	sender := newFakeSender()
+	sender.err = errors.New("connection refused")
+	notifyCallback(context.Background(), sender, deliveries, "https://example.com/callback", CallbackResult{JobID: "job1"}, nil)
+	sender.wait(t)
+
+	cases := []struct {
+		name         string
+		jobID        string
+		expectedCode int
+		expected     webhook.Delivery
+	}{
+		{
+			name:         "Recorded",
+			jobID:        "job1",
+			expectedCode: http.StatusOK,
+			expected: webhook.Delivery{
+				URL:      "https://example.com/callback",
+				Attempts: []webhook.Attempt{{Error: "connection refused"}},
+			},
+		},
+		{
+			name:         "Unknown job",
+			jobID:        "job2",
+			expectedCode: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/jobs/"+test.jobID+"/delivery", http.NoBody)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req = mux.SetURLVars(req, map[string]string{"jobId": test.jobID})
+			rr := httptest.NewRecorder()
+
+			DeliveryHandler(deliveries).ServeHTTP(rr, req)
+
+			if diff := cmp.Diff(test.expectedCode, rr.Code); diff != "" {
+				t.Fatal("Different response code than expected (-want +got):", diff)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			var actual webhook.Delivery
+			if err := json.Unmarshal(rr.Body.Bytes(), &actual); err != nil {
+				t.Fatalf("Invalid response %q: %v", rr.Body, err)
+			}
+			if diff := cmp.Diff(test.expected, actual); diff != "" {
+				t.Error("Different delivery than expected (-want +got):", diff)
+			}
+		})
+	}
+}
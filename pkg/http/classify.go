@@ -0,0 +1,116 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ssmall/nocco-video-extractor/pkg/drive"
+	"github.com/ssmall/nocco-video-extractor/pkg/logging"
+	"github.com/ssmall/nocco-video-extractor/pkg/video"
+	"github.com/ssmall/nocco-video-extractor/pkg/workspace"
+)
+
+// ClassificationHandler creates a http.HandlerFunc that handles requests to divide the audio of a
+// Google Drive recording into regions of silence, applause and music
+func ClassificationHandler(d drive.Client, e video.Extractor, opts ...HandlerOption) http.HandlerFunc {
+	cfg := newHandlerConfig(opts)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		var body ClassificationRequest
+		if !decodeRequest(w, r, &body) {
+			return
+		}
+
+		logger := logging.FromContext(r.Context())
+		logger.With("sourceFileId", body.SourceFileID).Infof("Classification of %s", body.SourceFileID)
+
+		start, end, err := body.interval()
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		var info *drive.FileInfo
+		cfg.serveJob(w, r, e, jobRequest{
+			space: func(ctx context.Context) (int64, error) {
+				var err error
+				if info, err = d.Stat(ctx, body.SourceFileID); err != nil {
+					return 0, err
+				}
+				return info.Size, nil
+			},
+			status: http.StatusOK,
+			run: func(ctx context.Context, job *workspace.Job, result *CallbackResult) (interface{}, error) {
+				regions, err := classify(ctx, d, e, job, body.SourceFileID, start, end)
+				if err != nil {
+					return nil, err
+				}
+				return &ClassificationResponse{Regions: regions}, nil
+			},
+		})
+	}
+}
+
+func (body ClassificationRequest) interval() (start, end time.Duration, err error) {
+	if body.SourceFileID == "" {
+		return 0, 0, errors.New("sourceFileId is required")
+	}
+	return parseInterval(body.StartTime, body.EndTime)
+}
+
+// parseInterval parses the optional HH:MM:SS timestamps of part of a recording,
+// returning an end of zero for the end of the recording
+func parseInterval(startTime, endTime string) (start, end time.Duration, err error) {
+	if startTime != "" {
+		if start, err = parseDuration(startTime); err != nil {
+			return 0, 0, err
+		}
+	}
+	if endTime != "" {
+		if end, err = parseDuration(endTime); err != nil {
+			return 0, 0, err
+		}
+		if end <= start {
+			return 0, 0, errors.New("endTime must be after startTime")
+		}
+	}
+	return start, end, nil
+}
+
+// classify downloads the Drive file with the given id into the job directory and classifies its audio
+func classify(ctx context.Context, d drive.Client, e video.Extractor, job *workspace.Job, id string, start, end time.Duration) (regions []Region, err error) {
+
+	source, err := downloadFile(ctx, d, job, id)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := e.Classify(ctx, source, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	regions = []Region{}
+	for _, r := range results {
+		regions = append(regions, Region{Kind: string(r.Kind), StartSeconds: r.Start.Seconds(), EndSeconds: r.End.Seconds()})
+	}
+	return regions, nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ssmall/nocco-video-extractor/pkg/video"
+)
+
+func TestClassificationHandler_HappyPath(t *testing.T) {
+	drive := &fakeDriveClient{
+		filename:     "concert.mp4",
+		fileContents: closingBuffer{bytes.NewBufferString("concert contents")},
+	}
+	extractor := &fakeExtractor{
+		regions: []video.Region{
+			{Kind: video.RegionApplause, Start: time.Minute, End: 75 * time.Second},
+			{Kind: video.RegionMusic, Start: 75 * time.Second, End: 2 * time.Minute},
+		},
+	}
+	handler := ClassificationHandler(drive, extractor)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, createRequest(t, `{"sourceFileId": "concert", "startTime": "00:01:00", "endTime": "00:02:00"}`))
+
+	if diff := cmp.Diff(http.StatusOK, rr.Code); diff != "" {
+		t.Fatal("Different response code than expected (+got -want):", diff, rr.Body)
+	}
+	if extractor.classifyStart != time.Minute || extractor.classifyEnd != 2*time.Minute {
+		t.Errorf("got Classify from %s to %s, want 1m0s to 2m0s", extractor.classifyStart, extractor.classifyEnd)
+	}
+	if extractor.classifyFile == "" {
+		t.Error("Expected the source to be downloaded")
+	}
+
+	expected := ClassificationResponse{Regions: []Region{
+		{Kind: "applause", StartSeconds: 60, EndSeconds: 75},
+		{Kind: "music", StartSeconds: 75, EndSeconds: 120},
+	}}
+	var actual ClassificationResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &actual); err != nil {
+		t.Fatalf("Invalid response %q: %v", rr.Body, err)
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Error("Different response than expected (-want +got):", diff)
+	}
+}
+
+func TestClassificationHandler_BadRequest(t *testing.T) {
+	cases := map[string]string{
+		"NoSource":       `{"startTime": "00:01:00"}`,
+		"BadTimestamp":   `{"sourceFileId": "concert", "startTime": "1:00"}`,
+		"EndBeforeStart": `{"sourceFileId": "concert", "startTime": "00:02:00", "endTime": "00:01:00"}`,
+	}
+	for name, requestJSON := range cases {
+		t.Run(name, func(t *testing.T) {
+			extractor := &fakeExtractor{}
+			handler := ClassificationHandler(&fakeDriveClient{}, extractor)
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, createRequest(t, requestJSON))
+
+			if diff := cmp.Diff(http.StatusBadRequest, rr.Code); diff != "" {
+				t.Error("Different response code than expected (+got -want):", diff)
+			}
+			if extractor.classifyFile != "" {
+				t.Error("Expected no classification to be attempted")
+			}
+		})
+	}
+}
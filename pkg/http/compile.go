@@ -0,0 +1,218 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ssmall/nocco-video-extractor/pkg/drive"
+	"github.com/ssmall/nocco-video-extractor/pkg/logging"
+	"github.com/ssmall/nocco-video-extractor/pkg/video"
+	"github.com/ssmall/nocco-video-extractor/pkg/workspace"
+)
+
+// defaultCompilationName is the name of an uploaded compilation whose request does not specify one
+const defaultCompilationName = "compilation.mp4"
+
+// CompilationHandler creates a http.HandlerFunc that handles requests to join segments
+// of one or more Google Drive files into a single video and upload it to Drive.
+func CompilationHandler(d drive.Client, e video.Extractor, opts ...HandlerOption) http.HandlerFunc {
+	cfg := newHandlerConfig(opts)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		var body CompilationRequest
+		if !decodeRequest(w, r, &body) {
+			return
+		}
+
+		logger := logging.FromContext(r.Context())
+		logger.WithFields(logging.Fields{
+			"segments":            len(body.Segments),
+			"destinationFolderId": body.DestinationFolderID,
+		}).Infof("Compilation of %d segments -> %s", len(body.Segments), body.DestinationFolderID)
+
+		segments, opts, err := cfg.compileOptions(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		cfg.serveJob(w, r, e, jobRequest{
+			callbackURL: body.CallbackURL,
+			priority:    body.Priority,
+			result:      CallbackResult{Compilation: &body},
+			space: func(ctx context.Context) (int64, error) {
+				size, err := totalSize(ctx, d, sourceFileIDs(body))
+				return requiredSpace(size), err
+			},
+			status: http.StatusCreated,
+			run: uploadJob(func(ctx context.Context, job *workspace.Job) (string, error) {
+				return compile(ctx, d, e, job, body, segments, opts)
+			}),
+		})
+	}
+}
+
+// compileOptions converts a compilation request to segments, without filenames, and CompileOptions,
+// returning an error if they are invalid
+func (cfg *handlerConfig) compileOptions(body CompilationRequest) ([]video.Segment, video.CompileOptions, error) {
+	var opts video.CompileOptions
+	segments := make([]video.Segment, len(body.Segments))
+	for i, s := range body.Segments {
+		if s.SourceFileID == "" {
+			return nil, opts, fmt.Errorf("segment %d has no sourceFileId", i+1)
+		}
+		start, err := parseDuration(s.ClipStartTime)
+		if err != nil {
+			return nil, opts, fmt.Errorf("segment %d: %w", i+1, err)
+		}
+		end, err := parseDuration(s.ClipEndTime)
+		if err != nil {
+			return nil, opts, fmt.Errorf("segment %d: %w", i+1, err)
+		}
+		segments[i] = video.Segment{Start: start, End: end}
+	}
+
+	if body.Preset != "" {
+		preset, ok := cfg.presets[body.Preset]
+		if !ok {
+			return nil, opts, fmt.Errorf("unknown preset %q", body.Preset)
+		}
+		opts.Preset = &preset
+	}
+	opts.Crossfade = fromSeconds(body.CrossfadeSeconds)
+	opts.Metadata = body.Metadata.metadata()
+
+	if err := video.ValidateSegments(segments, opts); err != nil {
+		return nil, opts, fmt.Errorf("invalid compilation: %w", err)
+	}
+	return segments, opts, nil
+}
+
+// sourceFileIDs returns the distinct Drive files from which a compilation's segments are taken
+func sourceFileIDs(body CompilationRequest) []string {
+	ids := make([]string, len(body.Segments))
+	for i, s := range body.Segments {
+		ids[i] = s.SourceFileID
+	}
+	return distinct(ids)
+}
+
+// compile downloads each source file of a compilation into the job directory once, joins the segments
+// and uploads the result to the destination folder.
+// Returns the URL of the uploaded compilation.
+func compile(ctx context.Context, d drive.Client, e video.Extractor, job *workspace.Job, body CompilationRequest, segments []video.Segment, opts video.CompileOptions) (string, error) {
+	logger := logging.FromContext(ctx)
+
+	files, err := downloadFiles(ctx, d, job, sourceFileIDs(body))
+	if err != nil {
+		return "", err
+	}
+	for i, s := range body.Segments {
+		segments[i].Filename = files[s.SourceFileID]
+	}
+
+	transcode, err := e.Compile(ctx, segments, opts)
+	if err != nil {
+		return "", err
+	}
+	defer transcode.Close()
+
+	name := outputName(body.OutputName, defaultCompilationName, ".mp4")
+
+	logger.Infof("Uploading compilation as %q", name)
+
+	return d.UploadFile(ctx, name, body.DestinationFolderID, transcode)
+}
+
+// outputName returns the name under which a file requested as name is uploaded, adding ext if it is missing,
+// or fallback if name is empty
+func outputName(name, fallback, ext string) string {
+	if name == "" {
+		return fallback
+	}
+	if !strings.EqualFold(filepath.Ext(name), ext) {
+		return name + ext
+	}
+	return name
+}
+
+// distinct returns ids without duplicates, in order of first occurrence
+func distinct(ids []string) []string {
+	var result []string
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// totalSize returns the total size of the Drive files with the given ids
+func totalSize(ctx context.Context, d drive.Client, ids []string) (int64, error) {
+	var size int64
+	for _, id := range ids {
+		info, err := d.Stat(ctx, id)
+		if err != nil {
+			return 0, err
+		}
+		size += info.Size
+	}
+	return size, nil
+}
+
+// downloadFiles downloads the Drive files with the given ids into the job directory,
+// returning their local paths by id
+func downloadFiles(ctx context.Context, d drive.Client, job *workspace.Job, ids []string) (map[string]string, error) {
+	files := make(map[string]string)
+	for _, id := range ids {
+		p, err := downloadFile(ctx, d, job, id)
+		if err != nil {
+			return nil, err
+		}
+		files[id] = p
+	}
+	return files, nil
+}
+
+// downloadFile downloads the Drive file with the given id into the job directory, returning the local path
+func downloadFile(ctx context.Context, d drive.Client, job *workspace.Job, id string) (string, error) {
+	filename, contents, err := d.GetFile(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	defer contents.Close()
+
+	f, err := ioutil.TempFile(job.Dir, "download-*"+path.Ext(filename))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if err := download(ctx, f, contents, filename); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
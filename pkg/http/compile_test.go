@@ -0,0 +1,161 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ssmall/nocco-video-extractor/pkg/video"
+)
+
+func TestCompilationHandler_HappyPath(t *testing.T) {
+	drive := &fakeDriveClient{
+		filename:       "concert.mp4",
+		fileContents:   closingBuffer{bytes.NewBufferString("original file contents")},
+		createdFileURL: "https://example.com",
+	}
+	extractor := &fakeExtractor{
+		contents: closingBuffer{bytes.NewBufferString("compilation contents")},
+	}
+	handler := CompilationHandler(drive, extractor)
+
+	requestJSON := `{
+		"segments": [
+			{"sourceFileId": "brahms", "clipStartTime": "00:00:45", "clipEndTime": "00:01:30"},
+			{"sourceFileId": "brahms", "clipStartTime": "00:12:00", "clipEndTime": "00:12:40"}
+		],
+		"destinationFolderId": "destinationFolderId",
+		"outputName": "promo",
+		"crossfadeSeconds": 1.5,
+		"preset": "web-720p"
+		}`
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, createRequest(t, requestJSON))
+
+	if diff := cmp.Diff(http.StatusCreated, rr.Code); diff != "" {
+		t.Fatal("Different response code than expected (+got -want):", diff, rr.Body)
+	}
+
+	if len(extractor.segments) != 2 || extractor.segments[0].Filename == "" || extractor.segments[0].Filename != extractor.segments[1].Filename {
+		t.Fatalf("Expected both segments to use the same downloaded file, got %+v", extractor.segments)
+	}
+	expectedSegments := []video.Segment{
+		{Filename: extractor.segments[0].Filename, Start: 45 * time.Second, End: 90 * time.Second},
+		{Filename: extractor.segments[0].Filename, Start: 12 * time.Minute, End: 12*time.Minute + 40*time.Second},
+	}
+	if diff := cmp.Diff(expectedSegments, extractor.segments); diff != "" {
+		t.Error("Segments different than expected (-want +got):", diff)
+	}
+
+	preset := video.BuiltinPresets()["web-720p"]
+	expectedOpts := video.CompileOptions{Crossfade: 1500 * time.Millisecond, Preset: &preset}
+	if diff := cmp.Diff(expectedOpts, extractor.compileOpts); diff != "" {
+		t.Error("Compile options different than expected (-want +got):", diff)
+	}
+
+	if drive.uploadFileName != "promo.mp4" || drive.uploadFileFolder != "destinationFolderId" {
+		t.Errorf("got UploadFile(context, %q, %q, ...), want UploadFile(context, %q, %q, ...)", drive.uploadFileName, drive.uploadFileFolder, "promo.mp4", "destinationFolderId")
+	}
+
+	var actual ExtractionResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &actual); err != nil {
+		t.Fatalf("Invalid response %q: %v", rr.Body, err)
+	}
+	if diff := cmp.Diff(ExtractionResponse{FileURL: drive.createdFileURL}, actual); diff != "" {
+		t.Error("Different response than expected (+got -want):", diff)
+	}
+}
+
+func TestCompilationHandler_BadRequest(t *testing.T) {
+	cases := map[string]string{
+		"NoSegments":       `{"segments": [], "destinationFolderId": "folder"}`,
+		"InvalidTime":      `{"segments": [{"sourceFileId": "a", "clipStartTime": "0:45", "clipEndTime": "00:01:30"}]}`,
+		"MissingSource":    `{"segments": [{"clipStartTime": "00:00:45", "clipEndTime": "00:01:30"}]}`,
+		"Reversed":         `{"segments": [{"sourceFileId": "a", "clipStartTime": "00:01:30", "clipEndTime": "00:00:45"}]}`,
+		"CrossfadeTooLong": `{"segments": [{"sourceFileId": "a", "clipStartTime": "00:00:00", "clipEndTime": "00:00:04"}], "crossfadeSeconds": 3}`,
+		"UnknownPreset":    `{"segments": [{"sourceFileId": "a", "clipStartTime": "00:00:00", "clipEndTime": "00:00:04"}], "preset": "nope"}`,
+	}
+	for name, requestJSON := range cases {
+		t.Run(name, func(t *testing.T) {
+			extractor := &fakeExtractor{}
+			handler := CompilationHandler(&fakeDriveClient{}, extractor)
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, createRequest(t, requestJSON))
+
+			if diff := cmp.Diff(http.StatusBadRequest, rr.Code); diff != "" {
+				t.Error("Different response code than expected (+got -want):", diff)
+			}
+			if extractor.segments != nil {
+				t.Error("Expected no compilation to be attempted")
+			}
+		})
+	}
+}
+
+func TestCompilationHandler_Callback(t *testing.T) {
+	drive := &fakeDriveClient{
+		filename:       "concert.mp4",
+		fileContents:   closingBuffer{bytes.NewBufferString("original file contents")},
+		createdFileURL: "https://example.com/compilation",
+	}
+	extractor := &fakeExtractor{
+		contents: closingBuffer{bytes.NewBufferString("compilation contents")},
+	}
+	sender := newFakeSender()
+	handler := CompilationHandler(drive, extractor, WithCallbacks(sender))
+
+	requestJSON := `{
+		"segments": [{"sourceFileId": "brahms", "clipStartTime": "00:00:45", "clipEndTime": "00:01:30"}],
+		"destinationFolderId": "destinationFolderId",
+		"callbackUrl": "https://example.com/callback"
+		}`
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, createRequest(t, requestJSON))
+
+	if diff := cmp.Diff(http.StatusAccepted, rr.Code); diff != "" {
+		t.Fatal("Different response code than expected (+got -want):", diff)
+	}
+	var accepted AcceptedResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &accepted); err != nil {
+		t.Fatalf("Invalid response %q: %v", rr.Body, err)
+	}
+
+	sent := sender.wait(t)
+
+	var actual CallbackResult
+	if err := json.Unmarshal(sent.payload, &actual); err != nil {
+		t.Fatalf("Invalid callback payload %q: %v", sent.payload, err)
+	}
+	expected := CallbackResult{JobID: accepted.JobID, Status: JobStatusSucceeded, FileURL: drive.createdFileURL}
+	if err := json.Unmarshal([]byte(requestJSON), &expected.Compilation); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Error("Different callback payload than expected (+got -want):", diff)
+	}
+
+	if drive.uploadFileName != defaultCompilationName {
+		t.Errorf("got upload named %q, want %q", drive.uploadFileName, defaultCompilationName)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/ssmall/nocco-video-extractor/pkg/drive"
+	"github.com/ssmall/nocco-video-extractor/pkg/logging"
+	"github.com/ssmall/nocco-video-extractor/pkg/video"
+	"github.com/ssmall/nocco-video-extractor/pkg/workspace"
+)
+
+// defaultCompositionName is the name of an uploaded composition whose request does not specify one
+const defaultCompositionName = "composition.mp4"
+
+// CompositionHandler creates a http.HandlerFunc that handles requests to draw several Google Drive files
+// side by side in a single video, such as the parts of a virtual ensemble, and upload it to Drive.
+func CompositionHandler(d drive.Client, e video.Extractor, opts ...HandlerOption) http.HandlerFunc {
+	cfg := newHandlerConfig(opts)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		var body CompositionRequest
+		if !decodeRequest(w, r, &body) {
+			return
+		}
+
+		logger := logging.FromContext(r.Context())
+		logger.WithFields(logging.Fields{
+			"parts":               len(body.Parts),
+			"destinationFolderId": body.DestinationFolderID,
+		}).Infof("Composition of %d parts -> %s", len(body.Parts), body.DestinationFolderID)
+
+		parts, opts, err := composeOptions(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		cfg.serveJob(w, r, e, jobRequest{
+			callbackURL: body.CallbackURL,
+			priority:    body.Priority,
+			result:      CallbackResult{Composition: &body},
+			space: func(ctx context.Context) (int64, error) {
+				size, err := totalSize(ctx, d, partFileIDs(body))
+				return requiredSpace(size), err
+			},
+			status: http.StatusCreated,
+			run: uploadJob(func(ctx context.Context, job *workspace.Job) (string, error) {
+				return compose(ctx, d, e, job, body, parts, opts)
+			}),
+		})
+	}
+}
+
+// composeOptions converts a composition request to parts, without filenames, and ComposeOptions,
+// returning an error if they are invalid
+func composeOptions(body CompositionRequest) ([]video.Part, video.ComposeOptions, error) {
+	opts := video.ComposeOptions{Width: body.Width, Height: body.Height, Metadata: body.Metadata.metadata()}
+	parts := make([]video.Part, len(body.Parts))
+	for i, p := range body.Parts {
+		if p.SourceFileID == "" {
+			return nil, opts, fmt.Errorf("part %d has no sourceFileId", i+1)
+		}
+		parts[i] = video.Part{Offset: fromSeconds(p.OffsetSeconds), GainDB: p.GainDB}
+	}
+	if body.AutoAlign != nil {
+		if err := body.AutoAlign.options().Validate(); err != nil {
+			return nil, opts, fmt.Errorf("invalid alignment: %w", err)
+		}
+	}
+	for _, c := range body.Layout {
+		opts.Layout = append(opts.Layout, video.Cell{X: c.X, Y: c.Y, Width: c.Width, Height: c.Height})
+	}
+
+	if err := video.ValidateParts(parts, opts); err != nil {
+		return nil, opts, fmt.Errorf("invalid composition: %w", err)
+	}
+	return parts, opts, nil
+}
+
+// partFileIDs returns the distinct Drive files shown in a composition
+func partFileIDs(body CompositionRequest) []string {
+	ids := make([]string, len(body.Parts))
+	for i, p := range body.Parts {
+		ids[i] = p.SourceFileID
+	}
+	return distinct(ids)
+}
+
+// compose downloads each file of a composition into the job directory, composes them
+// and uploads the result to the destination folder.
+// Returns the URL of the uploaded composition.
+func compose(ctx context.Context, d drive.Client, e video.Extractor, job *workspace.Job, body CompositionRequest, parts []video.Part, opts video.ComposeOptions) (string, error) {
+	logger := logging.FromContext(ctx)
+
+	files, err := downloadFiles(ctx, d, job, partFileIDs(body))
+	if err != nil {
+		return "", err
+	}
+	for i, p := range body.Parts {
+		parts[i].Filename = files[p.SourceFileID]
+	}
+
+	if body.AutoAlign != nil {
+		filenames := make([]string, len(parts))
+		for i, p := range parts {
+			filenames[i] = p.Filename
+		}
+		offsets, err := autoAlign(ctx, e, filenames, *body.AutoAlign)
+		if err != nil {
+			return "", err
+		}
+		for i := range parts {
+			parts[i].Offset += offsets[i]
+		}
+	}
+
+	transcode, err := e.Compose(ctx, parts, opts)
+	if err != nil {
+		return "", err
+	}
+	defer transcode.Close()
+
+	name := outputName(body.OutputName, defaultCompositionName, ".mp4")
+	logger.Infof("Uploading composition as %q", name)
+
+	return d.UploadFile(ctx, name, body.DestinationFolderID, transcode)
+}
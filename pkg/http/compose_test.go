@@ -0,0 +1,105 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/ssmall/nocco-video-extractor/pkg/video"
+)
+
+func TestCompositionHandler_HappyPath(t *testing.T) {
+	drive := &fakeDriveClient{
+		filename:       "part.mov",
+		fileContents:   closingBuffer{bytes.NewBufferString("part contents")},
+		createdFileURL: "https://example.com",
+	}
+	extractor := &fakeExtractor{
+		contents: closingBuffer{bytes.NewBufferString("composition contents")},
+	}
+	handler := CompositionHandler(drive, extractor)
+
+	requestJSON := `{
+		"parts": [
+			{"sourceFileId": "violin", "offsetSeconds": 1.5},
+			{"sourceFileId": "cello", "offsetSeconds": -0.25, "gainDb": -3}
+		],
+		"destinationFolderId": "destinationFolderId",
+		"width": 1280,
+		"height": 720,
+		"layout": [{"x": 0, "y": 0, "width": 640, "height": 720}, {"x": 640, "y": 0, "width": 640, "height": 720}]
+		}`
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, createRequest(t, requestJSON))
+
+	if diff := cmp.Diff(http.StatusCreated, rr.Code); diff != "" {
+		t.Fatal("Different response code than expected (+got -want):", diff, rr.Body)
+	}
+
+	expectedParts := []video.Part{
+		{Offset: 1500 * time.Millisecond},
+		{Offset: -250 * time.Millisecond, GainDB: -3},
+	}
+	if diff := cmp.Diff(expectedParts, extractor.parts, cmpopts.IgnoreFields(video.Part{}, "Filename")); diff != "" {
+		t.Error("Parts different than expected (-want +got):", diff)
+	}
+	for _, p := range extractor.parts {
+		if p.Filename == "" {
+			t.Errorf("Expected part %+v to have been downloaded", p)
+		}
+	}
+
+	expectedOpts := video.ComposeOptions{Width: 1280, Height: 720, Layout: []video.Cell{{X: 0, Y: 0, Width: 640, Height: 720}, {X: 640, Y: 0, Width: 640, Height: 720}}}
+	if diff := cmp.Diff(expectedOpts, extractor.composeOpts); diff != "" {
+		t.Error("Compose options different than expected (-want +got):", diff)
+	}
+
+	if drive.uploadFileName != defaultCompositionName || drive.uploadFileFolder != "destinationFolderId" {
+		t.Errorf("got UploadFile(context, %q, %q, ...), want UploadFile(context, %q, %q, ...)", drive.uploadFileName, drive.uploadFileFolder, defaultCompositionName, "destinationFolderId")
+	}
+}
+
+func TestCompositionHandler_BadRequest(t *testing.T) {
+	cases := map[string]string{
+		"NoParts":       `{"parts": []}`,
+		"MissingSource": `{"parts": [{"offsetSeconds": 1}]}`,
+		"TooLoud":       `{"parts": [{"sourceFileId": "a", "gainDb": 40}]}`,
+		"WindowTooLong": `{"parts": [{"sourceFileId": "a"}, {"sourceFileId": "b"}], "autoAlign": {"windowSeconds": 10800}}`,
+		"MissingCell":   `{"parts": [{"sourceFileId": "a"}, {"sourceFileId": "b"}], "layout": [{"x": 0, "y": 0, "width": 640, "height": 360}]}`,
+	}
+	for name, requestJSON := range cases {
+		t.Run(name, func(t *testing.T) {
+			extractor := &fakeExtractor{}
+			handler := CompositionHandler(&fakeDriveClient{}, extractor)
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, createRequest(t, requestJSON))
+
+			if diff := cmp.Diff(http.StatusBadRequest, rr.Code); diff != "" {
+				t.Error("Different response code than expected (+got -want):", diff)
+			}
+			if extractor.parts != nil {
+				t.Error("Expected no composition to be attempted")
+			}
+		})
+	}
+}
@@ -16,123 +16,509 @@
 package http
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/ssmall/nocco-video-extractor/pkg/drive"
+	"github.com/ssmall/nocco-video-extractor/pkg/logging"
+	"github.com/ssmall/nocco-video-extractor/pkg/marker"
+	"github.com/ssmall/nocco-video-extractor/pkg/tracing"
 	"github.com/ssmall/nocco-video-extractor/pkg/video"
+	"github.com/ssmall/nocco-video-extractor/pkg/webhook"
+	"github.com/ssmall/nocco-video-extractor/pkg/workspace"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
 )
 
+// unavailableRetryAfter is how long clients are asked to wait before retrying when
+// the Extractor is saturated or there is not enough disk space for the job
+const unavailableRetryAfter = 30 * time.Second
+
+// HandlerOption configures optional behaviour of the ClipExtractionHandler and the handlers of other jobs
+type HandlerOption func(*handlerConfig)
+
+type handlerConfig struct {
+	webhooks   webhook.Sender
+	deliveries *webhook.Store
+	workspace  *workspace.Workspace
+
+	presets   map[string]video.Preset
+	pipelines map[string]Pipeline
+	markers   *marker.Store
+
+	watermarks bool
+	logoPath   string
+	logoFileID string
+	assets     *drive.FileCache
+
+	bumpers     bool
+	introPath   string
+	introFileID string
+	outroPath   string
+	outroFileID string
+}
+
+func newHandlerConfig(opts []HandlerOption) handlerConfig {
+	cfg := handlerConfig{workspace: workspace.Default(), presets: video.BuiltinPresets()}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// WithCallbacks enables asynchronous processing of requests that specify a callback URL.
+// The result of each such request is delivered to its callback URL using s.
+func WithCallbacks(s webhook.Sender) HandlerOption {
+	return func(c *handlerConfig) {
+		c.webhooks = s
+	}
+}
+
+// WithDeliveries records the delivery of each asynchronous job's result in s, under the ID of the job
+func WithDeliveries(s *webhook.Store) HandlerOption {
+	return func(c *handlerConfig) {
+		c.deliveries = s
+	}
+}
+
+// WithWorkspace sets the workspace in which jobs create their temporary files.
+// By default, an unbudgeted workspace in the system temp directory is used.
+func WithWorkspace(ws *workspace.Workspace) HandlerOption {
+	return func(c *handlerConfig) {
+		c.workspace = ws
+	}
+}
+
+// WithWatermarks enables requests to draw a logo over their clips.
+// The default logo is read from the local file logoPath or, if that is empty, from the Drive file logoFileID.
+// Logos from Drive, including those requested by file ID, are downloaded once and kept in assets.
+func WithWatermarks(logoPath, logoFileID string, assets *drive.FileCache) HandlerOption {
+	return func(c *handlerConfig) {
+		c.watermarks = true
+		c.logoPath = logoPath
+		c.logoFileID = logoFileID
+		c.assets = assets
+	}
+}
+
+// WithBumpers enables requests to join a branded intro and outro to their clips.
+// Each bumper is read from a local path or, if that is empty, from a Drive file ID; either bumper may be left unset.
+// Bumpers from Drive are downloaded once and kept in assets.
+func WithBumpers(introPath, introFileID, outroPath, outroFileID string, assets *drive.FileCache) HandlerOption {
+	return func(c *handlerConfig) {
+		c.bumpers = true
+		c.introPath = introPath
+		c.introFileID = introFileID
+		c.outroPath = outroPath
+		c.outroFileID = outroFileID
+		c.assets = assets
+	}
+}
+
+// WithPresets sets the output presets that requests may select. By default, video.BuiltinPresets are available.
+func WithPresets(presets map[string]video.Preset) HandlerOption {
+	return func(c *handlerConfig) {
+		c.presets = presets
+	}
+}
+
 // ClipExtractionHandler creates a http.HandlerFunc that handles requests to
 // extract video clips from Google Drive files and reupload them to Drive.
-func ClipExtractionHandler(d drive.Client, e video.Extractor) http.HandlerFunc {
+func ClipExtractionHandler(d drive.Client, e video.Extractor, opts ...HandlerOption) http.HandlerFunc {
+	cfg := newHandlerConfig(opts)
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer r.Body.Close()
 		var body ExtractionRequest
-		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(err.Error()))
+		if !decodeRequest(w, r, &body) {
 			return
 		}
 
-		log.Printf("Request %s[%s,%s] -> %s", body.SourceFileID, body.ClipStartTime, body.ClipEndTime, body.DestinationFolderID)
+		logger := logging.FromContext(r.Context())
+		logger.WithFields(logging.Fields{
+			"sourceFileId":        body.SourceFileID,
+			"clipStartTime":       body.ClipStartTime,
+			"clipEndTime":         body.ClipEndTime,
+			"destinationFolderId": body.DestinationFolderID,
+		}).Infof("Request %s[%s,%s] -> %s", body.SourceFileID, body.ClipStartTime, body.ClipEndTime, body.DestinationFolderID)
 
-		start, err := parseDuration(body.ClipStartTime)
+		start, end, err := cfg.clipInterval(&body)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte(err.Error()))
 			return
 		}
 
-		end, err := parseDuration(body.ClipEndTime)
+		opts, err := cfg.clipOptions(body, end-start)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte(err.Error()))
 			return
 		}
-
-		filename, contents, err := d.GetFile(r.Context(), body.SourceFileID)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+		if err := cfg.checkAssets(r.Context(), body); err != nil {
+			if errors.Is(err, errMissingAsset) {
+				w.WriteHeader(http.StatusBadRequest)
+			} else {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
 			w.Write([]byte(err.Error()))
 			return
 		}
-		defer contents.Close()
 
-		f, err := ioutil.TempFile(os.TempDir(), "download-*"+path.Ext(filename))
+		var info *drive.FileInfo
+		cfg.serveJob(w, r, e, jobRequest{
+			callbackURL: body.CallbackURL,
+			priority:    body.Priority,
+			result:      CallbackResult{Request: &body},
+			space: func(ctx context.Context) (int64, error) {
+				var err error
+				if info, err = d.Stat(ctx, body.SourceFileID); err != nil {
+					return 0, err
+				}
+				if err := cfg.fetchAssets(ctx, body, &opts); err != nil {
+					return 0, err
+				}
+				if video.MayStream(info.Name) && !opts.RequiresSeeking() {
+					// Streamed clips need no disk space; if the source turns out to require seeking,
+					// extractClip reserves space for it before downloading
+					return 0, nil
+				}
+				return requiredSpace(info.Size), nil
+			},
+			status: http.StatusCreated,
+			run: uploadJob(func(ctx context.Context, job *workspace.Job) (string, error) {
+				return extractClip(ctx, d, e, job, info.Size, body, start, end, opts)
+			}),
+		})
+	}
+}
+
+// requiredSpace estimates the disk space needed to process a source file of the given size.
+// The source is downloaded in full, and a clip is assumed to be no larger than its source.
+func requiredSpace(sourceSize int64) int64 {
+	return 2 * sourceSize
+}
+
+// extractClip extracts the requested clip from the source file and uploads it to the destination folder.
+// If the source's container can be read sequentially and opts allow it, it is clipped as it is downloaded and the clip is
+// uploaded as it is produced. Otherwise, the source is downloaded into the job directory first,
+// after reserving disk space for a source of the given size.
+// Returns the URL of the uploaded clip.
+func extractClip(ctx context.Context, d drive.Client, e video.Extractor, job *workspace.Job, size int64, body ExtractionRequest, start, end time.Duration, opts video.ClipOptions) (string, error) {
+	logger := logging.FromContext(ctx)
+
+	filename, contents, err := d.GetFile(ctx, body.SourceFileID)
+	if err != nil {
+		return "", err
+	}
+	defer contents.Close()
+
+	source := bufio.NewReaderSize(contents, video.SniffLen)
+	header, err := source.Peek(video.SniffLen)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	var transcode io.ReadCloser
+	if c, ok := video.DetectContainer(filename, header); ok && opts.CanStream(c) {
+		logger.Infof("Streaming %q as %s", filename, c)
+		transcode, err = e.ClipStream(ctx, source, c, start, end, opts)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(err.Error()))
-			return
+			return "", err
+		}
+	} else {
+		if err := job.Reserve(requiredSpace(size)); err != nil {
+			return "", err
+		}
+
+		f, err := ioutil.TempFile(job.Dir, "download-*"+path.Ext(filename))
+		if err != nil {
+			return "", err
 		}
 
 		defer f.Close()
-		defer func() {
-			if err := os.Remove(f.Name()); err != nil {
-				log.Printf("Error deleting file %s: %v", f.Name(), err)
-			} else {
-				log.Println("Deleted", f.Name())
-			}
-		}()
 
-		log.Printf("Downloading %q to %s", filename, f.Name())
-		_, err = io.Copy(f, contents)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(err.Error()))
-			return
+		if err := download(ctx, f, source, filename); err != nil {
+			return "", err
 		}
-		log.Printf("Finished downloading %q to %s", filename, f.Name())
 
-		transcode, err := e.Clip(r.Context(), f.Name(), start, end)
+		transcode, err = e.Clip(ctx, f.Name(), start, end, opts)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(err.Error()))
-			return
+			return "", err
 		}
+	}
 
-		defer transcode.Close()
+	defer transcode.Close()
 
-		ext := filepath.Ext(filename)
-		base := strings.TrimSuffix(filename, ext)
-		newFilename := fmt.Sprintf("%s_%s_to_%s%s", base, body.ClipStartTime, body.ClipEndTime, ext)
+	output := clipOutputName(filename, opts)
+	newFilename := clipName(output, body.ClipStartTime, body.ClipEndTime)
+	if body.Privacy != nil {
+		if newFilename, err = anonymousName(output, body.Privacy.AnonymousID); err != nil {
+			return "", err
+		}
+	}
 
-		log.Printf("Uploading clip as %q", newFilename)
+	logger.Infof("Uploading clip as %q", newFilename)
 
-		url, err := d.UploadFile(r.Context(), newFilename, body.DestinationFolderID, transcode)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+	return d.UploadFile(ctx, newFilename, body.DestinationFolderID, transcode)
+}
+
+// clipOutputName returns filename with the extension of its clip written with opts,
+// which differs from its own if the clip is written in another container
+func clipOutputName(filename string, opts video.ClipOptions) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + video.ClipExtension(filename, opts)
+}
+
+// clipName names the clip of filename between the timestamps start and end
+func clipName(filename, start, end string) string {
+	ext := filepath.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+	return fmt.Sprintf("%s_%s_to_%s%s", base, start, end, ext)
+}
+
+// anonymousName names the clip of filename after id, or a random ID if it is empty, keeping its extension
+func anonymousName(filename, id string) (string, error) {
+	if id == "" {
+		var err error
+		if id, err = newJobID(); err != nil {
+			return "", err
+		}
+	}
+	return id + filepath.Ext(filename), nil
+}
+
+// jobRequest is the work of a decoded and validated request, which serveJob runs in a workspace job
+type jobRequest struct {
+	// callbackURL, if set, makes the request asynchronous, with its outcome delivered as result
+	callbackURL string
+	priority    int
+	result      CallbackResult
+	// space returns the disk space to reserve for the job, typically from the sizes of its Drive files
+	space func(ctx context.Context) (int64, error)
+	// status is the status of a synchronous response
+	status int
+	// run does the work in job, returning the body of a synchronous response.
+	// It records the outcome of the work in result, to be delivered to the callback URL.
+	// If it fails after completing part of the work, it may return a body describing that part along with the error.
+	run func(ctx context.Context, job *workspace.Job, result *CallbackResult) (interface{}, error)
+}
+
+// decodeRequest decodes the JSON body of r into body, responding 400 Bad Request if it cannot be decoded
+func decodeRequest(w http.ResponseWriter, r *http.Request, body interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return false
+	}
+	return true
+}
+
+// serveJob runs the work of req in a new workspace job and then closes the job. The request is rejected if its
+// callback URL is invalid, e is saturated or there is not enough disk space for the job.
+// If req has a callback URL, the request is accepted and the work runs asynchronously, with its outcome
+// delivered to the callback URL. Otherwise, the response is written once the work returns.
+func (cfg *handlerConfig) serveJob(w http.ResponseWriter, r *http.Request, e video.Extractor, req jobRequest) {
+	if req.callbackURL != "" {
+		if err := validateCallbackURL(cfg.webhooks, req.callbackURL); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte(err.Error()))
 			return
 		}
+	}
+
+	if s, ok := e.(video.Saturater); ok && s.Saturated() {
+		rejectUnavailable(w, video.ErrQueueFull)
+		return
+	}
+
+	space, err := req.space(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	job, err := cfg.workspace.NewJob(space)
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+
+	if req.callbackURL != "" {
+		acceptJob(w, r, job, req.priority, req.callbackURL, func(ctx context.Context, jobID string) {
+			result := req.result
+			result.JobID = jobID
+			_, err := runJob(ctx, job, req, &result)
+			notifyCallback(ctx, cfg.webhooks, cfg.deliveries, req.callbackURL, result, err)
+		})
+		return
+	}
+
+	defer closeJob(r.Context(), job)
+	resp, err := runJob(video.WithPriority(r.Context(), req.priority), job, req, &req.result)
+	if err != nil && resp == nil {
+		writeJobError(w, err)
+		return
+	}
+	status := req.status
+	if err != nil {
+		// The work failed after completing part of it, which resp describes
+		status = jobErrorStatus(err)
+	}
+
+	b, err := json.Marshal(resp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", strconv.Itoa(int(unavailableRetryAfter.Seconds())))
+	}
+	w.WriteHeader(status)
+	w.Write(b)
+}
 
-		resp, err := json.Marshal(&ExtractionResponse{FileURL: url})
+// uploadJob adapts work that uploads a file and returns its URL to the run of a jobRequest,
+// which responds with an ExtractionResponse
+func uploadJob(work func(ctx context.Context, job *workspace.Job) (string, error)) func(context.Context, *workspace.Job, *CallbackResult) (interface{}, error) {
+	return func(ctx context.Context, job *workspace.Job, result *CallbackResult) (interface{}, error) {
+		url, err := work(ctx, job)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(err.Error()))
-			return
+			return nil, err
 		}
+		result.FileURL = url
+		return &ExtractionResponse{FileURL: url}, nil
+	}
+}
+
+// runJob does the work of req in job, counting it in the job metrics
+func runJob(ctx context.Context, job *workspace.Job, req jobRequest, result *CallbackResult) (resp interface{}, err error) {
+	jobsInFlight.Inc()
+	defer jobsInFlight.Dec()
+	defer func() {
+		observeJob(err)
+	}()
+
+	return req.run(ctx, job, result)
+}
+
+// acceptJob responds 202 Accepted with a new job ID and runs the job in the background with that ID,
+// closing it once run returns. run is responsible for delivering the result to callbackURL.
+func acceptJob(w http.ResponseWriter, r *http.Request, job *workspace.Job, priority int, callbackURL string, run func(ctx context.Context, jobID string)) {
+	jobID, err := newJobID()
+	if err != nil {
+		job.Close()
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	resp, err := json.Marshal(&AcceptedResponse{JobID: jobID})
+	if err != nil {
+		job.Close()
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	jobLogger := logging.FromContext(r.Context()).With("jobId", jobID)
+	jobLogger.Infof("Job %s accepted, result will be delivered to %s", jobID, callbackURL)
+	go func() {
+		ctx := logging.NewContext(tracing.Detach(r.Context()), jobLogger)
+		ctx, cancel := context.WithTimeout(video.WithPriority(ctx, priority), asyncJobTimeout)
+		defer cancel()
+		defer closeJob(ctx, job)
+		run(ctx, jobID)
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(resp)
+}
+
+// writeJobError responds to a request whose job could not be created or failed
+func writeJobError(w http.ResponseWriter, err error) {
+	status := jobErrorStatus(err)
+	if status == http.StatusServiceUnavailable {
+		rejectUnavailable(w, err)
+		return
+	}
+	w.WriteHeader(status)
+	w.Write([]byte(err.Error()))
+}
+
+// jobErrorStatus returns the status of the response to a request whose job failed with err
+func jobErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, video.ErrInvalidCoverArt):
+		return http.StatusBadRequest
+	case errors.Is(err, video.ErrQueueFull), errors.Is(err, workspace.ErrInsufficientSpace):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, workspace.ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, video.ErrTargetTooSmall), errors.Is(err, video.ErrInvalidTrim):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, errCandidateInUse):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// rejectUnavailable responds to a request that cannot be processed now but may succeed if retried later
+func rejectUnavailable(w http.ResponseWriter, err error) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(unavailableRetryAfter.Seconds())))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write([]byte(err.Error()))
+}
+
+// closeJob removes the job's temporary files and releases its disk reservation
+func closeJob(ctx context.Context, job *workspace.Job) {
+	logger := logging.FromContext(ctx)
+	if err := job.Close(); err != nil {
+		logger.WithError(err).Warnf("Error deleting job directory %s", job.Dir)
+	} else {
+		logger.Debugf("Deleted %s", job.Dir)
+	}
+}
+
+// download copies the contents of a Drive file to the local file f
+func download(ctx context.Context, f *os.File, contents io.Reader, filename string) (err error) {
+	ctx, span := tracer.Start(ctx, "download", trace.WithAttributes(label.String("file.path", f.Name())))
+	defer func() {
+		tracing.End(ctx, span, err)
+	}()
 
-		w.WriteHeader(http.StatusCreated)
-		w.Write(resp)
+	logger := logging.FromContext(ctx)
+	logger.Infof("Downloading %q to %s", filename, f.Name())
+	n, err := io.Copy(f, contents)
+	span.SetAttributes(label.Int64("file.bytes", n))
+	if err != nil {
+		return err
 	}
+	logger.With("bytes", n).Infof("Finished downloading %q to %s", filename, f.Name())
+	return nil
 }
 
 func parseDuration(timestamp string) (time.Duration, error) {
-	r := regexp.MustCompile(`^(\d{2}):(\d{2}):(\d{2})$`)
+	r := regexp.MustCompile(`^(\d{2}):(\d{2}):(\d{2}(?:\.\d{1,3})?)$`)
 	matches := r.FindStringSubmatch(timestamp)
 	if matches == nil {
-		return 0, fmt.Errorf("%q does not match format HH:MM:SS", timestamp)
+		return 0, fmt.Errorf("%q does not match format HH:MM:SS or HH:MM:SS.mmm", timestamp)
 	}
 	return time.ParseDuration(fmt.Sprintf("%sh%sm%ss", matches[1], matches[2], matches[3]))
 }
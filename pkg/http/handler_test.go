@@ -19,15 +19,21 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"path/filepath"
+	"regexp"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/ssmall/nocco-video-extractor/pkg/drive"
+	"github.com/ssmall/nocco-video-extractor/pkg/video"
+	"github.com/ssmall/nocco-video-extractor/pkg/webhook"
+	"github.com/ssmall/nocco-video-extractor/pkg/workspace"
 )
 
 type closingBuffer struct {
@@ -45,10 +51,12 @@ func (b *closingBuffer) Read(p []byte) (n int, err error) {
 type fakeDriveClient struct {
 	// Stub outputs
 	filename       string
+	fileSize       int64
 	fileContents   closingBuffer
 	createdFileURL string
 
 	// Stub errors
+	statError    error
 	getFileError error
 	uploadError  error
 
@@ -67,6 +75,13 @@ func (c *fakeDriveClient) GetFile(ctx context.Context, id string) (string, io.Re
 	return c.filename, &c.fileContents, nil
 }
 
+func (c *fakeDriveClient) Stat(ctx context.Context, id string) (*drive.FileInfo, error) {
+	if c.statError != nil {
+		return nil, c.statError
+	}
+	return &drive.FileInfo{Name: c.filename, Size: c.fileSize}, nil
+}
+
 func (c *fakeDriveClient) UploadFile(ctx context.Context, name, folder string, contents io.Reader) (string, error) {
 	if c.uploadError != nil {
 		return "", c.uploadError
@@ -86,21 +101,159 @@ type fakeExtractor struct {
 	err error
 
 	// Capture inputs
-	clipFilename string
-	clipStart    time.Duration
-	clipEnd      time.Duration
+	clipFilename  string
+	clipStart     time.Duration
+	clipEnd       time.Duration
+	streamInput   []byte
+	streamFormat  video.Container
+	clipOptions   video.ClipOptions
+	segments      []video.Segment
+	compileOpts   video.CompileOptions
+	parts         []video.Part
+	composeOpts   video.ComposeOptions
+	tracks        []video.Track
+	mixOpts       video.MixOptions
+	alignInput    []string
+	alignOpts     video.AlignOptions
+	alignments    []video.Alignment
+	silenceInput  string
+	silenceOpts   video.SilenceOptions
+	silences      video.SilenceReport
+	classifyFile  string
+	classifyStart time.Duration
+	classifyEnd   time.Duration
+	regions       []video.Region
+	sceneFile     string
+	sceneOpts     video.SceneOptions
+	scenes        []video.Scene
 }
 
-func (e *fakeExtractor) Clip(ctx context.Context, filename string, start time.Duration, end time.Duration) (io.ReadCloser, error) {
+func (e *fakeExtractor) Clip(ctx context.Context, filename string, start time.Duration, end time.Duration, opts video.ClipOptions) (io.ReadCloser, error) {
 	if e.err != nil {
 		return nil, e.err
 	}
+	e.clipOptions = opts
 	e.clipFilename = filename
 	e.clipStart = start
 	e.clipEnd = end
 	return &e.contents, nil
 }
 
+func (e *fakeExtractor) ClipStream(ctx context.Context, r io.Reader, c video.Container, start time.Duration, end time.Duration, opts video.ClipOptions) (io.ReadCloser, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	e.clipOptions = opts
+	var err error
+	e.streamInput, err = ioutil.ReadAll(r)
+	e.streamFormat = c
+	e.clipStart = start
+	e.clipEnd = end
+	return &e.contents, err
+}
+
+func (e *fakeExtractor) Compile(ctx context.Context, segments []video.Segment, opts video.CompileOptions) (io.ReadCloser, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	e.segments = segments
+	e.compileOpts = opts
+	return &e.contents, nil
+}
+
+func (e *fakeExtractor) Compose(ctx context.Context, parts []video.Part, opts video.ComposeOptions) (io.ReadCloser, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	e.parts = parts
+	e.composeOpts = opts
+	return &e.contents, nil
+}
+
+func (e *fakeExtractor) Mixdown(ctx context.Context, tracks []video.Track, opts video.MixOptions) (io.ReadCloser, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	e.tracks = tracks
+	e.mixOpts = opts
+	return &e.contents, nil
+}
+
+func (e *fakeExtractor) DetectSilence(ctx context.Context, filename string, opts video.SilenceOptions) (*video.SilenceReport, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	e.silenceInput = filename
+	e.silenceOpts = opts
+	return &e.silences, nil
+}
+
+func (e *fakeExtractor) Classify(ctx context.Context, filename string, start, end time.Duration) ([]video.Region, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	e.classifyFile = filename
+	e.classifyStart = start
+	e.classifyEnd = end
+	return e.regions, nil
+}
+
+func (e *fakeExtractor) DetectScenes(ctx context.Context, filename string, start, end time.Duration, opts video.SceneOptions) ([]video.Scene, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	e.sceneFile = filename
+	e.sceneOpts = opts
+	return e.scenes, nil
+}
+
+func (e *fakeExtractor) Align(ctx context.Context, filenames []string, opts video.AlignOptions) ([]video.Alignment, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	e.alignInput = filenames
+	e.alignOpts = opts
+	return e.alignments, nil
+}
+
+type fakeSender struct {
+	// Stub errors
+	err error
+
+	// Capture inputs
+	sent chan sentWebhook
+}
+
+type sentWebhook struct {
+	url     string
+	payload []byte
+	// ctxErr is the error of the context in which the webhook was sent, when it was sent
+	ctxErr error
+}
+
+func newFakeSender() *fakeSender {
+	return &fakeSender{sent: make(chan sentWebhook, 1)}
+}
+
+func (s *fakeSender) Send(ctx context.Context, url string, payload []byte) (*webhook.Delivery, error) {
+	s.sent <- sentWebhook{url, payload, ctx.Err()}
+	if s.err != nil {
+		return &webhook.Delivery{URL: url, Attempts: []webhook.Attempt{{Error: s.err.Error()}}}, s.err
+	}
+	return &webhook.Delivery{URL: url, Attempts: []webhook.Attempt{{StatusCode: http.StatusOK}}, Succeeded: true}, nil
+}
+
+func (s *fakeSender) wait(t *testing.T) sentWebhook {
+	t.Helper()
+	select {
+	case w := <-s.sent:
+		return w
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for webhook")
+	}
+	return sentWebhook{}
+}
+
 func createRequest(t *testing.T, requestJSON string) *http.Request {
 	t.Helper()
 	req, err := http.NewRequest(http.MethodPost, "/extract", bytes.NewBuffer([]byte(requestJSON)))
@@ -128,6 +281,10 @@ func TestParseDuration(t *testing.T) {
 			input:    "00:00:90",
 			expected: 90 * time.Second,
 		},
+		{
+			input:    "00:01:18.25",
+			expected: 78*time.Second + 250*time.Millisecond,
+		},
 	}
 	for _, test := range cases {
 		t.Run(test.input, func(t *testing.T) {
@@ -186,7 +343,8 @@ func TestHandler_HappyPath(t *testing.T) {
 		t.Errorf("got Clip(context, <filename>%s, %s, %s), want Clip(context, <filename>%s, %s, %s)", clipExt, extractor.clipStart, extractor.clipEnd, filepath.Ext(drive.filename), expectedStart, expectedEnd)
 	}
 
-	expectedUploadName := "originalFile_00:01:23_to_00:02:34.fileExt"
+	// Clips of files in unknown containers are written as MP4
+	expectedUploadName := "originalFile_00:01:23_to_00:02:34.mp4"
 	if drive.uploadFileName != expectedUploadName || drive.uploadFileFolder != "destinationFolderId" || cmp.Diff(drive.uploadFileContents, []byte("clip contents")) != "" {
 		t.Errorf("got UploadFile(context, %q, %q, %q), want UploadFile(context, %q, %q, %q)", drive.uploadFileName, drive.uploadFileFolder, drive.uploadFileContents, expectedUploadName, "destinationFolderId", []byte("clip contents"))
 	}
@@ -239,6 +397,14 @@ func TestHandler_Error(t *testing.T) {
 			requestBody:          `{"clipStartTime": "00:02:34", "clipEndTime": "blah"}`,
 			expectedResponseCode: http.StatusBadRequest,
 		},
+		{
+			name:        "Error getting file metadata from drive",
+			requestBody: validRequest,
+			drive: &fakeDriveClient{
+				statError: errors.New("expected error"),
+			},
+			expectedResponseCode: http.StatusInternalServerError,
+		},
 		{
 			name:        "Error getting file from drive",
 			requestBody: validRequest,
@@ -289,3 +455,1007 @@ func TestHandler_Error(t *testing.T) {
 		})
 	}
 }
+
+func TestHandler_Callback(t *testing.T) {
+	requestJSON := `{
+		"sourceFileId": "sourceFileId",
+		"clipStartTime": "00:01:23",
+		"clipEndTime": "00:02:34",
+		"destinationFolderId": "destinationFolderId",
+		"callbackUrl": "https://example.com/callback"
+		}`
+
+	cases := []struct {
+		name           string
+		drive          *fakeDriveClient
+		expectedResult CallbackResult
+	}{
+		{
+			name: "Success",
+			drive: &fakeDriveClient{
+				filename:       "originalFile.fileExt",
+				fileContents:   closingBuffer{bytes.NewBufferString("original file contents")},
+				createdFileURL: "https://example.com/clip",
+			},
+			expectedResult: CallbackResult{
+				Status:  JobStatusSucceeded,
+				FileURL: "https://example.com/clip",
+			},
+		},
+		{
+			name: "Failure",
+			drive: &fakeDriveClient{
+				getFileError: errors.New("expected error"),
+			},
+			expectedResult: CallbackResult{
+				Status: JobStatusFailed,
+				Error:  "expected error",
+			},
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			sender := newFakeSender()
+			extractor := &fakeExtractor{
+				contents: closingBuffer{bytes.NewBufferString("clip contents")},
+			}
+			handler := ClipExtractionHandler(test.drive, extractor, WithCallbacks(sender))
+
+			req := createRequest(t, requestJSON)
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			if diff := cmp.Diff(http.StatusAccepted, rr.Code); diff != "" {
+				t.Fatal("Different response code than expected (+got -want):", diff)
+			}
+
+			var accepted AcceptedResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &accepted); err != nil {
+				t.Fatalf("Invalid response %q: %v", rr.Body, err)
+			}
+
+			sent := sender.wait(t)
+
+			if sent.url != "https://example.com/callback" {
+				t.Errorf("got callback to %q, want %q", sent.url, "https://example.com/callback")
+			}
+
+			var actual CallbackResult
+			if err := json.Unmarshal(sent.payload, &actual); err != nil {
+				t.Fatalf("Invalid callback payload %q: %v", sent.payload, err)
+			}
+
+			expected := test.expectedResult
+			expected.JobID = accepted.JobID
+			if err := json.Unmarshal([]byte(requestJSON), &expected.Request); err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(expected, actual); diff != "" {
+				t.Error("Different callback payload than expected (+got -want):", diff)
+			}
+		})
+	}
+}
+
+func TestHandler_Callback_Error(t *testing.T) {
+	cases := []struct {
+		name        string
+		callbackURL string
+		sender      webhook.Sender
+	}{
+		{
+			name:        "Callbacks not enabled",
+			callbackURL: "https://example.com/callback",
+		},
+		{
+			name:        "Relative callback URL",
+			callbackURL: "/callback",
+			sender:      newFakeSender(),
+		},
+		{
+			name:        "Unsupported scheme",
+			callbackURL: "ftp://example.com/callback",
+			sender:      newFakeSender(),
+		},
+		{
+			name:        "Loopback callback URL",
+			callbackURL: "http://127.0.0.1:8080/callback",
+			sender:      newFakeSender(),
+		},
+		{
+			name:        "Private callback URL",
+			callbackURL: "http://[fd00::1]/callback",
+			sender:      newFakeSender(),
+		},
+		{
+			name:        "Localhost callback URL",
+			callbackURL: "http://localhost/callback",
+			sender:      newFakeSender(),
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			var opts []HandlerOption
+			if test.sender != nil {
+				opts = append(opts, WithCallbacks(test.sender))
+			}
+			handler := ClipExtractionHandler(&fakeDriveClient{}, &fakeExtractor{}, opts...)
+
+			req := createRequest(t, `{"clipStartTime": "00:01:23", "clipEndTime": "00:02:34", "callbackUrl": "`+test.callbackURL+`"}`)
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			if diff := cmp.Diff(http.StatusBadRequest, rr.Code); diff != "" {
+				t.Fatal("Different response code than expected (+got -want):", diff)
+			}
+		})
+	}
+}
+
+type saturatedExtractor struct {
+	fakeExtractor
+}
+
+func (e *saturatedExtractor) Saturated() bool {
+	return true
+}
+
+func TestHandler_Saturated(t *testing.T) {
+	cases := []struct {
+		name      string
+		extractor video.Extractor
+	}{
+		{
+			name:      "Rejected before download",
+			extractor: &saturatedExtractor{},
+		},
+		{
+			name:      "Queue filled during download",
+			extractor: &fakeExtractor{err: video.ErrQueueFull},
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			drive := &fakeDriveClient{
+				filename:     "test file",
+				fileContents: closingBuffer{bytes.NewBufferString("file contents don't matter")},
+			}
+			handler := ClipExtractionHandler(drive, test.extractor)
+
+			req := createRequest(t, `{"clipStartTime": "00:01:23", "clipEndTime": "00:02:34"}`)
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			if diff := cmp.Diff(http.StatusServiceUnavailable, rr.Code); diff != "" {
+				t.Fatal("Different response code than expected (+got -want):", diff)
+			}
+
+			if rr.Header().Get("Retry-After") == "" {
+				t.Error("Expected Retry-After header")
+			}
+		})
+	}
+}
+
+func TestHandler_Workspace(t *testing.T) {
+	cases := []struct {
+		name                 string
+		budget               int64
+		fileSize             int64
+		expectedResponseCode int
+	}{
+		{
+			name:                 "Fits in budget",
+			budget:               100,
+			fileSize:             50,
+			expectedResponseCode: http.StatusCreated,
+		},
+		{
+			name:                 "Larger than budget",
+			budget:               100,
+			fileSize:             51,
+			expectedResponseCode: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			ws, err := workspace.New(t.TempDir(), test.budget)
+			if err != nil {
+				t.Fatal(err)
+			}
+			drive := &fakeDriveClient{
+				filename:     "test file",
+				fileSize:     test.fileSize,
+				fileContents: closingBuffer{bytes.NewBufferString("file contents don't matter")},
+			}
+			extractor := &fakeExtractor{
+				contents: closingBuffer{bytes.NewBufferString("clip contents")},
+			}
+			handler := ClipExtractionHandler(drive, extractor, WithWorkspace(ws))
+
+			req := createRequest(t, `{"clipStartTime": "00:01:23", "clipEndTime": "00:02:34"}`)
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			if diff := cmp.Diff(test.expectedResponseCode, rr.Code); diff != "" {
+				t.Fatal("Different response code than expected (+got -want):", diff)
+			}
+
+			if extractor.clipFilename != "" && filepath.Dir(filepath.Dir(extractor.clipFilename)) != ws.Root() {
+				t.Errorf("Source downloaded to %s, want a job directory in %s", extractor.clipFilename, ws.Root())
+			}
+
+			entries, err := ioutil.ReadDir(ws.Root())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 0 {
+				t.Errorf("Expected workspace to be empty after request, found %d entries", len(entries))
+			}
+		})
+	}
+}
+
+func TestHandler_InsufficientSpace(t *testing.T) {
+	ws, err := workspace.New(t.TempDir(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	held, err := ws.NewJob(60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+
+	drive := &fakeDriveClient{filename: "test file", fileSize: 25}
+	handler := ClipExtractionHandler(drive, &fakeExtractor{}, WithWorkspace(ws))
+
+	req := createRequest(t, `{"clipStartTime": "00:01:23", "clipEndTime": "00:02:34"}`)
+	rr := httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if diff := cmp.Diff(http.StatusServiceUnavailable, rr.Code); diff != "" {
+		t.Fatal("Different response code than expected (+got -want):", diff)
+	}
+
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("Expected Retry-After header")
+	}
+}
+
+func TestHandler_Stream(t *testing.T) {
+	ws, err := workspace.New(t.TempDir(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := append([]byte{0x1a, 0x45, 0xdf, 0xa3}, "rest of matroska file"...)
+	drive := &fakeDriveClient{
+		filename:       "concert.mkv",
+		fileSize:       1000,
+		fileContents:   closingBuffer{bytes.NewBuffer(source)},
+		createdFileURL: "https://example.com",
+	}
+	extractor := &fakeExtractor{
+		contents: closingBuffer{bytes.NewBufferString("clip contents")},
+	}
+	handler := ClipExtractionHandler(drive, extractor, WithWorkspace(ws))
+
+	req := createRequest(t, `{"clipStartTime": "00:01:23", "clipEndTime": "00:02:34"}`)
+	rr := httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	// The source is larger than the budget, but needs no disk space when streamed
+	if diff := cmp.Diff(http.StatusCreated, rr.Code); diff != "" {
+		t.Fatal("Different response code than expected (+got -want):", diff)
+	}
+
+	if extractor.clipFilename != "" {
+		t.Errorf("Expected source to be streamed, but it was downloaded to %s", extractor.clipFilename)
+	}
+	if diff := cmp.Diff(source, extractor.streamInput); diff != "" {
+		t.Error("Streamed input different than expected (-want +got):", diff)
+	}
+	if extractor.streamFormat != video.ContainerMatroska {
+		t.Errorf("got container %q, want %q", extractor.streamFormat, video.ContainerMatroska)
+	}
+	if diff := cmp.Diff([]byte("clip contents"), drive.uploadFileContents); diff != "" {
+		t.Error("Uploaded contents different than expected (-want +got):", diff)
+	}
+}
+
+func TestHandler_StreamFallback(t *testing.T) {
+	ws, err := workspace.New(t.TempDir(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An MP4 file with its media data before its index cannot be read sequentially
+	drive := &fakeDriveClient{
+		filename:     "concert.mp4",
+		fileSize:     1000,
+		fileContents: closingBuffer{bytes.NewBuffer([]byte{0, 0, 0, 8, 'm', 'd', 'a', 't'})},
+	}
+	extractor := &fakeExtractor{
+		contents: closingBuffer{bytes.NewBufferString("clip contents")},
+	}
+	handler := ClipExtractionHandler(drive, extractor, WithWorkspace(ws))
+
+	req := createRequest(t, `{"clipStartTime": "00:01:23", "clipEndTime": "00:02:34"}`)
+	rr := httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if diff := cmp.Diff(http.StatusRequestEntityTooLarge, rr.Code); diff != "" {
+		t.Fatal("Different response code than expected (+got -want):", diff)
+	}
+	if extractor.streamInput != nil {
+		t.Error("Expected source not to be streamed")
+	}
+}
+
+func TestHandler_Watermark(t *testing.T) {
+	assets := &fakeDriveClient{
+		filename:     "logo.png",
+		fileContents: closingBuffer{bytes.NewBufferString("png data")},
+	}
+	cache, err := drive.NewFileCache(assets, t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing, err := drive.NewFileCache(&fakeDriveClient{statError: fmt.Errorf("error getting file metadata: %w", drive.ErrNotFound)}, t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unavailable, err := drive.NewFileCache(&fakeDriveClient{statError: errors.New("backend error")}, t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name                 string
+		opts                 []HandlerOption
+		watermark            string
+		expectedResponseCode int
+		expectedLogo         string
+	}{
+		{
+			name:                 "Not enabled",
+			watermark:            `{}`,
+			expectedResponseCode: http.StatusBadRequest,
+		},
+		{
+			name:                 "Configured logo",
+			opts:                 []HandlerOption{WithWatermarks("/logos/default.png", "", cache)},
+			watermark:            `{"corner": "top-left", "margin": 10, "scale": 0.2, "opacity": 0.5}`,
+			expectedResponseCode: http.StatusCreated,
+			expectedLogo:         "/logos/default.png",
+		},
+		{
+			name:                 "Logo from Drive",
+			opts:                 []HandlerOption{WithWatermarks("/logos/default.png", "", cache)},
+			watermark:            `{"logoFileId": "logoId"}`,
+			expectedResponseCode: http.StatusCreated,
+			expectedLogo:         "logoId.png",
+		},
+		{
+			name:                 "Missing logo",
+			opts:                 []HandlerOption{WithWatermarks("/logos/default.png", "", missing)},
+			watermark:            `{"logoFileId": "logoId"}`,
+			expectedResponseCode: http.StatusBadRequest,
+		},
+		{
+			name:                 "Drive unavailable",
+			opts:                 []HandlerOption{WithWatermarks("/logos/default.png", "", unavailable)},
+			watermark:            `{"logoFileId": "logoId"}`,
+			expectedResponseCode: http.StatusInternalServerError,
+		},
+		{
+			name:                 "No logo",
+			opts:                 []HandlerOption{WithWatermarks("", "", cache)},
+			watermark:            `{}`,
+			expectedResponseCode: http.StatusBadRequest,
+		},
+		{
+			name:                 "Invalid corner",
+			opts:                 []HandlerOption{WithWatermarks("/logos/default.png", "", cache)},
+			watermark:            `{"corner": "middle"}`,
+			expectedResponseCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			drive := &fakeDriveClient{
+				filename:     "test file",
+				fileContents: closingBuffer{bytes.NewBufferString("file contents don't matter")},
+			}
+			extractor := &fakeExtractor{
+				contents: closingBuffer{bytes.NewBufferString("clip contents")},
+			}
+			handler := ClipExtractionHandler(drive, extractor, test.opts...)
+
+			req := createRequest(t, `{"clipStartTime": "00:01:23", "clipEndTime": "00:02:34", "watermark": `+test.watermark+`}`)
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			if diff := cmp.Diff(test.expectedResponseCode, rr.Code); diff != "" {
+				t.Fatal("Different response code than expected (+got -want):", diff, rr.Body.String())
+			}
+			if test.expectedLogo == "" {
+				return
+			}
+			if extractor.clipOptions.Overlay == nil || filepath.Base(extractor.clipOptions.Overlay.Path) != filepath.Base(test.expectedLogo) {
+				t.Errorf("got overlay %+v, want logo %s", extractor.clipOptions.Overlay, test.expectedLogo)
+			}
+		})
+	}
+}
+
+func TestHandler_Text(t *testing.T) {
+	source := append([]byte{0x1a, 0x45, 0xdf, 0xa3}, "rest of matroska file"...)
+	drive := &fakeDriveClient{
+		filename:     "concert.mkv",
+		fileContents: closingBuffer{bytes.NewBuffer(source)},
+	}
+	extractor := &fakeExtractor{
+		contents: closingBuffer{bytes.NewBufferString("clip contents")},
+	}
+	handler := ClipExtractionHandler(drive, extractor)
+
+	req := createRequest(t, `{
+		"clipStartTime": "00:01:23",
+		"clipEndTime": "00:02:34",
+		"text": {"title": "Beethoven 7 – II. Allegretto", "subtitle": "NOCCO, March 2020", "font": "serif", "durationSeconds": 4, "fadeSeconds": 0.5},
+		"titleCard": {"durationSeconds": 2.5}
+		}`)
+	rr := httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if diff := cmp.Diff(http.StatusCreated, rr.Code); diff != "" {
+		t.Fatal("Different response code than expected (+got -want):", diff, rr.Body.String())
+	}
+
+	// A title card requires the source to be downloaded, even though its container could be streamed
+	if extractor.clipFilename == "" {
+		t.Error("Expected source to be downloaded")
+	}
+
+	expected := video.ClipOptions{
+		Text: &video.TextOverlay{
+			Title:    "Beethoven 7 – II. Allegretto",
+			Subtitle: "NOCCO, March 2020",
+			Font:     "serif",
+			Duration: 4 * time.Second,
+			Fade:     500 * time.Millisecond,
+		},
+		TitleCard: &video.TitleCard{
+			Title:    "Beethoven 7 – II. Allegretto",
+			Subtitle: "NOCCO, March 2020",
+			Font:     "serif",
+			Duration: 2500 * time.Millisecond,
+		},
+	}
+	if diff := cmp.Diff(expected, extractor.clipOptions); diff != "" {
+		t.Error("Clip options different than expected (-want +got):", diff)
+	}
+}
+
+func TestHandler_InvalidText(t *testing.T) {
+	handler := ClipExtractionHandler(&fakeDriveClient{}, &fakeExtractor{})
+
+	req := createRequest(t, `{"clipStartTime": "00:01:23", "clipEndTime": "00:02:34", "text": {"title": "t", "font": "comic-sans"}}`)
+	rr := httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if diff := cmp.Diff(http.StatusBadRequest, rr.Code); diff != "" {
+		t.Fatal("Different response code than expected (+got -want):", diff)
+	}
+}
+
+func TestHandler_Preset(t *testing.T) {
+	cases := []struct {
+		name                 string
+		preset               string
+		clipEndTime          string
+		expectedResponseCode int
+	}{
+		{
+			name:                 "Builtin preset",
+			preset:               "instagram-square",
+			clipEndTime:          "00:00:45",
+			expectedResponseCode: http.StatusCreated,
+		},
+		{
+			name:                 "Unknown preset",
+			preset:               "tiktok",
+			clipEndTime:          "00:00:45",
+			expectedResponseCode: http.StatusBadRequest,
+		},
+		{
+			name:                 "Longer than preset allows",
+			preset:               "instagram-square",
+			clipEndTime:          "00:01:30",
+			expectedResponseCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			drive := &fakeDriveClient{
+				filename:     "test file",
+				fileContents: closingBuffer{bytes.NewBufferString("file contents don't matter")},
+			}
+			extractor := &fakeExtractor{
+				contents: closingBuffer{bytes.NewBufferString("clip contents")},
+			}
+			handler := ClipExtractionHandler(drive, extractor)
+
+			req := createRequest(t, `{"clipStartTime": "00:00:15", "clipEndTime": "`+test.clipEndTime+`", "preset": "`+test.preset+`"}`)
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			if diff := cmp.Diff(test.expectedResponseCode, rr.Code); diff != "" {
+				t.Fatal("Different response code than expected (+got -want):", diff, rr.Body.String())
+			}
+			if test.expectedResponseCode != http.StatusCreated {
+				return
+			}
+			if diff := cmp.Diff(video.BuiltinPresets()[test.preset], *extractor.clipOptions.Preset); diff != "" {
+				t.Error("Preset different than expected (-want +got):", diff)
+			}
+		})
+	}
+}
+
+func TestHandler_MaxSize(t *testing.T) {
+	cases := []struct {
+		name                 string
+		maxSize              string
+		extractorErr         error
+		expectedResponseCode int
+	}{
+		{"Fits", "25000000", nil, http.StatusCreated},
+		{"Too small for duration", "100000", nil, http.StatusBadRequest},
+		{"Could not fit after retries", "25000000", video.ErrTargetTooSmall, http.StatusUnprocessableEntity},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			drive := &fakeDriveClient{
+				filename:     "test file",
+				fileContents: closingBuffer{bytes.NewBufferString("file contents don't matter")},
+			}
+			extractor := &fakeExtractor{
+				contents: closingBuffer{bytes.NewBufferString("clip contents")},
+				err:      test.extractorErr,
+			}
+			handler := ClipExtractionHandler(drive, extractor)
+
+			req := createRequest(t, `{"clipStartTime": "00:01:00", "clipEndTime": "00:02:00", "maxSizeBytes": `+test.maxSize+`}`)
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			if diff := cmp.Diff(test.expectedResponseCode, rr.Code); diff != "" {
+				t.Fatal("Different response code than expected (+got -want):", diff, rr.Body.String())
+			}
+			if rr.Code == http.StatusCreated && extractor.clipOptions.MaxSize != 25000000 {
+				t.Errorf("got MaxSize %d, want 25000000", extractor.clipOptions.MaxSize)
+			}
+		})
+	}
+}
+
+func TestHandler_Fades(t *testing.T) {
+	cases := []struct {
+		name                 string
+		fades                string
+		expectedResponseCode int
+	}{
+		{"Valid", `{"videoInSeconds": 1, "audioOutSeconds": 2.5}`, http.StatusCreated},
+		{"Longer than half the clip", `{"audioInSeconds": 31}`, http.StatusBadRequest},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			drive := &fakeDriveClient{
+				filename:     "test file",
+				fileContents: closingBuffer{bytes.NewBufferString("file contents don't matter")},
+			}
+			extractor := &fakeExtractor{
+				contents: closingBuffer{bytes.NewBufferString("clip contents")},
+			}
+			handler := ClipExtractionHandler(drive, extractor)
+
+			req := createRequest(t, `{"clipStartTime": "00:01:00", "clipEndTime": "00:02:00", "fades": `+test.fades+`}`)
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			if diff := cmp.Diff(test.expectedResponseCode, rr.Code); diff != "" {
+				t.Fatal("Different response code than expected (+got -want):", diff, rr.Body.String())
+			}
+			if rr.Code != http.StatusCreated {
+				return
+			}
+			expected := &video.Fades{VideoIn: time.Second, AudioOut: 2500 * time.Millisecond}
+			if diff := cmp.Diff(expected, extractor.clipOptions.Fades); diff != "" {
+				t.Error("Fades different than expected (-want +got):", diff)
+			}
+		})
+	}
+}
+
+func TestHandler_Bumpers(t *testing.T) {
+	assets := &fakeDriveClient{
+		filename:     "outro.mp4",
+		fileContents: closingBuffer{bytes.NewBufferString("mp4 data")},
+	}
+	cache, err := drive.NewFileCache(assets, t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name                 string
+		opts                 []HandlerOption
+		bumpers              string
+		expectedResponseCode int
+		expectedIntro        string
+		expectedOutro        string
+	}{
+		{
+			name:                 "Not enabled",
+			bumpers:              `"intro": true`,
+			expectedResponseCode: http.StatusBadRequest,
+		},
+		{
+			name:                 "Intro and outro",
+			opts:                 []HandlerOption{WithBumpers("/bumpers/intro.mov", "", "", "outroId", cache)},
+			bumpers:              `"intro": true, "outro": true`,
+			expectedResponseCode: http.StatusCreated,
+			expectedIntro:        "intro.mov",
+			expectedOutro:        "outroId.mp4",
+		},
+		{
+			name:                 "Outro only",
+			opts:                 []HandlerOption{WithBumpers("/bumpers/intro.mov", "", "", "outroId", cache)},
+			bumpers:              `"outro": true`,
+			expectedResponseCode: http.StatusCreated,
+			expectedOutro:        "outroId.mp4",
+		},
+		{
+			name:                 "No outro configured",
+			opts:                 []HandlerOption{WithBumpers("/bumpers/intro.mov", "", "", "", cache)},
+			bumpers:              `"outro": true`,
+			expectedResponseCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			drive := &fakeDriveClient{
+				filename:     "test file",
+				fileContents: closingBuffer{bytes.NewBufferString("file contents don't matter")},
+			}
+			extractor := &fakeExtractor{
+				contents: closingBuffer{bytes.NewBufferString("clip contents")},
+			}
+			handler := ClipExtractionHandler(drive, extractor, test.opts...)
+
+			req := createRequest(t, `{"clipStartTime": "00:01:23", "clipEndTime": "00:02:34", `+test.bumpers+`}`)
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			if diff := cmp.Diff(test.expectedResponseCode, rr.Code); diff != "" {
+				t.Fatal("Different response code than expected (+got -want):", diff, rr.Body.String())
+			}
+			if test.expectedResponseCode != http.StatusCreated {
+				return
+			}
+			b := extractor.clipOptions.Bumpers
+			if b == nil {
+				t.Fatal("Expected bumpers to be set")
+			}
+			base := func(p string) string {
+				if p == "" {
+					return ""
+				}
+				return filepath.Base(p)
+			}
+			if base(b.Intro) != test.expectedIntro || base(b.Outro) != test.expectedOutro {
+				t.Errorf("got bumpers %q and %q, want %q and %q", b.Intro, b.Outro, test.expectedIntro, test.expectedOutro)
+			}
+		})
+	}
+}
+
+func TestHandler_AutoTrim(t *testing.T) {
+	cases := []struct {
+		name                 string
+		autoTrim             string
+		expectedResponseCode int
+		expected             *video.AutoTrim
+	}{
+		{"Default tolerance", `{}`, http.StatusCreated, &video.AutoTrim{}},
+		{"Tolerance", `{"toleranceSeconds": 4.5}`, http.StatusCreated, &video.AutoTrim{Tolerance: 4500 * time.Millisecond}},
+		{"Tolerance longer than half the clip", `{"toleranceSeconds": 30}`, http.StatusBadRequest, nil},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			drive := &fakeDriveClient{
+				filename:     "test.mp4",
+				fileContents: closingBuffer{bytes.NewBufferString("file contents don't matter")},
+			}
+			extractor := &fakeExtractor{
+				contents: closingBuffer{bytes.NewBufferString("clip contents")},
+			}
+			handler := ClipExtractionHandler(drive, extractor)
+
+			req := createRequest(t, `{"clipStartTime": "00:01:00", "clipEndTime": "00:02:00", "autoTrim": `+test.autoTrim+`}`)
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			if diff := cmp.Diff(test.expectedResponseCode, rr.Code); diff != "" {
+				t.Fatal("Different response code than expected (+got -want):", diff, rr.Body.String())
+			}
+			if diff := cmp.Diff(test.expected, extractor.clipOptions.AutoTrim); diff != "" {
+				t.Error("Auto trim different than expected (-want +got):", diff)
+			}
+			if rr.Code == http.StatusCreated && extractor.clipFilename == "" {
+				t.Error("Expected the source to be downloaded rather than streamed")
+			}
+		})
+	}
+}
+
+func TestHandler_SceneChapters(t *testing.T) {
+	drive := &fakeDriveClient{
+		filename:     "test.mp4",
+		fileContents: closingBuffer{bytes.NewBufferString("file contents don't matter")},
+	}
+	extractor := &fakeExtractor{
+		contents: closingBuffer{bytes.NewBufferString("clip contents")},
+	}
+	handler := ClipExtractionHandler(drive, extractor)
+
+	req := createRequest(t, `{"clipStartTime": "00:01:00", "clipEndTime": "00:02:00", "sceneChapters": {"threshold": 0.5}}`)
+	rr := httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if diff := cmp.Diff(http.StatusCreated, rr.Code); diff != "" {
+		t.Fatal("Different response code than expected (+got -want):", diff, rr.Body.String())
+	}
+	if c := extractor.clipOptions.Chapters; c == nil || c.Scenes == nil || c.Scenes.Threshold != 0.5 {
+		t.Errorf("got chapters %+v, want scene chapters with threshold 0.5", c)
+	}
+	if extractor.clipFilename == "" {
+		t.Error("Expected the source to be downloaded rather than streamed")
+	}
+}
+
+func TestHandler_Metadata(t *testing.T) {
+	drive := &fakeDriveClient{
+		filename:     "test.mp4",
+		fileContents: closingBuffer{bytes.NewBufferString("file contents don't matter")},
+	}
+	extractor := &fakeExtractor{
+		contents: closingBuffer{bytes.NewBufferString("clip contents")},
+	}
+	handler := ClipExtractionHandler(drive, extractor)
+
+	req := createRequest(t, `{
+		"clipStartTime": "00:01:00",
+		"clipEndTime": "00:02:00",
+		"metadata": {"title": "Symphony No. 7", "artist": "NOCCO", "date": "2020-03-14"},
+		"chapters": [{"startSeconds": 0, "title": "Allegretto"}, {"startSeconds": 42.5, "title": "Trio"}]
+		}`)
+	rr := httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if diff := cmp.Diff(http.StatusCreated, rr.Code); diff != "" {
+		t.Fatal("Different response code than expected (+got -want):", diff, rr.Body.String())
+	}
+	expectedMetadata := &video.Metadata{Title: "Symphony No. 7", Artist: "NOCCO", Date: "2020-03-14"}
+	if diff := cmp.Diff(expectedMetadata, extractor.clipOptions.Metadata); diff != "" {
+		t.Error("Metadata different than expected (-want +got):", diff)
+	}
+	expectedChapters := []video.Chapter{{Start: 0, Title: "Allegretto"}, {Start: 42500 * time.Millisecond, Title: "Trio"}}
+	if c := extractor.clipOptions.Chapters; c == nil || c.Scenes != nil {
+		t.Fatalf("got chapters %+v, want a chapter list without scenes", c)
+	}
+	if diff := cmp.Diff(expectedChapters, extractor.clipOptions.Chapters.List); diff != "" {
+		t.Error("Chapters different than expected (-want +got):", diff)
+	}
+}
+
+func TestHandler_ChapterOutsideClip(t *testing.T) {
+	handler := ClipExtractionHandler(&fakeDriveClient{}, &fakeExtractor{})
+
+	req := createRequest(t, `{"clipStartTime": "00:01:00", "clipEndTime": "00:02:00", "chapters": [{"startSeconds": 75, "title": "Coda"}]}`)
+	rr := httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if diff := cmp.Diff(http.StatusBadRequest, rr.Code); diff != "" {
+		t.Error("Different response code than expected (+got -want):", diff, rr.Body.String())
+	}
+}
+
+func TestHandler_Privacy(t *testing.T) {
+	cases := []struct {
+		name        string
+		privacy     string
+		uploadMatch string
+	}{
+		{
+			name:        "Anonymous ID",
+			privacy:     `{"muteChannels": [2], "anonymousId": "candidate-17"}`,
+			uploadMatch: `^candidate-17\.mp4$`,
+		},
+		{
+			name:        "Random ID",
+			privacy:     `{"muteChannels": [2]}`,
+			uploadMatch: `^[0-9a-f]{16}\.mp4$`,
+		},
+	}
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			drive := &fakeDriveClient{
+				filename:     "Jane Doe audition.mp4",
+				fileContents: closingBuffer{bytes.NewBufferString("file contents don't matter")},
+			}
+			extractor := &fakeExtractor{
+				contents: closingBuffer{bytes.NewBufferString("clip contents")},
+			}
+			handler := ClipExtractionHandler(drive, extractor)
+
+			req := createRequest(t, `{"clipStartTime": "00:01:00", "clipEndTime": "00:02:00", "privacy": `+test.privacy+`}`)
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			if diff := cmp.Diff(http.StatusCreated, rr.Code); diff != "" {
+				t.Fatal("Different response code than expected (+got -want):", diff, rr.Body.String())
+			}
+			if diff := cmp.Diff(&video.Privacy{MuteChannels: []int{1}}, extractor.clipOptions.Privacy); diff != "" {
+				t.Error("Privacy options different than expected (-want +got):", diff)
+			}
+			if !regexp.MustCompile(test.uploadMatch).MatchString(drive.uploadFileName) {
+				t.Errorf("Uploaded file name %q does not match %q", drive.uploadFileName, test.uploadMatch)
+			}
+		})
+	}
+}
+
+func TestHandler_InvalidPrivacy(t *testing.T) {
+	cases := []struct {
+		name    string
+		privacy string
+	}{
+		{"Channel zero", `{"muteChannels": [0]}`},
+		{"Duplicate channel", `{"muteChannels": [1, 1]}`},
+		{"Path in ID", `{"anonymousId": "../candidate"}`},
+	}
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			handler := ClipExtractionHandler(&fakeDriveClient{}, &fakeExtractor{})
+
+			req := createRequest(t, `{"clipStartTime": "00:01:00", "clipEndTime": "00:02:00", "privacy": `+test.privacy+`}`)
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			if diff := cmp.Diff(http.StatusBadRequest, rr.Code); diff != "" {
+				t.Error("Different response code than expected (+got -want):", diff, rr.Body.String())
+			}
+		})
+	}
+}
+
+// stallingExtractor is a fakeExtractor whose clips never finish before their context is done
+type stallingExtractor struct {
+	fakeExtractor
+}
+
+func (e *stallingExtractor) Clip(ctx context.Context, filename string, start time.Duration, end time.Duration, opts video.ClipOptions) (io.ReadCloser, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (e *stallingExtractor) ClipStream(ctx context.Context, r io.Reader, c video.Container, start time.Duration, end time.Duration, opts video.ClipOptions) (io.ReadCloser, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestHandler_CallbackAfterJobTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { asyncJobTimeout = timeout }(asyncJobTimeout)
+	asyncJobTimeout = 50 * time.Millisecond
+
+	drive := &fakeDriveClient{
+		filename:     "test.mp4",
+		fileContents: closingBuffer{bytes.NewBufferString("file contents don't matter")},
+	}
+	sender := newFakeSender()
+	handler := ClipExtractionHandler(drive, &stallingExtractor{}, WithCallbacks(sender))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, createRequest(t, `{"clipStartTime": "00:01:00", "clipEndTime": "00:02:00", "callbackUrl": "https://example.com/callback"}`))
+
+	if diff := cmp.Diff(http.StatusAccepted, rr.Code); diff != "" {
+		t.Fatal("Different response code than expected (+got -want):", diff, rr.Body.String())
+	}
+
+	sent := sender.wait(t)
+	if sent.ctxErr != nil {
+		t.Errorf("Callback sent with a done context: %v", sent.ctxErr)
+	}
+	var actual CallbackResult
+	if err := json.Unmarshal(sent.payload, &actual); err != nil {
+		t.Fatalf("Invalid callback payload %q: %v", sent.payload, err)
+	}
+	if actual.Status != JobStatusFailed || actual.Error != context.DeadlineExceeded.Error() {
+		t.Errorf("got callback with status %q and error %q, want a failure reporting the timeout", actual.Status, actual.Error)
+	}
+}
+
+func TestHandler_MetadataKeepsContainer(t *testing.T) {
+	ts := make([]byte, 189)
+	ts[0], ts[188] = 0x47, 0x47
+	cases := []struct {
+		filename   string
+		contents   []byte
+		stream     bool
+		uploadName string
+	}{
+		{"concert.mkv", []byte{0x1a, 0x45, 0xdf, 0xa3}, true, "concert_00:01:00_to_00:02:00.mkv"},
+		{"concert.mp3", []byte("ID3"), false, "concert_00:01:00_to_00:02:00.mp3"},
+		// MPEG-TS cannot hold tags, so the clip is written as MP4
+		{"concert.ts", ts, false, "concert_00:01:00_to_00:02:00.mp4"},
+	}
+	for _, test := range cases {
+		t.Run(test.filename, func(t *testing.T) {
+			drive := &fakeDriveClient{
+				filename:     test.filename,
+				fileContents: closingBuffer{bytes.NewBuffer(test.contents)},
+			}
+			extractor := &fakeExtractor{
+				contents: closingBuffer{bytes.NewBufferString("clip contents")},
+			}
+			handler := ClipExtractionHandler(drive, extractor)
+
+			req := createRequest(t, `{"sourceFileId": "concert", "clipStartTime": "00:01:00", "clipEndTime": "00:02:00", "metadata": {"title": "Spring Concert"}}`)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if diff := cmp.Diff(http.StatusCreated, rr.Code); diff != "" {
+				t.Fatal("Different response code than expected (+got -want):", diff, rr.Body.String())
+			}
+			if stream := extractor.streamFormat != ""; stream != test.stream {
+				t.Errorf("got streamed %v, want %v", stream, test.stream)
+			}
+			if diff := cmp.Diff(test.uploadName, drive.uploadFileName); diff != "" {
+				t.Error("Uploaded file name different than expected (-want +got):", diff)
+			}
+		})
+	}
+}
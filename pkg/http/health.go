@@ -0,0 +1,60 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/ssmall/nocco-video-extractor/pkg/health"
+	"github.com/ssmall/nocco-video-extractor/pkg/logging"
+)
+
+// LivenessHandler creates a http.HandlerFunc that reports that the process is able to serve requests
+func LivenessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, health.Report{Status: health.StatusOK, Checks: []health.Result{}})
+	}
+}
+
+// ReadinessHandler creates a http.HandlerFunc that runs the given checks and reports the status of each.
+// It responds with 503 Service Unavailable if any check fails.
+func ReadinessHandler(timeout time.Duration, checks ...health.Check) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := health.Run(r.Context(), timeout, checks...)
+		if report.Status != health.StatusOK {
+			logging.FromContext(r.Context()).With("report", report).Warnf("Readiness check failed")
+		}
+		writeReport(w, report)
+	}
+}
+
+func writeReport(w http.ResponseWriter, report health.Report) {
+	resp, err := json.Marshal(&report)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != health.StatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.Write(resp)
+}
@@ -0,0 +1,89 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ssmall/nocco-video-extractor/pkg/health"
+)
+
+func TestLivenessHandler(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	LivenessHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	if diff := cmp.Diff(http.StatusOK, rr.Code); diff != "" {
+		t.Error("Different response code than expected (+got -want):", diff)
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	ok := health.Check{Name: "ok", Func: func(context.Context) error { return nil }}
+	failing := health.Check{Name: "failing", Func: func(context.Context) error { return errors.New("expected error") }}
+
+	cases := []struct {
+		name                 string
+		checks               []health.Check
+		expectedResponseCode int
+		expectedStatuses     map[string]string
+	}{
+		{
+			name:                 "All checks pass",
+			checks:               []health.Check{ok},
+			expectedResponseCode: http.StatusOK,
+			expectedStatuses:     map[string]string{"ok": health.StatusOK},
+		},
+		{
+			name:                 "A check fails",
+			checks:               []health.Check{ok, failing},
+			expectedResponseCode: http.StatusServiceUnavailable,
+			expectedStatuses:     map[string]string{"ok": health.StatusOK, "failing": health.StatusFailed},
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+
+			ReadinessHandler(time.Second, test.checks...).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if diff := cmp.Diff(test.expectedResponseCode, rr.Code); diff != "" {
+				t.Error("Different response code than expected (+got -want):", diff)
+			}
+
+			var report health.Report
+			if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+				t.Fatalf("Invalid response %q: %v", rr.Body, err)
+			}
+
+			statuses := make(map[string]string)
+			for _, c := range report.Checks {
+				statuses[c.Name] = c.Status
+			}
+
+			if diff := cmp.Diff(test.expectedStatuses, statuses); diff != "" {
+				t.Error("Check statuses different than expected (+got -want):", diff)
+			}
+		})
+	}
+}
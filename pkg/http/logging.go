@@ -0,0 +1,77 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ssmall/nocco-video-extractor/pkg/logging"
+	"go.opentelemetry.io/otel/api/trace"
+)
+
+// RequestIDHeader is the header used to read and return the ID of a request
+const RequestIDHeader = "X-Request-ID"
+
+// cloudTraceHeader is set on requests by Google Cloud load balancers and Cloud Run,
+// in the format TRACE_ID/SPAN_ID;o=TRACE_TRUE
+const cloudTraceHeader = "X-Cloud-Trace-Context"
+
+// Log wraps h so that each request is assigned an ID and a logger carrying that ID
+// is attached to the request context. The ID is taken from the X-Request-ID or
+// X-Cloud-Trace-Context headers if present, and is returned in the X-Request-ID response header.
+func Log(name string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := requestID(r)
+		fields := logging.Fields{"requestId": id, "handler": name}
+		if sc := trace.SpanFromContext(r.Context()).SpanContext(); sc.HasTraceID() {
+			fields["traceId"] = sc.TraceID.String()
+		}
+		logger := logging.FromContext(r.Context()).WithFields(fields)
+
+		w.Header().Set(RequestIDHeader, id)
+		sw := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+
+		h.ServeHTTP(sw, r.WithContext(logging.NewContext(r.Context(), logger)))
+
+		logger.WithFields(logging.Fields{
+			"method":   r.Method,
+			"path":     r.URL.Path,
+			"status":   sw.status,
+			"duration": time.Since(start).Seconds(),
+		}).Infof("%s %s %d", r.Method, r.URL.Path, sw.status)
+	})
+}
+
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(RequestIDHeader); id != "" {
+		return id
+	}
+	if tc := r.Header.Get(cloudTraceHeader); tc != "" {
+		if i := strings.IndexByte(tc, '/'); i > 0 {
+			return tc[:i]
+		}
+		return tc
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,89 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ssmall/nocco-video-extractor/pkg/logging"
+)
+
+func TestLog_RequestID(t *testing.T) {
+	cases := []struct {
+		name       string
+		headers    map[string]string
+		expectedID string
+	}{
+		{
+			name:       "X-Request-ID",
+			headers:    map[string]string{"X-Request-ID": "request-id", "X-Cloud-Trace-Context": "trace-id/123;o=1"},
+			expectedID: "request-id",
+		},
+		{
+			name:       "X-Cloud-Trace-Context",
+			headers:    map[string]string{"X-Cloud-Trace-Context": "trace-id/123;o=1"},
+			expectedID: "trace-id",
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			var b bytes.Buffer
+			h := Log("test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				logging.FromContext(r.Context()).Infof("in handler")
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req = req.WithContext(logging.NewContext(req.Context(), logging.New(&b, logging.Info)))
+			for k, v := range test.headers {
+				req.Header.Set(k, v)
+			}
+			rr := httptest.NewRecorder()
+
+			h.ServeHTTP(rr, req)
+
+			if diff := cmp.Diff(test.expectedID, rr.Header().Get(RequestIDHeader)); diff != "" {
+				t.Error("Response request ID different than expected (-want +got):", diff)
+			}
+
+			var entry map[string]interface{}
+			if err := json.NewDecoder(&b).Decode(&entry); err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(test.expectedID, entry["requestId"]); diff != "" {
+				t.Error("Logged request ID different than expected (-want +got):", diff)
+			}
+		})
+	}
+}
+
+func TestLog_GeneratesRequestID(t *testing.T) {
+	h := Log("test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	first := httptest.NewRecorder()
+	h.ServeHTTP(first, httptest.NewRequest(http.MethodGet, "/test", nil))
+	second := httptest.NewRecorder()
+	h.ServeHTTP(second, httptest.NewRequest(http.MethodGet, "/test", nil))
+
+	if first.Header().Get(RequestIDHeader) == "" || first.Header().Get(RequestIDHeader) == second.Header().Get(RequestIDHeader) {
+		t.Errorf("Expected unique generated request IDs, got %q and %q", first.Header().Get(RequestIDHeader), second.Header().Get(RequestIDHeader))
+	}
+}
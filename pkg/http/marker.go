@@ -0,0 +1,191 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ssmall/nocco-video-extractor/pkg/logging"
+	"github.com/ssmall/nocco-video-extractor/pkg/marker"
+	"github.com/ssmall/nocco-video-extractor/pkg/video"
+)
+
+// WithMarkers enables requests to reference the markers kept in s instead of timestamps
+func WithMarkers(s *marker.Store) HandlerOption {
+	return func(c *handlerConfig) {
+		c.markers = s
+	}
+}
+
+// MarkerHandler creates a http.HandlerFunc that manages the markers of source files kept in s.
+// The file is identified by the route variable "sourceFileId", and a single marker by the route variable "name".
+// The markers of a file are listed with GET and created with POST; a single marker is fetched with GET,
+// edited with PUT and removed with DELETE.
+func MarkerHandler(s *marker.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		vars := mux.Vars(r)
+		id, name := vars["sourceFileId"], vars["name"]
+
+		logger := logging.FromContext(r.Context())
+		logger.WithFields(logging.Fields{
+			"sourceFileId": id,
+			"marker":       name,
+		}).Infof("%s markers of %s", r.Method, id)
+
+		var resp interface{}
+		status := http.StatusOK
+		var err error
+		switch {
+		case name == "" && r.Method == http.MethodGet:
+			resp = markerList(s.List(id))
+		case name == "" && r.Method == http.MethodPost:
+			var m marker.Marker
+			if m, err = decodeMarker(r); err == nil {
+				err = s.Create(id, m)
+				resp, status = markerResponse(m), http.StatusCreated
+			}
+		case name != "" && r.Method == http.MethodGet:
+			var m marker.Marker
+			m, err = s.Get(id, name)
+			resp = markerResponse(m)
+		case name != "" && r.Method == http.MethodPut:
+			var m marker.Marker
+			if m, err = decodeMarker(r); err == nil {
+				err = s.Update(id, name, m)
+				resp = markerResponse(m)
+			}
+		case name != "" && r.Method == http.MethodDelete:
+			err = s.Delete(id, name)
+			status = http.StatusNoContent
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err != nil {
+			switch {
+			case errors.Is(err, marker.ErrNotFound):
+				w.WriteHeader(http.StatusNotFound)
+			case errors.Is(err, marker.ErrExists):
+				w.WriteHeader(http.StatusConflict)
+			case errors.Is(err, errInvalidMarker):
+				w.WriteHeader(http.StatusBadRequest)
+			default:
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		if resp == nil {
+			w.WriteHeader(status)
+			return
+		}
+		b, err := json.Marshal(resp)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		w.WriteHeader(status)
+		w.Write(b)
+	}
+}
+
+// errInvalidMarker wraps the errors of markers in requests that cannot be stored
+var errInvalidMarker = errors.New("invalid marker")
+
+// decodeMarker decodes the Marker in the body of r
+func decodeMarker(r *http.Request) (marker.Marker, error) {
+	var body Marker
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return marker.Marker{}, fmt.Errorf("%w: %v", errInvalidMarker, err)
+	}
+	t, err := parseDuration(body.Timestamp)
+	if err != nil {
+		return marker.Marker{}, fmt.Errorf("%w: %v", errInvalidMarker, err)
+	}
+	m := marker.Marker{Name: body.Name, Time: t}
+	if err := m.Validate(); err != nil {
+		return marker.Marker{}, fmt.Errorf("%w: %v", errInvalidMarker, err)
+	}
+	return m, nil
+}
+
+func markerResponse(m marker.Marker) Marker {
+	return Marker{Name: m.Name, Timestamp: video.FormatHHMMSS(m.Time)}
+}
+
+func markerList(markers []marker.Marker) MarkerListResponse {
+	resp := MarkerListResponse{Markers: []Marker{}}
+	for _, m := range markers {
+		resp.Markers = append(resp.Markers, markerResponse(m))
+	}
+	return resp
+}
+
+// clipInterval returns the start and end of the clip requested by body, given either as timestamps or as markers
+// of the source file moved by their offsets. The timestamps of the request are set to those of any markers.
+func (cfg *handlerConfig) clipInterval(body *ExtractionRequest) (start, end time.Duration, err error) {
+	if body.StartMarker == "" && body.EndMarker == "" {
+		if start, err = parseDuration(body.ClipStartTime); err != nil {
+			return 0, 0, err
+		}
+		if end, err = parseDuration(body.ClipEndTime); err != nil {
+			return 0, 0, err
+		}
+		return start, end, nil
+	}
+
+	if cfg.markers == nil {
+		return 0, 0, errors.New("markers are not enabled on this server")
+	}
+	if body.StartMarker == "" || body.EndMarker == "" {
+		return 0, 0, errors.New("startMarker and endMarker must be given together")
+	}
+	if body.ClipStartTime != "" || body.ClipEndTime != "" {
+		return 0, 0, errors.New("clipStartTime and clipEndTime cannot be given with markers")
+	}
+	if start, err = cfg.markerTime(body.SourceFileID, body.StartMarker, body.StartOffsetSeconds); err != nil {
+		return 0, 0, err
+	}
+	if end, err = cfg.markerTime(body.SourceFileID, body.EndMarker, body.EndOffsetSeconds); err != nil {
+		return 0, 0, err
+	}
+	if end <= start {
+		return 0, 0, fmt.Errorf("marker %q must be after marker %q", body.EndMarker, body.StartMarker)
+	}
+	body.ClipStartTime, body.ClipEndTime = video.FormatHHMMSS(start), video.FormatHHMMSS(end)
+	return start, end, nil
+}
+
+// markerTime returns the time of the marker of the file with the given id and name, moved by offset seconds
+func (cfg *handlerConfig) markerTime(id, name string, offset float64) (time.Duration, error) {
+	m, err := cfg.markers.Get(id, name)
+	if err != nil {
+		return 0, err
+	}
+	t := m.Time + fromSeconds(offset)
+	if t < 0 {
+		return 0, fmt.Errorf("the offset moves marker %q before the start of the file", name)
+	}
+	return t, nil
+}
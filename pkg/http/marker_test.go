@@ -0,0 +1,157 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/gorilla/mux"
+	"github.com/ssmall/nocco-video-extractor/pkg/marker"
+)
+
+func newMarkerStore(t *testing.T, markers ...marker.Marker) *marker.Store {
+	t.Helper()
+	s, err := marker.Open(filepath.Join(t.TempDir(), "markers.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range markers {
+		if err := s.Create("symphony", m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func markerRequest(t *testing.T, method, name, body string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(method, "/markers/symphony/"+name, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]string{"sourceFileId": "symphony"}
+	if name != "" {
+		vars["name"] = name
+	}
+	return mux.SetURLVars(req, vars)
+}
+
+func TestMarkerHandler(t *testing.T) {
+	s := newMarkerStore(t)
+	handler := MarkerHandler(s)
+
+	steps := []struct {
+		method, name, body string
+		code               int
+	}{
+		{http.MethodPost, "", `{"name": "F", "timestamp": "00:05:00"}`, http.StatusCreated},
+		{http.MethodPost, "", `{"name": "B", "timestamp": "00:01:20"}`, http.StatusCreated},
+		{http.MethodPost, "", `{"name": "B", "timestamp": "00:01:30"}`, http.StatusConflict},
+		{http.MethodPost, "", `{"name": "C", "timestamp": "1:30"}`, http.StatusBadRequest},
+		{http.MethodPut, "F", `{"name": "G", "timestamp": "00:05:10"}`, http.StatusOK},
+		{http.MethodPut, "F", `{"name": "F", "timestamp": "00:05:10"}`, http.StatusNotFound},
+		{http.MethodPost, "", `{"name": "Q", "timestamp": "00:09:00"}`, http.StatusCreated},
+		{http.MethodDelete, "Q", "", http.StatusNoContent},
+		{http.MethodGet, "Q", "", http.StatusNotFound},
+		{http.MethodPatch, "B", "", http.StatusMethodNotAllowed},
+	}
+	for _, step := range steps {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, markerRequest(t, step.method, step.name, step.body))
+		if diff := cmp.Diff(step.code, rr.Code); diff != "" {
+			t.Errorf("%s %q %s: different response code than expected (+got -want): %s %s", step.method, step.name, step.body, diff, rr.Body)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, markerRequest(t, http.MethodGet, "", ""))
+	if diff := cmp.Diff(http.StatusOK, rr.Code); diff != "" {
+		t.Fatal("Different response code than expected (+got -want):", diff, rr.Body)
+	}
+	expected := MarkerListResponse{Markers: []Marker{{Name: "B", Timestamp: "00:01:20"}, {Name: "G", Timestamp: "00:05:10"}}}
+	var actual MarkerListResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &actual); err != nil {
+		t.Fatalf("Invalid response %q: %v", rr.Body, err)
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Error("Different markers than expected (-want +got):", diff)
+	}
+}
+
+func TestHandler_Markers(t *testing.T) {
+	drive := &fakeDriveClient{
+		filename:     "symphony.mp4",
+		fileContents: closingBuffer{bytes.NewBufferString("file contents don't matter")},
+	}
+	extractor := &fakeExtractor{
+		contents: closingBuffer{bytes.NewBufferString("clip contents")},
+	}
+	markers := newMarkerStore(t, marker.Marker{Name: "B", Time: 80 * time.Second}, marker.Marker{Name: "F", Time: 5 * time.Minute})
+	handler := ClipExtractionHandler(drive, extractor, WithMarkers(markers))
+
+	req := createRequest(t, `{"sourceFileId": "symphony", "startMarker": "B", "startOffsetSeconds": -2, "endMarker": "F", "endOffsetSeconds": 1.5}`)
+	rr := httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if diff := cmp.Diff(http.StatusCreated, rr.Code); diff != "" {
+		t.Fatal("Different response code than expected (+got -want):", diff, rr.Body.String())
+	}
+	if diff := cmp.Diff(78*time.Second, extractor.clipStart); diff != "" {
+		t.Error("Clip start different than expected (-want +got):", diff)
+	}
+	if diff := cmp.Diff(5*time.Minute+1500*time.Millisecond, extractor.clipEnd); diff != "" {
+		t.Error("Clip end different than expected (-want +got):", diff)
+	}
+	if diff := cmp.Diff("symphony_00:01:18_to_00:05:01.500.mp4", drive.uploadFileName); diff != "" {
+		t.Error("Uploaded file name different than expected (-want +got):", diff)
+	}
+}
+
+func TestHandler_InvalidMarkers(t *testing.T) {
+	markers := newMarkerStore(t, marker.Marker{Name: "B", Time: 80 * time.Second}, marker.Marker{Name: "F", Time: 5 * time.Minute})
+	cases := []struct {
+		name string
+		opts []HandlerOption
+		body string
+	}{
+		{"Disabled", nil, `{"sourceFileId": "symphony", "startMarker": "B", "endMarker": "F"}`},
+		{"Unknown", []HandlerOption{WithMarkers(markers)}, `{"sourceFileId": "symphony", "startMarker": "B", "endMarker": "Z"}`},
+		{"OtherFile", []HandlerOption{WithMarkers(markers)}, `{"sourceFileId": "concerto", "startMarker": "B", "endMarker": "F"}`},
+		{"OnlyStart", []HandlerOption{WithMarkers(markers)}, `{"sourceFileId": "symphony", "startMarker": "B", "clipEndTime": "00:04:00"}`},
+		{"Reversed", []HandlerOption{WithMarkers(markers)}, `{"sourceFileId": "symphony", "startMarker": "F", "endMarker": "B"}`},
+		{"BeforeStart", []HandlerOption{WithMarkers(markers)}, `{"sourceFileId": "symphony", "startMarker": "B", "startOffsetSeconds": -81, "endMarker": "F"}`},
+	}
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			handler := ClipExtractionHandler(&fakeDriveClient{}, &fakeExtractor{}, test.opts...)
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, createRequest(t, test.body))
+
+			if diff := cmp.Diff(http.StatusBadRequest, rr.Code); diff != "" {
+				t.Error("Different response code than expected (+got -want):", diff, rr.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nocco",
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Number of HTTP requests handled, by handler, method and status code.",
+	}, []string{"handler", "method", "code"})
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "nocco",
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Time taken to respond to HTTP requests, by handler, method and status code.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 4, 10),
+	}, []string{"handler", "method", "code"})
+	jobsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "nocco",
+		Name:      "jobs_in_flight",
+		Help:      "Number of extraction jobs currently being processed.",
+	})
+	jobsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nocco",
+		Name:      "jobs_total",
+		Help:      "Number of extraction jobs processed, by result.",
+	}, []string{"result"})
+)
+
+// Instrument wraps h so that its requests are counted and timed under the given handler name
+func Instrument(name string, h http.Handler) http.Handler {
+	labels := prometheus.Labels{"handler": name}
+	return promhttp.InstrumentHandlerCounter(requestsTotal.MustCurryWith(labels),
+		promhttp.InstrumentHandlerDuration(requestDuration.MustCurryWith(labels), h))
+}
+
+func observeJob(err error) {
+	if err != nil {
+		jobsTotal.WithLabelValues("error").Inc()
+	} else {
+		jobsTotal.WithLabelValues("success").Inc()
+	}
+}
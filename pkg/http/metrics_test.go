@@ -0,0 +1,57 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrument(t *testing.T) {
+	counter := requestsTotal.WithLabelValues("test", "post", "418")
+	before := testutil.ToFloat64(counter)
+
+	h := Instrument("test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/test", nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if diff := cmp.Diff(before+1, testutil.ToFloat64(counter)); diff != "" {
+		t.Error("Request count different than expected (-want +got):", diff)
+	}
+}
+
+func TestHandler_ObservesJobs(t *testing.T) {
+	counter := jobsTotal.WithLabelValues("error")
+	before := testutil.ToFloat64(counter)
+
+	handler := ClipExtractionHandler(&fakeDriveClient{getFileError: errors.New("expected error")}, &fakeExtractor{})
+	handler.ServeHTTP(httptest.NewRecorder(), createRequest(t, `{"clipStartTime": "00:01:23", "clipEndTime": "00:02:34"}`))
+
+	if diff := cmp.Diff(before+1, testutil.ToFloat64(counter)); diff != "" {
+		t.Error("Failed job count different than expected (-want +got):", diff)
+	}
+
+	if diff := cmp.Diff(0.0, testutil.ToFloat64(jobsInFlight)); diff != "" {
+		t.Error("In-flight jobs different than expected (-want +got):", diff)
+	}
+}
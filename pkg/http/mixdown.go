@@ -0,0 +1,161 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/ssmall/nocco-video-extractor/pkg/drive"
+	"github.com/ssmall/nocco-video-extractor/pkg/logging"
+	"github.com/ssmall/nocco-video-extractor/pkg/video"
+	"github.com/ssmall/nocco-video-extractor/pkg/workspace"
+)
+
+// MixdownHandler creates a http.HandlerFunc that handles requests to mix the audio of several
+// Google Drive files, such as section recordings, into an audio file and upload it to Drive.
+func MixdownHandler(d drive.Client, e video.Extractor, opts ...HandlerOption) http.HandlerFunc {
+	cfg := newHandlerConfig(opts)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		var body MixdownRequest
+		if !decodeRequest(w, r, &body) {
+			return
+		}
+
+		logger := logging.FromContext(r.Context())
+		logger.WithFields(logging.Fields{
+			"tracks":              len(body.Tracks),
+			"destinationFolderId": body.DestinationFolderID,
+		}).Infof("Mixdown of %d tracks -> %s", len(body.Tracks), body.DestinationFolderID)
+
+		tracks, opts, err := mixOptions(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		cfg.serveJob(w, r, e, jobRequest{
+			callbackURL: body.CallbackURL,
+			priority:    body.Priority,
+			result:      CallbackResult{Mixdown: &body},
+			space: func(ctx context.Context) (int64, error) {
+				ids := trackFileIDs(body)
+				if body.CoverArtFileID != "" {
+					ids = append(ids, body.CoverArtFileID)
+				}
+				size, err := totalSize(ctx, d, ids)
+				return requiredSpace(size), err
+			},
+			status: http.StatusCreated,
+			run: uploadJob(func(ctx context.Context, job *workspace.Job) (string, error) {
+				return mixdown(ctx, d, e, job, body, tracks, opts)
+			}),
+		})
+	}
+}
+
+// mixOptions converts a mixdown request to tracks, without filenames, and MixOptions,
+// returning an error if they are invalid
+func mixOptions(body MixdownRequest) ([]video.Track, video.MixOptions, error) {
+	opts := video.MixOptions{Format: video.AudioFormat(body.Format), Loudness: body.LoudnessLUFS, Metadata: body.Metadata.metadata()}
+	tracks := make([]video.Track, len(body.Tracks))
+	for i, t := range body.Tracks {
+		if t.SourceFileID == "" {
+			return nil, opts, fmt.Errorf("track %d has no sourceFileId", i+1)
+		}
+		tracks[i] = video.Track{Offset: fromSeconds(t.OffsetSeconds), GainDB: t.GainDB, Pan: t.Pan, Mute: t.Mute}
+	}
+	if body.AutoAlign != nil {
+		if err := body.AutoAlign.options().Validate(); err != nil {
+			return nil, opts, fmt.Errorf("invalid alignment: %w", err)
+		}
+	}
+
+	if err := video.ValidateTracks(tracks, opts); err != nil {
+		return nil, opts, fmt.Errorf("invalid mixdown: %w", err)
+	}
+	if body.CoverArtFileID != "" {
+		if err := video.ValidateCoverArtFormat(opts.Format); err != nil {
+			return nil, opts, fmt.Errorf("invalid mixdown: %w", err)
+		}
+	}
+	return tracks, opts, nil
+}
+
+// trackFileIDs returns the distinct Drive files heard in a mixdown; muted tracks are not downloaded
+func trackFileIDs(body MixdownRequest) []string {
+	var ids []string
+	for _, t := range body.Tracks {
+		if !t.Mute {
+			ids = append(ids, t.SourceFileID)
+		}
+	}
+	return distinct(ids)
+}
+
+// mixdown downloads each file heard in a mixdown into the job directory, mixes them
+// and uploads the result to the destination folder.
+// Returns the URL of the uploaded mixdown.
+func mixdown(ctx context.Context, d drive.Client, e video.Extractor, job *workspace.Job, body MixdownRequest, tracks []video.Track, opts video.MixOptions) (string, error) {
+	logger := logging.FromContext(ctx)
+
+	files, err := downloadFiles(ctx, d, job, trackFileIDs(body))
+	if err != nil {
+		return "", err
+	}
+	var audible []video.Track
+	for i, t := range body.Tracks {
+		if !t.Mute {
+			tracks[i].Filename = files[t.SourceFileID]
+			audible = append(audible, tracks[i])
+		}
+	}
+
+	if body.CoverArtFileID != "" {
+		if opts.CoverArt, err = downloadFile(ctx, d, job, body.CoverArtFileID); err != nil {
+			return "", err
+		}
+	}
+
+	if body.AutoAlign != nil {
+		filenames := make([]string, len(audible))
+		for i, t := range audible {
+			filenames[i] = t.Filename
+		}
+		offsets, err := autoAlign(ctx, e, filenames, *body.AutoAlign)
+		if err != nil {
+			return "", err
+		}
+		for i := range audible {
+			audible[i].Offset += offsets[i]
+		}
+	}
+
+	mix, err := e.Mixdown(ctx, audible, opts)
+	if err != nil {
+		return "", err
+	}
+	defer mix.Close()
+
+	ext := opts.Format.Ext()
+	name := outputName(body.OutputName, "mixdown"+ext, ext)
+	logger.Infof("Uploading mixdown as %q", name)
+
+	return d.UploadFile(ctx, name, body.DestinationFolderID, mix)
+}
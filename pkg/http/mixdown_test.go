@@ -0,0 +1,161 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/ssmall/nocco-video-extractor/pkg/video"
+)
+
+func TestMixdownHandler_HappyPath(t *testing.T) {
+	drive := &fakeDriveClient{
+		filename:       "section.wav",
+		fileContents:   closingBuffer{bytes.NewBufferString("section contents")},
+		createdFileURL: "https://example.com",
+	}
+	extractor := &fakeExtractor{
+		contents: closingBuffer{bytes.NewBufferString("mix contents")},
+	}
+	handler := MixdownHandler(drive, extractor)
+
+	requestJSON := `{
+		"tracks": [
+			{"sourceFileId": "violin1", "pan": -0.5},
+			{"sourceFileId": "violin2", "mute": true},
+			{"sourceFileId": "cello", "offsetSeconds": 0.5, "gainDb": -2}
+		],
+		"destinationFolderId": "destinationFolderId",
+		"format": "flac",
+		"loudnessLufs": -16,
+		"outputName": "play-along without violin 2"
+		}`
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, createRequest(t, requestJSON))
+
+	if diff := cmp.Diff(http.StatusCreated, rr.Code); diff != "" {
+		t.Fatal("Different response code than expected (+got -want):", diff, rr.Body)
+	}
+
+	expectedTracks := []video.Track{
+		{Pan: -0.5},
+		{Offset: 500 * time.Millisecond, GainDB: -2},
+	}
+	if diff := cmp.Diff(expectedTracks, extractor.tracks, cmpopts.IgnoreFields(video.Track{}, "Filename")); diff != "" {
+		t.Error("Tracks different than expected (-want +got):", diff)
+	}
+	if diff := cmp.Diff(video.MixOptions{Format: video.FormatFLAC, Loudness: -16}, extractor.mixOpts); diff != "" {
+		t.Error("Mix options different than expected (-want +got):", diff)
+	}
+
+	if expected := "play-along without violin 2.flac"; drive.uploadFileName != expected {
+		t.Errorf("got upload named %q, want %q", drive.uploadFileName, expected)
+	}
+}
+
+func TestMixdownHandler_Metadata(t *testing.T) {
+	drive := &fakeDriveClient{
+		filename:       "cover.jpg",
+		fileContents:   closingBuffer{bytes.NewBufferString("file contents")},
+		createdFileURL: "https://example.com",
+	}
+	extractor := &fakeExtractor{
+		contents: closingBuffer{bytes.NewBufferString("mix contents")},
+	}
+	handler := MixdownHandler(drive, extractor)
+
+	requestJSON := `{
+		"tracks": [{"sourceFileId": "orchestra"}],
+		"destinationFolderId": "destinationFolderId",
+		"format": "mp3",
+		"metadata": {"title": "Symphony No. 7", "composer": "Beethoven", "album": "Spring Concert"},
+		"coverArtFileId": "cover"
+		}`
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, createRequest(t, requestJSON))
+
+	if diff := cmp.Diff(http.StatusCreated, rr.Code); diff != "" {
+		t.Fatal("Different response code than expected (+got -want):", diff, rr.Body)
+	}
+	expected := &video.Metadata{Title: "Symphony No. 7", Composer: "Beethoven", Album: "Spring Concert"}
+	if diff := cmp.Diff(expected, extractor.mixOpts.Metadata); diff != "" {
+		t.Error("Metadata different than expected (-want +got):", diff)
+	}
+	if filepath.Ext(extractor.mixOpts.CoverArt) != ".jpg" {
+		t.Errorf("got cover art %q, want a downloaded JPEG", extractor.mixOpts.CoverArt)
+	}
+}
+
+func TestMixdownHandler_InvalidCoverArt(t *testing.T) {
+	drive := &fakeDriveClient{
+		filename:     "cover.pdf",
+		fileContents: closingBuffer{bytes.NewBufferString("file contents")},
+	}
+	extractor := &fakeExtractor{
+		err: fmt.Errorf("%w: must be a JPEG or PNG image, not %q", video.ErrInvalidCoverArt, "cover.pdf"),
+	}
+	handler := MixdownHandler(drive, extractor)
+
+	requestJSON := `{
+		"tracks": [{"sourceFileId": "orchestra"}],
+		"destinationFolderId": "destinationFolderId",
+		"format": "mp3",
+		"coverArtFileId": "cover"
+		}`
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, createRequest(t, requestJSON))
+
+	if diff := cmp.Diff(http.StatusBadRequest, rr.Code); diff != "" {
+		t.Error("Different response code than expected (+got -want):", diff, rr.Body)
+	}
+}
+
+func TestMixdownHandler_BadRequest(t *testing.T) {
+	cases := map[string]string{
+		"AllMuted":      `{"tracks": [{"sourceFileId": "a", "mute": true}]}`,
+		"MissingSource": `{"tracks": [{"gainDb": 1}]}`,
+		"UnknownFormat": `{"tracks": [{"sourceFileId": "a"}], "format": "ogg"}`,
+		"PanTooFar":     `{"tracks": [{"sourceFileId": "a", "pan": 2}]}`,
+		"CoverInWAV":    `{"tracks": [{"sourceFileId": "a"}], "coverArtFileId": "cover"}`,
+		"WindowTooLong": `{"tracks": [{"sourceFileId": "a"}, {"sourceFileId": "b"}], "autoAlign": {"windowSeconds": 10800}}`,
+	}
+	for name, requestJSON := range cases {
+		t.Run(name, func(t *testing.T) {
+			extractor := &fakeExtractor{}
+			handler := MixdownHandler(&fakeDriveClient{}, extractor)
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, createRequest(t, requestJSON))
+
+			if diff := cmp.Diff(http.StatusBadRequest, rr.Code); diff != "" {
+				t.Error("Different response code than expected (+got -want):", diff)
+			}
+			if extractor.tracks != nil {
+				t.Error("Expected no mixdown to be attempted")
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/ssmall/nocco-video-extractor/pkg/drive"
+	"github.com/ssmall/nocco-video-extractor/pkg/video"
+)
+
+// anonymousIDPattern matches the IDs that may name anonymised clips
+var anonymousIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{0,64}$`)
+
+// clipOptions converts the options of a request for a clip of the given duration to ClipOptions,
+// returning an error if they are invalid.
+// Drive files referenced by the options are not downloaded until fetchAssets is called.
+func (cfg *handlerConfig) clipOptions(body ExtractionRequest, duration time.Duration) (video.ClipOptions, error) {
+	var opts video.ClipOptions
+
+	if body.Preset != "" {
+		preset, ok := cfg.presets[body.Preset]
+		if !ok {
+			return opts, fmt.Errorf("unknown preset %q", body.Preset)
+		}
+		if preset.MaxDuration > 0 && duration > preset.MaxDuration {
+			return opts, fmt.Errorf("clip is %s long, but preset %q allows at most %s", duration, body.Preset, preset.MaxDuration)
+		}
+		opts.Preset = &preset
+	}
+
+	if wm := body.Watermark; wm != nil {
+		if !cfg.watermarks {
+			return opts, errors.New("watermarks are not enabled on this server")
+		}
+		if wm.LogoFileID == "" && cfg.logoPath == "" && cfg.logoFileID == "" {
+			return opts, errors.New("no logo is configured on this server, logoFileId is required")
+		}
+		opts.Overlay = &video.Overlay{
+			Path:    cfg.logoPath,
+			Corner:  video.Corner(wm.Corner),
+			Margin:  wm.Margin,
+			Scale:   wm.Scale,
+			Opacity: wm.Opacity,
+		}
+	}
+
+	if t := body.Text; t != nil {
+		opts.Text = &video.TextOverlay{
+			Title:    t.Title,
+			Subtitle: t.Subtitle,
+			Position: video.TextPosition(t.Position),
+			Font:     t.Font,
+			Duration: fromSeconds(t.DurationSeconds),
+			Fade:     fromSeconds(t.FadeSeconds),
+		}
+	}
+
+	if c := body.TitleCard; c != nil {
+		opts.TitleCard = &video.TitleCard{
+			Title:      c.Title,
+			Subtitle:   c.Subtitle,
+			Font:       c.Font,
+			Duration:   fromSeconds(c.DurationSeconds),
+			Fade:       fromSeconds(c.FadeSeconds),
+			Background: c.Background,
+		}
+		if t := body.Text; t != nil && c.Title == "" {
+			opts.TitleCard.Title = t.Title
+			opts.TitleCard.Subtitle = t.Subtitle
+		}
+		if t := body.Text; t != nil && c.Font == "" {
+			opts.TitleCard.Font = t.Font
+		}
+	}
+
+	if body.Intro || body.Outro {
+		if !cfg.bumpers {
+			return opts, errors.New("bumpers are not enabled on this server")
+		}
+		opts.Bumpers = &video.Bumpers{}
+		if body.Intro {
+			if cfg.introPath == "" && cfg.introFileID == "" {
+				return opts, errors.New("no intro is configured on this server")
+			}
+			opts.Bumpers.Intro = cfg.introPath
+		}
+		if body.Outro {
+			if cfg.outroPath == "" && cfg.outroFileID == "" {
+				return opts, errors.New("no outro is configured on this server")
+			}
+			opts.Bumpers.Outro = cfg.outroPath
+		}
+	}
+
+	opts.MaxSize = body.MaxSizeBytes
+
+	if f := body.Fades; f != nil {
+		opts.Fades = &video.Fades{
+			VideoIn:  fromSeconds(f.VideoInSeconds),
+			VideoOut: fromSeconds(f.VideoOutSeconds),
+			AudioIn:  fromSeconds(f.AudioInSeconds),
+			AudioOut: fromSeconds(f.AudioOutSeconds),
+		}
+	}
+
+	if a := body.AutoTrim; a != nil {
+		opts.AutoTrim = &video.AutoTrim{Tolerance: fromSeconds(a.ToleranceSeconds)}
+	}
+
+	if body.SceneChapters != nil || len(body.Chapters) > 0 {
+		opts.Chapters = &video.Chapters{}
+		for _, c := range body.Chapters {
+			opts.Chapters.List = append(opts.Chapters.List, video.Chapter{Start: fromSeconds(c.StartSeconds), Title: c.Title})
+		}
+		if c := body.SceneChapters; c != nil {
+			opts.Chapters.Scenes = &video.SceneOptions{Threshold: c.Threshold}
+		}
+	}
+	opts.Metadata = body.Metadata.metadata()
+
+	if p := body.Privacy; p != nil {
+		if !anonymousIDPattern.MatchString(p.AnonymousID) {
+			return opts, errors.New("anonymousId may contain only letters, digits, hyphens and underscores")
+		}
+		opts.Privacy = &video.Privacy{}
+		for _, c := range p.MuteChannels {
+			if c < 1 {
+				return opts, errors.New("muted channels are numbered from 1")
+			}
+			opts.Privacy.MuteChannels = append(opts.Privacy.MuteChannels, c-1)
+		}
+	}
+
+	opts.Loudness = body.LoudnessLUFS
+
+	if err := opts.Validate(duration); err != nil {
+		return opts, fmt.Errorf("invalid clip options: %w", err)
+	}
+	return opts, nil
+}
+
+// assetIDs returns the IDs of the Drive files referenced by a request's options,
+// each of which is empty if the asset is not used or is read from a local file
+func (cfg *handlerConfig) assetIDs(body ExtractionRequest) (logo, intro, outro string) {
+	if wm := body.Watermark; wm != nil {
+		logo = wm.LogoFileID
+		if logo == "" && cfg.logoPath == "" {
+			logo = cfg.logoFileID
+		}
+	}
+	if body.Intro && cfg.introPath == "" {
+		intro = cfg.introFileID
+	}
+	if body.Outro && cfg.outroPath == "" {
+		outro = cfg.outroFileID
+	}
+	return logo, intro, outro
+}
+
+// errMissingAsset wraps the errors of Drive files referenced by a request's options that do not exist
+var errMissingAsset = errors.New("missing asset")
+
+// checkAssets checks that the Drive files referenced by a request's options exist, so that a request
+// for a missing logo is rejected before its job is created. The error wraps errMissingAsset if one does not.
+func (cfg *handlerConfig) checkAssets(ctx context.Context, body ExtractionRequest) error {
+	logo, intro, outro := cfg.assetIDs(body)
+	for _, asset := range []struct{ name, id string }{{"logo", logo}, {"intro", intro}, {"outro", outro}} {
+		if asset.id == "" {
+			continue
+		}
+		if _, err := cfg.assets.Stat(ctx, asset.id); errors.Is(err, drive.ErrNotFound) {
+			return fmt.Errorf("%w: %s %s was not found", errMissingAsset, asset.name, asset.id)
+		} else if err != nil {
+			return fmt.Errorf("error checking %s %s: %w", asset.name, asset.id, err)
+		}
+	}
+	return nil
+}
+
+// fetchAssets downloads the Drive files referenced by a request's options to local files,
+// updating opts to refer to them
+func (cfg *handlerConfig) fetchAssets(ctx context.Context, body ExtractionRequest, opts *video.ClipOptions) error {
+	logo, intro, outro := cfg.assetIDs(body)
+	if logo != "" {
+		p, err := cfg.assets.Path(ctx, logo)
+		if err != nil {
+			return fmt.Errorf("error fetching logo %s: %w", logo, err)
+		}
+		opts.Overlay.Path = p
+	}
+	if intro != "" {
+		p, err := cfg.assets.Path(ctx, intro)
+		if err != nil {
+			return fmt.Errorf("error fetching intro %s: %w", intro, err)
+		}
+		opts.Bumpers.Intro = p
+	}
+	if outro != "" {
+		p, err := cfg.assets.Path(ctx, outro)
+		if err != nil {
+			return fmt.Errorf("error fetching outro %s: %w", outro, err)
+		}
+		opts.Bumpers.Outro = p
+	}
+	return nil
+}
+
+// metadata converts m to video.Metadata, or nil if m is nil
+func (m *Metadata) metadata() *video.Metadata {
+	if m == nil {
+		return nil
+	}
+	return &video.Metadata{
+		Title:    m.Title,
+		Artist:   m.Artist,
+		Composer: m.Composer,
+		Album:    m.Album,
+		Date:     m.Date,
+		Comment:  m.Comment,
+	}
+}
+
+func fromSeconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,135 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ssmall/nocco-video-extractor/pkg/drive"
+	"github.com/ssmall/nocco-video-extractor/pkg/logging"
+	"github.com/ssmall/nocco-video-extractor/pkg/video"
+	"github.com/ssmall/nocco-video-extractor/pkg/workspace"
+)
+
+// SceneHandler creates a http.HandlerFunc that handles requests to find the scene changes of a
+// Google Drive recording, optionally uploading a thumbnail of each scene
+func SceneHandler(d drive.Client, e video.Extractor, opts ...HandlerOption) http.HandlerFunc {
+	cfg := newHandlerConfig(opts)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		var body SceneRequest
+		if !decodeRequest(w, r, &body) {
+			return
+		}
+
+		logger := logging.FromContext(r.Context())
+		logger.With("sourceFileId", body.SourceFileID).Infof("Scene detection of %s", body.SourceFileID)
+
+		start, end, err := body.interval()
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		opts := video.SceneOptions{Threshold: body.Threshold, ThumbnailWidth: body.ThumbnailWidth}
+		if err := opts.Validate(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		var info *drive.FileInfo
+		cfg.serveJob(w, r, e, jobRequest{
+			callbackURL: body.CallbackURL,
+			priority:    body.Priority,
+			result:      CallbackResult{SceneDetection: &body},
+			space: func(ctx context.Context) (int64, error) {
+				var err error
+				if info, err = d.Stat(ctx, body.SourceFileID); err != nil {
+					return 0, err
+				}
+				return info.Size, nil
+			},
+			status: http.StatusOK,
+			run: func(ctx context.Context, job *workspace.Job, result *CallbackResult) (interface{}, error) {
+				scenes, err := detectScenes(ctx, d, e, job, info.Name, body, start, end, opts)
+				if err != nil {
+					return nil, err
+				}
+				result.Scenes = scenes
+				return &SceneResponse{Scenes: scenes}, nil
+			},
+		})
+	}
+}
+
+func (body SceneRequest) interval() (start, end time.Duration, err error) {
+	if body.SourceFileID == "" {
+		return 0, 0, errors.New("sourceFileId is required")
+	}
+	return parseInterval(body.StartTime, body.EndTime)
+}
+
+// detectScenes downloads the Drive file named filename into the job directory and finds its scenes,
+// uploading their thumbnails if the request has a thumbnail folder
+func detectScenes(ctx context.Context, d drive.Client, e video.Extractor, job *workspace.Job, filename string, body SceneRequest, start, end time.Duration, opts video.SceneOptions) (scenes []Scene, err error) {
+	source, err := downloadFile(ctx, d, job, body.SourceFileID)
+	if err != nil {
+		return nil, err
+	}
+	if body.ThumbnailFolderID != "" {
+		if opts.ThumbnailDir, err = ioutil.TempDir(job.Dir, "scenes-*"); err != nil {
+			return nil, err
+		}
+	}
+
+	results, err := e.DetectScenes(ctx, source, start, end, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	base := strings.TrimSuffix(filename, filepath.Ext(filename))
+	scenes = []Scene{}
+	for i, s := range results {
+		scene := Scene{StartSeconds: s.Start.Seconds(), Score: s.Score}
+		if s.Thumbnail != "" {
+			name := fmt.Sprintf("%s_scene_%03d.jpg", base, i+1)
+			if scene.ThumbnailURL, err = uploadThumbnail(ctx, d, s.Thumbnail, name, body.ThumbnailFolderID); err != nil {
+				return nil, err
+			}
+		}
+		scenes = append(scenes, scene)
+	}
+	logging.FromContext(ctx).Infof("Found %d scenes", len(scenes))
+	return scenes, nil
+}
+
+func uploadThumbnail(ctx context.Context, d drive.Client, path, name, folderID string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return d.UploadFile(ctx, name, folderID, f)
+}
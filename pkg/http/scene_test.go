@@ -0,0 +1,141 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ssmall/nocco-video-extractor/pkg/video"
+)
+
+func TestSceneHandler_Thumbnails(t *testing.T) {
+	thumbnail := filepath.Join(t.TempDir(), "scene-0002.jpg")
+	if err := ioutil.WriteFile(thumbnail, []byte("jpeg data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	drive := &fakeDriveClient{
+		filename:       "concert.mp4",
+		fileContents:   closingBuffer{bytes.NewBufferString("concert contents")},
+		createdFileURL: "https://drive.google.com/thumbnail",
+	}
+	extractor := &fakeExtractor{
+		scenes: []video.Scene{
+			{Start: 0},
+			{Start: 12500 * time.Millisecond, Score: 0.6, Thumbnail: thumbnail},
+		},
+	}
+	handler := SceneHandler(drive, extractor)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, createRequest(t, `{"sourceFileId": "concert", "threshold": 0.3, "thumbnailFolderId": "thumbs"}`))
+
+	if diff := cmp.Diff(http.StatusOK, rr.Code); diff != "" {
+		t.Fatal("Different response code than expected (+got -want):", diff, rr.Body)
+	}
+	if extractor.sceneOpts.Threshold != 0.3 || extractor.sceneOpts.ThumbnailDir == "" {
+		t.Errorf("got scene options %+v, want threshold 0.3 with a thumbnail directory", extractor.sceneOpts)
+	}
+
+	expected := SceneResponse{Scenes: []Scene{
+		{StartSeconds: 0},
+		{StartSeconds: 12.5, Score: 0.6, ThumbnailURL: "https://drive.google.com/thumbnail"},
+	}}
+	var actual SceneResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &actual); err != nil {
+		t.Fatalf("Invalid response %q: %v", rr.Body, err)
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Error("Different response than expected (-want +got):", diff)
+	}
+	if diff := cmp.Diff("concert_scene_002.jpg", drive.uploadFileName); diff != "" {
+		t.Error("Thumbnail name different than expected (-want +got):", diff)
+	}
+	if diff := cmp.Diff("jpeg data", string(drive.uploadFileContents)); diff != "" {
+		t.Error("Thumbnail contents different than expected (-want +got):", diff)
+	}
+}
+
+func TestSceneHandler_Callback(t *testing.T) {
+	drive := &fakeDriveClient{
+		filename:     "concert.mp4",
+		fileContents: closingBuffer{bytes.NewBufferString("concert contents")},
+	}
+	extractor := &fakeExtractor{
+		scenes: []video.Scene{{Start: 0}, {Start: 90 * time.Second, Score: 0.7}},
+	}
+	sender := newFakeSender()
+	handler := SceneHandler(drive, extractor, WithCallbacks(sender))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, createRequest(t, `{"sourceFileId": "concert", "callbackUrl": "https://example.com/callback", "priority": 2}`))
+
+	if diff := cmp.Diff(http.StatusAccepted, rr.Code); diff != "" {
+		t.Fatal("Different response code than expected (-want +got):", diff, rr.Body)
+	}
+	var accepted AcceptedResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &accepted); err != nil {
+		t.Fatalf("Invalid response %q: %v", rr.Body, err)
+	}
+
+	var actual CallbackResult
+	if err := json.Unmarshal(sender.wait(t).payload, &actual); err != nil {
+		t.Fatal(err)
+	}
+	expected := CallbackResult{
+		JobID:  accepted.JobID,
+		Status: JobStatusSucceeded,
+		SceneDetection: &SceneRequest{
+			SourceFileID: "concert",
+			CallbackURL:  "https://example.com/callback",
+			Priority:     2,
+		},
+		Scenes: []Scene{{StartSeconds: 0}, {StartSeconds: 90, Score: 0.7}},
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Error("Different callback payload than expected (-want +got):", diff)
+	}
+}
+
+func TestSceneHandler_BadRequest(t *testing.T) {
+	cases := map[string]string{
+		"NoSource":         `{"threshold": 0.3}`,
+		"ThresholdTooHigh": `{"sourceFileId": "concert", "threshold": 1.5}`,
+		"OddWidth":         `{"sourceFileId": "concert", "thumbnailWidth": 301}`,
+	}
+	for name, requestJSON := range cases {
+		t.Run(name, func(t *testing.T) {
+			extractor := &fakeExtractor{}
+			handler := SceneHandler(&fakeDriveClient{}, extractor)
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, createRequest(t, requestJSON))
+
+			if diff := cmp.Diff(http.StatusBadRequest, rr.Code); diff != "" {
+				t.Error("Different response code than expected (+got -want):", diff)
+			}
+			if extractor.sceneFile != "" {
+				t.Error("Expected no scene detection to be attempted")
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ssmall/nocco-video-extractor/pkg/drive"
+	"github.com/ssmall/nocco-video-extractor/pkg/logging"
+	"github.com/ssmall/nocco-video-extractor/pkg/video"
+	"github.com/ssmall/nocco-video-extractor/pkg/workspace"
+)
+
+// SplitHandler creates a http.HandlerFunc that handles requests to split a Google Drive recording
+// into pieces at its silences. The pieces are proposed as segments and, if requested, extracted as clips.
+func SplitHandler(d drive.Client, e video.Extractor, opts ...HandlerOption) http.HandlerFunc {
+	cfg := newHandlerConfig(opts)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		var body SplitRequest
+		if !decodeRequest(w, r, &body) {
+			return
+		}
+
+		logger := logging.FromContext(r.Context())
+		logger.WithFields(logging.Fields{
+			"sourceFileId": body.SourceFileID,
+			"execute":      body.Execute,
+		}).Infof("Split of %s at silences", body.SourceFileID)
+
+		opts, err := body.options()
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		status := http.StatusOK
+		if body.Execute {
+			status = http.StatusCreated
+		}
+		var info *drive.FileInfo
+		cfg.serveJob(w, r, e, jobRequest{
+			callbackURL: body.CallbackURL,
+			priority:    body.Priority,
+			result:      CallbackResult{Split: &body},
+			space: func(ctx context.Context) (int64, error) {
+				var err error
+				if info, err = d.Stat(ctx, body.SourceFileID); err != nil {
+					return 0, err
+				}
+				if body.Execute {
+					return requiredSpace(info.Size), nil
+				}
+				return info.Size, nil
+			},
+			status: status,
+			run: func(ctx context.Context, job *workspace.Job, result *CallbackResult) (interface{}, error) {
+				resp, err := split(ctx, d, e, job, info.Name, body, opts)
+				if resp == nil {
+					return nil, err
+				}
+				result.Segments = resp.Segments
+				return resp, err
+			},
+		})
+	}
+}
+
+func (body SplitRequest) options() (video.SilenceOptions, error) {
+	if body.SourceFileID == "" {
+		return video.SilenceOptions{}, errors.New("sourceFileId is required")
+	}
+	if body.Execute && body.DestinationFolderID == "" {
+		return video.SilenceOptions{}, errors.New("destinationFolderId is required to execute a split")
+	}
+	if body.MinPieceSeconds < 0 {
+		return video.SilenceOptions{}, errors.New("minPieceSeconds must not be negative")
+	}
+	opts := video.SilenceOptions{
+		ThresholdDB: body.ThresholdDB,
+		MinGap:      fromSeconds(body.MinGapSeconds),
+	}
+	return opts, opts.Validate()
+}
+
+// split downloads the Drive file named filename into the job directory and proposes the pieces between its silences,
+// extracting and uploading each piece if the request says to execute the split.
+// If a piece fails, the response is returned along with the error, with the URLs of the pieces uploaded before it.
+func split(ctx context.Context, d drive.Client, e video.Extractor, job *workspace.Job, filename string, body SplitRequest, opts video.SilenceOptions) (resp *SplitResponse, err error) {
+	logger := logging.FromContext(ctx)
+
+	source, err := downloadFile(ctx, d, job, body.SourceFileID)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := e.DetectSilence(ctx, source, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	resp = &SplitResponse{Silences: []Silence{}, Segments: []SplitSegment{}}
+	for _, s := range report.Silences {
+		resp.Silences = append(resp.Silences, Silence{StartSeconds: s.Start.Seconds(), EndSeconds: s.End.Seconds()})
+	}
+	pieces := report.Pieces(fromSeconds(body.MinPieceSeconds))
+	for i, p := range pieces {
+		end := p.End
+		if i == len(pieces)-1 {
+			// Round the end of the recording up so that no part of the last piece is cut off
+			end = (end + time.Second - 1).Truncate(time.Second)
+		}
+		resp.Segments = append(resp.Segments, SplitSegment{ClipStartTime: video.FormatHHMMSS(p.Start), ClipEndTime: video.FormatHHMMSS(end)})
+	}
+	logger.Infof("Found %d silences, proposing %d pieces", len(resp.Silences), len(resp.Segments))
+
+	if !body.Execute {
+		return resp, nil
+	}
+	for i := range resp.Segments {
+		s := &resp.Segments[i]
+		if s.FileURL, err = splitPiece(ctx, d, e, source, filename, body.DestinationFolderID, *s); err != nil {
+			err = fmt.Errorf("error splitting piece %d of %d: %w", i+1, len(resp.Segments), err)
+			resp.Error = err.Error()
+			return resp, err
+		}
+	}
+	return resp, nil
+}
+
+// splitPiece extracts a piece of the local file source, named after the Drive file filename, and uploads it
+func splitPiece(ctx context.Context, d drive.Client, e video.Extractor, source, filename, folderID string, s SplitSegment) (string, error) {
+	start, err := parseDuration(s.ClipStartTime)
+	if err != nil {
+		return "", err
+	}
+	end, err := parseDuration(s.ClipEndTime)
+	if err != nil {
+		return "", err
+	}
+
+	clip, err := e.Clip(ctx, source, start, end, video.ClipOptions{})
+	if err != nil {
+		return "", err
+	}
+	defer clip.Close()
+
+	name := clipName(clipOutputName(filename, video.ClipOptions{}), s.ClipStartTime, s.ClipEndTime)
+	logging.FromContext(ctx).Infof("Uploading piece as %q", name)
+
+	return d.UploadFile(ctx, name, folderID, clip)
+}
@@ -0,0 +1,221 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ssmall/nocco-video-extractor/pkg/video"
+)
+
+var concertSilences = video.SilenceReport{
+	Duration: 20*time.Minute + 300*time.Millisecond,
+	Silences: []video.Interval{
+		{Start: 7 * time.Minute, End: 7*time.Minute + 10*time.Second},
+		{Start: 15*time.Minute + 2*time.Second, End: 15*time.Minute + 4*time.Second},
+	},
+}
+
+func TestSplitHandler_Propose(t *testing.T) {
+	drive := &fakeDriveClient{
+		filename:     "concert.mp4",
+		fileContents: closingBuffer{bytes.NewBufferString("concert contents")},
+	}
+	extractor := &fakeExtractor{silences: concertSilences}
+	handler := SplitHandler(drive, extractor)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, createRequest(t, `{"sourceFileId": "concert", "thresholdDb": -50, "minGapSeconds": 1.5}`))
+
+	if diff := cmp.Diff(http.StatusOK, rr.Code); diff != "" {
+		t.Fatal("Different response code than expected (+got -want):", diff, rr.Body)
+	}
+	if diff := cmp.Diff(video.SilenceOptions{ThresholdDB: -50, MinGap: 1500 * time.Millisecond}, extractor.silenceOpts); diff != "" {
+		t.Error("Silence options different than expected (-want +got):", diff)
+	}
+	if extractor.clipFilename != "" {
+		t.Error("Expected no pieces to be extracted")
+	}
+
+	expected := SplitResponse{
+		Silences: []Silence{{StartSeconds: 420, EndSeconds: 430}, {StartSeconds: 902, EndSeconds: 904}},
+		Segments: []SplitSegment{
+			{ClipStartTime: "00:00:00", ClipEndTime: "00:07:05"},
+			{ClipStartTime: "00:07:05", ClipEndTime: "00:15:03"},
+			{ClipStartTime: "00:15:03", ClipEndTime: "00:20:01"},
+		},
+	}
+	var actual SplitResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &actual); err != nil {
+		t.Fatalf("Invalid response %q: %v", rr.Body, err)
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Error("Different response than expected (-want +got):", diff)
+	}
+}
+
+func TestSplitHandler_Execute(t *testing.T) {
+	drive := &fakeDriveClient{
+		filename:       "concert.mp4",
+		fileContents:   closingBuffer{bytes.NewBufferString("concert contents")},
+		createdFileURL: "https://drive.google.com/piece",
+	}
+	extractor := &fakeExtractor{
+		contents: closingBuffer{bytes.NewBufferString("piece contents")},
+		silences: concertSilences,
+	}
+	handler := SplitHandler(drive, extractor)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, createRequest(t, `{"sourceFileId": "concert", "minPieceSeconds": 360, "execute": true, "destinationFolderId": "pieces"}`))
+
+	if diff := cmp.Diff(http.StatusCreated, rr.Code); diff != "" {
+		t.Fatal("Different response code than expected (+got -want):", diff, rr.Body)
+	}
+
+	expected := []SplitSegment{
+		{ClipStartTime: "00:00:00", ClipEndTime: "00:07:05", FileURL: "https://drive.google.com/piece"},
+		{ClipStartTime: "00:07:05", ClipEndTime: "00:20:01", FileURL: "https://drive.google.com/piece"},
+	}
+	var actual SplitResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &actual); err != nil {
+		t.Fatalf("Invalid response %q: %v", rr.Body, err)
+	}
+	if diff := cmp.Diff(expected, actual.Segments); diff != "" {
+		t.Error("Different segments than expected (-want +got):", diff)
+	}
+
+	if diff := cmp.Diff(425*time.Second, extractor.clipStart); diff != "" {
+		t.Error("Last piece start different than expected (-want +got):", diff)
+	}
+	if diff := cmp.Diff("concert_00:07:05_to_00:20:01.mp4", drive.uploadFileName); diff != "" {
+		t.Error("Last piece name different than expected (-want +got):", diff)
+	}
+	if diff := cmp.Diff("pieces", drive.uploadFileFolder); diff != "" {
+		t.Error("Upload folder different than expected (-want +got):", diff)
+	}
+}
+
+// flakyUploadDrive fails every upload after the first
+type flakyUploadDrive struct {
+	*fakeDriveClient
+	uploads int
+}
+
+func (c *flakyUploadDrive) UploadFile(ctx context.Context, name, folder string, contents io.Reader) (string, error) {
+	c.uploads++
+	if c.uploads > 1 {
+		return "", errors.New("quota exceeded")
+	}
+	return c.fakeDriveClient.UploadFile(ctx, name, folder, contents)
+}
+
+func TestSplitHandler_PartialFailure(t *testing.T) {
+	expectedSegments := []SplitSegment{
+		{ClipStartTime: "00:00:00", ClipEndTime: "00:07:05", FileURL: "https://drive.google.com/piece"},
+		{ClipStartTime: "00:07:05", ClipEndTime: "00:20:01"},
+	}
+	expectedError := "error splitting piece 2 of 2: quota exceeded"
+	requestJSON := `{"sourceFileId": "concert", "minPieceSeconds": 360, "execute": true, "destinationFolderId": "pieces"`
+
+	newHandler := func(opts ...HandlerOption) http.HandlerFunc {
+		drive := &flakyUploadDrive{fakeDriveClient: &fakeDriveClient{
+			filename:       "concert.mp4",
+			fileContents:   closingBuffer{bytes.NewBufferString("concert contents")},
+			createdFileURL: "https://drive.google.com/piece",
+		}}
+		extractor := &fakeExtractor{
+			contents: closingBuffer{bytes.NewBufferString("piece contents")},
+			silences: concertSilences,
+		}
+		return SplitHandler(drive, extractor, opts...)
+	}
+
+	t.Run("Response", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		newHandler().ServeHTTP(rr, createRequest(t, requestJSON+"}"))
+
+		if diff := cmp.Diff(http.StatusInternalServerError, rr.Code); diff != "" {
+			t.Fatal("Different response code than expected (-want +got):", diff, rr.Body)
+		}
+		var actual SplitResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &actual); err != nil {
+			t.Fatalf("Invalid response %q: %v", rr.Body, err)
+		}
+		if diff := cmp.Diff(expectedSegments, actual.Segments); diff != "" {
+			t.Error("Different segments than expected (-want +got):", diff)
+		}
+		if diff := cmp.Diff(expectedError, actual.Error); diff != "" {
+			t.Error("Different error than expected (-want +got):", diff)
+		}
+	})
+
+	t.Run("Callback", func(t *testing.T) {
+		sender := newFakeSender()
+		rr := httptest.NewRecorder()
+		newHandler(WithCallbacks(sender)).ServeHTTP(rr, createRequest(t, requestJSON+`, "callbackUrl": "https://example.com/callback"}`))
+
+		if diff := cmp.Diff(http.StatusAccepted, rr.Code); diff != "" {
+			t.Fatal("Different response code than expected (-want +got):", diff, rr.Body)
+		}
+		var actual CallbackResult
+		if err := json.Unmarshal(sender.wait(t).payload, &actual); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(JobStatusFailed, actual.Status); diff != "" {
+			t.Error("Different status than expected (-want +got):", diff)
+		}
+		if diff := cmp.Diff(expectedSegments, actual.Segments); diff != "" {
+			t.Error("Different segments than expected (-want +got):", diff)
+		}
+		if diff := cmp.Diff(expectedError, actual.Error); diff != "" {
+			t.Error("Different error than expected (-want +got):", diff)
+		}
+	})
+}
+
+func TestSplitHandler_BadRequest(t *testing.T) {
+	cases := map[string]string{
+		"NoSource":          `{}`,
+		"NoDestination":     `{"sourceFileId": "concert", "execute": true}`,
+		"PositiveThreshold": `{"sourceFileId": "concert", "thresholdDb": 6}`,
+		"NegativePiece":     `{"sourceFileId": "concert", "minPieceSeconds": -1}`,
+	}
+	for name, requestJSON := range cases {
+		t.Run(name, func(t *testing.T) {
+			extractor := &fakeExtractor{}
+			handler := SplitHandler(&fakeDriveClient{}, extractor)
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, createRequest(t, requestJSON))
+
+			if diff := cmp.Diff(http.StatusBadRequest, rr.Code); diff != "" {
+				t.Error("Different response code than expected (+got -want):", diff)
+			}
+			if extractor.silenceInput != "" {
+				t.Error("Expected no silence detection to be attempted")
+			}
+		})
+	}
+}
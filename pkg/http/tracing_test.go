@@ -0,0 +1,56 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/propagators"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestTrace_PropagatesIncomingContext(t *testing.T) {
+	global.SetTextMapPropagator(propagators.TraceContext{})
+	global.SetTracerProvider(sdktrace.NewTracerProvider())
+
+	var got trace.SpanContext
+	h := Trace("test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = trace.SpanFromContext(r.Context()).SpanContext()
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/test", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+
+	h.ServeHTTP(rr, req)
+
+	if diff := cmp.Diff("4bf92f3577b34da6a3ce929d0e0e4736", got.TraceID.String()); diff != "" {
+		t.Error("Trace ID different than expected (-want +got):", diff)
+	}
+
+	if got.SpanID.String() == "00f067aa0ba902b7" {
+		t.Error("Expected a new child span, got the incoming span")
+	}
+
+	if diff := cmp.Diff(http.StatusTeapot, rr.Code); diff != "" {
+		t.Error("Different response code than expected (-want +got):", diff)
+	}
+}
@@ -20,9 +20,463 @@ type ExtractionRequest struct {
 	ClipStartTime       string `json:"clipStartTime"`
 	ClipEndTime         string `json:"clipEndTime"`
 	DestinationFolderID string `json:"destinationFolderId"`
+	// CallbackURL, if set, causes the request to be processed asynchronously
+	// with the result POSTed to this URL as a CallbackResult
+	CallbackURL string `json:"callbackUrl,omitempty"`
+	// Priority orders this request relative to others waiting for a free worker.
+	// Requests with higher priority are processed first; the default is 0.
+	Priority int `json:"priority,omitempty"`
+	// Preset, if set, names the output preset that determines the clip's dimensions and encoding.
+	// The clip is re-encoded.
+	Preset string `json:"preset,omitempty"`
+	// MaxSizeBytes, if set, is the maximum size of the clip. The clip is re-encoded in two passes at the
+	// bitrate that fits, and encoded again at a lower bitrate if it is still too large.
+	MaxSizeBytes int64 `json:"maxSizeBytes,omitempty"`
+	// Fades, if set, fade the clip in and out. Video fades require the clip to be re-encoded.
+	Fades *FadeOptions `json:"fades,omitempty"`
+	// Watermark, if set, draws a logo over the clip. The clip is re-encoded, which is much slower than copying it.
+	Watermark *Watermark `json:"watermark,omitempty"`
+	// Text, if set, draws a title and subtitle over the start of the clip. The clip is re-encoded.
+	Text *TextOptions `json:"text,omitempty"`
+	// TitleCard, if set, prepends a card showing a title and subtitle to the clip. The clip is re-encoded.
+	TitleCard *TitleCardOptions `json:"titleCard,omitempty"`
+	// Intro and Outro join the branded intro and outro configured on the server to the start and end of the clip,
+	// converted to match it. The clip is re-encoded.
+	Intro bool `json:"intro,omitempty"`
+	Outro bool `json:"outro,omitempty"`
+	// AutoTrim, if set, moves the start and end of the clip to the nearest boundaries of music,
+	// leaving out applause and silence. Video is re-encoded, as the boundaries rarely fall on keyframes.
+	AutoTrim *AutoTrimOptions `json:"autoTrim,omitempty"`
+	// SceneChapters, if set, adds a chapter to the clip at the start of each scene
+	SceneChapters *SceneChapterOptions `json:"sceneChapters,omitempty"`
+	// Chapters are written into the clip, together with any scene chapters
+	Chapters []Chapter `json:"chapters,omitempty"`
+	// Metadata, if set, are the tags written into the clip. Tagged clips of MPEG-TS files are written as MP4,
+	// which unlike MPEG-TS can hold tags.
+	Metadata *Metadata `json:"metadata,omitempty"`
+	// StartMarker and EndMarker, if set, name markers of the source file at which the clip starts and ends,
+	// instead of ClipStartTime and ClipEndTime. Each may be moved by an offset in seconds, which may be negative.
+	StartMarker        string  `json:"startMarker,omitempty"`
+	StartOffsetSeconds float64 `json:"startOffsetSeconds,omitempty"`
+	EndMarker          string  `json:"endMarker,omitempty"`
+	EndOffsetSeconds   float64 `json:"endOffsetSeconds,omitempty"`
+	// Privacy, if set, strips identifying information from the clip, such as for blind auditions
+	Privacy *PrivacyOptions `json:"privacy,omitempty"`
+	// LoudnessLUFS, if set, is the integrated loudness to which the clip's audio is normalised, e.g. -16.
+	// The audio is re-encoded.
+	LoudnessLUFS float64 `json:"loudnessLufs,omitempty"`
+}
+
+// PrivacyOptions customise how a clip is anonymised. The metadata of the source, such as its location
+// and creation time, are always removed.
+type PrivacyOptions struct {
+	// MuteChannels are the audio channels, numbered from 1, that are silenced. The audio is re-encoded.
+	MuteChannels []int `json:"muteChannels,omitempty"`
+	// AnonymousID names the uploaded clip instead of the source file, by default a random ID.
+	// It may contain only letters, digits, hyphens and underscores.
+	AnonymousID string `json:"anonymousId,omitempty"`
+}
+
+// Chapter is a titled point in a clip
+type Chapter struct {
+	// StartSeconds is the start of the chapter relative to the start of the clip
+	StartSeconds float64 `json:"startSeconds"`
+	Title        string  `json:"title"`
+}
+
+// Metadata are the tags written into an output file, shown by media players instead of its name
+type Metadata struct {
+	Title    string `json:"title,omitempty"`
+	Artist   string `json:"artist,omitempty"`
+	Composer string `json:"composer,omitempty"`
+	Album    string `json:"album,omitempty"`
+	Date     string `json:"date,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// SceneChapterOptions customise the chapters added at scene changes
+type SceneChapterOptions struct {
+	// Threshold is the scene score, from 0 to 1, above which a frame starts a new scene, by default 0.4
+	Threshold float64 `json:"threshold,omitempty"`
+}
+
+// AutoTrimOptions customise how a clip is trimmed to its music
+type AutoTrimOptions struct {
+	// ToleranceSeconds is how far either end of the clip may move, by default 10 seconds
+	ToleranceSeconds float64 `json:"toleranceSeconds,omitempty"`
+}
+
+// CompilationRequest represents the body of a request to the CompilationHandler
+type CompilationRequest struct {
+	// Segments are the parts of one or more Drive files to join, in order
+	Segments            []Segment `json:"segments"`
+	DestinationFolderID string    `json:"destinationFolderId"`
+	// OutputName is the name of the uploaded compilation, by default "compilation.mp4".
+	// The .mp4 extension is added if missing.
+	OutputName string `json:"outputName,omitempty"`
+	// CrossfadeSeconds is how long consecutive segments crossfade; by default they are cut together
+	CrossfadeSeconds float64 `json:"crossfadeSeconds,omitempty"`
+	// Preset, if set, names the output preset that determines the compilation's dimensions and encoding.
+	// Otherwise, all segments are scaled to the dimensions of the first.
+	Preset string `json:"preset,omitempty"`
+	// Metadata, if set, are the tags written into the compilation
+	Metadata *Metadata `json:"metadata,omitempty"`
+	// CallbackURL and Priority are as for an ExtractionRequest
+	CallbackURL string `json:"callbackUrl,omitempty"`
+	Priority    int    `json:"priority,omitempty"`
+}
+
+// Segment is a part of a Drive file included in a compilation
+type Segment struct {
+	SourceFileID  string `json:"sourceFileId"`
+	ClipStartTime string `json:"clipStartTime"`
+	ClipEndTime   string `json:"clipEndTime"`
+}
+
+// CompositionRequest represents the body of a request to the CompositionHandler
+type CompositionRequest struct {
+	// Parts are the Drive files shown side by side, with their audio mixed
+	Parts               []CompositionPart `json:"parts"`
+	DestinationFolderID string            `json:"destinationFolderId"`
+	// OutputName is the name of the uploaded composition, by default "composition.mp4".
+	// The .mp4 extension is added if missing.
+	OutputName string `json:"outputName,omitempty"`
+	// Width and Height are the dimensions of the composition, by default 1920x1080
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	// Layout, if set, gives the cell in which each part is shown, in order.
+	// By default, the parts are laid out in a grid.
+	Layout []Cell `json:"layout,omitempty"`
+	// AutoAlign, if set, aligns the parts by their audio before composing them.
+	// Each part's offsetSeconds is then added to the offset found for it.
+	AutoAlign *AlignOptions `json:"autoAlign,omitempty"`
+	// Metadata, if set, are the tags written into the composition
+	Metadata *Metadata `json:"metadata,omitempty"`
+	// CallbackURL and Priority are as for an ExtractionRequest
+	CallbackURL string `json:"callbackUrl,omitempty"`
+	Priority    int    `json:"priority,omitempty"`
+}
+
+// MixdownRequest represents the body of a request to the MixdownHandler
+type MixdownRequest struct {
+	// Tracks are the Drive files whose audio is mixed
+	Tracks              []MixdownTrack `json:"tracks"`
+	DestinationFolderID string         `json:"destinationFolderId"`
+	// Format is the file format of the mixdown: wav (the default), flac or mp3
+	Format string `json:"format,omitempty"`
+	// LoudnessLUFS, if set, is the integrated loudness to which the mixdown is normalised, e.g. -16
+	LoudnessLUFS float64 `json:"loudnessLufs,omitempty"`
+	// OutputName is the name of the uploaded mixdown, by default "mixdown" with the extension of the format.
+	// The extension is added if missing.
+	OutputName string `json:"outputName,omitempty"`
+	// AutoAlign, if set, aligns the tracks by their audio before mixing them, as for a CompositionRequest
+	AutoAlign *AlignOptions `json:"autoAlign,omitempty"`
+	// Metadata, if set, are the tags written into the mixdown
+	Metadata *Metadata `json:"metadata,omitempty"`
+	// CoverArtFileID, if set, is the Drive file ID of a JPEG or PNG image embedded as the cover of the mixdown.
+	// WAV files cannot have cover art.
+	CoverArtFileID string `json:"coverArtFileId,omitempty"`
+	// CallbackURL and Priority are as for an ExtractionRequest
+	CallbackURL string `json:"callbackUrl,omitempty"`
+	Priority    int    `json:"priority,omitempty"`
+}
+
+// MixdownTrack is a Drive file whose audio is included in a mixdown
+type MixdownTrack struct {
+	SourceFileID string `json:"sourceFileId"`
+	// OffsetSeconds aligns the track with the others, as for a CompositionPart
+	OffsetSeconds float64 `json:"offsetSeconds,omitempty"`
+	// GainDB adjusts the volume of the track in decibels
+	GainDB float64 `json:"gainDb,omitempty"`
+	// Pan positions the track from -1 (left) through 0 (centre) to 1 (right)
+	Pan float64 `json:"pan,omitempty"`
+	// Mute excludes the track from the mixdown, e.g. to make a play-along track without it
+	Mute bool `json:"mute,omitempty"`
+}
+
+// AlignmentRequest represents the body of a request to the AlignmentHandler
+type AlignmentRequest struct {
+	// SourceFileIDs are the Drive files to align; offsets are relative to the first
+	SourceFileIDs []string `json:"sourceFileIds"`
+	AlignOptions
+}
+
+// AlignOptions customise how recordings are aligned by their audio
+type AlignOptions struct {
+	// Method is correlation (the default), which matches each recording's audio against the first,
+	// or clap, which matches the first loud transient in each
+	Method string `json:"method,omitempty"`
+	// WindowSeconds is how much audio is analysed from the start of each recording, by default 120 and at most 300 seconds
+	WindowSeconds float64 `json:"windowSeconds,omitempty"`
+	// MaxOffsetSeconds is the largest offset considered, by default half the window
+	MaxOffsetSeconds float64 `json:"maxOffsetSeconds,omitempty"`
+}
+
+// AlignmentResponse represents the response body of the AlignmentHandler
+type AlignmentResponse struct {
+	Alignments []Alignment `json:"alignments"`
+}
+
+// Alignment is the position of a recording relative to the first
+type Alignment struct {
+	SourceFileID string `json:"sourceFileId"`
+	// OffsetSeconds is the time in the recording that coincides with the start of the first,
+	// suitable for a CompositionPart. It is negative if the recording started after the first.
+	OffsetSeconds float64 `json:"offsetSeconds"`
+	// Confidence is from 0, when another offset matched as well, to 1, when none came close
+	Confidence float64 `json:"confidence"`
+}
+
+// ClassificationRequest represents the body of a request to the ClassificationHandler
+type ClassificationRequest struct {
+	SourceFileID string `json:"sourceFileId"`
+	// StartTime and EndTime, in the format HH:MM:SS, limit the classification to part of the recording
+	StartTime string `json:"startTime,omitempty"`
+	EndTime   string `json:"endTime,omitempty"`
+}
+
+// ClassificationResponse represents the response body of the ClassificationHandler
+type ClassificationResponse struct {
+	Regions []Region `json:"regions"`
+}
+
+// Region is an interval of a recording containing one kind of sound: silence, applause or music
+type Region struct {
+	Kind         string  `json:"kind"`
+	StartSeconds float64 `json:"startSeconds"`
+	EndSeconds   float64 `json:"endSeconds"`
+}
+
+// SceneRequest represents the body of a request to the SceneHandler
+type SceneRequest struct {
+	SourceFileID string `json:"sourceFileId"`
+	// StartTime and EndTime, in the format HH:MM:SS, limit the detection to part of the recording
+	StartTime string `json:"startTime,omitempty"`
+	EndTime   string `json:"endTime,omitempty"`
+	// Threshold is the scene score, from 0 to 1, above which a frame starts a new scene, by default 0.4
+	Threshold float64 `json:"threshold,omitempty"`
+	// ThumbnailFolderID, if set, is the Drive folder to which a thumbnail of each scene is uploaded
+	ThumbnailFolderID string `json:"thumbnailFolderId,omitempty"`
+	// ThumbnailWidth is the width of thumbnails in pixels, by default 320
+	ThumbnailWidth int `json:"thumbnailWidth,omitempty"`
+	// CallbackURL, if set, makes the request asynchronous,
+	// with the result POSTed to this URL as a CallbackResult
+	CallbackURL string `json:"callbackUrl,omitempty"`
+	// Priority orders this request relative to others waiting for a free worker
+	Priority int `json:"priority,omitempty"`
+}
+
+// SceneResponse represents the response body of the SceneHandler
+type SceneResponse struct {
+	Scenes []Scene `json:"scenes"`
+}
+
+// Scene is a continuous shot of a recording
+type Scene struct {
+	StartSeconds float64 `json:"startSeconds"`
+	// Score is how different the first frame of the scene is from the frame before, from 0 to 1
+	Score float64 `json:"score"`
+	// ThumbnailURL is the URL of the scene's thumbnail, if requested
+	ThumbnailURL string `json:"thumbnailUrl,omitempty"`
+}
+
+// SplitRequest represents the body of a request to the SplitHandler
+type SplitRequest struct {
+	SourceFileID string `json:"sourceFileId"`
+	// ThresholdDB is the level below which audio is considered silent, by default -40 dB
+	ThresholdDB float64 `json:"thresholdDb,omitempty"`
+	// MinGapSeconds is the shortest silence between pieces, by default 2 seconds
+	MinGapSeconds float64 `json:"minGapSeconds,omitempty"`
+	// MinPieceSeconds is the shortest piece proposed; silences that would make shorter pieces are ignored
+	MinPieceSeconds float64 `json:"minPieceSeconds,omitempty"`
+	// Execute, if set, extracts each proposed piece as a clip uploaded to DestinationFolderID
+	Execute             bool   `json:"execute,omitempty"`
+	DestinationFolderID string `json:"destinationFolderId,omitempty"`
+	// CallbackURL, if set, makes the request asynchronous,
+	// with the result POSTed to this URL as a CallbackResult
+	CallbackURL string `json:"callbackUrl,omitempty"`
+	// Priority orders this request relative to others waiting for a free worker
+	Priority int `json:"priority,omitempty"`
+}
+
+// SplitResponse represents the response body of the SplitHandler
+type SplitResponse struct {
+	Silences []Silence      `json:"silences"`
+	Segments []SplitSegment `json:"segments"`
+	// Error is set if an executed split failed, in which case only the pieces before the failure have a FileURL
+	Error string `json:"error,omitempty"`
+}
+
+// Silence is a silent interval of a recording
+type Silence struct {
+	StartSeconds float64 `json:"startSeconds"`
+	EndSeconds   float64 `json:"endSeconds"`
+}
+
+// SplitSegment is a piece of a recording between silences, with timestamps suitable for an ExtractionRequest
+type SplitSegment struct {
+	ClipStartTime string `json:"clipStartTime"`
+	ClipEndTime   string `json:"clipEndTime"`
+	// FileURL is the URL of the piece's clip, if the split was executed
+	FileURL string `json:"fileUrl,omitempty"`
+}
+
+// Marker is a named point in a source file, such as a rehearsal letter
+type Marker struct {
+	Name string `json:"name"`
+	// Timestamp is the offset of the marker from the start of the file, as HH:MM:SS, followed by the milliseconds as .mmm if it is not a whole second
+	Timestamp string `json:"timestamp"`
+}
+
+// MarkerListResponse represents the response body of the MarkerHandler listing the markers of a file
+type MarkerListResponse struct {
+	// Markers are ordered by time
+	Markers []Marker `json:"markers"`
+}
+
+// AuditionRequest represents the body of a request to the AuditionHandler
+type AuditionRequest struct {
+	// Pipeline names the audition pipeline, configured on the server for each audition cycle
+	Pipeline     string `json:"pipeline"`
+	SourceFileID string `json:"sourceFileId"`
+	// CandidateID is the anonymous number under which the submission is reviewed, by default a random 12-digit number
+	// following the pipeline's prefix. It may contain only letters, digits, hyphens and underscores, and must not
+	// have been given to another submission.
+	CandidateID string `json:"candidateId,omitempty"`
+	// Excerpts locate each of the pipeline's required excerpts in the submission
+	Excerpts []AuditionExcerpt `json:"excerpts"`
+	// CallbackURL, if set, makes the request asynchronous,
+	// with the result POSTed to this URL as a CallbackResult
+	CallbackURL string `json:"callbackUrl,omitempty"`
+	// Priority orders this request relative to others waiting for a free worker
+	Priority int `json:"priority,omitempty"`
+}
+
+// AuditionExcerpt is the part of a submission in which a required excerpt is played
+type AuditionExcerpt struct {
+	Name          string `json:"name"`
+	ClipStartTime string `json:"clipStartTime"`
+	ClipEndTime   string `json:"clipEndTime"`
+}
+
+// AuditionResponse represents the response body of the AuditionHandler
+type AuditionResponse struct {
+	CandidateID string         `json:"candidateId"`
+	Clips       []AuditionClip `json:"clips"`
+	// MappingFileURL is the URL of the sheet, in the pipeline's restricted folder,
+	// that maps the candidate to the submission
+	MappingFileURL string `json:"mappingFileUrl"`
+}
+
+// AuditionClip is an anonymised excerpt uploaded to the review folder
+type AuditionClip struct {
+	Excerpt string `json:"excerpt"`
+	FileURL string `json:"fileUrl"`
+}
+
+// CompositionPart is a Drive file included in a composition
+type CompositionPart struct {
+	SourceFileID string `json:"sourceFileId"`
+	// OffsetSeconds is the time in the file at which the composition starts, aligning it with the other parts.
+	// A negative offset delays the part instead.
+	OffsetSeconds float64 `json:"offsetSeconds,omitempty"`
+	// GainDB adjusts the volume of the part in decibels
+	GainDB float64 `json:"gainDb,omitempty"`
+}
+
+// Cell is the area of a composition in which a part is shown, in pixels from the top left corner
+type Cell struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// Watermark describes a logo drawn over a clip
+type Watermark struct {
+	// LogoFileID is the Drive file ID of the logo, preferably a PNG with transparency.
+	// If empty, the logo configured on the server is used.
+	LogoFileID string `json:"logoFileId,omitempty"`
+	// Corner is the corner in which the logo is placed: top-left, top-right, bottom-left or bottom-right (the default)
+	Corner string `json:"corner,omitempty"`
+	// Margin is the distance in pixels between the logo and the edges of the frame
+	Margin int `json:"margin,omitempty"`
+	// Scale is the width of the logo as a fraction of the width of the frame, or 0 for the logo's original size
+	Scale float64 `json:"scale,omitempty"`
+	// Opacity is the opacity of the logo from 0 to 1; 0 (the default) is treated as fully opaque
+	Opacity float64 `json:"opacity,omitempty"`
+}
+
+// TextOptions describes text drawn over the start of a clip
+type TextOptions struct {
+	Title    string `json:"title"`
+	Subtitle string `json:"subtitle,omitempty"`
+	// Position is where the text is placed: lower-third (the default), top or center
+	Position string `json:"position,omitempty"`
+	// Font is one of the fonts bundled with the server: sans (the default), sans-bold, serif or serif-bold
+	Font string `json:"font,omitempty"`
+	// DurationSeconds is how long the text is shown, by default 5 seconds
+	DurationSeconds float64 `json:"durationSeconds,omitempty"`
+	// FadeSeconds is how long the text takes to fade in and out; by default it does not fade
+	FadeSeconds float64 `json:"fadeSeconds,omitempty"`
+}
+
+// TitleCardOptions describes a title card prepended to a clip
+type TitleCardOptions struct {
+	// Title and Subtitle default to those of the request's Text
+	Title    string `json:"title,omitempty"`
+	Subtitle string `json:"subtitle,omitempty"`
+	// Font defaults to that of the request's Text
+	Font string `json:"font,omitempty"`
+	// DurationSeconds is how long the card is shown, by default 3 seconds
+	DurationSeconds float64 `json:"durationSeconds,omitempty"`
+	// FadeSeconds is how long the card takes to fade in and out; by default it does not fade
+	FadeSeconds float64 `json:"fadeSeconds,omitempty"`
+	// Background is the colour of the card, by default black
+	Background string `json:"background,omitempty"`
+}
+
+// FadeOptions are the durations of fades at the start and end of a clip.
+// Each may be at most half the length of the clip; zero means no fade.
+type FadeOptions struct {
+	VideoInSeconds  float64 `json:"videoInSeconds,omitempty"`
+	VideoOutSeconds float64 `json:"videoOutSeconds,omitempty"`
+	AudioInSeconds  float64 `json:"audioInSeconds,omitempty"`
+	AudioOutSeconds float64 `json:"audioOutSeconds,omitempty"`
 }
 
 // ExtractionResponse represents the success response for the ClipExtractionHandler
 type ExtractionResponse struct {
 	FileURL string `json:"fileUrl"`
 }
+
+// AcceptedResponse represents the response for a request that has been accepted for asynchronous processing
+type AcceptedResponse struct {
+	JobID string `json:"jobId"`
+}
+
+// CallbackResult represents the body POSTed to the callback URL of an asynchronous request
+type CallbackResult struct {
+	JobID   string `json:"jobId"`
+	Status  string `json:"status"`
+	FileURL string `json:"fileUrl,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// Request is the request of an extraction job
+	Request *ExtractionRequest `json:"request,omitempty"`
+	// Compilation is the request of a compilation job
+	Compilation *CompilationRequest `json:"compilation,omitempty"`
+	// Composition is the request of a composition job
+	Composition *CompositionRequest `json:"composition,omitempty"`
+	// Mixdown is the request of a mixdown job
+	Mixdown *MixdownRequest `json:"mixdown,omitempty"`
+	// Split is the request of a split job
+	Split *SplitRequest `json:"split,omitempty"`
+	// Segments are the pieces proposed by a split job, including those uploaded before the job failed
+	Segments []SplitSegment `json:"segments,omitempty"`
+	// SceneDetection is the request of a scene detection job, and Scenes the scenes it found
+	SceneDetection *SceneRequest `json:"sceneDetection,omitempty"`
+	Scenes         []Scene       `json:"scenes,omitempty"`
+	// Audition is the request of an audition job, and Submission its outcome
+	Audition   *AuditionRequest  `json:"audition,omitempty"`
+	Submission *AuditionResponse `json:"submission,omitempty"`
+}
@@ -0,0 +1,199 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package logging provides structured, leveled JSON logging
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log entry
+type Level int
+
+// Log levels, in increasing order of severity
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = map[Level]string{
+	Debug: "DEBUG",
+	Info:  "INFO",
+	Warn:  "WARNING",
+	Error: "ERROR",
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel parses a level name such as "info" or "WARNING"
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToUpper(s) {
+	case "DEBUG":
+		return Debug, nil
+	case "INFO":
+		return Info, nil
+	case "WARN", "WARNING":
+		return Warn, nil
+	case "ERROR":
+		return Error, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// Fields are structured key/value pairs attached to log entries
+type Fields map[string]interface{}
+
+// Logger writes structured log entries as one JSON object per line.
+// The "severity" and "message" keys are compatible with Google Cloud Logging.
+type Logger struct {
+	out    *output
+	level  Level
+	fields Fields
+}
+
+// output serializes writes from all loggers sharing a writer
+type output struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// New creates a Logger that writes entries at or above level to w
+func New(w io.Writer, level Level) *Logger {
+	return &Logger{out: &output{w: w}, level: level}
+}
+
+var defaultLogger = New(os.Stderr, Info)
+
+// Default returns the logger used when none is attached to a context
+func Default() *Logger {
+	return defaultLogger
+}
+
+// SetDefault replaces the logger used when none is attached to a context
+func SetDefault(l *Logger) {
+	defaultLogger = l
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying l
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger carried by ctx, or the default logger if there is none
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+	return defaultLogger
+}
+
+// With returns a Logger that adds the given field to every entry
+func (l *Logger) With(key string, value interface{}) *Logger {
+	return l.WithFields(Fields{key: value})
+}
+
+// WithFields returns a Logger that adds the given fields to every entry
+func (l *Logger) WithFields(fields Fields) *Logger {
+	merged := make(Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Logger{out: l.out, level: l.level, fields: merged}
+}
+
+// WithError returns a Logger that adds err to every entry
+func (l *Logger) WithError(err error) *Logger {
+	return l.With("error", err.Error())
+}
+
+// Enabled reports whether entries at the given level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+// Debugf logs a formatted message at Debug level
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.log(Debug, format, args...)
+}
+
+// Infof logs a formatted message at Info level
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.log(Info, format, args...)
+}
+
+// Warnf logs a formatted message at Warn level
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.log(Warn, format, args...)
+}
+
+// Errorf logs a formatted message at Error level
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.log(Error, format, args...)
+}
+
+func (l *Logger) log(level Level, format string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	entry := make(Fields, len(l.fields)+3)
+	for k, v := range l.fields {
+		entry[k] = v
+	}
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["severity"] = level.String()
+	entry["message"] = fmt.Sprintf(format, args...)
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		b = []byte(fmt.Sprintf(`{"severity":"ERROR","message":%q}`, "error encoding log entry: "+err.Error()))
+	}
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.w.Write(append(b, '\n'))
+}
+
+// Writer returns an io.Writer that logs each line written to it at the given level.
+// It can be used to redirect the standard library logger.
+func (l *Logger) Writer(level Level) io.Writer {
+	return &lineWriter{l: l, level: level}
+}
+
+type lineWriter struct {
+	l     *Logger
+	level Level
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.l.log(w.level, "%s", strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func decodeEntries(t *testing.T, b *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var e map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("Invalid log line %q: %v", line, err)
+		}
+		delete(e, "time")
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestLogger(t *testing.T) {
+	var b bytes.Buffer
+	l := New(&b, Info).With("requestId", "abc")
+
+	l.Debugf("not logged")
+	l.WithFields(Fields{"bytes": 42}).Infof("hello %s", "world")
+	l.Errorf("uh oh")
+
+	expected := []map[string]interface{}{
+		{"severity": "INFO", "message": "hello world", "requestId": "abc", "bytes": 42.0},
+		{"severity": "ERROR", "message": "uh oh", "requestId": "abc"},
+	}
+
+	if diff := cmp.Diff(expected, decodeEntries(t, &b)); diff != "" {
+		t.Error("Log entries different than expected (-want +got):", diff)
+	}
+}
+
+func TestLogger_WithDoesNotModifyParent(t *testing.T) {
+	var b bytes.Buffer
+	parent := New(&b, Info).With("a", 1)
+	parent.With("b", 2)
+
+	parent.Infof("message")
+
+	expected := []map[string]interface{}{
+		{"severity": "INFO", "message": "message", "a": 1.0},
+	}
+
+	if diff := cmp.Diff(expected, decodeEntries(t, &b)); diff != "" {
+		t.Error("Log entries different than expected (-want +got):", diff)
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	if FromContext(context.Background()) != Default() {
+		t.Error("Expected default logger for context without logger")
+	}
+
+	l := New(&bytes.Buffer{}, Debug)
+	if FromContext(NewContext(context.Background(), l)) != l {
+		t.Error("Expected logger attached to context")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected Level
+	}{
+		{"debug", Debug},
+		{"INFO", Info},
+		{"warn", Warn},
+		{"Warning", Warn},
+		{"error", Error},
+	}
+
+	for _, test := range cases {
+		t.Run(test.input, func(t *testing.T) {
+			actual, err := ParseLevel(test.input)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(test.expected, actual); diff != "" {
+				t.Error("Level different than expected (-want +got):", diff)
+			}
+		})
+	}
+
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("Expected error")
+	}
+}
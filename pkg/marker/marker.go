@@ -0,0 +1,241 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package marker stores named points in source files, such as rehearsal letters,
+// so that clips can be requested between markers rather than timestamps
+package marker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxNameLength is the longest marker name, in bytes
+const maxNameLength = 64
+
+var (
+	// ErrNotFound is returned for a marker that is not in the Store
+	ErrNotFound = errors.New("marker not found")
+	// ErrExists is returned when creating a marker whose name is already used in the same file
+	ErrExists = errors.New("marker already exists")
+)
+
+// Marker is a named point in a source file
+type Marker struct {
+	Name string
+	// Time is the offset of the marker from the start of the file
+	Time time.Duration
+}
+
+// Validate returns an error if m is not a valid marker
+func (m *Marker) Validate() error {
+	if strings.TrimSpace(m.Name) != m.Name || m.Name == "" {
+		return errors.New("marker names must not be empty or start or end with spaces")
+	}
+	if len(m.Name) > maxNameLength {
+		return fmt.Errorf("marker names must not be longer than %d bytes", maxNameLength)
+	}
+	if strings.Contains(m.Name, "/") {
+		return errors.New("marker names must not contain /")
+	}
+	if m.Time < 0 {
+		return errors.New("marker times must not be negative")
+	}
+	return nil
+}
+
+// Store keeps the markers of each source file, identified by its Drive file ID, in a local JSON file.
+// It is safe for concurrent use within a process, but the file must not be shared between processes.
+type Store struct {
+	path string
+
+	mu      sync.Mutex
+	markers map[string]map[string]time.Duration
+}
+
+// storeFile is the format of a Store's file, mapping file IDs to the times of their markers in seconds
+type storeFile map[string]map[string]float64
+
+// Open opens the Store kept in the file at path, creating its directory if necessary.
+// The file is created when the first marker is added.
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	s := &Store{path: path, markers: make(map[string]map[string]time.Duration)}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file storeFile
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, fmt.Errorf("error parsing markers: %w", err)
+	}
+	for id, markers := range file {
+		s.markers[id] = make(map[string]time.Duration)
+		for name, seconds := range markers {
+			s.markers[id][name] = time.Duration(seconds * float64(time.Second))
+		}
+	}
+	return s, nil
+}
+
+// List returns the markers of the file with the given id, ordered by time
+func (s *Store) List(id string) []Marker {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	markers := []Marker{}
+	for name, t := range s.markers[id] {
+		markers = append(markers, Marker{Name: name, Time: t})
+	}
+	sort.Slice(markers, func(i, j int) bool {
+		if markers[i].Time != markers[j].Time {
+			return markers[i].Time < markers[j].Time
+		}
+		return markers[i].Name < markers[j].Name
+	})
+	return markers
+}
+
+// Get returns the marker of the file with the given id and name, or ErrNotFound
+func (s *Store) Get(id, name string) (Marker, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.markers[id][name]
+	if !ok {
+		return Marker{}, fmt.Errorf("%w: %q", ErrNotFound, name)
+	}
+	return Marker{Name: name, Time: t}, nil
+}
+
+// Create adds a marker to the file with the given id, returning ErrExists if the file has a marker with its name
+func (s *Store) Create(id string, m Marker) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.markers[id][m.Name]; ok {
+		return fmt.Errorf("%w: %q", ErrExists, m.Name)
+	}
+	return s.update(id, func(markers map[string]time.Duration) {
+		markers[m.Name] = m.Time
+	})
+}
+
+// Update replaces the marker of the file with the given id and name with m, which may rename it.
+// It returns ErrNotFound if there is no such marker, or ErrExists if it is renamed to the name of another marker.
+func (s *Store) Update(id, name string, m Marker) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.markers[id][name]; !ok {
+		return fmt.Errorf("%w: %q", ErrNotFound, name)
+	}
+	if _, ok := s.markers[id][m.Name]; ok && m.Name != name {
+		return fmt.Errorf("%w: %q", ErrExists, m.Name)
+	}
+	return s.update(id, func(markers map[string]time.Duration) {
+		delete(markers, name)
+		markers[m.Name] = m.Time
+	})
+}
+
+// Delete removes the marker of the file with the given id and name, returning ErrNotFound if there is none
+func (s *Store) Delete(id, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.markers[id][name]; !ok {
+		return fmt.Errorf("%w: %q", ErrNotFound, name)
+	}
+	return s.update(id, func(markers map[string]time.Duration) {
+		delete(markers, name)
+	})
+}
+
+// update applies change to a copy of the markers of the file with the given id and saves the result,
+// keeping the markers unchanged if they cannot be saved. s.mu must be held.
+func (s *Store) update(id string, change func(map[string]time.Duration)) error {
+	markers := make(map[string]time.Duration)
+	for name, t := range s.markers[id] {
+		markers[name] = t
+	}
+	change(markers)
+
+	previous, existed := s.markers[id]
+	if len(markers) == 0 {
+		delete(s.markers, id)
+	} else {
+		s.markers[id] = markers
+	}
+	if err := s.save(); err != nil {
+		if existed {
+			s.markers[id] = previous
+		} else {
+			delete(s.markers, id)
+		}
+		return err
+	}
+	return nil
+}
+
+// save writes all markers to the Store's file, replacing it atomically. s.mu must be held.
+func (s *Store) save() error {
+	file := make(storeFile)
+	for id, markers := range s.markers {
+		file[id] = make(map[string]float64)
+		for name, t := range markers {
+			file[id][name] = t.Seconds()
+		}
+	}
+	b, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(s.path), "markers-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path)
+}
@@ -0,0 +1,92 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package marker
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db", "markers.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range []Marker{{Name: "F", Time: 5 * time.Minute}, {Name: "B", Time: 83500 * time.Millisecond}} {
+		if err := s.Create("symphony", m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Create("symphony", Marker{Name: "B", Time: time.Minute}); !errors.Is(err, ErrExists) {
+		t.Errorf("got error %v creating a duplicate marker, want %v", err, ErrExists)
+	}
+	if err := s.Update("symphony", "F", Marker{Name: "G", Time: 5*time.Minute + 10*time.Second}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Update("symphony", "G", Marker{Name: "B", Time: time.Minute}); !errors.Is(err, ErrExists) {
+		t.Errorf("got error %v renaming a marker to an existing name, want %v", err, ErrExists)
+	}
+	if err := s.Update("symphony", "Z", Marker{Name: "Z"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v updating a missing marker, want %v", err, ErrNotFound)
+	}
+
+	// The markers are read back from the file
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Marker{{Name: "B", Time: 83500 * time.Millisecond}, {Name: "G", Time: 5*time.Minute + 10*time.Second}}
+	if diff := cmp.Diff(expected, s.List("symphony")); diff != "" {
+		t.Error("Markers different than expected (-want +got):", diff)
+	}
+	if diff := cmp.Diff([]Marker{}, s.List("concerto")); diff != "" {
+		t.Error("Markers of another file different than expected (-want +got):", diff)
+	}
+
+	if err := s.Delete("symphony", "B"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get("symphony", "B"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v getting a deleted marker, want %v", err, ErrNotFound)
+	}
+	if m, err := s.Get("symphony", "G"); err != nil || m.Time != 5*time.Minute+10*time.Second {
+		t.Errorf("got marker %+v (error %v), want G at 5m10s", m, err)
+	}
+}
+
+func TestMarker_Validate(t *testing.T) {
+	invalid := []Marker{
+		{},
+		{Name: " B"},
+		{Name: "B/C"},
+		{Name: "B", Time: -time.Second},
+		{Name: string(make([]byte, maxNameLength+1))},
+	}
+	for _, m := range invalid {
+		if err := m.Validate(); err == nil {
+			t.Errorf("Expected error validating %+v", m)
+		}
+	}
+
+	if err := (&Marker{Name: "Letter B", Time: time.Minute}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
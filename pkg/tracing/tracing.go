@@ -0,0 +1,107 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tracing configures OpenTelemetry tracing for the service
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/stdout"
+	"go.opentelemetry.io/otel/propagators"
+	exporttrace "go.opentelemetry.io/otel/sdk/export/trace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+)
+
+const serviceName = "nocco-video-extractor"
+
+const (
+	// ExporterNone disables trace export
+	ExporterNone = "none"
+	// ExporterStdout writes spans to standard output, for local testing
+	ExporterStdout = "stdout"
+	// ExporterOTLP sends spans to an OpenTelemetry collector
+	ExporterOTLP = "otlp"
+)
+
+// Setup installs a global tracer provider that sends spans to the given exporter
+// and configures propagation of W3C trace context.
+// endpoint is the address of the collector and is only used by the OTLP exporter.
+// The returned function flushes any buffered spans and should be called before the program exits.
+func Setup(exporter, endpoint string) (func(context.Context), error) {
+	global.SetTextMapPropagator(propagators.TraceContext{})
+
+	var e exporttrace.SpanExporter
+	switch exporter {
+	case "", ExporterNone:
+		return func(context.Context) {}, nil
+	case ExporterStdout:
+		s, err := stdout.NewExporter(stdout.WithPrettyPrint(), stdout.WithoutMetricExport())
+		if err != nil {
+			return nil, err
+		}
+		e = s
+	case ExporterOTLP:
+		o, err := otlp.NewExporter(otlp.WithInsecure(), otlp.WithAddress(endpoint))
+		if err != nil {
+			return nil, err
+		}
+		e = o
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", exporter)
+	}
+
+	bsp := sdktrace.NewBatchSpanProcessor(e)
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(bsp),
+		sdktrace.WithResource(resource.New(semconv.ServiceNameKey.String(serviceName))),
+	)
+	global.SetTracerProvider(tp)
+	log.Printf("Exporting traces to %s", exporter)
+
+	return func(ctx context.Context) {
+		tp.UnregisterSpanProcessor(bsp)
+		if err := e.Shutdown(ctx); err != nil {
+			log.Println("Error shutting down trace exporter:", err)
+		}
+	}, nil
+}
+
+// Tracer returns the tracer to be used by the given package
+func Tracer(pkg string) trace.Tracer {
+	return global.Tracer("github.com/ssmall/nocco-video-extractor/pkg/" + pkg)
+}
+
+// End ends span, recording err on it if it is not nil
+func End(ctx context.Context, span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(ctx, err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Detach returns a new background context carrying the span from ctx,
+// for work that outlives the request that started it
+func Detach(ctx context.Context) context.Context {
+	return trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx))
+}
@@ -0,0 +1,341 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/cmplx"
+	"strconv"
+	"time"
+
+	"github.com/ssmall/nocco-video-extractor/pkg/logging"
+	"github.com/ssmall/nocco-video-extractor/pkg/tracing"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
+)
+
+// AlignMethod is how recordings are aligned with each other
+type AlignMethod string
+
+const (
+	// AlignCorrelation aligns recordings by cross-correlating their audio with that of the first
+	AlignCorrelation AlignMethod = "correlation"
+	// AlignClap aligns recordings by the first loud transient in each, such as a clap or click
+	AlignClap AlignMethod = "clap"
+)
+
+const (
+	// DefaultAlignWindow is how much audio is analysed from the start of each recording by default
+	DefaultAlignWindow = 2 * time.Minute
+	// MaxAlignWindow is the most audio that may be analysed from each recording, bounding the memory
+	// used to correlate recordings to about 128MB per pair
+	MaxAlignWindow = 5 * time.Minute
+	// alignSampleRate is the rate at which audio is analysed, limiting the precision of offsets to 0.25ms
+	alignSampleRate = 4000
+	// clapBlock is the length of the blocks whose loudness is compared to find a clap
+	clapBlock = 10 * time.Millisecond
+	// clapThreshold is the loudness, relative to the loudest block, at which a block is considered a clap
+	clapThreshold = 0.5
+	// peakExclusion is the distance around the best match within which other matches are not considered alternatives
+	peakExclusion = 20 * time.Millisecond
+)
+
+// AlignOptions customise how recordings are aligned
+type AlignOptions struct {
+	// Method is how recordings are aligned, by default AlignCorrelation
+	Method AlignMethod
+	// Window is how much audio is analysed from the start of each recording, by default DefaultAlignWindow
+	Window time.Duration
+	// MaxOffset is the largest offset considered, by default half the window
+	MaxOffset time.Duration
+}
+
+func (o AlignOptions) method() AlignMethod {
+	if o.Method == "" {
+		return AlignCorrelation
+	}
+	return o.Method
+}
+
+func (o AlignOptions) window() time.Duration {
+	if o.Window == 0 {
+		return DefaultAlignWindow
+	}
+	return o.Window
+}
+
+func (o AlignOptions) maxOffset() time.Duration {
+	if o.MaxOffset == 0 {
+		return o.window() / 2
+	}
+	return o.MaxOffset
+}
+
+// Validate returns an error if the options are invalid
+func (o AlignOptions) Validate() error {
+	if m := o.method(); m != AlignCorrelation && m != AlignClap {
+		return fmt.Errorf("unknown alignment method %q", o.Method)
+	}
+	if o.Window < 0 || o.MaxOffset < 0 {
+		return errors.New("alignment window and maximum offset must not be negative")
+	}
+	if o.window() > MaxAlignWindow {
+		return fmt.Errorf("alignment window %s is longer than the maximum of %s", o.window(), MaxAlignWindow)
+	}
+	if o.maxOffset() > o.window() {
+		return fmt.Errorf("maximum offset %s is longer than the window %s", o.maxOffset(), o.window())
+	}
+	return nil
+}
+
+// Alignment is the position of a recording relative to the first
+type Alignment struct {
+	// Offset is the position in the recording that coincides with the start of the first,
+	// as used by Part. It is negative if the recording started after the first.
+	Offset time.Duration
+	// Confidence is from 0, when another offset matched as well, to 1, when none came close
+	Confidence float64
+}
+
+func (f *ffmpegExtractor) Align(ctx context.Context, filenames []string, opts AlignOptions) (alignments []Alignment, err error) {
+	ctx, span := tracer.Start(ctx, "ffmpeg.Align", trace.WithAttributes(
+		label.Int("ffmpeg.sources", len(filenames)),
+		label.String("ffmpeg.align_method", string(opts.method())),
+	))
+	defer func() {
+		tracing.End(ctx, span, err)
+	}()
+
+	if len(filenames) == 0 {
+		return nil, errors.New("at least one recording is required")
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	tracks := make([][]float64, len(filenames))
+	for i, filename := range filenames {
+		if tracks[i], err = f.decodePCM(ctx, span, filename, opts.window()); err != nil {
+			return nil, err
+		}
+		if len(tracks[i]) == 0 {
+			return nil, fmt.Errorf("recording %d has no audio", i+1)
+		}
+	}
+
+	maxLag := samples(opts.maxOffset())
+	alignments = make([]Alignment, len(tracks))
+	alignments[0] = Alignment{Confidence: 1}
+	switch opts.method() {
+	case AlignCorrelation:
+		for i := 1; i < len(tracks); i++ {
+			lag, confidence := correlate(tracks[0], tracks[i], maxLag)
+			alignments[i] = Alignment{Offset: duration(lag), Confidence: confidence}
+		}
+	case AlignClap:
+		ref, refConfidence := clapOnset(tracks[0])
+		alignments[0].Confidence = refConfidence
+		for i := 1; i < len(tracks); i++ {
+			onset, confidence := clapOnset(tracks[i])
+			lag := onset - ref
+			if lag > maxLag || lag < -maxLag {
+				confidence = 0
+			}
+			alignments[i] = Alignment{Offset: duration(lag), Confidence: math.Min(confidence, refConfidence)}
+		}
+	}
+	logging.FromContext(ctx).With("alignments", alignments).Infof("Aligned %d recordings", len(filenames))
+	return alignments, nil
+}
+
+// decodePCM decodes up to the given duration of the first audio stream of filename
+// as mono samples at alignSampleRate
+func (f *ffmpegExtractor) decodePCM(ctx context.Context, span trace.Span, filename string, d time.Duration) ([]float64, error) {
+	var pcm []float64
+	_, err := f.runOutput(ctx, span, modeAnalyse, func(r io.Reader) error {
+		b, err := ioutil.ReadAll(r)
+		pcm = parsePCM(b)
+		return err
+	}, "-v", "error", "-t", seconds(d), "-i", filename, "-vn", "-ac", "1", "-ar", strconv.Itoa(alignSampleRate), "-f", "f32le", "pipe:1")
+	if err != nil {
+		return nil, fmt.Errorf("error decoding audio: %w", err)
+	}
+	return pcm, nil
+}
+
+// parsePCM parses little-endian 32-bit float samples
+func parsePCM(b []byte) []float64 {
+	pcm := make([]float64, len(b)/4)
+	for i := range pcm {
+		pcm[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:])))
+	}
+	return pcm
+}
+
+func samples(d time.Duration) int {
+	return int(d * alignSampleRate / time.Second)
+}
+
+func duration(samples int) time.Duration {
+	return time.Duration(samples) * time.Second / alignSampleRate
+}
+
+// correlate returns the lag, at most maxLag samples either way, at which x best matches ref,
+// and how much better that match is than the best match at any other lag
+func correlate(ref, x []float64, maxLag int) (lag int, confidence float64) {
+	n := 1
+	for n < len(ref)+len(x) {
+		n <<= 1
+	}
+	a := make([]complex128, n)
+	b := make([]complex128, n)
+	for i, v := range ref {
+		a[i] = complex(v, 0)
+	}
+	for i, v := range x {
+		b[i] = complex(v, 0)
+	}
+	fft(a, false)
+	fft(b, false)
+	for i := range a {
+		a[i] = cmplx.Conj(a[i]) * b[i]
+	}
+	fft(a, true)
+
+	// a[k] now holds the correlation of ref with x delayed by k samples; negative lags wrap around
+	at := func(k int) float64 {
+		if k < 0 {
+			k += n
+		}
+		return math.Abs(real(a[k]))
+	}
+	if maxLag > n/2-1 {
+		maxLag = n/2 - 1
+	}
+	best := -maxLag
+	for k := -maxLag; k <= maxLag; k++ {
+		if at(k) > at(best) {
+			best = k
+		}
+	}
+	if at(best) == 0 {
+		return 0, 0
+	}
+
+	exclusion := samples(peakExclusion)
+	var second float64
+	for k := -maxLag; k <= maxLag; k++ {
+		if (k < best-exclusion || k > best+exclusion) && at(k) > second {
+			second = at(k)
+		}
+	}
+	return best, 1 - second/at(best)
+}
+
+// clapOnset returns the position of the first block of pcm at least clapThreshold as loud as the loudest,
+// and how much louder it is than the audio before it
+func clapOnset(pcm []float64) (onset int, confidence float64) {
+	block := samples(clapBlock)
+	var loudness []float64
+	for start := 0; start < len(pcm); start += block {
+		end := start + block
+		if end > len(pcm) {
+			end = len(pcm)
+		}
+		var sum float64
+		for _, v := range pcm[start:end] {
+			sum += v * v
+		}
+		loudness = append(loudness, math.Sqrt(sum/float64(end-start)))
+	}
+
+	var loudest float64
+	for _, l := range loudness {
+		loudest = math.Max(loudest, l)
+	}
+	if loudest == 0 {
+		return 0, 0
+	}
+
+	var background float64
+	for i, l := range loudness {
+		if l < clapThreshold*loudest {
+			background = math.Max(background, l)
+			continue
+		}
+		// Find the start of the transient within the block
+		start := i * block
+		var peak float64
+		for _, v := range pcm[start:min(start+block, len(pcm))] {
+			peak = math.Max(peak, math.Abs(v))
+		}
+		for j := start; j < start+block && j < len(pcm); j++ {
+			if math.Abs(pcm[j]) >= clapThreshold*peak {
+				return j, 1 - background/l
+			}
+		}
+	}
+	return 0, 0
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// fft replaces a, whose length must be a power of two, with its discrete Fourier transform or, if invert is set,
+// its inverse
+func fft(a []complex128, invert bool) {
+	n := len(a)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			a[i], a[j] = a[j], a[i]
+		}
+	}
+	for length := 2; length <= n; length <<= 1 {
+		angle := 2 * math.Pi / float64(length)
+		if invert {
+			angle = -angle
+		}
+		w := cmplx.Rect(1, angle)
+		for i := 0; i < n; i += length {
+			wn := complex(1, 0)
+			for j := 0; j < length/2; j++ {
+				u, v := a[i+j], a[i+j+length/2]*wn
+				a[i+j] = u + v
+				a[i+j+length/2] = u - v
+				wn *= w
+			}
+		}
+	}
+	if invert {
+		for i := range a {
+			a[i] /= complex(float64(n), 0)
+		}
+	}
+}
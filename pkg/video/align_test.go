@@ -0,0 +1,139 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"encoding/binary"
+	"math"
+	"math/cmplx"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// noise returns n samples of deterministic white noise
+func noise(n int, seed int64) []float64 {
+	r := rand.New(rand.NewSource(seed))
+	pcm := make([]float64, n)
+	for i := range pcm {
+		pcm[i] = r.Float64()*2 - 1
+	}
+	return pcm
+}
+
+func TestFFT_Inverse(t *testing.T) {
+	a := make([]complex128, 16)
+	for i, v := range noise(16, 1) {
+		a[i] = complex(v, 0)
+	}
+	b := append([]complex128(nil), a...)
+	fft(b, false)
+	fft(b, true)
+	for i := range a {
+		if cmplx.Abs(a[i]-b[i]) > 1e-9 {
+			t.Fatalf("got %v at %d after round trip, want %v", b[i], i, a[i])
+		}
+	}
+}
+
+func TestCorrelate(t *testing.T) {
+	performance := noise(3*alignSampleRate, 2)
+	for _, lag := range []int{0, 1234, -987} {
+		// ref and x record the same performance, with x starting lag samples earlier, and some independent noise
+		ref := make([]float64, 2*alignSampleRate)
+		x := make([]float64, 2*alignSampleRate)
+		room := noise(len(ref)+len(x), 3)
+		for i := range ref {
+			ref[i] = performance[alignSampleRate/2+i] + 0.5*room[i]
+			if j := alignSampleRate/2 + i - lag; j >= 0 && j < len(performance) {
+				x[i] = performance[j]
+			}
+			x[i] += 0.5 * room[len(ref)+i]
+		}
+
+		actual, confidence := correlate(ref, x, alignSampleRate/2)
+		if actual != lag {
+			t.Errorf("got lag %d, want %d", actual, lag)
+		}
+		if confidence < 0.5 {
+			t.Errorf("got confidence %f for lag %d, want at least 0.5", confidence, lag)
+		}
+	}
+}
+
+func TestCorrelate_Unrelated(t *testing.T) {
+	_, confidence := correlate(noise(alignSampleRate, 4), noise(alignSampleRate, 5), alignSampleRate/2)
+	if confidence > 0.3 {
+		t.Errorf("got confidence %f aligning unrelated recordings, want at most 0.3", confidence)
+	}
+}
+
+func TestClapOnset(t *testing.T) {
+	pcm := noise(2*alignSampleRate, 6)
+	for i := range pcm {
+		pcm[i] *= 0.01
+	}
+	clap := 5000
+	for i := 0; i < 200; i++ {
+		pcm[clap+i] = math.Exp(-float64(i)/40) * math.Sin(float64(i))
+	}
+
+	onset, confidence := clapOnset(pcm)
+	if onset < clap || onset > clap+5 {
+		t.Errorf("got onset %d, want %d", onset, clap)
+	}
+	if confidence < 0.9 {
+		t.Errorf("got confidence %f, want at least 0.9", confidence)
+	}
+
+	if _, confidence := clapOnset(make([]float64, 100)); confidence != 0 {
+		t.Errorf("got confidence %f for silence, want 0", confidence)
+	}
+}
+
+func TestParsePCM(t *testing.T) {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint32(b, math.Float32bits(0.5))
+	binary.LittleEndian.PutUint32(b[4:], math.Float32bits(-0.25))
+	if diff := cmp.Diff([]float64{0.5, -0.25}, parsePCM(b)); diff != "" {
+		t.Error("Samples different than expected (-want +got):", diff)
+	}
+	if diff := cmp.Diff(1234*time.Millisecond/4, duration(1234)); diff != "" {
+		t.Error("Duration different than expected (-want +got):", diff)
+	}
+}
+
+func TestAlignOptions_Validate(t *testing.T) {
+	invalid := []AlignOptions{
+		{Method: "telepathy"},
+		{Window: -time.Second},
+		{Window: time.Minute, MaxOffset: 2 * time.Minute},
+		{Window: MaxAlignWindow + time.Second},
+		{Window: 3 * time.Hour, MaxOffset: time.Minute},
+	}
+	for _, o := range invalid {
+		if err := o.Validate(); err == nil {
+			t.Errorf("Expected error validating %+v", o)
+		}
+	}
+	if err := (AlignOptions{Method: AlignClap, Window: 30 * time.Second}).Validate(); err != nil {
+		t.Error(err)
+	}
+	if err := (AlignOptions{Window: MaxAlignWindow}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Bumpers are short videos, such as a branded intro and outro, joined to the start and end of a clip.
+// They are scaled and converted to match the clip.
+type Bumpers struct {
+	// Intro and Outro are the local paths of the videos to prepend and append; either may be empty
+	Intro string
+	Outro string
+
+	// intro and outro describe the bumpers once probed
+	intro *mediaInfo
+	outro *mediaInfo
+}
+
+// probed returns a copy of b describing its files
+func (b *Bumpers) probed(ctx context.Context) (*Bumpers, error) {
+	p := *b
+	for _, f := range []struct {
+		path string
+		info **mediaInfo
+	}{{b.Intro, &p.intro}, {b.Outro, &p.outro}} {
+		if f.path == "" {
+			continue
+		}
+		info, err := probe(ctx, f.path)
+		if err != nil {
+			return nil, fmt.Errorf("error probing bumper %s: %w", filepath.Base(f.path), err)
+		}
+		if !info.HasVideo {
+			return nil, fmt.Errorf("bumper %s has no video stream", filepath.Base(f.path))
+		}
+		*f.info = info
+	}
+	return &p, nil
+}
+
+// duration returns the total duration of the probed bumpers
+func (b *Bumpers) duration() time.Duration {
+	var d time.Duration
+	for _, info := range []*mediaInfo{b.intro, b.outro} {
+		if info != nil {
+			d += info.Duration
+		}
+	}
+	return d
+}
+
+// paths returns the paths of the bumpers in use, in the order they are added as inputs
+func (b *Bumpers) paths() []string {
+	var paths []string
+	for _, p := range []string{b.Intro, b.Outro} {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// conform returns filters that convert the bumper read from the given input to match the clip described by
+// info, labelled [name] and, if the clip has audio, [nameaudio]
+func conform(input int, bumper, info *mediaInfo, name string) string {
+	chains := []string{fmt.Sprintf("[%d:v]scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,setsar=%s,fps=%s,format=yuv420p[%s]",
+		input, info.Width, info.Height, info.Width, info.Height, strings.Replace(info.SAR, ":", "/", 1), info.FrameRate, name)}
+	if !info.HasAudio {
+		return chains[0]
+	}
+	if bumper.HasAudio {
+		chains = append(chains, fmt.Sprintf("[%d:a]aresample=%d,aformat=channel_layouts=%s[%saudio]", input, info.SampleRate, info.ChannelLayout, name))
+	} else {
+		chains = append(chains, fmt.Sprintf("anullsrc=r=%d:cl=%s,atrim=duration=%s[%saudio]", info.SampleRate, info.ChannelLayout, seconds(bumper.Duration), name))
+	}
+	return strings.Join(chains, ";")
+}
@@ -0,0 +1,81 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFilterGraph_Bumpers(t *testing.T) {
+	info := &mediaInfo{HasVideo: true, Width: 1280, Height: 720, FrameRate: "25/1", SAR: "1:1", HasAudio: true, SampleRate: 48000, ChannelLayout: "stereo"}
+	opts := ClipOptions{
+		Overlay:   &Overlay{},
+		TitleCard: &TitleCard{Title: "NOCCO", Duration: 2 * time.Second},
+		Fades:     &Fades{AudioOut: time.Second},
+		Bumpers: &Bumpers{
+			Intro: "intro.mov",
+			Outro: "outro.mp4",
+			intro: &mediaInfo{HasVideo: true, Width: 1920, Height: 1080, FrameRate: "30/1", Duration: 3 * time.Second},
+			outro: &mediaInfo{HasVideo: true, Width: 1920, Height: 1080, FrameRate: "30/1", HasAudio: true, SampleRate: 44100, ChannelLayout: "mono", Duration: 4 * time.Second},
+		},
+	}
+
+	expected := strings.Join([]string{
+		"[0:a]afade=t=out:st=19:d=1[faded]",
+		"[1:v]format=rgba,colorchannelmixer=aa=1[img];[0:v][img]overlay=x=W-w-0:y=H-h-0[v0]",
+		"[2:v]scale=1280:720:force_original_aspect_ratio=decrease,pad=1280:720:(ow-iw)/2:(oh-ih)/2,setsar=1/1,fps=25/1,format=yuv420p[intro]",
+		"anullsrc=r=48000:cl=stereo,atrim=duration=3[introaudio]",
+		"color=c=black:s=1280x720:r=25/1:d=2,setsar=1/1,format=yuv420p," +
+			"drawtext=fontfile=/fonts/DejaVuSans.ttf:expansion=none:text=NOCCO:fontsize=h/12:x=(w-text_w)/2:y=h/2-text_h:fontcolor=white[card]",
+		"anullsrc=r=48000:cl=stereo,atrim=duration=2[cardaudio]",
+		"[3:v]scale=1280:720:force_original_aspect_ratio=decrease,pad=1280:720:(ow-iw)/2:(oh-ih)/2,setsar=1/1,fps=25/1,format=yuv420p[outro]",
+		"[3:a]aresample=48000,aformat=channel_layouts=stereo[outroaudio]",
+		"[intro][introaudio][card][cardaudio][v0][faded][outro][outroaudio]concat=n=4:v=1:a=1[v][a]",
+	}, ";")
+
+	graph, audio := opts.filterGraph("/fonts", info, 20*time.Second)
+	if diff := cmp.Diff(expected, graph); diff != "" {
+		t.Error("Filter graph different than expected (-want +got):", diff)
+	}
+	if !audio {
+		t.Error("Expected filter graph to filter audio")
+	}
+
+	args := (&ffmpegExtractor{fontDir: "/fonts"}).clipArgs("in.mp4", "", 0, 20*time.Second, opts, info, ContainerMP4, 0)
+	expectedInputs := []string{"-ss", "00:00:00", "-t", "00:00:20", "-i", "in.mp4", "-i", "", "-i", "intro.mov", "-i", "outro.mp4"}
+	if diff := cmp.Diff(expectedInputs, args[:len(expectedInputs)]); diff != "" {
+		t.Error("Inputs different than expected (-want +got):", diff)
+	}
+}
+
+func TestFilterGraph_OutroWithoutAudio(t *testing.T) {
+	info := &mediaInfo{HasVideo: true, Width: 640, Height: 360, FrameRate: "25/1", SAR: "1:1"}
+	opts := ClipOptions{Bumpers: &Bumpers{Outro: "outro.mp4", outro: &mediaInfo{HasVideo: true, Width: 640, Height: 480, FrameRate: "25/1", HasAudio: true}}}
+
+	expected := "[1:v]scale=640:360:force_original_aspect_ratio=decrease,pad=640:360:(ow-iw)/2:(oh-ih)/2,setsar=1/1,fps=25/1,format=yuv420p[outro];" +
+		"[0:v][outro]concat=n=2:v=1:a=0[v]"
+
+	graph, audio := opts.filterGraph("/fonts", info, 20*time.Second)
+	if diff := cmp.Diff(expected, graph); diff != "" {
+		t.Error("Filter graph different than expected (-want +got):", diff)
+	}
+	if audio {
+		t.Error("Expected filter graph not to filter audio")
+	}
+}
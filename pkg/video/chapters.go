@@ -0,0 +1,114 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Chapters mark points in a clip between which players can skip
+type Chapters struct {
+	// List are chapters starting at the given times relative to the start of the clip
+	List []Chapter
+	// Scenes, if set, adds a chapter at the start of each scene detected in the clip
+	Scenes *SceneOptions
+
+	// file is the FFMETADATA file describing the chapters, once written
+	file string
+}
+
+// Chapter is a titled point in a clip
+type Chapter struct {
+	Start time.Duration
+	Title string
+}
+
+// Validate returns an error if the chapters are invalid for a clip of the given duration
+func (c *Chapters) Validate(clip time.Duration) error {
+	for _, ch := range c.List {
+		if ch.Start < 0 || ch.Start >= clip {
+			return fmt.Errorf("chapter %q must start within the clip", ch.Title)
+		}
+	}
+	if c.Scenes != nil {
+		if c.Scenes.ThumbnailDir != "" {
+			return errors.New("thumbnails cannot be written for scene chapters")
+		}
+		return c.Scenes.Validate()
+	}
+	return nil
+}
+
+// written returns a copy of the chapters of the clip of filename between start and end, with any scenes detected,
+// written to an FFMETADATA file in dir. leadIn is the time before the clip in the output, and total the output's duration.
+func (c *Chapters) written(ctx context.Context, f *ffmpegExtractor, filename string, start, end, leadIn, total time.Duration, dir string) (*Chapters, error) {
+	chapters := append([]Chapter(nil), c.List...)
+	if c.Scenes != nil {
+		scenes, err := f.DetectScenes(ctx, filename, start, end, *c.Scenes)
+		if err != nil {
+			return nil, err
+		}
+		for i, s := range scenes {
+			chapters = append(chapters, Chapter{Start: s.Start - start, Title: fmt.Sprintf("Scene %d", i+1)})
+		}
+	}
+
+	tmp, err := ioutil.TempFile(dir, "chapters-*.txt")
+	if err != nil {
+		return nil, err
+	}
+	defer tmp.Close()
+	if _, err := tmp.WriteString(ffmetadata(chapters, leadIn, total)); err != nil {
+		return nil, err
+	}
+
+	written := *c
+	written.file = tmp.Name()
+	return &written, nil
+}
+
+// ffmetadata returns an FFMETADATA file describing chapters, which are shifted by leadIn
+// and each end at the start of the next or at total
+func ffmetadata(chapters []Chapter, leadIn, total time.Duration) string {
+	sorted := append([]Chapter(nil), chapters...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+
+	var b strings.Builder
+	b.WriteString(";FFMETADATA1\n")
+	for i, ch := range sorted {
+		end := total
+		if i+1 < len(sorted) {
+			end = leadIn + sorted[i+1].Start
+		}
+		start := leadIn + ch.Start
+		if end <= start {
+			continue
+		}
+		fmt.Fprintf(&b, "[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+			start.Milliseconds(), end.Milliseconds(), escapeMetadata(ch.Title))
+	}
+	return b.String()
+}
+
+// escapeMetadata escapes the characters that are special in FFMETADATA values
+func escapeMetadata(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", "\\\n").Replace(s)
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// Requirements lists the ffmpeg capabilities needed by the Extractor
+type Requirements struct {
+	Encoders []string
+	Filters  []string
+}
+
+// DefaultRequirements are the ffmpeg capabilities used by NewExtractor
+var DefaultRequirements = Requirements{
+	Encoders: []string{"aac", "flac", "libmp3lame", "libopus", "libx264", "libx265", "libvpx-vp9", "pcm_s16le"},
+	Filters: []string{
+		"acrossfade", "adelay", "afade", "aformat", "amix", "anull", "anullsrc", "aresample", "asetpts", "atrim", "color", "colorchannelmixer", "concat", "crop", "drawtext", "fade", "format", "fps", "loudnorm", "metadata", "null", "overlay", "pad", "pan", "scale", "scale2ref", "select", "setpts", "setsar", "silencedetect", "volume",
+	},
+}
+
+// CheckFFmpeg verifies that the ffmpeg and ffprobe binaries are present and that ffmpeg supports
+// the given encoders and filters
+func CheckFFmpeg(ctx context.Context, req Requirements) error {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return err
+	}
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		return err
+	}
+
+	if out, err := exec.CommandContext(ctx, "ffmpeg", "-hide_banner", "-version").CombinedOutput(); err != nil {
+		return fmt.Errorf("error running ffmpeg: %w: %s", err, out)
+	}
+
+	if len(req.Encoders) > 0 {
+		out, err := exec.CommandContext(ctx, "ffmpeg", "-hide_banner", "-encoders").Output()
+		if err != nil {
+			return fmt.Errorf("error listing ffmpeg encoders: %w", err)
+		}
+		if missing := missingNames(parseEncoders(string(out)), req.Encoders); len(missing) > 0 {
+			return fmt.Errorf("ffmpeg is missing required encoders: %s", strings.Join(missing, ", "))
+		}
+	}
+
+	if len(req.Filters) > 0 {
+		out, err := exec.CommandContext(ctx, "ffmpeg", "-hide_banner", "-filters").Output()
+		if err != nil {
+			return fmt.Errorf("error listing ffmpeg filters: %w", err)
+		}
+		if missing := missingNames(parseFilters(string(out)), req.Filters); len(missing) > 0 {
+			return fmt.Errorf("ffmpeg is missing required filters: %s", strings.Join(missing, ", "))
+		}
+	}
+
+	return nil
+}
+
+// parseEncoders parses the names of encoders from the output of "ffmpeg -encoders".
+// Encoders are listed one per line after a "------" separator, as "<flags> <name> <description>".
+func parseEncoders(out string) map[string]bool {
+	names := make(map[string]bool)
+	started := false
+	s := bufio.NewScanner(strings.NewReader(out))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if !started {
+			started = len(fields) == 1 && strings.HasPrefix(fields[0], "---")
+			continue
+		}
+		if len(fields) >= 2 {
+			names[fields[1]] = true
+		}
+	}
+	return names
+}
+
+// parseFilters parses the names of filters from the output of "ffmpeg -filters".
+// Filters are listed one per line as "<flags> <name> <inputs>-><outputs> <description>".
+func parseFilters(out string) map[string]bool {
+	names := make(map[string]bool)
+	s := bufio.NewScanner(strings.NewReader(out))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 3 && strings.Contains(fields[2], "->") {
+			names[fields[1]] = true
+		}
+	}
+	return names
+}
+
+func missingNames(available map[string]bool, required []string) []string {
+	var missing []string
+	for _, r := range required {
+		if !available[r] {
+			missing = append(missing, r)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
@@ -0,0 +1,72 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const encodersOutput = `Encoders:
+ V..... = Video
+ A..... = Audio
+ S..... = Subtitle
+ .F.... = Frame-level multithreading
+ ..S... = Slice-level multithreading
+ ...X.. = Codec is experimental
+ ....B. = Supports draw_horiz_band
+ .....D = Supports direct rendering method 1
+ ------
+ V..... libx264              libx264 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10 (codec h264)
+ A..... aac                  AAC (Advanced Audio Coding)
+`
+
+const filtersOutput = `Filters:
+  T.. = Timeline support
+  .S. = Slice threading
+  ..C = Command support
+  A = Audio input/output
+  V = Video input/output
+  N = Dynamic size
+  | = Source or sink filter
+ ... afade             A->A       Fade in/out input audio.
+ TSC drawtext          V->V       Draw text on top of video frames using libfreetype library.
+ ... amix              N->A       Audio mixing.
+`
+
+func TestParseEncoders(t *testing.T) {
+	expected := map[string]bool{"libx264": true, "aac": true}
+
+	if diff := cmp.Diff(expected, parseEncoders(encodersOutput)); diff != "" {
+		t.Error("Encoders different than expected (-want +got):", diff)
+	}
+}
+
+func TestParseFilters(t *testing.T) {
+	expected := map[string]bool{"afade": true, "drawtext": true, "amix": true}
+
+	if diff := cmp.Diff(expected, parseFilters(filtersOutput)); diff != "" {
+		t.Error("Filters different than expected (-want +got):", diff)
+	}
+}
+
+func TestMissingNames(t *testing.T) {
+	available := parseFilters(filtersOutput)
+
+	if diff := cmp.Diff([]string{"overlay", "scale"}, missingNames(available, []string{"scale", "drawtext", "overlay"})); diff != "" {
+		t.Error("Missing filters different than expected (-want +got):", diff)
+	}
+}
@@ -0,0 +1,270 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/ssmall/nocco-video-extractor/pkg/logging"
+	"github.com/ssmall/nocco-video-extractor/pkg/tracing"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
+)
+
+// RegionKind is the kind of sound in a region of a recording
+type RegionKind string
+
+const (
+	// RegionSilence is a region quieter than DefaultSilenceThreshold
+	RegionSilence RegionKind = "silence"
+	// RegionApplause is a region of broadband noise, such as applause
+	RegionApplause RegionKind = "applause"
+	// RegionMusic is a region of tonal sound, such as music
+	RegionMusic RegionKind = "music"
+)
+
+const (
+	// DefaultTrimTolerance is how far the ends of a clip are moved to the nearest music by default
+	DefaultTrimTolerance = 10 * time.Second
+	// classifyFrame is the number of samples, at alignSampleRate, classified together
+	classifyFrame = 512
+	// applauseFlatness is the spectral flatness above which a frame is considered noise rather than music.
+	// White noise has a flatness of about 0.56, while tonal sound is close to 0.
+	applauseFlatness = 0.3
+	// smoothFrames is the number of neighbouring frames whose majority decides the kind of each frame
+	smoothFrames = 5
+	// minRegion is the length of the shortest region reported; shorter regions join the one before
+	minRegion = time.Second
+)
+
+// Region is an interval of a recording containing one kind of sound
+type Region struct {
+	Kind  RegionKind
+	Start time.Duration
+	End   time.Duration
+}
+
+// ErrInvalidTrim is returned when the options of a clip are not valid for its length once trimmed,
+// such as fades longer than half the trimmed clip or chapters that start after its end
+var ErrInvalidTrim = errors.New("clip options are invalid for the trimmed clip")
+
+// AutoTrim moves the ends of a clip to the nearest boundaries of music
+type AutoTrim struct {
+	// Tolerance is how far either end of the clip may move, by default DefaultTrimTolerance
+	Tolerance time.Duration
+}
+
+func (a AutoTrim) tolerance() time.Duration {
+	if a.Tolerance == 0 {
+		return DefaultTrimTolerance
+	}
+	return a.Tolerance
+}
+
+// Validate returns an error if the options are invalid for a clip of the given duration
+func (a AutoTrim) Validate(clip time.Duration) error {
+	if a.Tolerance < 0 {
+		return errors.New("trim tolerance must not be negative")
+	}
+	if 2*a.tolerance() >= clip {
+		return fmt.Errorf("trim tolerance %s must be less than half the clip", a.tolerance())
+	}
+	return nil
+}
+
+func (f *ffmpegExtractor) Classify(ctx context.Context, filename string, start, end time.Duration) (regions []Region, err error) {
+	ctx, span := tracer.Start(ctx, "ffmpeg.Classify", trace.WithAttributes(
+		label.String("ffmpeg.input", filename),
+		label.String("ffmpeg.start", FormatHHMMSS(start)),
+		label.String("ffmpeg.end", FormatHHMMSS(end)),
+		label.String("ffmpeg.mode", modeAnalyse),
+	))
+	defer func() {
+		tracing.End(ctx, span, err)
+	}()
+
+	if start < 0 || end != 0 && end <= start {
+		return nil, errors.New("classification must end after it starts")
+	}
+	var length time.Duration
+	if end > 0 {
+		length = end - start
+	}
+	return f.classify(ctx, span, filename, start, length)
+}
+
+// autoTrim returns start and end moved to the nearest boundaries of music within the tolerance,
+// the start to where music begins and the end to where it stops
+func (f *ffmpegExtractor) autoTrim(ctx context.Context, span trace.Span, filename string, start, end time.Duration, a AutoTrim) (time.Duration, time.Duration, error) {
+	tol := a.tolerance()
+	from := start - tol
+	if from < 0 {
+		from = 0
+	}
+	head, err := f.classify(ctx, span, filename, from, start+tol-from)
+	if err != nil {
+		return 0, 0, err
+	}
+	tail, err := f.classify(ctx, span, filename, end-tol, 2*tol)
+	if err != nil {
+		return 0, 0, err
+	}
+	trimmedStart, trimmedEnd := trimBounds(head, tail, start, end)
+	logging.FromContext(ctx).Infof("Trimmed clip from %s-%s to %s-%s", start, end, trimmedStart, trimmedEnd)
+	return trimmedStart, trimmedEnd, nil
+}
+
+// trimBounds returns start moved to the nearest start of music in head, and end moved to the nearest end of music
+// in tail. The edges of head and tail are not boundaries, because the sound beyond them is unknown.
+func trimBounds(head, tail []Region, start, end time.Duration) (time.Duration, time.Duration) {
+	trimmedStart, best := start, time.Duration(-1)
+	for i := 1; i < len(head); i++ {
+		if d := abs(head[i].Start - start); head[i].Kind == RegionMusic && (best < 0 || d < best) {
+			trimmedStart, best = head[i].Start, d
+		}
+	}
+	trimmedEnd, best := end, time.Duration(-1)
+	for i := 0; i < len(tail)-1; i++ {
+		if d := abs(tail[i].End - end); tail[i].Kind == RegionMusic && (best < 0 || d < best) {
+			trimmedEnd, best = tail[i].End, d
+		}
+	}
+	return trimmedStart, trimmedEnd
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// classify classifies the audio of filename from start for the given length, or to the end if length is 0
+func (f *ffmpegExtractor) classify(ctx context.Context, span trace.Span, filename string, start, length time.Duration) ([]Region, error) {
+	args := []string{"-v", "error"}
+	if start > 0 {
+		args = append(args, "-ss", seconds(start))
+	}
+	if length > 0 {
+		args = append(args, "-t", seconds(length))
+	}
+	args = append(args, "-i", filename, "-vn", "-ac", "1", "-ar", strconv.Itoa(alignSampleRate), "-f", "f32le", "pipe:1")
+
+	var kinds []RegionKind
+	_, err := f.runOutput(ctx, span, modeAnalyse, func(r io.Reader) (err error) {
+		kinds, err = classifyFrames(bufio.NewReader(r))
+		return err
+	}, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding audio: %w", err)
+	}
+	return regions(kinds, start), nil
+}
+
+// classifyFrames classifies each whole frame of the little-endian 32-bit float samples read from r
+func classifyFrames(r io.Reader) ([]RegionKind, error) {
+	var kinds []RegionKind
+	buf := make([]byte, 4*classifyFrame)
+	for {
+		if _, err := io.ReadFull(r, buf); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return kinds, nil
+		} else if err != nil {
+			return nil, err
+		}
+		kinds = append(kinds, classifyPCM(parsePCM(buf)))
+	}
+}
+
+// classifyPCM classifies a frame of samples by its loudness and spectral flatness,
+// the ratio of the geometric to the arithmetic mean of its power spectrum
+func classifyPCM(pcm []float64) RegionKind {
+	var sum float64
+	for _, v := range pcm {
+		sum += v * v
+	}
+	if rms := math.Sqrt(sum / float64(len(pcm))); rms == 0 || 20*math.Log10(rms) < DefaultSilenceThreshold {
+		return RegionSilence
+	}
+
+	a := make([]complex128, len(pcm))
+	for i, v := range pcm {
+		// A Hann window keeps tones from leaking across the spectrum
+		w := 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(len(pcm)-1))
+		a[i] = complex(v*w, 0)
+	}
+	fft(a, false)
+
+	var logSum, powerSum float64
+	bins := a[1 : len(a)/2]
+	for _, c := range bins {
+		p := real(c)*real(c) + imag(c)*imag(c) + 1e-12
+		logSum += math.Log(p)
+		powerSum += p
+	}
+	n := float64(len(bins))
+	if math.Exp(logSum/n)/(powerSum/n) > applauseFlatness {
+		return RegionApplause
+	}
+	return RegionMusic
+}
+
+// regions smooths the kinds of consecutive frames starting at offset and joins them into regions
+func regions(kinds []RegionKind, offset time.Duration) []Region {
+	frame := duration(classifyFrame)
+	var result []Region
+	for i := range kinds {
+		counts := make(map[RegionKind]int)
+		for j := i - smoothFrames/2; j <= i+smoothFrames/2; j++ {
+			if j >= 0 && j < len(kinds) {
+				counts[kinds[j]]++
+			}
+		}
+		kind := kinds[i]
+		for _, k := range []RegionKind{RegionSilence, RegionApplause, RegionMusic} {
+			if counts[k] > counts[kind] {
+				kind = k
+			}
+		}
+
+		start := offset + time.Duration(i)*frame
+		if n := len(result); n > 0 && result[n-1].Kind == kind {
+			result[n-1].End = start + frame
+			continue
+		}
+		result = append(result, Region{Kind: kind, Start: start, End: start + frame})
+	}
+
+	// Join regions too short to be meaningful to the region before. A short first region joins the one after.
+	var joined []Region
+	for _, r := range result {
+		n := len(joined)
+		switch {
+		case n > 0 && (joined[n-1].Kind == r.Kind || r.End-r.Start < minRegion):
+			joined[n-1].End = r.End
+		case n == 1 && joined[0].End-joined[0].Start < minRegion:
+			joined[0] = Region{Kind: r.Kind, Start: joined[0].Start, End: r.End}
+		default:
+			joined = append(joined, r)
+		}
+	}
+	return joined
+}
@@ -0,0 +1,197 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestClassifyPCM(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	cases := map[RegionKind]func(i int) float64{
+		RegionSilence: func(i int) float64 { return 0.001 * math.Sin(float64(i)) },
+		RegionApplause: func(i int) float64 {
+			return 0.3 * rng.NormFloat64()
+		},
+		RegionMusic: func(i int) float64 {
+			// An oboe A with its first harmonics
+			t := float64(i) / alignSampleRate
+			return 0.3*math.Sin(2*math.Pi*440*t) + 0.1*math.Sin(2*math.Pi*880*t) + 0.05*math.Sin(2*math.Pi*1320*t)
+		},
+	}
+	for expected, signal := range cases {
+		t.Run(string(expected), func(t *testing.T) {
+			pcm := make([]float64, classifyFrame)
+			for i := range pcm {
+				pcm[i] = signal(i)
+			}
+			if diff := cmp.Diff(expected, classifyPCM(pcm)); diff != "" {
+				t.Error("Kind different than expected (-want +got):", diff)
+			}
+		})
+	}
+}
+
+func TestClassifyFrames_IgnoresPartialFrame(t *testing.T) {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, make([]float32, 2*classifyFrame+10))
+	kinds, err := classifyFrames(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]RegionKind{RegionSilence, RegionSilence}, kinds); diff != "" {
+		t.Error("Kinds different than expected (-want +got):", diff)
+	}
+}
+
+func TestRegions(t *testing.T) {
+	frame := duration(classifyFrame)
+	var kinds []RegionKind
+	add := func(kind RegionKind, n int) {
+		for i := 0; i < n; i++ {
+			kinds = append(kinds, kind)
+		}
+	}
+	// A short burst of silence at the start, applause with a lone misclassified frame, then music with a short pause
+	add(RegionSilence, 3)
+	add(RegionApplause, 20)
+	add(RegionMusic, 1)
+	add(RegionApplause, 20)
+	add(RegionMusic, 30)
+	add(RegionSilence, 4)
+	add(RegionMusic, 30)
+
+	offset := time.Minute
+	expected := []Region{
+		{Kind: RegionApplause, Start: offset, End: offset + 44*frame},
+		{Kind: RegionMusic, Start: offset + 44*frame, End: offset + 108*frame},
+	}
+	if diff := cmp.Diff(expected, regions(kinds, offset)); diff != "" {
+		t.Error("Regions different than expected (-want +got):", diff)
+	}
+}
+
+func TestTrimBounds(t *testing.T) {
+	head := []Region{
+		{Kind: RegionMusic, Start: 50 * time.Second, End: 52 * time.Second},
+		{Kind: RegionApplause, Start: 52 * time.Second, End: 58 * time.Second},
+		{Kind: RegionMusic, Start: 58 * time.Second, End: 63 * time.Second},
+		{Kind: RegionSilence, Start: 63 * time.Second, End: 64 * time.Second},
+		{Kind: RegionMusic, Start: 64 * time.Second, End: 70 * time.Second},
+	}
+	tail := []Region{
+		{Kind: RegionMusic, Start: 110 * time.Second, End: 117 * time.Second},
+		{Kind: RegionApplause, Start: 117 * time.Second, End: 130 * time.Second},
+	}
+
+	start, end := trimBounds(head, tail, time.Minute, 2*time.Minute)
+	if diff := cmp.Diff(58*time.Second, start); diff != "" {
+		t.Error("Start different than expected (-want +got):", diff)
+	}
+	if diff := cmp.Diff(117*time.Second, end); diff != "" {
+		t.Error("End different than expected (-want +got):", diff)
+	}
+
+	// Without boundaries inside the windows, the clip is unchanged
+	start, end = trimBounds(head[:1], tail[1:], time.Minute, 2*time.Minute)
+	if start != time.Minute || end != 2*time.Minute {
+		t.Errorf("got %s-%s, want the clip unchanged", start, end)
+	}
+}
+
+func TestAutoTrim_Validate(t *testing.T) {
+	invalid := []AutoTrim{
+		{Tolerance: -time.Second},
+		{Tolerance: 15 * time.Second},
+		{},
+	}
+	for _, a := range invalid {
+		if err := a.Validate(20 * time.Second); err == nil {
+			t.Errorf("Expected error validating %+v", a)
+		}
+	}
+
+	if err := (AutoTrim{Tolerance: 5 * time.Second}).Validate(20 * time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClipOptions_ValidateTrimmed(t *testing.T) {
+	opts := ClipOptions{
+		AutoTrim: &AutoTrim{Tolerance: 10 * time.Second},
+		Fades:    &Fades{AudioOut: 8 * time.Second},
+		Chapters: &Chapters{List: []Chapter{{Title: "Coda", Start: 20 * time.Second}}},
+	}
+	if err := opts.Validate(30 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		clip    time.Duration
+		wantErr bool
+	}{
+		{"Untrimmed", 30 * time.Second, false},
+		{"Fade longer than half", 15 * time.Second, true},
+		{"Chapter after end", 18 * time.Second, true},
+		// The tolerance only applies to the requested bounds, and is not checked again
+		{"Shorter than tolerance", 20*time.Second + time.Millisecond, false},
+	}
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			err := opts.validateTrimmed(test.clip)
+			if test.wantErr != errors.Is(err, ErrInvalidTrim) {
+				t.Errorf("validateTrimmed(%s) = %v, want error %t", test.clip, err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestClipArgs_AutoTrimKeepsPreciseBounds(t *testing.T) {
+	opts := ClipOptions{AutoTrim: &AutoTrim{}}
+	start, end := 62*time.Second+250*time.Millisecond, 2*time.Minute+31500*time.Millisecond
+
+	video := &mediaInfo{HasVideo: true, HasAudio: true}
+	expected := []string{
+		"-ss", "00:01:02.250", "-t", "00:01:29.250", "-i", "in.mp4",
+		"-filter_complex", "[0:v]null[v]", "-map", "[v]",
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "20", "-pix_fmt", "yuv420p",
+		"-map", "0:a?", "-c:a", "copy",
+	}
+	actual := (&ffmpegExtractor{}).clipArgs("in.mp4", "", start, end, opts, video, ContainerMP4, 0)
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Error("Arguments for video different than expected (-want +got):", diff)
+	}
+
+	audio := &mediaInfo{HasAudio: true, SampleRate: 44100, Channels: 2}
+	if err := opts.checkSource("concert.m4a", audio); err != nil {
+		t.Error(err)
+	}
+	expected = []string{
+		"-noaccurate_seek", "-ss", "00:01:02.250", "-i", "concert.m4a", "-t", "00:01:29.250", "-avoid_negative_ts", "make_zero", "-c", "copy",
+	}
+	actual = (&ffmpegExtractor{}).clipArgs("concert.m4a", "", start, end, opts, audio, ContainerMP4, 0)
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Error("Arguments for audio different than expected (-want +got):", diff)
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ssmall/nocco-video-extractor/pkg/logging"
+	"github.com/ssmall/nocco-video-extractor/pkg/tracing"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
+)
+
+const (
+	// compileSampleRate and compileChannelLayout are the audio format to which all segments of a compilation are converted
+	compileSampleRate    = 48000
+	compileChannelLayout = "stereo"
+	// defaultFrameRate is used when a source's frame rate is unknown
+	defaultFrameRate = "30"
+)
+
+// Segment is a part of a local file to include in a compilation
+type Segment struct {
+	Filename string
+	Start    time.Duration
+	End      time.Duration
+}
+
+func (s Segment) duration() time.Duration {
+	return s.End - s.Start
+}
+
+// CompileOptions customise how segments are compiled into a single video
+type CompileOptions struct {
+	// Crossfade is the duration of the crossfade between consecutive segments, or zero to cut between them
+	Crossfade time.Duration
+	// Preset, if set, determines the dimensions and encoding of the compilation.
+	// Otherwise, all segments are scaled to the dimensions of the first.
+	Preset *Preset
+	// Metadata, if set, are the tags of the compilation
+	Metadata *Metadata
+}
+
+// ValidateSegments returns an error if the segments cannot be compiled with opts
+func ValidateSegments(segments []Segment, opts CompileOptions) error {
+	if len(segments) == 0 {
+		return errors.New("at least one segment is required")
+	}
+	if opts.Crossfade < 0 {
+		return errors.New("crossfade must not be negative")
+	}
+	if opts.Metadata != nil {
+		if err := opts.Metadata.Validate(); err != nil {
+			return err
+		}
+	}
+	for i, s := range segments {
+		if s.End <= s.Start {
+			return fmt.Errorf("segment %d ends before it starts", i+1)
+		}
+		if 2*opts.Crossfade > s.duration() {
+			return fmt.Errorf("crossfade of %s is longer than half of segment %d", opts.Crossfade, i+1)
+		}
+	}
+	if opts.Preset != nil {
+		if err := opts.Preset.Validate(); err != nil {
+			return err
+		}
+		var total time.Duration
+		for _, s := range segments {
+			total += s.duration()
+		}
+		total -= time.Duration(len(segments)-1) * opts.Crossfade
+		if opts.Preset.MaxDuration > 0 && total > opts.Preset.MaxDuration {
+			return fmt.Errorf("compilation is %s long, but the preset allows at most %s", total, opts.Preset.MaxDuration)
+		}
+	}
+	return nil
+}
+
+func (f *ffmpegExtractor) Compile(ctx context.Context, segments []Segment, opts CompileOptions) (clip io.ReadCloser, err error) {
+	ctx, span := tracer.Start(ctx, "ffmpeg.Compile", trace.WithAttributes(
+		label.Int("ffmpeg.segments", len(segments)),
+		label.String("ffmpeg.mode", modeCompile),
+	))
+	defer func() {
+		tracing.End(ctx, span, err)
+	}()
+
+	if err := ValidateSegments(segments, opts); err != nil {
+		return nil, err
+	}
+
+	infos := make([]*mediaInfo, len(segments))
+	for i, s := range segments {
+		if infos[i], err = probe(ctx, s.Filename); err != nil {
+			return nil, err
+		}
+		if !infos[i].HasVideo {
+			return nil, fmt.Errorf("%s has no video stream", filepath.Base(s.Filename))
+		}
+	}
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(segments[0].Filename), "ffmpeg-*.mp4")
+	if err != nil {
+		return nil, err
+	}
+
+	logger := logging.FromContext(ctx)
+	logger.Debugf("Created temp file for compilation: %s", tmpFile.Name())
+
+	args := append(compileArgs(segments, infos, opts), "-y", tmpFile.Name())
+	if err := f.run(ctx, span, modeCompile, args...); err != nil {
+		return nil, err
+	}
+
+	if fi, err := tmpFile.Stat(); err == nil {
+		ffmpegOutputBytes.WithLabelValues(modeCompile).Add(float64(fi.Size()))
+	}
+	logger.Infof("Compilation %q finished", tmpFile.Name())
+
+	return &tmpFileAutoCleanup{tmpFile, logger}, nil
+}
+
+// compileArgs returns the ffmpeg arguments, other than the output, that compile segments described by infos
+func compileArgs(segments []Segment, infos []*mediaInfo, opts CompileOptions) []string {
+	var args []string
+	for _, s := range segments {
+		args = append(args, "-ss", FormatHHMMSS(s.Start), "-t", FormatHHMMSS(s.duration()), "-i", s.Filename)
+	}
+	args = append(args, "-filter_complex", compileGraph(segments, infos, opts), "-map", "[v]", "-map", "[a]")
+	args = append(args, ContainerMP4.videoEncoderArgs(opts.Preset, 0)...)
+	args = append(args, ContainerMP4.audioEncoderArgs(opts.Preset)...)
+	if opts.Metadata != nil {
+		args = append(args, opts.Metadata.args()...)
+	}
+	return append(args, "-movflags", "+faststart")
+}
+
+// compileGraph returns a filter graph that normalises each segment to the same dimensions, frame rate
+// and audio format and joins them, labelling the outputs [v] and [a]
+func compileGraph(segments []Segment, infos []*mediaInfo, opts CompileOptions) string {
+	target := infos[0]
+	if opts.Preset != nil {
+		target = opts.Preset.outputInfo(target)
+	}
+	frameRate := target.FrameRate
+	if frameRate == "" || strings.HasPrefix(frameRate, "0/") {
+		frameRate = defaultFrameRate
+	}
+	w, h := target.Width, target.Height
+
+	pixFmt := "yuv420p"
+	if opts.Crossfade > 0 {
+		// Segments are faded in over their predecessors using an alpha channel
+		pixFmt = "yuva420p"
+	}
+
+	var chains []string
+	var offset, total time.Duration
+	for i, s := range segments {
+		v := fmt.Sprintf("[%d:v]scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=%s,format=%s", i, w, h, w, h, frameRate, pixFmt)
+		if opts.Crossfade > 0 && i > 0 {
+			v += fmt.Sprintf(",fade=t=in:st=0:d=%s:alpha=1", seconds(opts.Crossfade))
+		}
+		if opts.Crossfade > 0 {
+			v += fmt.Sprintf(",setpts=PTS-STARTPTS+%s/TB[v%d]", seconds(offset), i)
+		} else {
+			v += fmt.Sprintf(",setpts=PTS-STARTPTS[v%d]", i)
+		}
+		chains = append(chains, v)
+
+		if infos[i].HasAudio {
+			chains = append(chains, fmt.Sprintf("[%d:a]aresample=%d,aformat=sample_fmts=fltp:channel_layouts=%s,asetpts=PTS-STARTPTS[a%d]", i, compileSampleRate, compileChannelLayout, i))
+		} else {
+			chains = append(chains, fmt.Sprintf("anullsrc=r=%d:cl=%s,atrim=duration=%s,aformat=sample_fmts=fltp[a%d]", compileSampleRate, compileChannelLayout, seconds(s.duration()), i))
+		}
+
+		total = offset + s.duration()
+		offset += s.duration() - opts.Crossfade
+	}
+
+	if opts.Crossfade == 0 {
+		var inputs string
+		for i := range segments {
+			inputs += fmt.Sprintf("[v%d][a%d]", i, i)
+		}
+		chains = append(chains, fmt.Sprintf("%sconcat=n=%d:v=1:a=1[v][a]", inputs, len(segments)))
+		return strings.Join(chains, ";")
+	}
+
+	// Each segment is overlaid on a canvas lasting the whole compilation, starting where the previous segment's
+	// crossfade begins
+	chains = append(chains, fmt.Sprintf("color=c=black:s=%dx%d:r=%s:d=%s,format=yuv420p[base]", w, h, frameRate, seconds(total)))
+	video, audio := "[base]", "[a0]"
+	for i := range segments {
+		out := fmt.Sprintf("[o%d]", i)
+		if i == len(segments)-1 {
+			out = "[v]"
+		}
+		chains = append(chains, fmt.Sprintf("%s[v%d]overlay=eof_action=pass%s", video, i, out))
+		video = out
+	}
+	for i := 1; i < len(segments); i++ {
+		out := fmt.Sprintf("[x%d]", i)
+		if i == len(segments)-1 {
+			out = "[a]"
+		}
+		chains = append(chains, fmt.Sprintf("%s[a%d]acrossfade=d=%s%s", audio, i, seconds(opts.Crossfade), out))
+		audio = out
+	}
+	if len(segments) == 1 {
+		chains = append(chains, "[a0]anull[a]")
+	}
+	return strings.Join(chains, ";")
+}
@@ -0,0 +1,124 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestValidateSegments(t *testing.T) {
+	segments := []Segment{
+		{Filename: "a.mp4", Start: 45 * time.Second, End: 90 * time.Second},
+		{Filename: "b.mov", Start: 12 * time.Minute, End: 12*time.Minute + 4*time.Second},
+	}
+	cases := []struct {
+		name     string
+		segments []Segment
+		opts     CompileOptions
+		valid    bool
+	}{
+		{name: "Cut", segments: segments, valid: true},
+		{name: "Crossfade", segments: segments, opts: CompileOptions{Crossfade: 2 * time.Second}, valid: true},
+		{name: "CrossfadeTooLong", segments: segments, opts: CompileOptions{Crossfade: 3 * time.Second}},
+		{name: "NegativeCrossfade", segments: segments, opts: CompileOptions{Crossfade: -time.Second}},
+		{name: "NoSegments"},
+		{name: "Reversed", segments: []Segment{{Start: time.Minute, End: time.Second}}},
+		{name: "PresetMaxDuration", segments: segments, opts: CompileOptions{Preset: &Preset{Width: 640, MaxDuration: 30 * time.Second}}},
+	}
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateSegments(test.segments, test.opts)
+			if test.valid && err != nil {
+				t.Error(err)
+			}
+			if !test.valid && err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
+
+func TestCompileGraph_Concat(t *testing.T) {
+	segments := []Segment{
+		{Filename: "a.mp4", Start: 45 * time.Second, End: 90 * time.Second},
+		{Filename: "b.mov", Start: 12 * time.Minute, End: 12*time.Minute + 40*time.Second},
+	}
+	infos := []*mediaInfo{
+		{HasVideo: true, Width: 1920, Height: 1080, FrameRate: "30000/1001", HasAudio: true},
+		{HasVideo: true, Width: 1280, Height: 720, FrameRate: "25/1"},
+	}
+	expected := strings.Join([]string{
+		"[0:v]scale=1920:1080:force_original_aspect_ratio=decrease,pad=1920:1080:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=30000/1001,format=yuv420p,setpts=PTS-STARTPTS[v0]",
+		"[0:a]aresample=48000,aformat=sample_fmts=fltp:channel_layouts=stereo,asetpts=PTS-STARTPTS[a0]",
+		"[1:v]scale=1920:1080:force_original_aspect_ratio=decrease,pad=1920:1080:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=30000/1001,format=yuv420p,setpts=PTS-STARTPTS[v1]",
+		"anullsrc=r=48000:cl=stereo,atrim=duration=40,aformat=sample_fmts=fltp[a1]",
+		"[v0][a0][v1][a1]concat=n=2:v=1:a=1[v][a]",
+	}, ";")
+	if diff := cmp.Diff(expected, compileGraph(segments, infos, CompileOptions{})); diff != "" {
+		t.Error("Filter graph different than expected (-want +got):", diff)
+	}
+}
+
+func TestCompileGraph_Crossfade(t *testing.T) {
+	segments := []Segment{
+		{Filename: "a.mp4", End: 10 * time.Second},
+		{Filename: "a.mp4", Start: time.Minute, End: 70 * time.Second},
+		{Filename: "b.mp4", End: 5 * time.Second},
+	}
+	info := &mediaInfo{HasVideo: true, Width: 1920, Height: 1080, FrameRate: "0/0", HasAudio: true}
+	infos := []*mediaInfo{info, info, info}
+	opts := CompileOptions{Crossfade: time.Second, Preset: &Preset{Width: 1280, Height: 720, Fit: FitPad}}
+	expected := strings.Join([]string{
+		"[0:v]scale=1280:720:force_original_aspect_ratio=decrease,pad=1280:720:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=30,format=yuva420p,setpts=PTS-STARTPTS+0/TB[v0]",
+		"[0:a]aresample=48000,aformat=sample_fmts=fltp:channel_layouts=stereo,asetpts=PTS-STARTPTS[a0]",
+		"[1:v]scale=1280:720:force_original_aspect_ratio=decrease,pad=1280:720:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=30,format=yuva420p,fade=t=in:st=0:d=1:alpha=1,setpts=PTS-STARTPTS+9/TB[v1]",
+		"[1:a]aresample=48000,aformat=sample_fmts=fltp:channel_layouts=stereo,asetpts=PTS-STARTPTS[a1]",
+		"[2:v]scale=1280:720:force_original_aspect_ratio=decrease,pad=1280:720:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=30,format=yuva420p,fade=t=in:st=0:d=1:alpha=1,setpts=PTS-STARTPTS+18/TB[v2]",
+		"[2:a]aresample=48000,aformat=sample_fmts=fltp:channel_layouts=stereo,asetpts=PTS-STARTPTS[a2]",
+		"color=c=black:s=1280x720:r=30:d=23,format=yuv420p[base]",
+		"[base][v0]overlay=eof_action=pass[o0]",
+		"[o0][v1]overlay=eof_action=pass[o1]",
+		"[o1][v2]overlay=eof_action=pass[v]",
+		"[a0][a1]acrossfade=d=1[x1]",
+		"[x1][a2]acrossfade=d=1[a]",
+	}, ";")
+	if diff := cmp.Diff(expected, compileGraph(segments, infos, opts)); diff != "" {
+		t.Error("Filter graph different than expected (-want +got):", diff)
+	}
+}
+
+func TestCompileArgs(t *testing.T) {
+	segments := []Segment{
+		{Filename: "a.mp4", Start: 45 * time.Second, End: 90 * time.Second},
+		{Filename: "b.mov", Start: 12 * time.Minute, End: 12*time.Minute + 40*time.Second},
+	}
+	info := &mediaInfo{HasVideo: true, Width: 1280, Height: 720, FrameRate: "25/1", HasAudio: true}
+	args := compileArgs(segments, []*mediaInfo{info, info}, CompileOptions{})
+	expected := []string{
+		"-ss", "00:00:45", "-t", "00:00:45", "-i", "a.mp4",
+		"-ss", "00:12:00", "-t", "00:00:40", "-i", "b.mov",
+		"-filter_complex",
+	}
+	if diff := cmp.Diff(expected, args[:len(expected)]); diff != "" {
+		t.Error("Input arguments different than expected (-want +got):", diff)
+	}
+	if diff := cmp.Diff([]string{"-map", "[v]", "-map", "[a]"}, args[len(expected)+1:len(expected)+5]); diff != "" {
+		t.Error("Output mapping different than expected (-want +got):", diff)
+	}
+}
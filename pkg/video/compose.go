@@ -0,0 +1,275 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ssmall/nocco-video-extractor/pkg/logging"
+	"github.com/ssmall/nocco-video-extractor/pkg/tracing"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
+)
+
+const (
+	// DefaultCompositionWidth and DefaultCompositionHeight are the dimensions of a composition by default
+	DefaultCompositionWidth  = 1920
+	DefaultCompositionHeight = 1080
+	// maxGainDB bounds the gain applied to a part
+	maxGainDB = 30
+)
+
+// Part is a local file shown and heard in a composition
+type Part struct {
+	Filename string
+	// Offset is the position in the file at which the composition starts, aligning it with the other parts.
+	// A negative offset delays the part instead.
+	Offset time.Duration
+	// GainDB adjusts the volume of the part in decibels
+	GainDB float64
+}
+
+// Cell is the area of a composition in which a part is shown, in pixels
+type Cell struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// ComposeOptions customise how parts are composed into a single video
+type ComposeOptions struct {
+	// Width and Height are the dimensions of the composition, by default DefaultCompositionWidth and DefaultCompositionHeight
+	Width  int
+	Height int
+	// Layout, if set, gives the cell of each part. Otherwise, the parts are laid out in a grid in order.
+	Layout []Cell
+	// Metadata, if set, are the tags of the composition
+	Metadata *Metadata
+}
+
+func (o ComposeOptions) size() (int, int) {
+	if o.Width == 0 && o.Height == 0 {
+		return DefaultCompositionWidth, DefaultCompositionHeight
+	}
+	return o.Width, o.Height
+}
+
+// ValidateParts returns an error if the parts cannot be composed with opts
+func ValidateParts(parts []Part, opts ComposeOptions) error {
+	if len(parts) == 0 {
+		return errors.New("at least one part is required")
+	}
+	for i, p := range parts {
+		if math.Abs(p.GainDB) > maxGainDB {
+			return fmt.Errorf("gain of part %d must be between -%d and %d dB", i+1, maxGainDB, maxGainDB)
+		}
+	}
+	w, h := opts.size()
+	if w <= 0 || h <= 0 || w%2 != 0 || h%2 != 0 {
+		return fmt.Errorf("composition dimensions %dx%d must be positive and even", w, h)
+	}
+	if opts.Metadata != nil {
+		if err := opts.Metadata.Validate(); err != nil {
+			return err
+		}
+	}
+	if opts.Layout == nil {
+		return nil
+	}
+	if len(opts.Layout) != len(parts) {
+		return fmt.Errorf("layout has %d cells for %d parts", len(opts.Layout), len(parts))
+	}
+	for i, c := range opts.Layout {
+		if c.Width <= 0 || c.Height <= 0 || c.Width%2 != 0 || c.Height%2 != 0 {
+			return fmt.Errorf("cell %d must have positive, even dimensions", i+1)
+		}
+		if c.X < 0 || c.Y < 0 || c.X+c.Width > w || c.Y+c.Height > h {
+			return fmt.Errorf("cell %d is outside the %dx%d frame", i+1, w, h)
+		}
+	}
+	return nil
+}
+
+// grid lays out n cells of equal size in rows filling a frame of the given dimensions.
+// The last row is centred if it is not full.
+func grid(n, width, height int) []Cell {
+	cols := int(math.Ceil(math.Sqrt(float64(n))))
+	rows := (n + cols - 1) / cols
+	w, h := width/cols&^1, height/rows&^1
+	offsetY := (height - rows*h) / 2
+
+	cells := make([]Cell, n)
+	for i := range cells {
+		row, col := i/cols, i%cols
+		inRow := cols
+		if row == rows-1 {
+			inRow = n - row*cols
+		}
+		offsetX := (width - inRow*w) / 2
+		cells[i] = Cell{X: offsetX + col*w, Y: offsetY + row*h, Width: w, Height: h}
+	}
+	return cells
+}
+
+func (f *ffmpegExtractor) Compose(ctx context.Context, parts []Part, opts ComposeOptions) (clip io.ReadCloser, err error) {
+	ctx, span := tracer.Start(ctx, "ffmpeg.Compose", trace.WithAttributes(
+		label.Int("ffmpeg.parts", len(parts)),
+		label.String("ffmpeg.mode", modeCompose),
+	))
+	defer func() {
+		tracing.End(ctx, span, err)
+	}()
+
+	if err := ValidateParts(parts, opts); err != nil {
+		return nil, err
+	}
+
+	infos := make([]*mediaInfo, len(parts))
+	for i, p := range parts {
+		if infos[i], err = probe(ctx, p.Filename); err != nil {
+			return nil, err
+		}
+		if !infos[i].HasVideo {
+			return nil, fmt.Errorf("%s has no video stream", filepath.Base(p.Filename))
+		}
+	}
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(parts[0].Filename), "ffmpeg-*.mp4")
+	if err != nil {
+		return nil, err
+	}
+
+	logger := logging.FromContext(ctx)
+	logger.Debugf("Created temp file for composition: %s", tmpFile.Name())
+
+	args := append(composeArgs(parts, infos, opts), "-y", tmpFile.Name())
+	if err := f.run(ctx, span, modeCompose, args...); err != nil {
+		return nil, err
+	}
+
+	if fi, err := tmpFile.Stat(); err == nil {
+		ffmpegOutputBytes.WithLabelValues(modeCompose).Add(float64(fi.Size()))
+	}
+	logger.Infof("Composition %q finished", tmpFile.Name())
+
+	return &tmpFileAutoCleanup{tmpFile, logger}, nil
+}
+
+// composeArgs returns the ffmpeg arguments, other than the output, that compose parts described by infos
+func composeArgs(parts []Part, infos []*mediaInfo, opts ComposeOptions) []string {
+	var args []string
+	for _, p := range parts {
+		args = append(args, offsetArgs(p.Offset)...)
+		args = append(args, "-i", p.Filename)
+	}
+	graph, audio := composeGraph(parts, infos, opts)
+	args = append(args, "-filter_complex", graph, "-map", "[v]")
+	args = append(args, ContainerMP4.videoEncoderArgs(nil, 0)...)
+	if audio {
+		args = append(args, "-map", "[a]")
+		args = append(args, ContainerMP4.audioEncoderArgs(nil)...)
+	}
+	if opts.Metadata != nil {
+		args = append(args, opts.Metadata.args()...)
+	}
+	return append(args, "-movflags", "+faststart")
+}
+
+// offsetArgs returns the input arguments that skip the start of a file with a positive offset
+func offsetArgs(offset time.Duration) []string {
+	if offset > 0 {
+		return []string{"-ss", seconds(offset)}
+	}
+	return nil
+}
+
+// audioChain returns filters that convert the audio of the given input to stereo, adjust its volume
+// and delay it if its offset is negative
+func audioChain(input int, offset time.Duration, gainDB float64) string {
+	a := fmt.Sprintf("[%d:a]aresample=%d,aformat=sample_fmts=fltp:channel_layouts=%s,asetpts=PTS-STARTPTS", input, compileSampleRate, compileChannelLayout)
+	if gainDB != 0 {
+		a += fmt.Sprintf(",volume=%gdB", gainDB)
+	}
+	if offset < 0 {
+		ms := (-offset).Milliseconds()
+		a += fmt.Sprintf(",adelay=%d|%d", ms, ms)
+	}
+	return a
+}
+
+// composeGraph returns a filter graph that draws each part in its cell over a black frame, labelled [v],
+// and, if any part has audio, mixes their audio, labelled [a]
+func composeGraph(parts []Part, infos []*mediaInfo, opts ComposeOptions) (graph string, audio bool) {
+	w, h := opts.size()
+	cells := opts.Layout
+	if cells == nil {
+		cells = grid(len(parts), w, h)
+	}
+
+	var total time.Duration
+	for i, p := range parts {
+		if d := infos[i].Duration - p.Offset; d > total {
+			total = d
+		}
+	}
+
+	chains := []string{fmt.Sprintf("color=c=black:s=%dx%d:r=%s:d=%s[base]", w, h, defaultFrameRate, seconds(total))}
+	var mixed []string
+	for i, p := range parts {
+		c := cells[i]
+		v := fmt.Sprintf("[%d:v]scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=%s,setpts=PTS-STARTPTS",
+			i, c.Width, c.Height, c.Width, c.Height, defaultFrameRate)
+		if p.Offset < 0 {
+			v += fmt.Sprintf("+%s/TB", seconds(-p.Offset))
+		}
+		chains = append(chains, fmt.Sprintf("%s[p%d]", v, i))
+
+		if !infos[i].HasAudio {
+			continue
+		}
+		chains = append(chains, fmt.Sprintf("%s[a%d]", audioChain(i, p.Offset, p.GainDB), i))
+		mixed = append(mixed, fmt.Sprintf("[a%d]", i))
+	}
+
+	in := "[base]"
+	for i, c := range cells {
+		out := fmt.Sprintf("[o%d]", i)
+		if i == len(cells)-1 {
+			out = "[v]"
+		}
+		chains = append(chains, fmt.Sprintf("%s[p%d]overlay=x=%d:y=%d:eof_action=pass%s", in, i, c.X, c.Y, out))
+		in = out
+	}
+
+	switch len(mixed) {
+	case 0:
+		return strings.Join(chains, ";"), false
+	case 1:
+		chains = append(chains, mixed[0]+"anull[a]")
+	default:
+		// amix divides each input by the number of inputs; restore their original levels
+		chains = append(chains, fmt.Sprintf("%samix=inputs=%d:duration=longest:dropout_transition=0,volume=%d[a]", strings.Join(mixed, ""), len(mixed), len(mixed)))
+	}
+	return strings.Join(chains, ";"), true
+}
@@ -0,0 +1,111 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGrid(t *testing.T) {
+	expected := []Cell{
+		{X: 0, Y: 0, Width: 640, Height: 540},
+		{X: 640, Y: 0, Width: 640, Height: 540},
+		{X: 1280, Y: 0, Width: 640, Height: 540},
+		{X: 320, Y: 540, Width: 640, Height: 540},
+		{X: 960, Y: 540, Width: 640, Height: 540},
+	}
+	if diff := cmp.Diff(expected, grid(5, 1920, 1080)); diff != "" {
+		t.Error("Grid different than expected (-want +got):", diff)
+	}
+
+	// The last of 5 rows holds a single part, which is centred
+	cells := grid(21, 1920, 1080)
+	if diff := cmp.Diff(Cell{X: 0, Y: 0, Width: 384, Height: 216}, cells[0]); diff != "" {
+		t.Error("First cell different than expected (-want +got):", diff)
+	}
+	if diff := cmp.Diff(Cell{X: 768, Y: 864, Width: 384, Height: 216}, cells[20]); diff != "" {
+		t.Error("Last cell different than expected (-want +got):", diff)
+	}
+}
+
+func TestValidateParts(t *testing.T) {
+	parts := []Part{{Filename: "a.mp4"}, {Filename: "b.mp4", GainDB: -6}}
+	cases := []struct {
+		name  string
+		parts []Part
+		opts  ComposeOptions
+		valid bool
+	}{
+		{name: "Grid", parts: parts, valid: true},
+		{name: "Layout", parts: parts, opts: ComposeOptions{Width: 1280, Height: 720, Layout: []Cell{{0, 0, 640, 720}, {640, 0, 640, 720}}}, valid: true},
+		{name: "NoParts"},
+		{name: "TooLoud", parts: []Part{{Filename: "a.mp4", GainDB: 31}}},
+		{name: "OddDimensions", parts: parts, opts: ComposeOptions{Width: 1281, Height: 720}},
+		{name: "MissingCell", parts: parts, opts: ComposeOptions{Layout: []Cell{{0, 0, 640, 720}}}},
+		{name: "CellOutsideFrame", parts: parts, opts: ComposeOptions{Width: 1280, Height: 720, Layout: []Cell{{0, 0, 640, 720}, {700, 0, 640, 720}}}},
+	}
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateParts(test.parts, test.opts)
+			if test.valid && err != nil {
+				t.Error(err)
+			}
+			if !test.valid && err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
+
+func TestComposeArgs(t *testing.T) {
+	parts := []Part{
+		{Filename: "violin.mp4", Offset: 1500 * time.Millisecond},
+		{Filename: "cello.mov", Offset: -250 * time.Millisecond, GainDB: -3},
+		{Filename: "piano.mp4"},
+	}
+	infos := []*mediaInfo{
+		{HasVideo: true, Width: 1920, Height: 1080, HasAudio: true, Duration: 61500 * time.Millisecond},
+		{HasVideo: true, Width: 1080, Height: 1920, HasAudio: true, Duration: 62 * time.Second},
+		{HasVideo: true, Width: 1280, Height: 720, Duration: 30 * time.Second},
+	}
+	opts := ComposeOptions{Width: 1280, Height: 720}
+
+	expectedGraph := strings.Join([]string{
+		"color=c=black:s=1280x720:r=30:d=62.25[base]",
+		"[0:v]scale=640:360:force_original_aspect_ratio=decrease,pad=640:360:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=30,setpts=PTS-STARTPTS[p0]",
+		"[0:a]aresample=48000,aformat=sample_fmts=fltp:channel_layouts=stereo,asetpts=PTS-STARTPTS[a0]",
+		"[1:v]scale=640:360:force_original_aspect_ratio=decrease,pad=640:360:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=30,setpts=PTS-STARTPTS+0.25/TB[p1]",
+		"[1:a]aresample=48000,aformat=sample_fmts=fltp:channel_layouts=stereo,asetpts=PTS-STARTPTS,volume=-3dB,adelay=250|250[a1]",
+		"[2:v]scale=640:360:force_original_aspect_ratio=decrease,pad=640:360:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=30,setpts=PTS-STARTPTS[p2]",
+		"[base][p0]overlay=x=0:y=0:eof_action=pass[o0]",
+		"[o0][p1]overlay=x=640:y=0:eof_action=pass[o1]",
+		"[o1][p2]overlay=x=320:y=360:eof_action=pass[v]",
+		"[a0][a1]amix=inputs=2:duration=longest:dropout_transition=0,volume=2[a]",
+	}, ";")
+	expected := []string{
+		"-ss", "1.5", "-i", "violin.mp4", "-i", "cello.mov", "-i", "piano.mp4",
+		"-filter_complex", expectedGraph, "-map", "[v]",
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "20", "-pix_fmt", "yuv420p",
+		"-map", "[a]", "-c:a", "aac", "-b:a", "192000",
+		"-movflags", "+faststart",
+	}
+	if diff := cmp.Diff(expected, composeArgs(parts, infos, opts)); diff != "" {
+		t.Error("Arguments different than expected (-want +got):", diff)
+	}
+}
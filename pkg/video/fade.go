@@ -0,0 +1,82 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Fades are fades from black and silence at the start of a clip, and to black and silence at its end.
+// Zero durations mean no fade.
+type Fades struct {
+	VideoIn  time.Duration
+	VideoOut time.Duration
+	AudioIn  time.Duration
+	AudioOut time.Duration
+}
+
+// Validate returns an error if any fade is negative or longer than half of a clip of the given duration
+func (f *Fades) Validate(clip time.Duration) error {
+	for _, fade := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"video fade in", f.VideoIn},
+		{"video fade out", f.VideoOut},
+		{"audio fade in", f.AudioIn},
+		{"audio fade out", f.AudioOut},
+	} {
+		if fade.d < 0 {
+			return fmt.Errorf("%s must not be negative", fade.name)
+		}
+		if 2*fade.d > clip {
+			return fmt.Errorf("%s of %s is longer than half the clip's length of %s", fade.name, fade.d, clip)
+		}
+	}
+	return nil
+}
+
+// video reports whether the video fades, which requires it to be re-encoded
+func (f *Fades) video() bool {
+	return f.VideoIn > 0 || f.VideoOut > 0
+}
+
+// audio reports whether the audio fades, which requires it to be re-encoded
+func (f *Fades) audio() bool {
+	return f.AudioIn > 0 || f.AudioOut > 0
+}
+
+// videoFilter returns a filter chain that fades video of the given duration
+func (f *Fades) videoFilter(clip time.Duration) string {
+	return fadeFilters("fade", clip, f.VideoIn, f.VideoOut)
+}
+
+// audioFilter returns a filter chain that fades audio of the given duration
+func (f *Fades) audioFilter(clip time.Duration) string {
+	return fadeFilters("afade", clip, f.AudioIn, f.AudioOut)
+}
+
+func fadeFilters(filter string, clip, in, out time.Duration) string {
+	var filters []string
+	if in > 0 {
+		filters = append(filters, fmt.Sprintf("%s=t=in:st=0:d=%s", filter, seconds(in)))
+	}
+	if out > 0 {
+		filters = append(filters, fmt.Sprintf("%s=t=out:st=%s:d=%s", filter, seconds(clip-out), seconds(out)))
+	}
+	return strings.Join(filters, ",")
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFades_Validate(t *testing.T) {
+	clip := 10 * time.Second
+	invalid := []Fades{
+		{VideoIn: 6 * time.Second},
+		{AudioOut: 5001 * time.Millisecond},
+		{AudioIn: -time.Second},
+	}
+	for _, f := range invalid {
+		if err := f.Validate(clip); err == nil {
+			t.Errorf("Expected error validating %+v", f)
+		}
+	}
+
+	valid := Fades{VideoIn: 5 * time.Second, VideoOut: 5 * time.Second, AudioIn: time.Second, AudioOut: 2 * time.Second}
+	if err := valid.Validate(clip); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClipArgs_AudioFadesOnly(t *testing.T) {
+	opts := ClipOptions{Fades: &Fades{AudioIn: time.Second, AudioOut: 2500 * time.Millisecond}}
+	expected := []string{
+		"-noaccurate_seek", "-ss", "00:01:00", "-i", "in.mp4", "-t", "00:00:30", "-avoid_negative_ts", "make_zero", "-c", "copy",
+		"-af", "afade=t=in:st=0:d=1,afade=t=out:st=27.5:d=2.5", "-c:a", "aac", "-b:a", "192000",
+	}
+	actual := (&ffmpegExtractor{}).clipArgs("in.mp4", "", time.Minute, 90*time.Second, opts, nil, ContainerMP4, 0)
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Error("Arguments different than expected (-want +got):", diff)
+	}
+}
+
+func TestClipArgs_VideoAndAudioFades(t *testing.T) {
+	opts := ClipOptions{Fades: &Fades{VideoOut: 2 * time.Second, AudioOut: 2 * time.Second}}
+	expected := []string{
+		"-ss", "00:01:00", "-t", "00:00:30", "-i", "in.mp4",
+		"-filter_complex", "[0:v]fade=t=out:st=28:d=2[v]", "-map", "[v]",
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "20", "-pix_fmt", "yuv420p",
+		"-map", "0:a?", "-af", "afade=t=out:st=28:d=2", "-c:a", "aac", "-b:a", "192000",
+	}
+	actual := (&ffmpegExtractor{}).clipArgs("in.mp4", "", time.Minute, 90*time.Second, opts, nil, ContainerMP4, 0)
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Error("Arguments different than expected (-want +got):", diff)
+	}
+}
+
+func TestFilterGraph_FadesWithTitleCard(t *testing.T) {
+	info := &mediaInfo{HasVideo: true, Width: 640, Height: 360, FrameRate: "25/1", SAR: "1:1", HasAudio: true, SampleRate: 44100, ChannelLayout: "mono"}
+	opts := ClipOptions{
+		TitleCard: &TitleCard{Title: "NOCCO", Duration: 2 * time.Second},
+		Fades:     &Fades{AudioIn: time.Second},
+	}
+
+	graph, audio := opts.filterGraph("/fonts", info, 20*time.Second)
+	expected := "[0:a]afade=t=in:st=0:d=1[faded];" +
+		"color=c=black:s=640x360:r=25/1:d=2,setsar=1/1,format=yuv420p," +
+		"drawtext=fontfile=/fonts/DejaVuSans.ttf:expansion=none:text=NOCCO:fontsize=h/12:x=(w-text_w)/2:y=h/2-text_h:fontcolor=white[card];" +
+		"anullsrc=r=44100:cl=mono,atrim=duration=2[cardaudio];" +
+		"[card][cardaudio][0:v][faded]concat=n=2:v=1:a=1[v][a]"
+	if diff := cmp.Diff(expected, graph); diff != "" {
+		t.Error("Filter graph different than expected (-want +got):", diff)
+	}
+	if !audio {
+		t.Error("Expected filter graph to filter audio")
+	}
+}
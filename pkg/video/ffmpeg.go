@@ -15,80 +15,233 @@
 package video
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"time"
+
+	"github.com/ssmall/nocco-video-extractor/pkg/logging"
+	"github.com/ssmall/nocco-video-extractor/pkg/tracing"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
 )
 
+var tracer = tracing.Tracer("video")
+
+// DefaultFontDir is the directory in which the bundled fonts are found by default
+const DefaultFontDir = "fonts"
+
 type ffmpegExtractor struct {
+	fontDir string
+}
+
+// ExtractorOption configures optional behaviour of the Extractor created by NewExtractor
+type ExtractorOption func(*ffmpegExtractor)
+
+// WithFontDir sets the directory containing the bundled fonts used to draw text, by default DefaultFontDir
+func WithFontDir(dir string) ExtractorOption {
+	return func(f *ffmpegExtractor) {
+		f.fontDir = dir
+	}
 }
 
 // NewExtractor creates a new Extractor that uses ffmpeg as a backend
-func NewExtractor() Extractor {
-	return &ffmpegExtractor{}
+func NewExtractor(opts ...ExtractorOption) Extractor {
+	f := &ffmpegExtractor{fontDir: DefaultFontDir}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
 }
 
-func (f *ffmpegExtractor) Clip(ctx context.Context, filename string, start time.Duration, end time.Duration) (io.ReadCloser, error) {
+func (f *ffmpegExtractor) Clip(ctx context.Context, filename string, start time.Duration, end time.Duration, opts ClipOptions) (clip io.ReadCloser, err error) {
+	mode := opts.mode(false)
+	ctx, span := tracer.Start(ctx, "ffmpeg.Clip", trace.WithAttributes(
+		label.String("ffmpeg.input", filename),
+		label.String("ffmpeg.start", FormatHHMMSS(start)),
+		label.String("ffmpeg.end", FormatHHMMSS(end)),
+		label.String("ffmpeg.mode", mode),
+	))
+	defer func() {
+		tracing.End(ctx, span, err)
+	}()
+
 	if _, err := os.Stat(filename); err != nil {
 		return nil, err
 	}
 
-	tmpFile, err := ioutil.TempFile(os.TempDir(), "ffmpeg-*.mp4")
+	var info *mediaInfo
+	if opts.RequiresSeeking() {
+		if info, err = probe(ctx, filename); err != nil {
+			return nil, err
+		}
+		if err := opts.checkSource(filepath.Base(filename), info); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Bumpers != nil {
+		if opts.Bumpers, err = opts.Bumpers.probed(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if opts.AutoTrim != nil {
+		if start, end, err = f.autoTrim(ctx, span, filename, start, end, *opts.AutoTrim); err != nil {
+			return nil, err
+		}
+		if err := opts.validateTrimmed(end - start); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Chapters != nil {
+		if opts.Chapters, err = opts.Chapters.written(ctx, f, filename, start, end, opts.leadIn(), opts.outputDuration(end-start), filepath.Dir(filename)); err != nil {
+			return nil, err
+		}
+		defer os.Remove(opts.Chapters.file)
+	}
+
+	c, _ := sourceContainer(filename)
+	c = clipContainer(c, opts)
+	tmpFile, err := ioutil.TempFile(filepath.Dir(filename), "ffmpeg-*"+ClipExtension(filename, opts))
 
 	if err != nil {
 		return nil, err
 	}
 
-	log.Println("Created temp file for transcoding:", tmpFile.Name())
-
-	dur := end - start
-
-	cmd := exec.CommandContext(ctx, "ffmpeg", "-noaccurate_seek", "-ss", formatHHMMSS(start), "-i", filename, "-t", formatHHMMSS(dur), "-avoid_negative_ts", "make_zero", "-y", "-c", "copy", tmpFile.Name())
-	log.Println("Running command:", cmd)
-
-	stderr, err := cmd.StderrPipe()
+	logger := logging.FromContext(ctx)
+	logger.Debugf("Created temp file for transcoding: %s", tmpFile.Name())
 
+	if opts.MaxSize > 0 {
+		err = f.encodeToSize(ctx, span, filename, start, end, opts, info, c, tmpFile.Name())
+	} else {
+		args := append(f.clipArgs(filename, "", start, end, opts, info, c, 0), c.fileMuxerArgs()...)
+		err = f.run(ctx, span, mode, append(args, "-y", tmpFile.Name())...)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	if err := cmd.Start(); err != nil {
+	if fi, err := tmpFile.Stat(); err == nil {
+		ffmpegOutputBytes.WithLabelValues(mode).Add(float64(fi.Size()))
+	}
+
+	logger.Infof("File %q finished", tmpFile.Name())
+
+	return &tmpFileAutoCleanup{tmpFile, logger}, nil
+}
+
+func (f *ffmpegExtractor) ClipStream(ctx context.Context, r io.Reader, c Container, start time.Duration, end time.Duration, opts ClipOptions) (io.ReadCloser, error) {
+	mode := opts.mode(true)
+	ctx, span := tracer.Start(ctx, "ffmpeg.ClipStream", trace.WithAttributes(
+		label.String("ffmpeg.container", string(c)),
+		label.String("ffmpeg.start", FormatHHMMSS(start)),
+		label.String("ffmpeg.end", FormatHHMMSS(end)),
+		label.String("ffmpeg.mode", mode),
+	))
+
+	if !opts.CanStream(c) {
+		err := errors.New("clip options require a seekable source")
+		tracing.End(ctx, span, err)
 		return nil, err
 	}
 
-	e, err := ioutil.ReadAll(stderr)
+	args := append(f.clipArgs("pipe:0", c.demuxer(), start, end, opts, nil, c, 0), c.muxerArgs()...)
+	args = append(args, "pipe:1")
 
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stdin = r
+
+	logger := logging.FromContext(ctx)
+	logger.With("command", cmd.String()).Infof("Running ffmpeg")
+	span.SetAttributes(label.String("ffmpeg.command", cmd.String()))
+
+	out, err := startPiped(ctx, cmd, mode, span)
 	if err != nil {
+		tracing.End(ctx, span, err)
 		return nil, err
 	}
+	return out, nil
+}
 
-	if err := cmd.Wait(); err != nil {
-		log.Println(string(e))
+// run runs ffmpeg with the given arguments to completion, recording its duration under mode
+func (f *ffmpegExtractor) run(ctx context.Context, span trace.Span, mode string, args ...string) error {
+	_, err := f.runStderr(ctx, span, mode, args...)
+	return err
+}
+
+// runStderr is like run, but also returns what ffmpeg wrote to stderr
+func (f *ffmpegExtractor) runStderr(ctx context.Context, span trace.Span, mode string, args ...string) ([]byte, error) {
+	return f.runOutput(ctx, span, mode, nil, args...)
+}
+
+// runOutput is like runStderr, but if read is not nil, it is passed what ffmpeg writes to stdout as ffmpeg runs.
+// If read returns an error, ffmpeg is stopped and that error is returned.
+func (f *ffmpegExtractor) runOutput(ctx context.Context, span trace.Span, mode string, read func(io.Reader) error, args ...string) ([]byte, error) {
+	logger := logging.FromContext(ctx)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	logger.With("command", cmd.String()).Infof("Running ffmpeg")
+	span.SetAttributes(label.String("ffmpeg.command", cmd.String()))
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	var stdout io.Reader
+	if read != nil {
+		var err error
+		if stdout, err = cmd.StdoutPipe(); err != nil {
+			return nil, err
+		}
+	}
+
+	started := time.Now()
+	if err := cmd.Start(); err != nil {
 		return nil, err
 	}
 
-	log.Printf("File %q finished", tmpFile.Name())
+	if read != nil {
+		if err := read(stdout); err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			ffmpegDuration.WithLabelValues(mode, "error").Observe(time.Since(started).Seconds())
+			return nil, err
+		}
+		// Let ffmpeg write any output that read did not need, so that it can exit
+		io.Copy(ioutil.Discard, stdout)
+	}
 
-	return &tmpFileAutoCleanup{tmpFile}, nil
+	if err := cmd.Wait(); err != nil {
+		ffmpegDuration.WithLabelValues(mode, "error").Observe(time.Since(started).Seconds())
+		logger.WithError(err).With("ffmpegStderr", stderr.String()).Errorf("ffmpeg failed")
+		return nil, err
+	}
+	ffmpegDuration.WithLabelValues(mode, "success").Observe(time.Since(started).Seconds())
+	logger.With("ffmpegStderr", stderr.String()).Debugf("ffmpeg output")
+	return stderr.Bytes(), nil
 }
 
-func formatHHMMSS(d time.Duration) string {
-	d = d.Round(time.Second)
+// FormatHHMMSS formats d, rounded to the nearest millisecond, as HH:MM:SS, followed by the milliseconds
+// as .mmm if d is not a whole number of seconds
+func FormatHHMMSS(d time.Duration) string {
+	d = d.Round(time.Millisecond)
 	h := d / time.Hour
 	d -= h * time.Hour
 	m := d / time.Minute
 	d -= m * time.Minute
 	s := d / time.Second
+	d -= s * time.Second
+	if ms := d / time.Millisecond; ms != 0 {
+		return fmt.Sprintf("%02d:%02d:%02d.%03d", h, m, s, ms)
+	}
 	return fmt.Sprintf("%02d:%02d:%02d", h, m, s)
 }
 
 type tmpFileAutoCleanup struct {
-	file *os.File
+	file   *os.File
+	logger *logging.Logger
 }
 
 func (f *tmpFileAutoCleanup) Read(p []byte) (n int, err error) {
@@ -98,9 +251,9 @@ func (f *tmpFileAutoCleanup) Read(p []byte) (n int, err error) {
 func (f *tmpFileAutoCleanup) Close() error {
 	defer func() {
 		if err := os.Remove(f.file.Name()); err != nil {
-			log.Println("Error deleting file:", err)
+			f.logger.WithError(err).Warnf("Error deleting file %s", f.file.Name())
 		} else {
-			log.Println("Deleted", f.file.Name())
+			f.logger.Debugf("Deleted %s", f.file.Name())
 		}
 	}()
 	return f.file.Close()
@@ -51,11 +51,19 @@ func TestFormatHHMMSS(t *testing.T) {
 			dur:      90 * time.Second,
 			expected: "00:01:30",
 		},
+		{
+			dur:      83*time.Second + 250*time.Millisecond,
+			expected: "00:01:23.250",
+		},
+		{
+			dur:      time.Minute + 999600*time.Microsecond,
+			expected: "00:01:01",
+		},
 	}
 
 	for _, test := range tests {
 		t.Run(fmt.Sprint(test.dur), func(t *testing.T) {
-			if diff := cmp.Diff(test.expected, formatHHMMSS(test.dur)); diff != "" {
+			if diff := cmp.Diff(test.expected, FormatHHMMSS(test.dur)); diff != "" {
 				t.Error("Output different than expected (-want +got):", diff)
 			}
 		})
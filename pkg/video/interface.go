@@ -23,6 +23,31 @@ import (
 
 // Extractor extracts a clip from a video source
 type Extractor interface {
-	// Clip extracts a clip from the given video (or audio) file between the given start time and end time (inclusive)
-	Clip(ctx context.Context, filename string, start time.Duration, end time.Duration) (io.ReadCloser, error)
+	// Clip extracts a clip from the given video (or audio) file between the given start time and end time (inclusive).
+	// Any temporary files are created in the same directory as filename.
+	Clip(ctx context.Context, filename string, start time.Duration, end time.Duration, opts ClipOptions) (io.ReadCloser, error)
+	// ClipStream extracts a clip between the given start time and end time (inclusive) from a video (or audio)
+	// read sequentially from r, which must be in container c. The clip is written in the same container.
+	// Closing the returned reader before reaching its end aborts the extraction.
+	// Fails if opts.RequiresSeeking().
+	ClipStream(ctx context.Context, r io.Reader, c Container, start time.Duration, end time.Duration, opts ClipOptions) (io.ReadCloser, error)
+	// Compile joins the given segments, in order, into a single video. Segments from sources with different
+	// dimensions, frame rates or audio formats are converted to match.
+	// Any temporary files are created in the same directory as the first segment's file.
+	Compile(ctx context.Context, segments []Segment, opts CompileOptions) (io.ReadCloser, error)
+	// Compose draws the given parts side by side in a single video and mixes their audio.
+	// Any temporary files are created in the same directory as the first part's file.
+	Compose(ctx context.Context, parts []Part, opts ComposeOptions) (io.ReadCloser, error)
+	// Mixdown mixes the audio of the given tracks into a stereo audio file.
+	// Any temporary files are created in the same directory as the first track's file.
+	Mixdown(ctx context.Context, tracks []Track, opts MixOptions) (io.ReadCloser, error)
+	// DetectSilence finds the silences in the audio of the given file
+	DetectSilence(ctx context.Context, filename string, opts SilenceOptions) (*SilenceReport, error)
+	// Classify divides the audio of the given file between start and end, or its end if end is zero,
+	// into regions of silence, applause and music
+	Classify(ctx context.Context, filename string, start, end time.Duration) ([]Region, error)
+	// DetectScenes finds the scenes of the given file between start and end, or its end if end is zero
+	DetectScenes(ctx context.Context, filename string, start, end time.Duration, opts SceneOptions) ([]Scene, error)
+	// Align finds the offsets of the given recordings relative to the first by analysing their audio
+	Align(ctx context.Context, filenames []string, opts AlignOptions) ([]Alignment, error)
 }
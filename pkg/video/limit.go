@@ -0,0 +1,259 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned by a limited Extractor when all workers are busy and the wait queue is full
+var ErrQueueFull = errors.New("too many concurrent jobs, try again later")
+
+type priorityKey struct{}
+
+// WithPriority returns a copy of ctx that gives jobs started with it the given priority.
+// When jobs are queued, those with higher priority run first.
+func WithPriority(ctx context.Context, priority int) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+func priorityFromContext(ctx context.Context) int {
+	p, _ := ctx.Value(priorityKey{}).(int)
+	return p
+}
+
+// Saturater is implemented by Extractors that can report whether they would reject a new job
+type Saturater interface {
+	// Saturated reports whether a job started now would fail with ErrQueueFull
+	Saturated() bool
+}
+
+type limitedExtractor struct {
+	e          Extractor
+	maxRunning int
+	maxQueue   int
+
+	mu      sync.Mutex
+	running int
+	queue   waitQueue
+	seq     uint64
+}
+
+// NewLimitedExtractor wraps e so that at most maxRunning clips are extracted concurrently.
+// Up to maxQueue further jobs wait for a free worker in priority order; beyond that, jobs fail with ErrQueueFull.
+func NewLimitedExtractor(e Extractor, maxRunning, maxQueue int) Extractor {
+	return &limitedExtractor{e: e, maxRunning: maxRunning, maxQueue: maxQueue}
+}
+
+func (l *limitedExtractor) Clip(ctx context.Context, filename string, start time.Duration, end time.Duration, opts ClipOptions) (io.ReadCloser, error) {
+	if err := l.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer l.release()
+	return l.e.Clip(ctx, filename, start, end, opts)
+}
+
+func (l *limitedExtractor) Compile(ctx context.Context, segments []Segment, opts CompileOptions) (io.ReadCloser, error) {
+	if err := l.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer l.release()
+	return l.e.Compile(ctx, segments, opts)
+}
+
+func (l *limitedExtractor) Compose(ctx context.Context, parts []Part, opts ComposeOptions) (io.ReadCloser, error) {
+	if err := l.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer l.release()
+	return l.e.Compose(ctx, parts, opts)
+}
+
+func (l *limitedExtractor) Mixdown(ctx context.Context, tracks []Track, opts MixOptions) (io.ReadCloser, error) {
+	if err := l.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer l.release()
+	return l.e.Mixdown(ctx, tracks, opts)
+}
+
+func (l *limitedExtractor) DetectSilence(ctx context.Context, filename string, opts SilenceOptions) (*SilenceReport, error) {
+	if err := l.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer l.release()
+	return l.e.DetectSilence(ctx, filename, opts)
+}
+
+func (l *limitedExtractor) Classify(ctx context.Context, filename string, start, end time.Duration) ([]Region, error) {
+	if err := l.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer l.release()
+	return l.e.Classify(ctx, filename, start, end)
+}
+
+func (l *limitedExtractor) DetectScenes(ctx context.Context, filename string, start, end time.Duration, opts SceneOptions) ([]Scene, error) {
+	if err := l.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer l.release()
+	return l.e.DetectScenes(ctx, filename, start, end, opts)
+}
+
+func (l *limitedExtractor) Align(ctx context.Context, filenames []string, opts AlignOptions) ([]Alignment, error) {
+	if err := l.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer l.release()
+	return l.e.Align(ctx, filenames, opts)
+}
+
+func (l *limitedExtractor) ClipStream(ctx context.Context, r io.Reader, c Container, start time.Duration, end time.Duration, opts ClipOptions) (io.ReadCloser, error) {
+	if err := l.acquire(ctx); err != nil {
+		return nil, err
+	}
+	clip, err := l.e.ClipStream(ctx, r, c, start, end, opts)
+	if err != nil {
+		l.release()
+		return nil, err
+	}
+	// The clip is extracted while it is read, so the slot is held until it is closed
+	return &releasingReadCloser{ReadCloser: clip, release: l.release}, nil
+}
+
+func (l *limitedExtractor) Saturated() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.running >= l.maxRunning && l.queue.Len() >= l.maxQueue
+}
+
+func (l *limitedExtractor) acquire(ctx context.Context) error {
+	l.mu.Lock()
+	if l.running < l.maxRunning && l.queue.Len() == 0 {
+		l.running++
+		l.observe()
+		l.mu.Unlock()
+		return nil
+	}
+	if l.queue.Len() >= l.maxQueue {
+		l.mu.Unlock()
+		return ErrQueueFull
+	}
+	l.seq++
+	w := &waiter{priority: priorityFromContext(ctx), seq: l.seq, ready: make(chan struct{})}
+	heap.Push(&l.queue, w)
+	l.observe()
+	l.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if w.index >= 0 {
+			heap.Remove(&l.queue, w.index)
+			l.observe()
+			return ctx.Err()
+		}
+		// The slot was handed over concurrently with cancellation, so pass it on
+		l.releaseLocked()
+		return ctx.Err()
+	}
+}
+
+func (l *limitedExtractor) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.releaseLocked()
+}
+
+// releaseLocked hands the caller's slot to the highest priority waiter, if any.
+// l.mu must be held.
+func (l *limitedExtractor) releaseLocked() {
+	if l.queue.Len() > 0 {
+		w := heap.Pop(&l.queue).(*waiter)
+		close(w.ready)
+	} else {
+		l.running--
+	}
+	l.observe()
+}
+
+// observe records the current pool state. l.mu must be held.
+func (l *limitedExtractor) observe() {
+	jobsRunning.Set(float64(l.running))
+	jobsQueued.Set(float64(l.queue.Len()))
+}
+
+// releasingReadCloser calls release once when it is closed
+type releasingReadCloser struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (r *releasingReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.release)
+	return err
+}
+
+type waiter struct {
+	priority int
+	seq      uint64
+	ready    chan struct{}
+	index    int
+}
+
+// waitQueue is a heap of waiters ordered by descending priority, then by arrival
+type waitQueue []*waiter
+
+func (q waitQueue) Len() int { return len(q) }
+
+func (q waitQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q waitQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *waitQueue) Push(x interface{}) {
+	w := x.(*waiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *waitQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	w := old[n-1]
+	old[n-1] = nil
+	w.index = -1
+	*q = old[:n-1]
+	return w
+}
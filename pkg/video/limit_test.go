@@ -0,0 +1,237 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// blockingExtractor records the order in which clips start and blocks each one until released
+type blockingExtractor struct {
+	mu      sync.Mutex
+	started []string
+	startc  chan string
+	release chan struct{}
+}
+
+func newBlockingExtractor() *blockingExtractor {
+	return &blockingExtractor{startc: make(chan string, 10), release: make(chan struct{})}
+}
+
+func (b *blockingExtractor) Clip(ctx context.Context, filename string, start time.Duration, end time.Duration, opts ClipOptions) (io.ReadCloser, error) {
+	b.mu.Lock()
+	b.started = append(b.started, filename)
+	b.mu.Unlock()
+	b.startc <- filename
+	<-b.release
+	return ioutil.NopCloser(strings.NewReader(filename)), nil
+}
+
+func (b *blockingExtractor) ClipStream(ctx context.Context, r io.Reader, c Container, start time.Duration, end time.Duration, opts ClipOptions) (io.ReadCloser, error) {
+	return ioutil.NopCloser(r), nil
+}
+
+func (b *blockingExtractor) Compile(ctx context.Context, segments []Segment, opts CompileOptions) (io.ReadCloser, error) {
+	return b.Clip(ctx, segments[0].Filename, segments[0].Start, segments[0].End, ClipOptions{})
+}
+
+func (b *blockingExtractor) Compose(ctx context.Context, parts []Part, opts ComposeOptions) (io.ReadCloser, error) {
+	return b.Clip(ctx, parts[0].Filename, 0, 0, ClipOptions{})
+}
+
+func (b *blockingExtractor) Mixdown(ctx context.Context, tracks []Track, opts MixOptions) (io.ReadCloser, error) {
+	return b.Clip(ctx, tracks[0].Filename, 0, 0, ClipOptions{})
+}
+
+func (b *blockingExtractor) DetectSilence(ctx context.Context, filename string, opts SilenceOptions) (*SilenceReport, error) {
+	r, err := b.Clip(ctx, filename, 0, 0, ClipOptions{})
+	if err != nil {
+		return nil, err
+	}
+	r.Close()
+	return &SilenceReport{}, nil
+}
+
+func (b *blockingExtractor) Classify(ctx context.Context, filename string, start, end time.Duration) ([]Region, error) {
+	r, err := b.Clip(ctx, filename, start, end, ClipOptions{})
+	if err != nil {
+		return nil, err
+	}
+	r.Close()
+	return nil, nil
+}
+
+func (b *blockingExtractor) DetectScenes(ctx context.Context, filename string, start, end time.Duration, opts SceneOptions) ([]Scene, error) {
+	r, err := b.Clip(ctx, filename, start, end, ClipOptions{})
+	if err != nil {
+		return nil, err
+	}
+	r.Close()
+	return nil, nil
+}
+
+func (b *blockingExtractor) Align(ctx context.Context, filenames []string, opts AlignOptions) ([]Alignment, error) {
+	r, err := b.Clip(ctx, filenames[0], 0, 0, ClipOptions{})
+	if err != nil {
+		return nil, err
+	}
+	r.Close()
+	return make([]Alignment, len(filenames)), nil
+}
+
+func (b *blockingExtractor) waitStarted(t *testing.T) string {
+	t.Helper()
+	select {
+	case f := <-b.startc:
+		return f
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for clip to start")
+	}
+	return ""
+}
+
+// waitQueued waits until the limiter has n queued jobs
+func waitQueued(t *testing.T, l *limitedExtractor, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		l.mu.Lock()
+		queued := l.queue.Len()
+		l.mu.Unlock()
+		if queued == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %d queued jobs", n)
+}
+
+func TestLimitedExtractor_PriorityOrder(t *testing.T) {
+	b := newBlockingExtractor()
+	l := NewLimitedExtractor(b, 1, 3).(*limitedExtractor)
+
+	var wg sync.WaitGroup
+	clip := func(name string, priority int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := l.Clip(WithPriority(context.Background(), priority), name, 0, 0, ClipOptions{}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	clip("running", 0)
+	b.waitStarted(t)
+
+	clip("low", -1)
+	waitQueued(t, l, 1)
+	clip("normal", 0)
+	waitQueued(t, l, 2)
+	clip("high", 5)
+	waitQueued(t, l, 3)
+
+	if !l.Saturated() {
+		t.Error("Expected limiter to be saturated")
+	}
+
+	if _, err := l.Clip(context.Background(), "rejected", 0, 0, ClipOptions{}); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("got error %v, want %v", err, ErrQueueFull)
+	}
+
+	for i := 0; i < 4; i++ {
+		b.release <- struct{}{}
+		if i < 3 {
+			b.waitStarted(t)
+		}
+	}
+	wg.Wait()
+
+	if diff := cmp.Diff([]string{"running", "high", "normal", "low"}, b.started); diff != "" {
+		t.Error("Clips started in different order than expected (-want +got):", diff)
+	}
+
+	if l.running != 0 || l.queue.Len() != 0 {
+		t.Errorf("got %d running and %d queued after completion, want 0 and 0", l.running, l.queue.Len())
+	}
+}
+
+func TestLimitedExtractor_CancelWhileQueued(t *testing.T) {
+	b := newBlockingExtractor()
+	l := NewLimitedExtractor(b, 1, 1).(*limitedExtractor)
+
+	done := make(chan struct{})
+	go func() {
+		l.Clip(context.Background(), "running", 0, 0, ClipOptions{})
+		close(done)
+	}()
+	b.waitStarted(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() {
+		_, err := l.Clip(ctx, "cancelled", 0, 0, ClipOptions{})
+		errc <- err
+	}()
+	waitQueued(t, l, 1)
+	cancel()
+
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+
+	if l.Saturated() {
+		t.Error("Expected cancelled job to leave the queue")
+	}
+
+	b.release <- struct{}{}
+	<-done
+
+	if diff := cmp.Diff([]string{"running"}, b.started); diff != "" {
+		t.Error("Clips started different than expected (-want +got):", diff)
+	}
+}
+
+func TestLimitedExtractor_StreamHoldsSlotUntilClosed(t *testing.T) {
+	l := NewLimitedExtractor(newBlockingExtractor(), 1, 0).(*limitedExtractor)
+
+	clip, err := l.ClipStream(context.Background(), strings.NewReader("source"), ContainerMatroska, 0, 0, ClipOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !l.Saturated() {
+		t.Error("Expected limiter to be saturated while the stream is open")
+	}
+
+	clip.Close()
+	clip.Close()
+
+	l.mu.Lock()
+	running := l.running
+	l.mu.Unlock()
+	if running != 0 {
+		t.Errorf("got %d running after close, want 0", running)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// maxTagLength is the longest metadata value accepted, in bytes
+const maxTagLength = 1024
+
+// Metadata are the tags written into an output file, as MP4 or Matroska tags, ID3v2.3 frames in MP3,
+// Vorbis comments in FLAC or INFO chunks in WAV. Empty tags are not written.
+type Metadata struct {
+	Title    string
+	Artist   string
+	Composer string
+	Album    string
+	Date     string
+	Comment  string
+}
+
+func (m *Metadata) tags() [][2]string {
+	return [][2]string{
+		{"title", m.Title},
+		{"artist", m.Artist},
+		{"composer", m.Composer},
+		{"album", m.Album},
+		{"date", m.Date},
+		{"comment", m.Comment},
+	}
+}
+
+// Validate returns an error if any tag is too long
+func (m *Metadata) Validate() error {
+	for _, tag := range m.tags() {
+		if len(tag[1]) > maxTagLength {
+			return fmt.Errorf("%s must be at most %d bytes", tag[0], maxTagLength)
+		}
+	}
+	return nil
+}
+
+// args returns the ffmpeg output arguments that write the tags
+func (m *Metadata) args() []string {
+	var args []string
+	for _, tag := range m.tags() {
+		if tag[1] != "" {
+			args = append(args, "-metadata", tag[0]+"="+tag[1])
+		}
+	}
+	return args
+}
+
+// ErrInvalidCoverArt is returned when cover art cannot be embedded in a mixdown
+var ErrInvalidCoverArt = errors.New("invalid cover art")
+
+// ValidateCoverArtFormat returns an error wrapping ErrInvalidCoverArt if cover art cannot be embedded in files of the given format
+func ValidateCoverArtFormat(format AudioFormat) error {
+	if format == "" || format == FormatWAV {
+		return fmt.Errorf("%w: cannot be embedded in %s files", ErrInvalidCoverArt, FormatWAV)
+	}
+	return nil
+}
+
+// validateCoverArt returns an error wrapping ErrInvalidCoverArt if the image at path cannot be embedded in a file of the given format
+func validateCoverArt(path string, format AudioFormat) error {
+	if err := ValidateCoverArtFormat(format); err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg", ".png":
+		return nil
+	}
+	return fmt.Errorf("%w: must be a JPEG or PNG image, not %q", ErrInvalidCoverArt, filepath.Base(path))
+}
+
+// coverArtArgs returns the ffmpeg output arguments that embed the image of the given input as the front cover
+func coverArtArgs(input int) []string {
+	return []string{
+		"-map", fmt.Sprintf("%d:v", input), "-c:v", "copy", "-disposition:v", "attached_pic",
+		"-metadata:s:v", "title=Album cover", "-metadata:s:v", "comment=Cover (front)",
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMetadata_Validate(t *testing.T) {
+	if err := (&Metadata{Comment: strings.Repeat("a", maxTagLength+1)}).Validate(); err == nil {
+		t.Error("Expected error validating a long comment")
+	}
+	if err := (&Metadata{Title: "Symphony No. 7", Date: "2020-03-14"}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateCoverArt(t *testing.T) {
+	cases := []struct {
+		name    string
+		path    string
+		format  AudioFormat
+		wantErr bool
+	}{
+		{name: "JPEG in MP3", path: "cover.jpeg", format: FormatMP3},
+		{name: "PNG in FLAC", path: "cover.PNG", format: FormatFLAC},
+		{name: "Default format", path: "cover.jpg", wantErr: true},
+		{name: "WAV", path: "cover.jpg", format: FormatWAV, wantErr: true},
+		{name: "NotAnImage", path: "cover.pdf", format: FormatMP3, wantErr: true},
+	}
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateCoverArt(test.path, test.format)
+			if test.wantErr != errors.Is(err, ErrInvalidCoverArt) {
+				t.Errorf("got error %v, want error wrapping %v: %t", err, ErrInvalidCoverArt, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestClipArgs_Metadata(t *testing.T) {
+	opts := ClipOptions{Metadata: &Metadata{Title: "Symphony No. 7: II. Allegretto", Composer: "Beethoven", Artist: "NOCCO"}}
+	expected := []string{
+		"-noaccurate_seek", "-ss", "00:01:00", "-i", "in.mp4", "-t", "00:00:30", "-avoid_negative_ts", "make_zero", "-c", "copy",
+		"-metadata", "title=Symphony No. 7: II. Allegretto", "-metadata", "artist=NOCCO", "-metadata", "composer=Beethoven",
+	}
+	actual := (&ffmpegExtractor{}).clipArgs("in.mp4", "", time.Minute, 90*time.Second, opts, nil, ContainerMP4, 0)
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Error("Arguments different than expected (-want +got):", diff)
+	}
+}
+
+func TestMixdownArgs_CoverArt(t *testing.T) {
+	opts := MixOptions{Format: FormatMP3, Metadata: &Metadata{Album: "Spring Concert", Date: "2020"}, CoverArt: "cover.jpg"}
+	expected := []string{
+		"-i", "piano.flac", "-i", "cover.jpg",
+		"-filter_complex", "[0:a]aresample=48000,aformat=sample_fmts=fltp:channel_layouts=stereo,asetpts=PTS-STARTPTS[a0];[a0]anull[a]",
+		"-map", "[a]",
+		"-map", "1:v", "-c:v", "copy", "-disposition:v", "attached_pic",
+		"-metadata:s:v", "title=Album cover", "-metadata:s:v", "comment=Cover (front)",
+		"-metadata", "album=Spring Concert", "-metadata", "date=2020", "-id3v2_version", "3",
+		"-c:a", "libmp3lame", "-b:a", "320k",
+	}
+	if diff := cmp.Diff(expected, mixdownArgs([]Track{{Filename: "piano.flac"}}, opts)); diff != "" {
+		t.Error("Arguments different than expected (-want +got):", diff)
+	}
+}
+
+func TestClipContainer_Metadata(t *testing.T) {
+	tagged := ClipOptions{Metadata: &Metadata{Title: "Symphony No. 7"}}
+	cases := []struct {
+		filename  string
+		extension string
+		stream    bool
+		muxer     []string
+	}{
+		{"symphony.mp4", ".mp4", true, nil},
+		{"symphony.MOV", ".MOV", true, nil},
+		{"symphony.mkv", ".mkv", true, []string{"-f", "matroska"}},
+		{"symphony.webm", ".webm", true, []string{"-f", "webm"}},
+		{"symphony.mp3", ".mp3", false, []string{"-f", "mp3", "-id3v2_version", "3"}},
+		// MPEG-TS cannot hold tags
+		{"symphony.ts", ".mp4", false, nil},
+		{"symphony.avi", ".mp4", false, nil},
+	}
+	for _, test := range cases {
+		t.Run(test.filename, func(t *testing.T) {
+			if diff := cmp.Diff(test.extension, ClipExtension(test.filename, tagged)); diff != "" {
+				t.Error("Extension different than expected (-want +got):", diff)
+			}
+			c, _ := sourceContainer(test.filename)
+			if diff := cmp.Diff(test.muxer, clipContainer(c, tagged).fileMuxerArgs()); diff != "" {
+				t.Error("Muxer arguments different than expected (-want +got):", diff)
+			}
+			if stream := MayStream(test.filename) && tagged.CanStream(c); stream != test.stream {
+				t.Errorf("got streamable %v, want %v", stream, test.stream)
+			}
+		})
+	}
+
+	if !(ClipOptions{}).CanStream(ContainerMPEGTS) {
+		t.Error("Expected untagged MPEG-TS clips to be streamable")
+	}
+	if diff := cmp.Diff(".ts", ClipExtension("symphony.ts", ClipOptions{})); diff != "" {
+		t.Error("Extension of untagged MPEG-TS clip different than expected (-want +got):", diff)
+	}
+}
+
+func TestClipArgs_MetadataMP3(t *testing.T) {
+	info := &mediaInfo{HasAudio: true, SampleRate: 44100, Channels: 2, ChannelLayout: "stereo"}
+	opts := ClipOptions{Metadata: &Metadata{Title: "Sonata"}, Fades: &Fades{AudioIn: time.Second}}
+	expected := []string{
+		"-noaccurate_seek", "-ss", "00:01:00", "-i", "in.mp3", "-t", "00:00:30", "-avoid_negative_ts", "make_zero", "-c", "copy",
+		"-af", "afade=t=in:st=0:d=1", "-c:a", "libmp3lame", "-b:a", "192000",
+		"-metadata", "title=Sonata",
+	}
+	actual := (&ffmpegExtractor{}).clipArgs("in.mp3", "", time.Minute, 90*time.Second, opts, info, ContainerMP3, 0)
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Error("Arguments different than expected (-want +got):", diff)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ffmpeg modes, used to label metrics and spans
+const (
+	// modeCopy copies streams between files without re-encoding
+	modeCopy = "copy"
+	// modeEncode re-encodes video between files
+	modeEncode = "encode"
+	// modeTwoPass re-encodes video between files in two passes to reach a target size
+	modeTwoPass = "two-pass"
+	// modeCompile re-encodes and joins segments of one or more files
+	modeCompile = "compile"
+	// modeCompose re-encodes several files drawn side by side into one
+	modeCompose = "compose"
+	// modeMixdown mixes the audio of several files
+	modeMixdown = "mixdown"
+	// modeAnalyse decodes a file to analyse it without producing output
+	modeAnalyse = "analyse"
+	// modeStreamCopy copies streams from a pipe to a pipe without re-encoding
+	modeStreamCopy = "stream-copy"
+	// modeStreamEncode re-encodes video from a pipe to a pipe
+	modeStreamEncode = "stream-encode"
+)
+
+var (
+	ffmpegDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "nocco",
+		Subsystem: "ffmpeg",
+		Name:      "duration_seconds",
+		Help:      "Time taken by ffmpeg invocations, by mode.",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 12),
+	}, []string{"mode", "result"})
+	ffmpegOutputBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nocco",
+		Subsystem: "ffmpeg",
+		Name:      "output_bytes_total",
+		Help:      "Total number of bytes written by ffmpeg, by mode.",
+	}, []string{"mode"})
+	jobsRunning = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "nocco",
+		Subsystem: "ffmpeg",
+		Name:      "jobs_running",
+		Help:      "Number of clips currently being extracted by a limited Extractor.",
+	})
+	jobsQueued = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "nocco",
+		Subsystem: "ffmpeg",
+		Name:      "jobs_queued",
+		Help:      "Number of clips waiting for a free worker in a limited Extractor.",
+	})
+)
@@ -0,0 +1,235 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ssmall/nocco-video-extractor/pkg/logging"
+	"github.com/ssmall/nocco-video-extractor/pkg/tracing"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
+)
+
+// AudioFormat is the file format of a mixdown
+type AudioFormat string
+
+const (
+	// FormatWAV is 16-bit PCM in a WAV file
+	FormatWAV AudioFormat = "wav"
+	// FormatFLAC is lossless FLAC
+	FormatFLAC AudioFormat = "flac"
+	// FormatMP3 is MP3 at 320 kbit/s
+	FormatMP3 AudioFormat = "mp3"
+)
+
+const (
+	// minLoudness and maxLoudness bound the integrated loudness targets accepted by loudnorm, in LUFS
+	minLoudness = -70
+	maxLoudness = -5
+	// loudnessTruePeak and loudnessRange are the other loudnorm targets used with a loudness target
+	loudnessTruePeak = -1.5
+	loudnessRange    = 11
+)
+
+// Track is a local file whose audio is included in a mixdown
+type Track struct {
+	Filename string
+	// Offset aligns the track with the others, as for Part
+	Offset time.Duration
+	// GainDB adjusts the volume of the track in decibels
+	GainDB float64
+	// Pan positions the track from -1 (left) through 0 (centre) to 1 (right)
+	Pan float64
+	// Mute excludes the track from the mixdown
+	Mute bool
+}
+
+// MixOptions customise how tracks are mixed
+type MixOptions struct {
+	// Format is the file format of the mixdown, by default FormatWAV
+	Format AudioFormat
+	// Loudness, if set, is the integrated loudness in LUFS to which the mixdown is normalised, e.g. -16
+	Loudness float64
+	// Metadata, if set, are the tags of the mixdown
+	Metadata *Metadata
+	// CoverArt, if set, is the local path of a JPEG or PNG image embedded as the cover of the mixdown.
+	// WAV files cannot have cover art.
+	CoverArt string
+}
+
+// Ext returns the file extension of the format, including the leading dot
+func (f AudioFormat) Ext() string {
+	if f == "" {
+		return "." + string(FormatWAV)
+	}
+	return "." + string(f)
+}
+
+func (f AudioFormat) encoderArgs() []string {
+	switch f {
+	case FormatFLAC:
+		return []string{"-c:a", "flac"}
+	case FormatMP3:
+		return []string{"-c:a", "libmp3lame", "-b:a", "320k"}
+	}
+	return []string{"-c:a", "pcm_s16le"}
+}
+
+// ValidateTracks returns an error if the tracks cannot be mixed with opts
+func ValidateTracks(tracks []Track, opts MixOptions) error {
+	var audible int
+	for i, t := range tracks {
+		if math.Abs(t.GainDB) > maxGainDB {
+			return fmt.Errorf("gain of track %d must be between -%d and %d dB", i+1, maxGainDB, maxGainDB)
+		}
+		if t.Pan < -1 || t.Pan > 1 {
+			return fmt.Errorf("pan of track %d must be between -1 and 1", i+1)
+		}
+		if !t.Mute {
+			audible++
+		}
+	}
+	if audible == 0 {
+		return errors.New("at least one track that is not muted is required")
+	}
+	switch opts.Format {
+	case "", FormatWAV, FormatFLAC, FormatMP3:
+	default:
+		return fmt.Errorf("unknown audio format %q", opts.Format)
+	}
+	if opts.Loudness != 0 && (opts.Loudness < minLoudness || opts.Loudness > maxLoudness) {
+		return fmt.Errorf("loudness target must be between %d and %d LUFS", minLoudness, maxLoudness)
+	}
+	if opts.Metadata != nil {
+		if err := opts.Metadata.Validate(); err != nil {
+			return err
+		}
+	}
+	if opts.CoverArt != "" {
+		return validateCoverArt(opts.CoverArt, opts.Format)
+	}
+	return nil
+}
+
+func (f *ffmpegExtractor) Mixdown(ctx context.Context, tracks []Track, opts MixOptions) (mix io.ReadCloser, err error) {
+	ctx, span := tracer.Start(ctx, "ffmpeg.Mixdown", trace.WithAttributes(
+		label.Int("ffmpeg.tracks", len(tracks)),
+		label.String("ffmpeg.mode", modeMixdown),
+	))
+	defer func() {
+		tracing.End(ctx, span, err)
+	}()
+
+	if err := ValidateTracks(tracks, opts); err != nil {
+		return nil, err
+	}
+
+	var audible []Track
+	for _, t := range tracks {
+		if t.Mute {
+			continue
+		}
+		info, err := probe(ctx, t.Filename)
+		if err != nil {
+			return nil, err
+		}
+		if !info.HasAudio {
+			return nil, fmt.Errorf("%s has no audio stream", filepath.Base(t.Filename))
+		}
+		audible = append(audible, t)
+	}
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(audible[0].Filename), "ffmpeg-*"+opts.Format.Ext())
+	if err != nil {
+		return nil, err
+	}
+
+	logger := logging.FromContext(ctx)
+	logger.Debugf("Created temp file for mixdown: %s", tmpFile.Name())
+
+	args := append(mixdownArgs(audible, opts), "-y", tmpFile.Name())
+	if err := f.run(ctx, span, modeMixdown, args...); err != nil {
+		return nil, err
+	}
+
+	if fi, err := tmpFile.Stat(); err == nil {
+		ffmpegOutputBytes.WithLabelValues(modeMixdown).Add(float64(fi.Size()))
+	}
+	logger.Infof("Mixdown %q finished", tmpFile.Name())
+
+	return &tmpFileAutoCleanup{tmpFile, logger}, nil
+}
+
+// mixdownArgs returns the ffmpeg arguments, other than the output, that mix the audio of tracks, none of which are muted
+func mixdownArgs(tracks []Track, opts MixOptions) []string {
+	var args []string
+	for _, t := range tracks {
+		args = append(args, offsetArgs(t.Offset)...)
+		args = append(args, "-i", t.Filename)
+	}
+	if opts.CoverArt != "" {
+		args = append(args, "-i", opts.CoverArt)
+	}
+
+	var chains, mixed []string
+	for i, t := range tracks {
+		chain := audioChain(i, t.Offset, t.GainDB)
+		if t.Pan != 0 {
+			// Balance: the side the track is panned away from is attenuated
+			left, right := math.Min(1, 1-t.Pan), math.Min(1, 1+t.Pan)
+			chain += fmt.Sprintf(",pan=stereo|c0=%g*c0|c1=%g*c1", left, right)
+		}
+		chains = append(chains, fmt.Sprintf("%s[a%d]", chain, i))
+		mixed = append(mixed, fmt.Sprintf("[a%d]", i))
+	}
+
+	mix := mixed[0] + "anull"
+	if len(mixed) > 1 {
+		// amix divides each input by the number of inputs; restore their original levels
+		mix = fmt.Sprintf("%samix=inputs=%d:duration=longest:dropout_transition=0,volume=%d", strings.Join(mixed, ""), len(mixed), len(mixed))
+	}
+	if opts.Loudness != 0 {
+		mix += "," + loudnormFilter(opts.Loudness, compileSampleRate)
+	}
+	chains = append(chains, mix+"[a]")
+
+	args = append(args, "-filter_complex", strings.Join(chains, ";"), "-map", "[a]")
+	if opts.CoverArt != "" {
+		args = append(args, coverArtArgs(len(tracks))...)
+	}
+	if opts.Metadata != nil {
+		args = append(args, opts.Metadata.args()...)
+	}
+	if opts.Format == FormatMP3 && (opts.Metadata != nil || opts.CoverArt != "") {
+		// ID3v2.3 tags are read by more players than the default ID3v2.4
+		args = append(args, "-id3v2_version", "3")
+	}
+	return append(args, opts.Format.encoderArgs()...)
+}
+
+// loudnormFilter returns a filter that normalises audio to the integrated loudness target in LUFS.
+// loudnorm upsamples its output, so it is resampled to the given rate.
+func loudnormFilter(target float64, sampleRate int) string {
+	return fmt.Sprintf("loudnorm=I=%g:TP=%g:LRA=%d,aresample=%d", target, loudnessTruePeak, loudnessRange, sampleRate)
+}
@@ -0,0 +1,87 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestValidateTracks(t *testing.T) {
+	tracks := []Track{{Filename: "violin1.wav"}, {Filename: "violin2.wav", Mute: true}}
+	cases := []struct {
+		name   string
+		tracks []Track
+		opts   MixOptions
+		valid  bool
+	}{
+		{name: "Default", tracks: tracks, valid: true},
+		{name: "FLAC with loudness", tracks: tracks, opts: MixOptions{Format: FormatFLAC, Loudness: -16}, valid: true},
+		{name: "AllMuted", tracks: []Track{{Filename: "a.wav", Mute: true}}},
+		{name: "NoTracks"},
+		{name: "PanTooFar", tracks: []Track{{Filename: "a.wav", Pan: 1.5}}},
+		{name: "TooQuiet", tracks: []Track{{Filename: "a.wav", GainDB: -40}}},
+		{name: "UnknownFormat", tracks: tracks, opts: MixOptions{Format: "ogg"}},
+		{name: "LoudnessTooHigh", tracks: tracks, opts: MixOptions{Loudness: -2}},
+		{name: "MP3 with cover", tracks: tracks, opts: MixOptions{Format: FormatMP3, CoverArt: "cover.JPG"}, valid: true},
+		{name: "WAV with cover", tracks: tracks, opts: MixOptions{CoverArt: "cover.png"}},
+		{name: "CoverNotAnImage", tracks: tracks, opts: MixOptions{Format: FormatFLAC, CoverArt: "cover.pdf"}},
+	}
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateTracks(test.tracks, test.opts)
+			if test.valid && err != nil {
+				t.Error(err)
+			}
+			if !test.valid && err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
+
+func TestMixdownArgs(t *testing.T) {
+	tracks := []Track{
+		{Filename: "violin1.mov", Offset: 2 * time.Second, Pan: -0.5},
+		{Filename: "viola.wav", Offset: -100 * time.Millisecond, GainDB: 3, Pan: 0.25},
+		{Filename: "cello.mp4"},
+	}
+	expectedGraph := strings.Join([]string{
+		"[0:a]aresample=48000,aformat=sample_fmts=fltp:channel_layouts=stereo,asetpts=PTS-STARTPTS,pan=stereo|c0=1*c0|c1=0.5*c1[a0]",
+		"[1:a]aresample=48000,aformat=sample_fmts=fltp:channel_layouts=stereo,asetpts=PTS-STARTPTS,volume=3dB,adelay=100|100,pan=stereo|c0=0.75*c0|c1=1*c1[a1]",
+		"[2:a]aresample=48000,aformat=sample_fmts=fltp:channel_layouts=stereo,asetpts=PTS-STARTPTS[a2]",
+		"[a0][a1][a2]amix=inputs=3:duration=longest:dropout_transition=0,volume=3,loudnorm=I=-16:TP=-1.5:LRA=11,aresample=48000[a]",
+	}, ";")
+	expected := []string{
+		"-ss", "2", "-i", "violin1.mov", "-i", "viola.wav", "-i", "cello.mp4",
+		"-filter_complex", expectedGraph, "-map", "[a]", "-c:a", "libmp3lame", "-b:a", "320k",
+	}
+	if diff := cmp.Diff(expected, mixdownArgs(tracks, MixOptions{Format: FormatMP3, Loudness: -16})); diff != "" {
+		t.Error("Arguments different than expected (-want +got):", diff)
+	}
+
+	single := mixdownArgs([]Track{{Filename: "piano.flac"}}, MixOptions{})
+	expected = []string{
+		"-i", "piano.flac",
+		"-filter_complex", "[0:a]aresample=48000,aformat=sample_fmts=fltp:channel_layouts=stereo,asetpts=PTS-STARTPTS[a0];[a0]anull[a]",
+		"-map", "[a]", "-c:a", "pcm_s16le",
+	}
+	if diff := cmp.Diff(expected, single); diff != "" {
+		t.Error("Arguments different than expected (-want +got):", diff)
+	}
+}
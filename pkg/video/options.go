@@ -0,0 +1,516 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ClipOptions customise how a clip is extracted.
+// The zero value copies the clip's streams unchanged, which is fast and lossless.
+// Any other options require the video to be re-encoded.
+type ClipOptions struct {
+	// Preset, if set, determines the dimensions and encoding of the clip
+	Preset *Preset
+	// Overlay, if set, is drawn over the video
+	Overlay *Overlay
+	// Text, if set, is drawn over the start of the video
+	Text *TextOverlay
+	// TitleCard, if set, is prepended to the clip
+	TitleCard *TitleCard
+	// Bumpers, if set, are joined to the start and end of the clip, outside any title card
+	Bumpers *Bumpers
+	// Fades, if set, fade the clip in and out. Video fades require the video to be re-encoded,
+	// but audio fades alone only require the audio to be.
+	Fades *Fades
+	// MaxSize, if greater than zero, is the maximum size of the clip in bytes.
+	// The video bitrate is derived from it, and the clip is encoded in two passes.
+	MaxSize int64
+	// AutoTrim, if set, moves the start and end of the clip to the nearest boundaries of music,
+	// leaving out applause and silence. Video is re-encoded, as the boundaries rarely fall on keyframes.
+	AutoTrim *AutoTrim
+	// Chapters, if set, are written into the clip
+	Chapters *Chapters
+	// Metadata, if set, are the tags of the clip, written in the clip's container.
+	// MPEG-TS cannot hold tags, so tagged clips of MPEG-TS files are written as MP4.
+	Metadata *Metadata
+	// Privacy, if set, removes identifying information from the clip. Muting channels requires the audio
+	// to be re-encoded.
+	Privacy *Privacy
+	// Loudness, if set, is the integrated loudness in LUFS to which the audio is normalised, e.g. -16.
+	// The audio is re-encoded.
+	Loudness float64
+}
+
+// Validate returns an error if any of the options are invalid for a clip of the given duration
+func (o ClipOptions) Validate(clip time.Duration) error {
+	if o.Preset != nil {
+		if err := o.Preset.Validate(); err != nil {
+			return err
+		}
+	}
+	if o.Overlay != nil {
+		if err := o.Overlay.Validate(); err != nil {
+			return err
+		}
+	}
+	if o.Text != nil {
+		if err := o.Text.Validate(); err != nil {
+			return err
+		}
+	}
+	if o.TitleCard != nil {
+		if err := o.TitleCard.Validate(); err != nil {
+			return err
+		}
+	}
+	if o.Fades != nil {
+		if err := o.Fades.Validate(clip); err != nil {
+			return err
+		}
+	}
+	if o.AutoTrim != nil {
+		if err := o.AutoTrim.Validate(clip); err != nil {
+			return err
+		}
+	}
+	if o.Chapters != nil {
+		if err := o.Chapters.Validate(clip); err != nil {
+			return err
+		}
+	}
+	if o.Metadata != nil {
+		if err := o.Metadata.Validate(); err != nil {
+			return err
+		}
+	}
+	if o.Privacy != nil {
+		if err := o.Privacy.Validate(); err != nil {
+			return err
+		}
+	}
+	if o.Loudness != 0 && (o.Loudness < minLoudness || o.Loudness > maxLoudness) {
+		return fmt.Errorf("loudness target must be between %d and %d LUFS", minLoudness, maxLoudness)
+	}
+	if o.MaxSize < 0 {
+		return fmt.Errorf("maximum size must not be negative")
+	}
+	if o.MaxSize > 0 {
+		if _, err := o.VideoBitrate(clip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateTrimmed returns an error wrapping ErrInvalidTrim if the options, which were validated against the
+// requested length of the clip, are invalid for its length once trimmed by AutoTrim
+func (o ClipOptions) validateTrimmed(clip time.Duration) error {
+	o.AutoTrim = nil
+	if err := o.Validate(clip); err != nil {
+		return fmt.Errorf("%w of %s: %v", ErrInvalidTrim, clip, err)
+	}
+	return nil
+}
+
+// RequiresSeeking reports whether the options need the source to be inspected before it is clipped,
+// so that it cannot be read sequentially with ClipStream
+func (o ClipOptions) RequiresSeeking() bool {
+	return o.TitleCard != nil || o.Bumpers != nil || o.MaxSize > 0 || o.AutoTrim != nil || o.Chapters != nil ||
+		o.mutesChannels() || o.Loudness != 0
+}
+
+// CanStream reports whether a source in container c can be clipped with these options by ClipStream
+func (o ClipOptions) CanStream(c Container) bool {
+	return !o.RequiresSeeking() && clipContainer(c, o) == c
+}
+
+// reencode reports whether the options require the video to be re-encoded rather than copied
+func (o ClipOptions) reencode() bool {
+	return o.Preset != nil || o.Overlay != nil || o.Text != nil || o.TitleCard != nil || o.Bumpers != nil || o.MaxSize > 0 ||
+		(o.Fades != nil && o.Fades.video())
+}
+
+// requiresVideo reports whether the options draw on or join to the source's video, so that they cannot be
+// applied to an audio-only source
+func (o ClipOptions) requiresVideo() bool {
+	return o.TitleCard != nil || o.Bumpers != nil || o.MaxSize > 0 || o.Overlay != nil || o.Text != nil ||
+		(o.Chapters != nil && o.Chapters.Scenes != nil)
+}
+
+// checkSource returns an error if the options cannot be applied to the source named filename, described by info
+func (o ClipOptions) checkSource(filename string, info *mediaInfo) error {
+	if o.requiresVideo() && !info.HasVideo {
+		return fmt.Errorf("%s has no video stream", filename)
+	}
+	if o.Privacy != nil {
+		return o.Privacy.checkChannels(info)
+	}
+	return nil
+}
+
+// reencodes is like reencode, but also reports whether the video described by info, which may be nil,
+// is re-encoded because its bounds were moved by AutoTrim, and so need not fall on keyframes
+func (o ClipOptions) reencodes(info *mediaInfo) bool {
+	return o.reencode() || (o.AutoTrim != nil && info != nil && info.HasVideo)
+}
+
+// mutesChannels reports whether the options silence any audio channels
+func (o ClipOptions) mutesChannels() bool {
+	return o.Privacy != nil && len(o.Privacy.MuteChannels) > 0
+}
+
+// filtersAudio reports whether the options filter the audio with audioFilter
+func (o ClipOptions) filtersAudio() bool {
+	return o.fadesAudio() || o.mutesChannels() || o.Loudness != 0
+}
+
+// fadesAudio reports whether the options fade the audio
+func (o ClipOptions) fadesAudio() bool {
+	return o.Fades != nil && o.Fades.audio()
+}
+
+// outputDuration returns the duration of a clip of the given length after applying the options
+func (o ClipOptions) outputDuration(clip time.Duration) time.Duration {
+	d := clip
+	if o.TitleCard != nil {
+		d += o.TitleCard.duration()
+	}
+	if o.Bumpers != nil {
+		d += o.Bumpers.duration()
+	}
+	if o.Preset != nil && o.Preset.MaxDuration > 0 && d > o.Preset.MaxDuration {
+		d = o.Preset.MaxDuration
+	}
+	return d
+}
+
+// leadIn returns the duration of the output before the clip: the intro and any title card
+func (o ClipOptions) leadIn() time.Duration {
+	var d time.Duration
+	if o.Bumpers != nil && o.Bumpers.intro != nil {
+		d += o.Bumpers.intro.Duration
+	}
+	if o.TitleCard != nil {
+		d += o.TitleCard.duration()
+	}
+	return d
+}
+
+// clipPortion returns how much of a clip of the given length remains in the output after applying the options
+func (o ClipOptions) clipPortion(clip time.Duration) time.Duration {
+	d := o.outputDuration(clip)
+	if o.TitleCard != nil {
+		d -= o.TitleCard.duration()
+	}
+	if o.Bumpers != nil {
+		d -= o.Bumpers.duration()
+	}
+	return d
+}
+
+// mode returns the ffmpeg mode used to extract a clip with these options
+func (o ClipOptions) mode(stream bool) string {
+	switch {
+	case stream && o.reencode():
+		return modeStreamEncode
+	case stream:
+		return modeStreamCopy
+	case o.MaxSize > 0:
+		return modeTwoPass
+	case o.reencode():
+		return modeEncode
+	}
+	return modeCopy
+}
+
+// filterGraph returns the filter graph that applies the options to a clip of the given duration from input 0,
+// which is described by info. info may be nil unless RequiresSeeking is true. The graph's video output is
+// labelled [v], and it reports whether the graph also filters audio, in which case its audio output is labelled [a].
+// Otherwise, audio may need filtering with audioFilter.
+func (o ClipOptions) filterGraph(fontDir string, info *mediaInfo, clip time.Duration) (graph string, audio bool) {
+	var chains []string
+	var stages []func(in, out string) string
+	if o.Preset != nil && o.Preset.scales() {
+		stages = append(stages, o.Preset.filter)
+		if info != nil {
+			info = o.Preset.outputInfo(info)
+		}
+	}
+	if o.Overlay != nil {
+		stages = append(stages, o.Overlay.filter)
+	}
+	if o.Text != nil {
+		stages = append(stages, func(in, out string) string {
+			return in + o.Text.filter(fontDir) + out
+		})
+	}
+	if o.Fades != nil && o.Fades.video() {
+		stages = append(stages, func(in, out string) string {
+			return in + o.Fades.videoFilter(o.clipPortion(clip)) + out
+		})
+	}
+
+	// Videos joined before and after the clip are labelled [name] and, if the clip has audio, [nameaudio]
+	var sources, before, after []string
+	input := 1
+	if o.Overlay != nil {
+		input++
+	}
+	if o.Bumpers != nil && o.Bumpers.intro != nil {
+		sources = append(sources, conform(input, o.Bumpers.intro, info, "intro"))
+		before = append(before, "intro")
+		input++
+	}
+	if o.TitleCard != nil {
+		sources = append(sources, o.TitleCard.filter(fontDir, info))
+		before = append(before, "card")
+	}
+	if o.Bumpers != nil && o.Bumpers.outro != nil {
+		sources = append(sources, conform(input, o.Bumpers.outro, info, "outro"))
+		after = append(after, "outro")
+	}
+	joins := len(before)+len(after) > 0
+
+	audioIn := "[0:a]"
+	if joins && info.HasAudio && o.filtersAudio() {
+		chains = append(chains, audioIn+o.audioFilter(info, clip)+"[faded]")
+		audioIn = "[faded]"
+	}
+
+	if len(stages) == 0 && !joins {
+		stages = append(stages, func(in, out string) string {
+			return in + "null" + out
+		})
+	}
+
+	in := "[0:v]"
+	for i, stage := range stages {
+		out := "[v]"
+		if i < len(stages)-1 || joins {
+			out = fmt.Sprintf("[v%d]", i)
+		}
+		chains = append(chains, stage(in, out))
+		in = out
+	}
+	if !joins {
+		return strings.Join(chains, ";"), false
+	}
+
+	audio = info.HasAudio
+	var inputs string
+	label := func(name string) string {
+		if audio {
+			return "[" + name + "][" + name + "audio]"
+		}
+		return "[" + name + "]"
+	}
+	for _, name := range before {
+		inputs += label(name)
+	}
+	inputs += in
+	if audio {
+		inputs += audioIn
+	}
+	for _, name := range after {
+		inputs += label(name)
+	}
+	outputs, a := "[v]", 0
+	if audio {
+		outputs, a = "[v][a]", 1
+	}
+	chains = append(chains, sources...)
+	chains = append(chains, fmt.Sprintf("%sconcat=n=%d:v=1:a=%d%s", inputs, len(before)+len(after)+1, a, outputs))
+	return strings.Join(chains, ";"), audio
+}
+
+// audioFilter returns the filters to apply to the audio of a clip of the given duration, described by info,
+// or an empty string if there are none. info may be nil unless channels are muted or the loudness is normalised.
+func (o ClipOptions) audioFilter(info *mediaInfo, clip time.Duration) string {
+	var filters []string
+	if o.mutesChannels() {
+		filters = append(filters, o.Privacy.muteFilter(info))
+	}
+	if o.Loudness != 0 {
+		rate := info.SampleRate
+		if rate == 0 {
+			rate = compileSampleRate
+		}
+		filters = append(filters, loudnormFilter(o.Loudness, rate))
+	}
+	if o.fadesAudio() {
+		filters = append(filters, o.Fades.audioFilter(o.clipPortion(clip)))
+	}
+	return strings.Join(filters, ",")
+}
+
+// Corner is a corner of the video frame
+type Corner string
+
+// Corners of the video frame
+const (
+	CornerTopLeft     Corner = "top-left"
+	CornerTopRight    Corner = "top-right"
+	CornerBottomLeft  Corner = "bottom-left"
+	CornerBottomRight Corner = "bottom-right"
+)
+
+// Overlay is an image, such as a logo, drawn in a corner of the video
+type Overlay struct {
+	// Path is the local path of the image. PNG images with transparency are supported.
+	Path string
+	// Corner is the corner of the frame in which the image is placed, by default CornerBottomRight
+	Corner Corner
+	// Margin is the distance in pixels between the image and the edges of the frame
+	Margin int
+	// Scale is the width of the image as a fraction of the width of the frame.
+	// If zero, the image is drawn at its original size.
+	Scale float64
+	// Opacity is the opacity of the image, from 0 (exclusive) to 1 (fully opaque).
+	// If zero, the image is fully opaque.
+	Opacity float64
+}
+
+// Validate returns an error if o's placement is not valid
+func (o *Overlay) Validate() error {
+	switch o.Corner {
+	case "", CornerTopLeft, CornerTopRight, CornerBottomLeft, CornerBottomRight:
+	default:
+		return fmt.Errorf("invalid overlay corner %q: must be one of %s, %s, %s or %s", o.Corner, CornerTopLeft, CornerTopRight, CornerBottomLeft, CornerBottomRight)
+	}
+	if o.Margin < 0 {
+		return fmt.Errorf("invalid overlay margin %d: must not be negative", o.Margin)
+	}
+	if o.Scale < 0 || o.Scale > 1 {
+		return fmt.Errorf("invalid overlay scale %g: must be between 0 and 1", o.Scale)
+	}
+	if o.Opacity < 0 || o.Opacity > 1 {
+		return fmt.Errorf("invalid overlay opacity %g: must be between 0 and 1", o.Opacity)
+	}
+	return nil
+}
+
+// filter returns a filter graph that draws the image from input 1 over the video in, labelling the result out
+func (o *Overlay) filter(in, out string) string {
+	opacity := o.Opacity
+	if opacity == 0 {
+		opacity = 1
+	}
+	graph := fmt.Sprintf("[1:v]format=rgba,colorchannelmixer=aa=%g[img];", opacity)
+	base := in
+	if o.Scale > 0 {
+		graph += fmt.Sprintf("[img]%sscale2ref=w=main_w*%g:h=ow/a[img][base];", in, o.Scale)
+		base = "[base]"
+	}
+
+	m := o.Margin
+	var x, y string
+	switch o.Corner {
+	case CornerTopLeft:
+		x, y = fmt.Sprint(m), fmt.Sprint(m)
+	case CornerTopRight:
+		x, y = fmt.Sprintf("W-w-%d", m), fmt.Sprint(m)
+	case CornerBottomLeft:
+		x, y = fmt.Sprint(m), fmt.Sprintf("H-h-%d", m)
+	default:
+		x, y = fmt.Sprintf("W-w-%d", m), fmt.Sprintf("H-h-%d", m)
+	}
+	return graph + fmt.Sprintf("%s[img]overlay=x=%s:y=%s%s", base, x, y, out)
+}
+
+// clipArgs returns the ffmpeg arguments, other than those describing the output, that extract the clip
+// between start and end from input using opts. If format is not empty, it names the input's demuxer.
+// info describes the input, and may be nil unless opts.RequiresSeeking is true.
+// A re-encoded clip is encoded with codecs suitable for container c, at the given video bitrate
+// in bits per second or, if it is zero, at a constant quality.
+func (f *ffmpegExtractor) clipArgs(input, format string, start, end time.Duration, opts ClipOptions, info *mediaInfo, c Container, bitrate int64) []string {
+	var args []string
+	reencode := opts.reencodes(info)
+	if !reencode {
+		// Streams can only be copied from a keyframe, so seek to the one before start
+		args = append(args, "-noaccurate_seek")
+	}
+	if format != "" {
+		args = append(args, "-f", format)
+	}
+	args = append(args, "-ss", FormatHHMMSS(start))
+	if !reencode {
+		args = append(args, "-i", input)
+		if opts.Chapters != nil {
+			args = append(args, "-i", opts.Chapters.file, "-map_chapters", "1")
+		}
+		args = append(args, "-t", FormatHHMMSS(end-start), "-avoid_negative_ts", "make_zero", "-c", "copy")
+		if af := opts.audioFilter(info, end-start); af != "" {
+			args = append(args, "-af", af)
+			args = append(args, c.audioEncoderArgs(opts.Preset)...)
+		}
+		if opts.Privacy != nil {
+			args = append(args, opts.Privacy.args(opts.Chapters != nil)...)
+		}
+		if opts.Metadata != nil {
+			args = append(args, opts.Metadata.args()...)
+		}
+		return args
+	}
+
+	// The duration limits the input rather than the output, which may be longer than the clip
+	args = append(args, "-t", FormatHHMMSS(end-start), "-i", input)
+	inputs := 1
+	if opts.Overlay != nil {
+		args = append(args, "-i", opts.Overlay.Path)
+		inputs++
+	}
+	if opts.Bumpers != nil {
+		for _, p := range opts.Bumpers.paths() {
+			args = append(args, "-i", p)
+			inputs++
+		}
+	}
+	if opts.Chapters != nil {
+		args = append(args, "-i", opts.Chapters.file, "-map_chapters", strconv.Itoa(inputs))
+	}
+
+	graph, audio := opts.filterGraph(f.fontDir, info, end-start)
+	args = append(args, "-filter_complex", graph, "-map", "[v]")
+	args = append(args, c.videoEncoderArgs(opts.Preset, bitrate)...)
+	af := ""
+	if audio {
+		args = append(args, "-map", "[a]")
+	} else {
+		args = append(args, "-map", "0:a?")
+		if af = opts.audioFilter(info, end-start); af != "" {
+			args = append(args, "-af", af)
+		}
+	}
+	if audio || af != "" || opts.MaxSize > 0 || (opts.Preset != nil && opts.Preset.AudioBitrate != "") {
+		args = append(args, c.audioEncoderArgs(opts.Preset)...)
+	} else {
+		args = append(args, "-c:a", "copy")
+	}
+	if opts.Preset != nil && opts.Preset.MaxDuration > 0 {
+		// The output, including any title card, may not exceed the preset's limit
+		args = append(args, "-t", FormatHHMMSS(opts.Preset.MaxDuration))
+	}
+	if opts.Privacy != nil {
+		args = append(args, opts.Privacy.args(opts.Chapters != nil)...)
+	}
+	if opts.Metadata != nil {
+		args = append(args, opts.Metadata.args()...)
+	}
+	return args
+}
@@ -0,0 +1,169 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestClipArgs_Copy(t *testing.T) {
+	expected := []string{"-noaccurate_seek", "-f", "matroska", "-ss", "00:01:00", "-i", "pipe:0", "-t", "00:00:30", "-avoid_negative_ts", "make_zero", "-c", "copy"}
+	actual := (&ffmpegExtractor{}).clipArgs("pipe:0", "matroska", time.Minute, 90*time.Second, ClipOptions{}, nil, ContainerMatroska, 0)
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Error("Arguments different than expected (-want +got):", diff)
+	}
+}
+
+func TestClipArgs_Overlay(t *testing.T) {
+	opts := ClipOptions{Overlay: &Overlay{Path: "logo.png", Corner: CornerTopLeft, Margin: 10, Scale: 0.2, Opacity: 0.5}}
+	expected := []string{
+		"-ss", "00:01:00", "-t", "00:00:30", "-i", "in.mp4", "-i", "logo.png",
+		"-filter_complex", "[1:v]format=rgba,colorchannelmixer=aa=0.5[img];[img][0:v]scale2ref=w=main_w*0.2:h=ow/a[img][base];[base][img]overlay=x=10:y=10[v]",
+		"-map", "[v]",
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "20", "-pix_fmt", "yuv420p",
+		"-map", "0:a?", "-c:a", "copy",
+	}
+	actual := (&ffmpegExtractor{}).clipArgs("in.mp4", "", time.Minute, 90*time.Second, opts, nil, ContainerMP4, 0)
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Error("Arguments different than expected (-want +got):", diff)
+	}
+}
+
+func TestOverlay_Filter(t *testing.T) {
+	cases := []struct {
+		name     string
+		overlay  Overlay
+		expected string
+	}{
+		{
+			name:     "Defaults",
+			overlay:  Overlay{},
+			expected: "[1:v]format=rgba,colorchannelmixer=aa=1[img];[0:v][img]overlay=x=W-w-0:y=H-h-0[v]",
+		},
+		{
+			name:     "Top right",
+			overlay:  Overlay{Corner: CornerTopRight, Margin: 20},
+			expected: "[1:v]format=rgba,colorchannelmixer=aa=1[img];[0:v][img]overlay=x=W-w-20:y=20[v]",
+		},
+		{
+			name:     "Bottom left",
+			overlay:  Overlay{Corner: CornerBottomLeft, Margin: 5, Opacity: 0.75},
+			expected: "[1:v]format=rgba,colorchannelmixer=aa=0.75[img];[0:v][img]overlay=x=5:y=H-h-5[v]",
+		},
+	}
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			if diff := cmp.Diff(test.expected, test.overlay.filter("[0:v]", "[v]")); diff != "" {
+				t.Error("Filter different than expected (-want +got):", diff)
+			}
+		})
+	}
+}
+
+func TestOverlay_Validate(t *testing.T) {
+	invalid := []Overlay{
+		{Corner: "middle"},
+		{Margin: -1},
+		{Scale: 1.5},
+		{Opacity: -0.1},
+	}
+	for _, o := range invalid {
+		if err := o.Validate(); err == nil {
+			t.Errorf("Expected error validating %+v", o)
+		}
+	}
+
+	if err := (&Overlay{Corner: CornerBottomRight, Margin: 10, Scale: 0.15, Opacity: 0.8}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClipOptions_FilterGraph(t *testing.T) {
+	info := &mediaInfo{HasVideo: true, Width: 1280, Height: 720, FrameRate: "25/1", SAR: "1:1", HasAudio: true, SampleRate: 48000, ChannelLayout: "stereo"}
+	opts := ClipOptions{
+		Overlay:   &Overlay{},
+		Text:      &TextOverlay{Title: "Beethoven 7"},
+		TitleCard: &TitleCard{Title: "NOCCO", Duration: 2 * time.Second},
+	}
+
+	expected := "[1:v]format=rgba,colorchannelmixer=aa=1[img];[0:v][img]overlay=x=W-w-0:y=H-h-0[v0];" +
+		"[v0]drawtext=fontfile=/fonts/DejaVuSans.ttf:expansion=none:text=Beethoven 7:fontsize=h/16:x=w*0.05:y=h*0.72" +
+		":fontcolor=white:box=1:boxcolor=black@0.5:boxborderw=12:enable='between(t,0,5)'[v1];" +
+		"color=c=black:s=1280x720:r=25/1:d=2,setsar=1/1,format=yuv420p," +
+		"drawtext=fontfile=/fonts/DejaVuSans.ttf:expansion=none:text=NOCCO:fontsize=h/12:x=(w-text_w)/2:y=h/2-text_h:fontcolor=white[card];" +
+		"anullsrc=r=48000:cl=stereo,atrim=duration=2[cardaudio];" +
+		"[card][cardaudio][v1][0:a]concat=n=2:v=1:a=1[v][a]"
+
+	graph, audio := opts.filterGraph("/fonts", info, time.Minute)
+	if diff := cmp.Diff(expected, graph); diff != "" {
+		t.Error("Filter graph different than expected (-want +got):", diff)
+	}
+	if !audio {
+		t.Error("Expected filter graph to filter audio")
+	}
+}
+
+func TestClipArgs_Loudness(t *testing.T) {
+	info := &mediaInfo{HasVideo: true, HasAudio: true, SampleRate: 44100, Channels: 2, ChannelLayout: "stereo"}
+	opts := ClipOptions{Loudness: -16}
+	expected := []string{
+		"-noaccurate_seek", "-ss", "00:01:00", "-i", "in.mp4", "-t", "00:00:30", "-avoid_negative_ts", "make_zero", "-c", "copy",
+		"-af", "loudnorm=I=-16:TP=-1.5:LRA=11,aresample=44100", "-c:a", "aac", "-b:a", "192000",
+	}
+	actual := (&ffmpegExtractor{}).clipArgs("in.mp4", "", time.Minute, 90*time.Second, opts, info, ContainerMP4, 0)
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Error("Arguments different than expected (-want +got):", diff)
+	}
+	if err := (ClipOptions{Loudness: -3}).Validate(30 * time.Second); err == nil {
+		t.Error("Expected error validating a loudness target above the limit")
+	}
+}
+
+func TestClipOptions_CheckSourceAudioOnly(t *testing.T) {
+	info := &mediaInfo{HasAudio: true, SampleRate: 48000, Channels: 2, ChannelLayout: "stereo"}
+
+	opts := ClipOptions{Loudness: -16}
+	if !opts.RequiresSeeking() {
+		t.Fatal("Expected loudness normalisation to require the source to be probed")
+	}
+	if err := opts.checkSource("excerpt.wav", info); err != nil {
+		t.Error(err)
+	}
+	expected := []string{
+		"-noaccurate_seek", "-ss", "00:01:00", "-i", "excerpt.wav", "-t", "00:00:30", "-avoid_negative_ts", "make_zero", "-c", "copy",
+		"-af", "loudnorm=I=-16:TP=-1.5:LRA=11,aresample=48000", "-c:a", "aac", "-b:a", "192000",
+	}
+	actual := (&ffmpegExtractor{}).clipArgs("excerpt.wav", "", time.Minute, 90*time.Second, opts, info, ContainerMP4, 0)
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Error("Arguments different than expected (-want +got):", diff)
+	}
+
+	needVideo := []ClipOptions{
+		{TitleCard: &TitleCard{Title: "NOCCO"}},
+		{Bumpers: &Bumpers{}},
+		{MaxSize: 1 << 20},
+		{Overlay: &Overlay{}},
+		{Text: &TextOverlay{Title: "Spring Concert"}},
+		{Chapters: &Chapters{Scenes: &SceneOptions{}}},
+	}
+	for _, o := range needVideo {
+		if err := o.checkSource("excerpt.wav", info); err == nil {
+			t.Errorf("Expected error applying %+v to an audio-only source", o)
+		}
+	}
+}
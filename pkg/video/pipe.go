@@ -0,0 +1,115 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/ssmall/nocco-video-extractor/pkg/logging"
+	"github.com/ssmall/nocco-video-extractor/pkg/tracing"
+	"go.opentelemetry.io/otel/api/trace"
+)
+
+// pipedOutput streams the standard output of a running ffmpeg process.
+// Once the output is exhausted, Read returns ffmpeg's error instead of io.EOF if the process failed,
+// so that a consumer does not mistake truncated output for a complete clip.
+type pipedOutput struct {
+	ctx     context.Context
+	cmd     *exec.Cmd
+	stdout  io.ReadCloser
+	stderr  *bytes.Buffer
+	mode    string
+	span    trace.Span
+	started time.Time
+	written int64
+	eof     bool
+
+	once    sync.Once
+	waitErr error
+}
+
+// startPiped starts cmd, which must write its output to standard output
+func startPiped(ctx context.Context, cmd *exec.Cmd, mode string, span trace.Span) (*pipedOutput, error) {
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+
+	started := time.Now()
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &pipedOutput{
+		ctx:     ctx,
+		cmd:     cmd,
+		stdout:  stdout,
+		stderr:  stderr,
+		mode:    mode,
+		span:    span,
+		started: started,
+	}, nil
+}
+
+func (p *pipedOutput) Read(b []byte) (int, error) {
+	n, err := p.stdout.Read(b)
+	p.written += int64(n)
+	if err == io.EOF {
+		p.eof = true
+		if werr := p.wait(); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// Close stops ffmpeg if it is still running and releases its resources
+func (p *pipedOutput) Close() error {
+	if !p.eof {
+		// The consumer gave up before the end of the output, so there is no point in letting ffmpeg finish
+		p.cmd.Process.Kill()
+		p.wait()
+		return nil
+	}
+	return p.wait()
+}
+
+// wait waits for ffmpeg to exit, recording the outcome exactly once
+func (p *pipedOutput) wait() error {
+	p.once.Do(func() {
+		err := p.cmd.Wait()
+		logger := logging.FromContext(p.ctx).With("ffmpegStderr", p.stderr.String())
+		if err != nil {
+			p.waitErr = fmt.Errorf("ffmpeg failed: %w", err)
+			ffmpegDuration.WithLabelValues(p.mode, "error").Observe(time.Since(p.started).Seconds())
+			logger.WithError(err).Errorf("ffmpeg failed")
+		} else {
+			ffmpegDuration.WithLabelValues(p.mode, "success").Observe(time.Since(p.started).Seconds())
+			logger.Debugf("ffmpeg output")
+			logging.FromContext(p.ctx).Infof("ffmpeg finished streaming %d bytes", p.written)
+		}
+		ffmpegOutputBytes.WithLabelValues(p.mode).Add(float64(p.written))
+		tracing.End(p.ctx, p.span, p.waitErr)
+	})
+	return p.waitErr
+}
@@ -0,0 +1,181 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"time"
+)
+
+// Fit is how a video is made to fit the dimensions of a Preset with a different aspect ratio
+type Fit string
+
+// Fit strategies
+const (
+	// FitPad scales the video to fit within the preset's dimensions and pads it with black bars
+	FitPad Fit = "pad"
+	// FitCrop scales the video to fill the preset's dimensions and crops whatever overflows
+	FitCrop Fit = "crop"
+)
+
+// Video codecs that may be used by a Preset
+const (
+	CodecH264 = "h264"
+	CodecH265 = "h265"
+)
+
+// Preset is a named set of output settings, such as those suited to a social media site
+type Preset struct {
+	// Width and Height are the dimensions of the output in pixels. If both are set, Fit determines how
+	// the video is made to fit them. If only one is set, the other is derived from the video's aspect ratio.
+	// If neither is set, the video keeps its original dimensions.
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	// Fit is how the video is fitted to Width and Height, by default FitPad
+	Fit Fit `json:"fit,omitempty"`
+	// VideoCodec is CodecH264 (the default) or CodecH265. WebM output always uses VP9.
+	VideoCodec string `json:"videoCodec,omitempty"`
+	// VideoBitrate caps the video bitrate, e.g. "2500k". If empty, only quality determines the bitrate.
+	VideoBitrate string `json:"videoBitrate,omitempty"`
+	// AudioBitrate is the audio bitrate, e.g. "128k". If empty, audio is copied unless it must be re-encoded.
+	AudioBitrate string `json:"audioBitrate,omitempty"`
+	// MaxDuration is the longest clip the preset allows, or zero for no limit
+	MaxDuration time.Duration `json:"-"`
+}
+
+// BuiltinPresets returns the presets that are available without a presets file
+func BuiltinPresets() map[string]Preset {
+	return map[string]Preset{
+		"web-720p": {
+			Width:        1280,
+			Height:       720,
+			Fit:          FitPad,
+			VideoBitrate: "3000k",
+			AudioBitrate: "160k",
+		},
+		"instagram-square": {
+			Width:        1080,
+			Height:       1080,
+			Fit:          FitCrop,
+			VideoBitrate: "3500k",
+			AudioBitrate: "128k",
+			MaxDuration:  60 * time.Second,
+		},
+		"instagram-reel-9x16": {
+			Width:        1080,
+			Height:       1920,
+			Fit:          FitCrop,
+			VideoBitrate: "5000k",
+			AudioBitrate: "128k",
+			MaxDuration:  90 * time.Second,
+		},
+		"email-small": {
+			Height:       480,
+			VideoBitrate: "1000k",
+			AudioBitrate: "96k",
+			MaxDuration:  2 * time.Minute,
+		},
+	}
+}
+
+// LoadPresets reads presets from a JSON object mapping preset names to presets, with maximum durations
+// given as "maxDurationSeconds". The presets are added to the builtin presets, replacing any with the same name.
+func LoadPresets(r io.Reader) (map[string]Preset, error) {
+	var file map[string]struct {
+		Preset
+		MaxDurationSeconds float64 `json:"maxDurationSeconds,omitempty"`
+	}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("error parsing presets: %w", err)
+	}
+
+	presets := BuiltinPresets()
+	for name, p := range file {
+		preset := p.Preset
+		preset.MaxDuration = time.Duration(p.MaxDurationSeconds * float64(time.Second))
+		if err := preset.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid preset %q: %w", name, err)
+		}
+		presets[name] = preset
+	}
+	return presets, nil
+}
+
+var bitrateRegexp = regexp.MustCompile(`^\d+(\.\d+)?[kM]?$`)
+
+// Validate returns an error if p is not a valid preset
+func (p *Preset) Validate() error {
+	if p.Width < 0 || p.Height < 0 || p.Width%2 != 0 || p.Height%2 != 0 {
+		return fmt.Errorf("dimensions %dx%d must be even and not negative", p.Width, p.Height)
+	}
+	switch p.Fit {
+	case "", FitPad, FitCrop:
+	default:
+		return fmt.Errorf("invalid fit %q: must be %s or %s", p.Fit, FitPad, FitCrop)
+	}
+	switch p.VideoCodec {
+	case "", CodecH264, CodecH265:
+	default:
+		return fmt.Errorf("invalid video codec %q: must be %s or %s", p.VideoCodec, CodecH264, CodecH265)
+	}
+	for _, b := range []string{p.VideoBitrate, p.AudioBitrate} {
+		if b != "" && !bitrateRegexp.MatchString(b) {
+			return fmt.Errorf("invalid bitrate %q", b)
+		}
+	}
+	if p.MaxDuration < 0 {
+		return fmt.Errorf("maximum duration must not be negative")
+	}
+	return nil
+}
+
+// filter returns a filter chain that scales the video in to the preset's dimensions, labelling the result out
+func (p *Preset) filter(in, out string) string {
+	switch {
+	case p.Width > 0 && p.Height > 0 && p.Fit == FitCrop:
+		return fmt.Sprintf("%sscale=%[2]d:%[3]d:force_original_aspect_ratio=increase,crop=%[2]d:%[3]d,setsar=1%s", in, p.Width, p.Height, out)
+	case p.Width > 0 && p.Height > 0:
+		return fmt.Sprintf("%sscale=%[2]d:%[3]d:force_original_aspect_ratio=decrease,pad=%[2]d:%[3]d:(ow-iw)/2:(oh-ih)/2,setsar=1%s", in, p.Width, p.Height, out)
+	case p.Width > 0:
+		return fmt.Sprintf("%sscale=%d:-2%s", in, p.Width, out)
+	}
+	return fmt.Sprintf("%sscale=-2:%d%s", in, p.Height, out)
+}
+
+// scales reports whether the preset changes the video's dimensions
+func (p *Preset) scales() bool {
+	return p.Width > 0 || p.Height > 0
+}
+
+// outputInfo returns a description of video described by info after it is scaled by the preset
+func (p *Preset) outputInfo(info *mediaInfo) *mediaInfo {
+	out := *info
+	switch {
+	case p.Width > 0 && p.Height > 0:
+		out.Width, out.Height, out.SAR = p.Width, p.Height, "1:1"
+	case p.Width > 0 && info.Width > 0:
+		// As calculated by ffmpeg's scale filter for a height of -2
+		out.Width, out.Height = p.Width, 2*int(math.Round(float64(p.Width)*float64(info.Height)/float64(2*info.Width)))
+	case p.Height > 0 && info.Height > 0:
+		out.Width, out.Height = 2*int(math.Round(float64(p.Height)*float64(info.Width)/float64(2*info.Height))), p.Height
+	}
+	return &out
+}
@@ -0,0 +1,117 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoadPresets(t *testing.T) {
+	presets, err := LoadPresets(strings.NewReader(`{
+		"web-720p": {"width": 1280, "height": 720, "fit": "crop"},
+		"youtube-1080p": {"height": 1080, "videoCodec": "h265", "videoBitrate": "8M", "maxDurationSeconds": 600}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Preset{Height: 1080, VideoCodec: CodecH265, VideoBitrate: "8M", MaxDuration: 10 * time.Minute}
+	if diff := cmp.Diff(expected, presets["youtube-1080p"]); diff != "" {
+		t.Error("Preset different than expected (-want +got):", diff)
+	}
+	if diff := cmp.Diff(Preset{Width: 1280, Height: 720, Fit: FitCrop}, presets["web-720p"]); diff != "" {
+		t.Error("Expected builtin preset to be replaced (-want +got):", diff)
+	}
+	if _, ok := presets["instagram-square"]; !ok {
+		t.Error("Expected builtin presets to be kept")
+	}
+}
+
+func TestLoadPresets_Invalid(t *testing.T) {
+	files := []string{
+		`{"odd": {"width": 1279}}`,
+		`{"fit": {"width": 1280, "height": 720, "fit": "stretch"}}`,
+		`{"codec": {"videoCodec": "mpeg2"}}`,
+		`{"bitrate": {"videoBitrate": "fast"}}`,
+		`{"unknown": {"resolution": "720p"}}`,
+	}
+	for _, f := range files {
+		if _, err := LoadPresets(strings.NewReader(f)); err == nil {
+			t.Errorf("Expected error loading %s", f)
+		}
+	}
+}
+
+func TestBuiltinPresets_Valid(t *testing.T) {
+	for name, p := range BuiltinPresets() {
+		if err := p.Validate(); err != nil {
+			t.Errorf("Builtin preset %q is invalid: %v", name, err)
+		}
+	}
+}
+
+func TestPreset_Filter(t *testing.T) {
+	cases := []struct {
+		name     string
+		preset   Preset
+		expected string
+	}{
+		{"Pad", Preset{Width: 1280, Height: 720}, "[0:v]scale=1280:720:force_original_aspect_ratio=decrease,pad=1280:720:(ow-iw)/2:(oh-ih)/2,setsar=1[v]"},
+		{"Crop", Preset{Width: 1080, Height: 1920, Fit: FitCrop}, "[0:v]scale=1080:1920:force_original_aspect_ratio=increase,crop=1080:1920,setsar=1[v]"},
+		{"Width", Preset{Width: 640}, "[0:v]scale=640:-2[v]"},
+		{"Height", Preset{Height: 480}, "[0:v]scale=-2:480[v]"},
+	}
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			if diff := cmp.Diff(test.expected, test.preset.filter("[0:v]", "[v]")); diff != "" {
+				t.Error("Filter different than expected (-want +got):", diff)
+			}
+		})
+	}
+}
+
+func TestPreset_OutputInfo(t *testing.T) {
+	info := &mediaInfo{HasVideo: true, Width: 1920, Height: 1080, SAR: "1:1"}
+
+	out := (&Preset{Height: 480}).outputInfo(info)
+	if out.Width != 854 || out.Height != 480 {
+		t.Errorf("got %dx%d, want 854x480", out.Width, out.Height)
+	}
+
+	out = (&Preset{Width: 1080, Height: 1080, Fit: FitCrop}).outputInfo(info)
+	if out.Width != 1080 || out.Height != 1080 {
+		t.Errorf("got %dx%d, want 1080x1080", out.Width, out.Height)
+	}
+}
+
+func TestClipArgs_Preset(t *testing.T) {
+	preset := BuiltinPresets()["instagram-square"]
+	expected := []string{
+		"-ss", "00:00:10", "-t", "00:00:30", "-i", "in.mov",
+		"-filter_complex", "[0:v]scale=1080:1080:force_original_aspect_ratio=increase,crop=1080:1080,setsar=1[v]",
+		"-map", "[v]",
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "20", "-pix_fmt", "yuv420p", "-maxrate", "3500k", "-bufsize", "3500k",
+		"-map", "0:a?", "-c:a", "aac", "-b:a", "128k",
+		"-t", "00:01:00",
+	}
+	actual := (&ffmpegExtractor{}).clipArgs("in.mov", "", 10*time.Second, 40*time.Second, ClipOptions{Preset: &preset}, nil, ContainerMP4, 0)
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Error("Arguments different than expected (-want +got):", diff)
+	}
+}
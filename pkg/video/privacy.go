@@ -0,0 +1,88 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Privacy removes identifying information from a clip. All container and stream metadata of the source,
+// such as GPS coordinates, device details and creation times, are left out, as are its chapters.
+type Privacy struct {
+	// MuteChannels are the audio channels of the source, numbered from 0, that are silenced,
+	// e.g. one carrying the voice of someone off camera
+	MuteChannels []int
+}
+
+// Validate returns an error if the options are invalid
+func (p *Privacy) Validate() error {
+	seen := make(map[int]bool)
+	for _, c := range p.MuteChannels {
+		if c < 0 {
+			return errors.New("muted channels must not be negative")
+		}
+		if seen[c] {
+			return fmt.Errorf("channel %d is muted more than once", c)
+		}
+		seen[c] = true
+	}
+	return nil
+}
+
+// checkChannels returns an error if the channels to mute are not in the audio described by info
+func (p *Privacy) checkChannels(info *mediaInfo) error {
+	if len(p.MuteChannels) == 0 {
+		return nil
+	}
+	if !info.HasAudio {
+		return errors.New("the source has no audio channels to mute")
+	}
+	for _, c := range p.MuteChannels {
+		if c >= info.Channels {
+			return fmt.Errorf("cannot mute channel %d of audio with %d channels", c, info.Channels)
+		}
+	}
+	return nil
+}
+
+// muteFilter returns a filter that silences the muted channels of the audio described by info
+func (p *Privacy) muteFilter(info *mediaInfo) string {
+	muted := make(map[int]bool)
+	for _, c := range p.MuteChannels {
+		muted[c] = true
+	}
+	channels := make([]string, info.Channels)
+	for i := range channels {
+		if muted[i] {
+			channels[i] = fmt.Sprintf("c%d=0*c%d", i, i)
+		} else {
+			channels[i] = fmt.Sprintf("c%d=c%d", i, i)
+		}
+	}
+	// The layout is given by its number of channels, as ffprobe does not report the layout of every source
+	return fmt.Sprintf("pan=%dc|%s", info.Channels, strings.Join(channels, "|"))
+}
+
+// args returns the ffmpeg output arguments that leave out the metadata of the inputs, and the encoder tag
+// that ffmpeg would otherwise add. The chapters are kept if they were written by the Extractor.
+func (p *Privacy) args(chapters bool) []string {
+	args := []string{"-map_metadata", "-1", "-map_metadata:s", "-1", "-fflags", "+bitexact"}
+	if !chapters {
+		args = append(args, "-map_chapters", "-1")
+	}
+	return args
+}
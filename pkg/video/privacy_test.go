@@ -0,0 +1,104 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPrivacy_Validate(t *testing.T) {
+	invalid := []Privacy{
+		{MuteChannels: []int{-1}},
+		{MuteChannels: []int{0, 1, 0}},
+	}
+	for _, p := range invalid {
+		if err := p.Validate(); err == nil {
+			t.Errorf("Expected error validating %+v", p)
+		}
+	}
+
+	if err := (&Privacy{MuteChannels: []int{1, 0}}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPrivacy_CheckChannels(t *testing.T) {
+	p := Privacy{MuteChannels: []int{1}}
+	if err := p.checkChannels(&mediaInfo{HasAudio: true, Channels: 2}); err != nil {
+		t.Error(err)
+	}
+	if err := p.checkChannels(&mediaInfo{HasAudio: true, Channels: 1}); err == nil {
+		t.Error("Expected error muting channel 1 of mono audio")
+	}
+	if err := p.checkChannels(&mediaInfo{HasAudio: false}); err == nil {
+		t.Error("Expected error muting a source without audio")
+	}
+	if err := (&Privacy{}).checkChannels(&mediaInfo{HasAudio: false}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClipArgs_Privacy(t *testing.T) {
+	opts := ClipOptions{Privacy: &Privacy{}}
+	expected := []string{
+		"-noaccurate_seek", "-ss", "00:01:00", "-i", "in.mp4", "-t", "00:00:30", "-avoid_negative_ts", "make_zero", "-c", "copy",
+		"-map_metadata", "-1", "-map_metadata:s", "-1", "-fflags", "+bitexact", "-map_chapters", "-1",
+	}
+	actual := (&ffmpegExtractor{}).clipArgs("in.mp4", "", time.Minute, 90*time.Second, opts, nil, ContainerMP4, 0)
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Error("Arguments different than expected (-want +got):", diff)
+	}
+}
+
+func TestClipArgs_PrivacyMutesChannels(t *testing.T) {
+	info := &mediaInfo{HasVideo: true, HasAudio: true, Channels: 3, ChannelLayout: "3.0"}
+	opts := ClipOptions{
+		Privacy: &Privacy{MuteChannels: []int{2}},
+		Fades:   &Fades{AudioIn: time.Second},
+	}
+	expected := []string{
+		"-noaccurate_seek", "-ss", "00:01:00", "-i", "in.mp4", "-t", "00:00:30", "-avoid_negative_ts", "make_zero", "-c", "copy",
+		"-af", "pan=3c|c0=c0|c1=c1|c2=0*c2,afade=t=in:st=0:d=1", "-c:a", "aac", "-b:a", "192000",
+		"-map_metadata", "-1", "-map_metadata:s", "-1", "-fflags", "+bitexact", "-map_chapters", "-1",
+	}
+	actual := (&ffmpegExtractor{}).clipArgs("in.mp4", "", time.Minute, 90*time.Second, opts, info, ContainerMP4, 0)
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Error("Arguments different than expected (-want +got):", diff)
+	}
+	if !opts.RequiresSeeking() {
+		t.Error("Expected muting channels to require seeking")
+	}
+}
+
+func TestPrivacy_MutesAudioOnlySource(t *testing.T) {
+	info := &mediaInfo{HasAudio: true, SampleRate: 48000, Channels: 2, ChannelLayout: "stereo"}
+	opts := ClipOptions{Privacy: &Privacy{MuteChannels: []int{1}}}
+	if err := opts.checkSource("audition.wav", info); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"-noaccurate_seek", "-ss", "00:01:00", "-i", "audition.wav", "-t", "00:00:30", "-avoid_negative_ts", "make_zero", "-c", "copy",
+		"-af", "pan=2c|c0=c0|c1=0*c1", "-c:a", "aac", "-b:a", "192000",
+		"-map_metadata", "-1", "-map_metadata:s", "-1", "-fflags", "+bitexact", "-map_chapters", "-1",
+	}
+	actual := (&ffmpegExtractor{}).clipArgs("audition.wav", "", time.Minute, 90*time.Second, opts, info, ContainerMP4, 0)
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Error("Arguments different than expected (-want +got):", diff)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// mediaInfo describes the first video and audio streams of a file and its duration
+type mediaInfo struct {
+	HasVideo bool
+	Width    int
+	Height   int
+	// FrameRate is the video's frame rate as a rational, e.g. "30000/1001"
+	FrameRate string
+	// SAR is the video's sample aspect ratio, e.g. "1:1"
+	SAR string
+	// Duration is the duration of the file
+	Duration time.Duration
+
+	HasAudio      bool
+	SampleRate    int
+	ChannelLayout string
+	Channels      int
+}
+
+// probe describes the streams of filename using ffprobe
+func probe(ctx context.Context, filename string) (*mediaInfo, error) {
+	out, err := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-show_entries",
+		"stream=codec_type,width,height,r_frame_rate,sample_aspect_ratio,sample_rate,channels,channel_layout:stream_disposition=attached_pic:format=duration",
+		"-of", "json", filename).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("ffprobe failed: %w: %s", err, exitErr.Stderr)
+		}
+		return nil, err
+	}
+	return parseProbe(out)
+}
+
+// parseProbe parses the JSON output of ffprobe
+func parseProbe(out []byte) (*mediaInfo, error) {
+	var result struct {
+		Streams []struct {
+			CodecType     string `json:"codec_type"`
+			Width         int    `json:"width"`
+			Height        int    `json:"height"`
+			FrameRate     string `json:"r_frame_rate"`
+			SAR           string `json:"sample_aspect_ratio"`
+			SampleRate    string `json:"sample_rate"`
+			ChannelLayout string `json:"channel_layout"`
+			Channels      int    `json:"channels"`
+			Disposition   struct {
+				AttachedPic int `json:"attached_pic"`
+			} `json:"disposition"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return nil, fmt.Errorf("error parsing ffprobe output: %w", err)
+	}
+
+	info := &mediaInfo{}
+	if d, err := strconv.ParseFloat(result.Format.Duration, 64); err == nil {
+		info.Duration = time.Duration(d * float64(time.Second))
+	}
+	for _, s := range result.Streams {
+		switch {
+		case s.CodecType == "video" && s.Disposition.AttachedPic != 0:
+			// Cover art, as in MP3 files, is not video
+		case s.CodecType == "video" && !info.HasVideo:
+			info.HasVideo = true
+			info.Width = s.Width
+			info.Height = s.Height
+			info.FrameRate = s.FrameRate
+			info.SAR = s.SAR
+			if info.SAR == "" || info.SAR == "0:1" || info.SAR == "N/A" {
+				info.SAR = "1:1"
+			}
+		case s.CodecType == "audio" && !info.HasAudio:
+			info.HasAudio = true
+			info.SampleRate, _ = strconv.Atoi(s.SampleRate)
+			info.ChannelLayout = s.ChannelLayout
+			info.Channels = s.Channels
+			if info.ChannelLayout == "" {
+				info.ChannelLayout = "stereo"
+			}
+			if info.Channels == 0 {
+				info.Channels = 2
+			}
+		}
+	}
+	return info, nil
+}
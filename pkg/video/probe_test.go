@@ -0,0 +1,90 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const ffprobeOutput = `{
+    "programs": [
+
+    ],
+    "streams": [
+        {
+            "codec_type": "video",
+            "width": 1920,
+            "height": 1080,
+            "sample_aspect_ratio": "0:1",
+            "r_frame_rate": "30000/1001"
+        },
+        {
+            "codec_type": "audio",
+            "sample_rate": "48000",
+            "channels": 2,
+            "channel_layout": "stereo",
+            "r_frame_rate": "0/0"
+        },
+        {
+            "codec_type": "audio",
+            "sample_rate": "44100",
+            "channel_layout": "mono",
+            "r_frame_rate": "0/0"
+        }
+    ],
+    "format": {
+        "duration": "63.250000"
+    }
+}`
+
+func TestParseProbe(t *testing.T) {
+	expected := &mediaInfo{
+		HasVideo:      true,
+		Width:         1920,
+		Height:        1080,
+		FrameRate:     "30000/1001",
+		SAR:           "1:1",
+		Duration:      63250 * time.Millisecond,
+		HasAudio:      true,
+		SampleRate:    48000,
+		ChannelLayout: "stereo",
+		Channels:      2,
+	}
+
+	actual, err := parseProbe([]byte(ffprobeOutput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Error("mediaInfo different than expected (-want +got):", diff)
+	}
+}
+
+func TestParseProbe_CoverArt(t *testing.T) {
+	out := `{"streams": [
+		{"codec_type": "audio", "sample_rate": "44100", "channels": 2, "channel_layout": "stereo", "disposition": {"attached_pic": 0}},
+		{"codec_type": "video", "width": 600, "height": 600, "disposition": {"attached_pic": 1}}
+	], "format": {"duration": "180.000000"}}`
+	info, err := parseProbe([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.HasVideo {
+		t.Error("Expected cover art not to count as video")
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/ssmall/nocco-video-extractor/pkg/tracing"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
+)
+
+const (
+	// DefaultSceneThreshold is the scene score above which a frame starts a new scene by default
+	DefaultSceneThreshold = 0.4
+	// DefaultThumbnailWidth is the width of scene thumbnails by default
+	DefaultThumbnailWidth = 320
+)
+
+// SceneOptions customise how scenes are detected
+type SceneOptions struct {
+	// Threshold is the scene score, from 0 to 1, above which a frame starts a new scene,
+	// by default DefaultSceneThreshold. Lower thresholds detect subtler cuts.
+	Threshold float64
+	// ThumbnailDir, if set, is the directory in which a JPEG thumbnail of the first frame of each scene is written
+	ThumbnailDir string
+	// ThumbnailWidth is the width of thumbnails, by default DefaultThumbnailWidth
+	ThumbnailWidth int
+}
+
+func (o SceneOptions) threshold() float64 {
+	if o.Threshold == 0 {
+		return DefaultSceneThreshold
+	}
+	return o.Threshold
+}
+
+func (o SceneOptions) thumbnailWidth() int {
+	if o.ThumbnailWidth == 0 {
+		return DefaultThumbnailWidth
+	}
+	return o.ThumbnailWidth
+}
+
+// Validate returns an error if the options are invalid
+func (o SceneOptions) Validate() error {
+	if o.Threshold < 0 || o.Threshold >= 1 {
+		return errors.New("scene threshold must be between 0 and 1")
+	}
+	if o.ThumbnailWidth < 0 || o.ThumbnailWidth%2 != 0 {
+		return errors.New("thumbnail width must be a positive even number")
+	}
+	return nil
+}
+
+// Scene is a continuous shot of a video
+type Scene struct {
+	// Start is the time in the file at which the scene starts
+	Start time.Duration
+	// Score is how different the first frame of the scene is from the frame before, from 0 to 1
+	Score float64
+	// Thumbnail is the path of the scene's thumbnail, if requested
+	Thumbnail string
+}
+
+func (f *ffmpegExtractor) DetectScenes(ctx context.Context, filename string, start, end time.Duration, opts SceneOptions) (scenes []Scene, err error) {
+	ctx, span := tracer.Start(ctx, "ffmpeg.DetectScenes", trace.WithAttributes(
+		label.String("ffmpeg.input", filename),
+		label.String("ffmpeg.start", FormatHHMMSS(start)),
+		label.String("ffmpeg.end", FormatHHMMSS(end)),
+		label.String("ffmpeg.mode", modeAnalyse),
+	))
+	defer func() {
+		tracing.End(ctx, span, err)
+	}()
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if start < 0 || end != 0 && end <= start {
+		return nil, errors.New("scene detection must end after it starts")
+	}
+
+	stderr, err := f.runStderr(ctx, span, modeAnalyse, sceneArgs(filename, start, end, opts)...)
+	if err != nil {
+		return nil, err
+	}
+	scenes = parseScenes(stderr, start)
+	if opts.ThumbnailDir != "" {
+		for i := range scenes {
+			scenes[i].Thumbnail = filepath.Join(opts.ThumbnailDir, fmt.Sprintf(thumbnailPattern, i+1))
+		}
+	}
+	return scenes, nil
+}
+
+// thumbnailPattern names the thumbnails written by ffmpeg, numbered from 1
+const thumbnailPattern = "scene-%04d.jpg"
+
+// sceneArgs returns the ffmpeg arguments that log the first frame of each scene of filename between start and end,
+// or its end if end is zero, writing thumbnails of them if requested
+func sceneArgs(filename string, start, end time.Duration, opts SceneOptions) []string {
+	args := []string{"-nostats"}
+	if start > 0 {
+		args = append(args, "-ss", FormatHHMMSS(start))
+	}
+	if end > 0 {
+		args = append(args, "-t", FormatHHMMSS(end-start))
+	}
+	filter := fmt.Sprintf("select='eq(n,0)+gt(scene,%g)',metadata=print", opts.threshold())
+	args = append(args, "-i", filename, "-an")
+	if opts.ThumbnailDir == "" {
+		return append(args, "-vf", filter, "-f", "null", os.DevNull)
+	}
+	filter += fmt.Sprintf(",scale=%d:-2", opts.thumbnailWidth())
+	return append(args, "-vf", filter, "-vsync", "vfr", "-q:v", "3", filepath.Join(opts.ThumbnailDir, thumbnailPattern))
+}
+
+var (
+	sceneFrameLine = regexp.MustCompile(`pts_time:(-?[0-9.]+)`)
+	sceneScoreLine = regexp.MustCompile(`lavfi\.scene_score=([0-9.]+)`)
+)
+
+// parseScenes parses the frames logged by the metadata filter as the starts of scenes, in a clip beginning at start
+func parseScenes(stderr []byte, start time.Duration) []Scene {
+	var scenes []Scene
+	scanner := bufio.NewScanner(bytes.NewReader(stderr))
+	for scanner.Scan() {
+		if m := sceneFrameLine.FindStringSubmatch(scanner.Text()); m != nil {
+			t, err := strconv.ParseFloat(m[1], 64)
+			if err != nil {
+				continue
+			}
+			if t < 0 {
+				t = 0
+			}
+			scenes = append(scenes, Scene{Start: start + time.Duration(t*float64(time.Second))})
+		} else if m := sceneScoreLine.FindStringSubmatch(scanner.Text()); m != nil && len(scenes) > 0 {
+			scenes[len(scenes)-1].Score, _ = strconv.ParseFloat(m[1], 64)
+		}
+	}
+	return scenes
+}
@@ -0,0 +1,149 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseScenes(t *testing.T) {
+	stderr := `Input #0, mov,mp4,m4a,3gp,3g2,mj2, from 'concert.mp4':
+[Parsed_metadata_1 @ 0x5581] frame:0    pts:0       pts_time:0
+[Parsed_metadata_1 @ 0x5581] lavfi.scene_score=0.000000
+[Parsed_metadata_1 @ 0x5581] frame:1    pts:187500  pts_time:12.5
+[Parsed_metadata_1 @ 0x5581] lavfi.scene_score=0.612000
+[Parsed_metadata_1 @ 0x5581] frame:2    pts:550000  pts_time:36.666667
+[Parsed_metadata_1 @ 0x5581] lavfi.scene_score=0.450000
+frame=    3 fps=0.0 q=-0.0 Lsize=N/A time=00:01:00.00 bitrate=N/A speed= 200x
+`
+	expected := []Scene{
+		{Start: time.Minute},
+		{Start: time.Minute + 12500*time.Millisecond, Score: 0.612},
+		{Start: time.Minute + 36666667*time.Microsecond, Score: 0.45},
+	}
+	if diff := cmp.Diff(expected, parseScenes([]byte(stderr), time.Minute)); diff != "" {
+		t.Error("Scenes different than expected (-want +got):", diff)
+	}
+}
+
+func TestSceneArgs(t *testing.T) {
+	cases := []struct {
+		name     string
+		opts     SceneOptions
+		expected []string
+	}{
+		{
+			name: "Timestamps only",
+			opts: SceneOptions{},
+			expected: []string{
+				"-nostats", "-ss", "00:01:00", "-t", "00:01:00", "-i", "in.mp4", "-an",
+				"-vf", "select='eq(n,0)+gt(scene,0.4)',metadata=print", "-f", "null", "/dev/null",
+			},
+		},
+		{
+			name: "Thumbnails",
+			opts: SceneOptions{Threshold: 0.25, ThumbnailDir: "/tmp/scenes"},
+			expected: []string{
+				"-nostats", "-ss", "00:01:00", "-t", "00:01:00", "-i", "in.mp4", "-an",
+				"-vf", "select='eq(n,0)+gt(scene,0.25)',metadata=print,scale=320:-2", "-vsync", "vfr", "-q:v", "3", "/tmp/scenes/scene-%04d.jpg",
+			},
+		},
+	}
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			if diff := cmp.Diff(test.expected, sceneArgs("in.mp4", time.Minute, 2*time.Minute, test.opts)); diff != "" {
+				t.Error("Arguments different than expected (-want +got):", diff)
+			}
+		})
+	}
+}
+
+func TestSceneOptions_Validate(t *testing.T) {
+	invalid := []SceneOptions{
+		{Threshold: -0.1},
+		{Threshold: 1},
+		{ThumbnailWidth: 321},
+	}
+	for _, o := range invalid {
+		if err := o.Validate(); err == nil {
+			t.Errorf("Expected error validating %+v", o)
+		}
+	}
+
+	if err := (SceneOptions{Threshold: 0.3, ThumbnailWidth: 480}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFFMetadata(t *testing.T) {
+	chapters := []Chapter{
+		{Start: 40 * time.Second, Title: "II. Allegretto"},
+		{Start: 0, Title: "I. Poco sostenuto; Vivace"},
+	}
+	expected := ";FFMETADATA1\n" +
+		"[CHAPTER]\nTIMEBASE=1/1000\nSTART=3000\nEND=43000\ntitle=I. Poco sostenuto\\; Vivace\n" +
+		"[CHAPTER]\nTIMEBASE=1/1000\nSTART=43000\nEND=63000\ntitle=II. Allegretto\n"
+	if diff := cmp.Diff(expected, ffmetadata(chapters, 3*time.Second, 63*time.Second)); diff != "" {
+		t.Error("Metadata different than expected (-want +got):", diff)
+	}
+}
+
+func TestChapters_Validate(t *testing.T) {
+	invalid := []Chapters{
+		{List: []Chapter{{Start: time.Minute, Title: "Too late"}}},
+		{List: []Chapter{{Start: -time.Second, Title: "Too early"}}},
+		{Scenes: &SceneOptions{ThumbnailDir: "/tmp"}},
+		{Scenes: &SceneOptions{Threshold: 2}},
+	}
+	for _, c := range invalid {
+		if err := c.Validate(time.Minute); err == nil {
+			t.Errorf("Expected error validating %+v", c)
+		}
+	}
+
+	valid := Chapters{List: []Chapter{{Start: 0, Title: "Start"}}, Scenes: &SceneOptions{}}
+	if err := valid.Validate(time.Minute); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClipArgs_Chapters(t *testing.T) {
+	chapters := &Chapters{file: "chapters.txt"}
+
+	expected := []string{
+		"-noaccurate_seek", "-ss", "00:01:00", "-i", "in.mp4", "-i", "chapters.txt", "-map_chapters", "1",
+		"-t", "00:00:30", "-avoid_negative_ts", "make_zero", "-c", "copy",
+	}
+	actual := (&ffmpegExtractor{}).clipArgs("in.mp4", "", time.Minute, 90*time.Second, ClipOptions{Chapters: chapters}, nil, ContainerMP4, 0)
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Error("Copy arguments different than expected (-want +got):", diff)
+	}
+
+	opts := ClipOptions{Overlay: &Overlay{Path: "logo.png"}, Chapters: chapters}
+	expected = []string{
+		"-ss", "00:01:00", "-t", "00:00:30", "-i", "in.mp4", "-i", "logo.png", "-i", "chapters.txt", "-map_chapters", "2",
+		"-filter_complex", "[1:v]format=rgba,colorchannelmixer=aa=1[img];[0:v][img]overlay=x=W-w-0:y=H-h-0[v]",
+		"-map", "[v]",
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "20", "-pix_fmt", "yuv420p",
+		"-map", "0:a?", "-c:a", "copy",
+	}
+	actual = (&ffmpegExtractor{}).clipArgs("in.mp4", "", time.Minute, 90*time.Second, opts, nil, ContainerMP4, 0)
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Error("Encode arguments different than expected (-want +got):", diff)
+	}
+}
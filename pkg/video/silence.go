@@ -0,0 +1,169 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/ssmall/nocco-video-extractor/pkg/tracing"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
+)
+
+const (
+	// DefaultSilenceThreshold is the level in dB below which audio is considered silent by default
+	DefaultSilenceThreshold = -40
+	// DefaultSilenceGap is the shortest silence reported by default
+	DefaultSilenceGap = 2 * time.Second
+)
+
+// SilenceOptions customise how silences are detected
+type SilenceOptions struct {
+	// ThresholdDB is the level below which audio is considered silent, by default DefaultSilenceThreshold
+	ThresholdDB float64
+	// MinGap is the shortest silence reported, by default DefaultSilenceGap
+	MinGap time.Duration
+}
+
+func (o SilenceOptions) threshold() float64 {
+	if o.ThresholdDB == 0 {
+		return DefaultSilenceThreshold
+	}
+	return o.ThresholdDB
+}
+
+func (o SilenceOptions) minGap() time.Duration {
+	if o.MinGap == 0 {
+		return DefaultSilenceGap
+	}
+	return o.MinGap
+}
+
+// Validate returns an error if the options are invalid
+func (o SilenceOptions) Validate() error {
+	if o.ThresholdDB > 0 || o.ThresholdDB < -100 {
+		return errors.New("silence threshold must be between -100 and 0 dB")
+	}
+	if o.MinGap < 0 {
+		return errors.New("minimum silence must not be negative")
+	}
+	return nil
+}
+
+// Interval is a span of time within a file
+type Interval struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// SilenceReport describes the silences found in a file
+type SilenceReport struct {
+	// Duration is the duration of the file
+	Duration time.Duration
+	// Silences are the silent intervals of the file, in order
+	Silences []Interval
+}
+
+// Pieces splits the file in the middle of its silences into intervals at least minLength long,
+// ignoring silences that would make shorter pieces
+func (r *SilenceReport) Pieces(minLength time.Duration) []Interval {
+	var pieces []Interval
+	var start time.Duration
+	for _, s := range r.Silences {
+		split := (s.Start + s.End) / 2
+		if split-start < minLength || r.Duration-split < minLength {
+			continue
+		}
+		pieces = append(pieces, Interval{Start: start, End: split})
+		start = split
+	}
+	if start < r.Duration {
+		pieces = append(pieces, Interval{Start: start, End: r.Duration})
+	}
+	return pieces
+}
+
+func (f *ffmpegExtractor) DetectSilence(ctx context.Context, filename string, opts SilenceOptions) (report *SilenceReport, err error) {
+	ctx, span := tracer.Start(ctx, "ffmpeg.DetectSilence", trace.WithAttributes(
+		label.String("ffmpeg.input", filename),
+		label.String("ffmpeg.mode", modeAnalyse),
+	))
+	defer func() {
+		tracing.End(ctx, span, err)
+	}()
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	info, err := probe(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	if !info.HasAudio {
+		return nil, errors.New("the source has no audio stream")
+	}
+
+	filter := fmt.Sprintf("silencedetect=noise=%gdB:d=%s", opts.threshold(), seconds(opts.minGap()))
+	stderr, err := f.runStderr(ctx, span, modeAnalyse, "-nostats", "-i", filename, "-vn", "-af", filter, "-f", "null", os.DevNull)
+	if err != nil {
+		return nil, err
+	}
+	return &SilenceReport{Duration: info.Duration, Silences: parseSilences(stderr, info.Duration)}, nil
+}
+
+var silenceLine = regexp.MustCompile(`silence_(start|end): (-?[0-9.]+)`)
+
+// parseSilences parses the silences logged by silencedetect. A silence still running at the end of a file
+// of the given duration ends with it.
+func parseSilences(stderr []byte, d time.Duration) []Interval {
+	var silences []Interval
+	var current *Interval
+	scanner := bufio.NewScanner(bytes.NewReader(stderr))
+	for scanner.Scan() {
+		m := silenceLine.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		s, err := strconv.ParseFloat(m[2], 64)
+		if err != nil {
+			continue
+		}
+		t := time.Duration(s * float64(time.Second))
+		if t < 0 {
+			t = 0
+		}
+		switch {
+		case m[1] == "start":
+			current = &Interval{Start: t}
+		case current != nil:
+			current.End = t
+			silences = append(silences, *current)
+			current = nil
+		}
+	}
+	if current != nil {
+		current.End = d
+		silences = append(silences, *current)
+	}
+	return silences
+}
@@ -0,0 +1,80 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseSilences(t *testing.T) {
+	stderr := `Input #0, mov,mp4,m4a,3gp,3g2,mj2, from 'concert.mp4':
+  Duration: 00:10:00.00, start: 0.000000, bitrate: 2000 kb/s
+[silencedetect @ 0x55d0c0] silence_start: -0.0213
+[silencedetect @ 0x55d0c0] silence_end: 3.5 | silence_duration: 3.52
+[silencedetect @ 0x55d0c0] silence_start: 312.25
+[silencedetect @ 0x55d0c0] silence_end: 318.75 | silence_duration: 6.5
+size=N/A time=00:10:00.00 bitrate=N/A speed= 120x
+[silencedetect @ 0x55d0c0] silence_start: 597
+`
+	expected := []Interval{
+		{Start: 0, End: 3500 * time.Millisecond},
+		{Start: 312250 * time.Millisecond, End: 318750 * time.Millisecond},
+		{Start: 597 * time.Second, End: 10 * time.Minute},
+	}
+	if diff := cmp.Diff(expected, parseSilences([]byte(stderr), 10*time.Minute)); diff != "" {
+		t.Error("Silences different than expected (-want +got):", diff)
+	}
+}
+
+func TestSilenceReport_Pieces(t *testing.T) {
+	report := SilenceReport{
+		Duration: 30 * time.Minute,
+		Silences: []Interval{
+			{Start: 0, End: 10 * time.Second},
+			{Start: 8 * time.Minute, End: 8*time.Minute + 10*time.Second},
+			{Start: 9 * time.Minute, End: 9*time.Minute + 4*time.Second},
+			{Start: 20 * time.Minute, End: 20*time.Minute + 20*time.Second},
+			{Start: 29 * time.Minute, End: 29*time.Minute + 30*time.Second},
+		},
+	}
+	expected := []Interval{
+		{Start: 0, End: 8*time.Minute + 5*time.Second},
+		{Start: 8*time.Minute + 5*time.Second, End: 20*time.Minute + 10*time.Second},
+		{Start: 20*time.Minute + 10*time.Second, End: 30 * time.Minute},
+	}
+	if diff := cmp.Diff(expected, report.Pieces(5*time.Minute)); diff != "" {
+		t.Error("Pieces different than expected (-want +got):", diff)
+	}
+}
+
+func TestSilenceOptions_Validate(t *testing.T) {
+	invalid := []SilenceOptions{
+		{ThresholdDB: 3},
+		{ThresholdDB: -120},
+		{MinGap: -time.Second},
+	}
+	for _, o := range invalid {
+		if err := o.Validate(); err == nil {
+			t.Errorf("Expected error validating %+v", o)
+		}
+	}
+
+	if err := (SilenceOptions{ThresholdDB: -50, MinGap: 3 * time.Second}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ssmall/nocco-video-extractor/pkg/logging"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
+)
+
+// ErrTargetTooSmall is returned when a clip cannot be encoded within its maximum size at a usable quality
+var ErrTargetTooSmall = errors.New("maximum size is too small for the clip")
+
+const (
+	// minVideoBitrate is the lowest video bitrate, in bits per second, at which a clip is still worth watching
+	minVideoBitrate = 150000
+	// defaultAudioBitrate is the bitrate, in bits per second, of re-encoded audio when no preset sets one
+	defaultAudioBitrate = 192000
+	// containerOverhead is the fraction of a file taken up by the container rather than the streams
+	containerOverhead = 0.02
+	// maxSizeAttempts is how many times a clip is encoded, at decreasing bitrates, to fit within its maximum size
+	maxSizeAttempts = 3
+)
+
+// VideoBitrate returns the video bitrate, in bits per second, at which a clip of the given duration is encoded
+// to fit within o.MaxSize. Returns ErrTargetTooSmall if that bitrate would be unusably low.
+func (o ClipOptions) VideoBitrate(clip time.Duration) (int64, error) {
+	d := o.outputDuration(clip).Seconds()
+	if d <= 0 {
+		return 0, fmt.Errorf("clip duration must be positive")
+	}
+
+	audio := int64(defaultAudioBitrate)
+	if o.Preset != nil && o.Preset.AudioBitrate != "" {
+		var err error
+		if audio, err = parseBitrate(o.Preset.AudioBitrate); err != nil {
+			return 0, err
+		}
+	}
+
+	bitrate := int64(float64(o.MaxSize)*8*(1-containerOverhead)/d) - audio
+	if bitrate < minVideoBitrate {
+		return 0, fmt.Errorf("%w: %d bytes over %s leaves %d bits per second for video, need at least %d", ErrTargetTooSmall, o.MaxSize, o.outputDuration(clip), bitrate, minVideoBitrate)
+	}
+
+	if o.Preset != nil && o.Preset.VideoBitrate != "" {
+		limit, err := parseBitrate(o.Preset.VideoBitrate)
+		if err != nil {
+			return 0, err
+		}
+		if limit < bitrate {
+			bitrate = limit
+		}
+	}
+	return bitrate, nil
+}
+
+// encodeToSize encodes the clip between start and end of filename to output, in container c, in two passes, so that it fits
+// within opts.MaxSize. If the first attempt is too large, the clip is encoded again at a proportionally
+// lower bitrate, up to maxSizeAttempts times.
+func (f *ffmpegExtractor) encodeToSize(ctx context.Context, span trace.Span, filename string, start, end time.Duration, opts ClipOptions, info *mediaInfo, c Container, output string) error {
+	logger := logging.FromContext(ctx)
+
+	bitrate, err := opts.VideoBitrate(end - start)
+	if err != nil {
+		return err
+	}
+
+	passlog := strings.TrimSuffix(output, filepath.Ext(output)) + "-passlog"
+	defer func() {
+		logs, _ := filepath.Glob(passlog + "*")
+		for _, l := range logs {
+			os.Remove(l)
+		}
+	}()
+
+	for attempt := 1; ; attempt++ {
+		span.SetAttributes(label.Int64("ffmpeg.video_bitrate", bitrate), label.Int("ffmpeg.attempt", attempt))
+		logger.Infof("Encoding clip at %d bits per second to fit within %d bytes (attempt %d)", bitrate, opts.MaxSize, attempt)
+
+		args := f.clipArgs(filename, "", start, end, opts, info, c, bitrate)
+		pass1 := append(append(args[:len(args):len(args)], passArgs(opts.Preset, 1, passlog)...), "-an", "-f", "null", os.DevNull)
+		pass2 := append(append(append(args[:len(args):len(args)], passArgs(opts.Preset, 2, passlog)...), c.fileMuxerArgs()...), "-y", output)
+		if err := f.run(ctx, span, modeTwoPass, pass1...); err != nil {
+			return err
+		}
+		if err := f.run(ctx, span, modeTwoPass, pass2...); err != nil {
+			return err
+		}
+
+		fi, err := os.Stat(output)
+		if err != nil {
+			return err
+		}
+		if fi.Size() <= opts.MaxSize {
+			return nil
+		}
+		if attempt == maxSizeAttempts {
+			return fmt.Errorf("%w: clip is still %d bytes after %d attempts", ErrTargetTooSmall, fi.Size(), attempt)
+		}
+
+		// Aim a little lower than the overshoot alone suggests, so that the next attempt is likely to be the last
+		bitrate = int64(float64(bitrate) * float64(opts.MaxSize) / float64(fi.Size()) * 0.95)
+		if bitrate < minVideoBitrate {
+			return fmt.Errorf("%w: clip is %d bytes at the lowest usable bitrate", ErrTargetTooSmall, fi.Size())
+		}
+		logger.Warnf("Clip is %d bytes, more than the maximum of %d, retrying at a lower bitrate", fi.Size(), opts.MaxSize)
+	}
+}
+
+// passArgs returns the ffmpeg arguments for the given pass of a two-pass encode,
+// which shares its statistics through files named with the prefix passlog
+func passArgs(p *Preset, pass int, passlog string) []string {
+	if p != nil && p.VideoCodec == CodecH265 {
+		return []string{"-x265-params", fmt.Sprintf("pass=%d:stats=%s.log", pass, passlog)}
+	}
+	return []string{"-pass", strconv.Itoa(pass), "-passlogfile", passlog}
+}
+
+// parseBitrate parses a bitrate such as "128k" or "2.5M" in bits per second
+func parseBitrate(s string) (int64, error) {
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(s, "k"):
+		multiplier = 1e3
+		s = strings.TrimSuffix(s, "k")
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1e6
+		s = strings.TrimSuffix(s, "M")
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid bitrate %q", s)
+	}
+	return int64(v * multiplier), nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestClipOptions_VideoBitrate(t *testing.T) {
+	cases := []struct {
+		name     string
+		opts     ClipOptions
+		clip     time.Duration
+		expected int64
+	}{
+		{
+			// 25MB over 100s is 2Mbps, less 2% overhead and 192kbps of audio
+			name:     "Default audio",
+			opts:     ClipOptions{MaxSize: 25000000},
+			clip:     100 * time.Second,
+			expected: 1768000,
+		},
+		{
+			name:     "Preset audio bitrate and title card",
+			opts:     ClipOptions{MaxSize: 25000000, Preset: &Preset{AudioBitrate: "128k"}, TitleCard: &TitleCard{Title: "t", Duration: 25 * time.Second}},
+			clip:     75 * time.Second,
+			expected: 1832000,
+		},
+		{
+			name:     "Limited by preset video bitrate",
+			opts:     ClipOptions{MaxSize: 25000000, Preset: &Preset{VideoBitrate: "1M"}},
+			clip:     100 * time.Second,
+			expected: 1000000,
+		},
+	}
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := test.opts.VideoBitrate(test.clip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.expected, actual); diff != "" {
+				t.Error("Bitrate different than expected (-want +got):", diff)
+			}
+		})
+	}
+}
+
+func TestClipOptions_VideoBitrate_TooSmall(t *testing.T) {
+	_, err := ClipOptions{MaxSize: 1000000}.VideoBitrate(10 * time.Minute)
+	if !errors.Is(err, ErrTargetTooSmall) {
+		t.Errorf("got error %v, want %v", err, ErrTargetTooSmall)
+	}
+}
+
+func TestParseBitrate(t *testing.T) {
+	cases := map[string]int64{
+		"128k": 128000,
+		"2.5M": 2500000,
+		"9600": 9600,
+	}
+	for input, expected := range cases {
+		actual, err := parseBitrate(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != expected {
+			t.Errorf("got parseBitrate(%q) = %d, want %d", input, actual, expected)
+		}
+	}
+}
+
+func TestPassArgs(t *testing.T) {
+	if diff := cmp.Diff([]string{"-pass", "2", "-passlogfile", "/tmp/out-passlog"}, passArgs(nil, 2, "/tmp/out-passlog")); diff != "" {
+		t.Error("Arguments different than expected (-want +got):", diff)
+	}
+	if diff := cmp.Diff([]string{"-x265-params", "pass=1:stats=/tmp/out-passlog.log"}, passArgs(&Preset{VideoCodec: CodecH265}, 1, "/tmp/out-passlog")); diff != "" {
+		t.Error("Arguments different than expected (-want +got):", diff)
+	}
+}
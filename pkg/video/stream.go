@@ -0,0 +1,242 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Container is a media container format that can be read and written sequentially
+type Container string
+
+// Streamable containers
+const (
+	// ContainerMP4 is MP4/QuickTime with its index ("moov" box) before the media data.
+	// It is written as fragmented MP4.
+	ContainerMP4 Container = "mp4"
+	// ContainerMatroska is Matroska (.mkv)
+	ContainerMatroska Container = "matroska"
+	// ContainerWebM is WebM, a subset of Matroska
+	ContainerWebM Container = "webm"
+	// ContainerMPEGTS is an MPEG transport stream
+	ContainerMPEGTS Container = "mpegts"
+)
+
+// ContainerMP3 is MP3 audio. Clips of MP3 files are written as MP3, but they are never streamed.
+const ContainerMP3 Container = "mp3"
+
+// SniffLen is the number of leading bytes of a file needed by DetectContainer
+const SniffLen = 64 * 1024
+
+var streamableExtensions = map[string]Container{
+	".mp4":  ContainerMP4,
+	".m4v":  ContainerMP4,
+	".m4a":  ContainerMP4,
+	".mov":  ContainerMP4,
+	".mkv":  ContainerMatroska,
+	".mka":  ContainerMatroska,
+	".webm": ContainerWebM,
+	".ts":   ContainerMPEGTS,
+	".mts":  ContainerMPEGTS,
+	".m2ts": ContainerMPEGTS,
+}
+
+// clipExtensions are the containers of files, other than streamable ones, whose clips keep their container
+var clipExtensions = map[string]Container{
+	".mp3": ContainerMP3,
+}
+
+// sourceContainer returns the container of the file with the given name, judged by its extension,
+// if its clips are written in the same container
+func sourceContainer(filename string) (Container, bool) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if c, ok := streamableExtensions[ext]; ok {
+		return c, true
+	}
+	c, ok := clipExtensions[ext]
+	return c, ok
+}
+
+// clipContainer returns the container in which a clip of a source in container c is written with opts.
+// Clips keep their source's container, except that MPEG-TS, which cannot hold tags, is written as MP4 if opts has tags.
+func clipContainer(c Container, opts ClipOptions) Container {
+	if c == "" || (c == ContainerMPEGTS && opts.Metadata != nil) {
+		return ContainerMP4
+	}
+	return c
+}
+
+// ClipExtension returns the extension of the clip of the file with the given name written with opts:
+// the file's own extension if the clip keeps its container, or .mp4 otherwise
+func ClipExtension(filename string, opts ClipOptions) string {
+	if c, ok := sourceContainer(filename); ok && clipContainer(c, opts) == c {
+		return filepath.Ext(filename)
+	}
+	return ".mp4"
+}
+
+// MayStream reports whether a file with the given name is in a container that
+// might be readable without seeking. DetectContainer gives a definite answer.
+func MayStream(filename string) bool {
+	_, ok := streamableExtensions[strings.ToLower(filepath.Ext(filename))]
+	return ok
+}
+
+// DetectContainer determines whether a file can be clipped without seeking,
+// given its name and up to SniffLen of its leading bytes.
+// Returns false if the container requires seeking or cannot be identified.
+func DetectContainer(filename string, header []byte) (Container, bool) {
+	c, ok := streamableExtensions[strings.ToLower(filepath.Ext(filename))]
+	if !ok {
+		return "", false
+	}
+
+	switch c {
+	case ContainerMP4:
+		return c, mp4IndexFirst(header)
+	case ContainerMatroska, ContainerWebM:
+		return c, bytes.HasPrefix(header, []byte{0x1a, 0x45, 0xdf, 0xa3})
+	case ContainerMPEGTS:
+		return c, len(header) > 188 && header[0] == 0x47 && header[188] == 0x47
+	}
+	return "", false
+}
+
+// mp4IndexFirst walks the top-level boxes of an MP4 file and reports whether
+// the "moov" box precedes the "mdat" box, which is required to read it sequentially
+func mp4IndexFirst(header []byte) bool {
+	offset := uint64(0)
+	for offset+8 <= uint64(len(header)) {
+		size := uint64(binary.BigEndian.Uint32(header[offset:]))
+		boxType := string(header[offset+4 : offset+8])
+		headerLen := uint64(8)
+
+		if size == 1 {
+			if offset+16 > uint64(len(header)) {
+				return false
+			}
+			size = binary.BigEndian.Uint64(header[offset+8:])
+			headerLen = 16
+		}
+
+		switch boxType {
+		case "moov":
+			return true
+		case "mdat":
+			return false
+		}
+
+		if size < headerLen || size > uint64(len(header))-offset {
+			// Either the box extends to the end of the file (size 0), or the next
+			// box starts beyond the header, so its type cannot be determined
+			return false
+		}
+		offset += size
+	}
+	return false
+}
+
+// demuxer returns the name of the ffmpeg demuxer for c
+func (c Container) demuxer() string {
+	switch c {
+	case ContainerMP4:
+		return "mov"
+	case ContainerWebM:
+		return "matroska"
+	}
+	return string(c)
+}
+
+// muxerArgs returns the ffmpeg output arguments needed to write c to a pipe
+func (c Container) muxerArgs() []string {
+	if c == ContainerMP4 {
+		return []string{"-movflags", "frag_keyframe+empty_moov+default_base_moof", "-f", "mp4"}
+	}
+	return []string{"-f", string(c)}
+}
+
+// fileMuxerArgs returns the ffmpeg output arguments needed to write c to a file. MP4 files are written with
+// the variant of the muxer that ffmpeg chooses by their extension, e.g. mov for .mov.
+func (c Container) fileMuxerArgs() []string {
+	switch c {
+	case ContainerMP4:
+		return nil
+	case ContainerMP3:
+		// ID3v2.3 is more widely read than ffmpeg's default of ID3v2.4
+		return []string{"-f", "mp3", "-id3v2_version", "3"}
+	}
+	return []string{"-f", string(c)}
+}
+
+// videoEncoderArgs returns the ffmpeg arguments that re-encode video for c, using the settings of p if it is not nil.
+// If target is greater than zero, video is encoded at that average bitrate in bits per second.
+func (c Container) videoEncoderArgs(p *Preset, target int64) []string {
+	bitrate := ""
+	if p != nil {
+		bitrate = p.VideoBitrate
+	}
+
+	if target > 0 {
+		args := []string{"-c:v", "libx264", "-preset", "medium", "-pix_fmt", "yuv420p"}
+		switch {
+		case c == ContainerWebM:
+			args = []string{"-c:v", "libvpx-vp9", "-deadline", "good", "-cpu-used", "4"}
+		case p != nil && p.VideoCodec == CodecH265:
+			args = []string{"-c:v", "libx265", "-preset", "medium", "-pix_fmt", "yuv420p", "-tag:v", "hvc1"}
+		}
+		return append(args, "-b:v", strconv.FormatInt(target, 10))
+	}
+
+	// In each case, quality determines the bitrate, which may not exceed the preset's
+	if c == ContainerWebM {
+		if bitrate == "" {
+			bitrate = "0"
+		}
+		return []string{"-c:v", "libvpx-vp9", "-crf", "31", "-b:v", bitrate, "-deadline", "realtime", "-cpu-used", "8"}
+	}
+
+	var args []string
+	if p != nil && p.VideoCodec == CodecH265 {
+		args = []string{"-c:v", "libx265", "-preset", "veryfast", "-crf", "24", "-pix_fmt", "yuv420p", "-tag:v", "hvc1"}
+	} else {
+		args = []string{"-c:v", "libx264", "-preset", "veryfast", "-crf", "20", "-pix_fmt", "yuv420p"}
+	}
+	if bitrate != "" {
+		args = append(args, "-maxrate", bitrate, "-bufsize", bitrate)
+	}
+	return args
+}
+
+// audioEncoderArgs returns the ffmpeg arguments that re-encode audio for c, using the settings of p if it is not nil
+func (c Container) audioEncoderArgs(p *Preset) []string {
+	bitrate := strconv.Itoa(defaultAudioBitrate)
+	if c == ContainerWebM {
+		bitrate = "160k"
+	}
+	if p != nil && p.AudioBitrate != "" {
+		bitrate = p.AudioBitrate
+	}
+	switch c {
+	case ContainerWebM:
+		return []string{"-c:a", "libopus", "-b:a", bitrate}
+	case ContainerMP3:
+		return []string{"-c:a", "libmp3lame", "-b:a", bitrate}
+	}
+	return []string{"-c:a", "aac", "-b:a", bitrate}
+}
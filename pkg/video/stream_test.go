@@ -0,0 +1,63 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// box encodes an MP4 box with the given type and payload length
+func box(boxType string, payloadLen int) []byte {
+	b := make([]byte, 8+payloadLen)
+	binary.BigEndian.PutUint32(b, uint32(len(b)))
+	copy(b[4:], boxType)
+	return b
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestDetectContainer(t *testing.T) {
+	ts := make([]byte, 376)
+	ts[0], ts[188] = 0x47, 0x47
+
+	cases := []struct {
+		name      string
+		filename  string
+		header    []byte
+		container Container
+		ok        bool
+	}{
+		{"MP4 index first", "a.mp4", concat(box("ftyp", 16), box("moov", 100), box("mdat", 10)), ContainerMP4, true},
+		{"MP4 data first", "a.MOV", concat(box("ftyp", 16), box("mdat", 10), box("moov", 100)), ContainerMP4, false},
+		{"MP4 box beyond header", "a.mp4", concat(box("ftyp", 16), box("free", 100)[:20]), ContainerMP4, false},
+		{"Matroska", "a.mkv", []byte{0x1a, 0x45, 0xdf, 0xa3, 0x01}, ContainerMatroska, true},
+		{"WebM", "a.webm", []byte{0x1a, 0x45, 0xdf, 0xa3, 0x01}, ContainerWebM, true},
+		{"Mislabelled Matroska", "a.mkv", []byte("not matroska"), ContainerMatroska, false},
+		{"MPEG-TS", "a.ts", ts, ContainerMPEGTS, true},
+		{"Unknown extension", "a.avi", []byte{0x1a, 0x45, 0xdf, 0xa3}, "", false},
+	}
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			c, ok := DetectContainer(test.filename, test.header)
+			if c != test.container || ok != test.ok {
+				t.Errorf("got DetectContainer() = (%q, %t), want (%q, %t)", c, ok, test.container, test.ok)
+			}
+		})
+	}
+}
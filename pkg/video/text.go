@@ -0,0 +1,244 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultFont is the font used for text when none is specified
+const DefaultFont = "sans"
+
+// fonts maps the names of the fonts that may be used for text to their files in the font directory.
+// The fonts are bundled with the service so that text renders identically wherever it runs.
+var fonts = map[string]string{
+	"sans":       "DejaVuSans.ttf",
+	"sans-bold":  "DejaVuSans-Bold.ttf",
+	"serif":      "DejaVuSerif.ttf",
+	"serif-bold": "DejaVuSerif-Bold.ttf",
+}
+
+// Fonts returns the names of the fonts that may be used for text
+func Fonts() []string {
+	var names []string
+	for name := range fonts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultTextDuration is how long text is shown if TextOverlay.Duration is zero
+const DefaultTextDuration = 5 * time.Second
+
+// DefaultTitleCardDuration is how long a title card is shown if TitleCard.Duration is zero
+const DefaultTitleCardDuration = 3 * time.Second
+
+// TextPosition is where text is placed in the frame
+type TextPosition string
+
+// Text positions
+const (
+	// TextLowerThird places text left-aligned in the lower third of the frame
+	TextLowerThird TextPosition = "lower-third"
+	// TextTop places text centred at the top of the frame
+	TextTop TextPosition = "top"
+	// TextCenter places text centred in the frame
+	TextCenter TextPosition = "center"
+)
+
+// TextOverlay is a title, and optionally a subtitle, drawn over the start of the video
+type TextOverlay struct {
+	Title    string
+	Subtitle string
+	// Position is where the text is placed, by default TextLowerThird
+	Position TextPosition
+	// Font is the name of the font, one of Fonts(). By default DefaultFont is used.
+	Font string
+	// Duration is how long the text is shown from the start of the clip, by default DefaultTextDuration
+	Duration time.Duration
+	// Fade is how long the text takes to fade in and out, or zero to show it without fading
+	Fade time.Duration
+}
+
+// Validate returns an error if t is not a valid text overlay
+func (t *TextOverlay) Validate() error {
+	if t.Title == "" {
+		return fmt.Errorf("text title is required")
+	}
+	switch t.Position {
+	case "", TextLowerThird, TextTop, TextCenter:
+	default:
+		return fmt.Errorf("invalid text position %q: must be one of %s, %s or %s", t.Position, TextLowerThird, TextTop, TextCenter)
+	}
+	if err := validateFont(t.Font); err != nil {
+		return err
+	}
+	return validateTiming("text", t.duration(), t.Fade)
+}
+
+func (t *TextOverlay) duration() time.Duration {
+	if t.Duration == 0 {
+		return DefaultTextDuration
+	}
+	return t.Duration
+}
+
+// filter returns a filter chain that draws the text
+func (t *TextOverlay) filter(fontDir string) string {
+	duration := t.duration()
+
+	var x, titleY, subtitleY string
+	switch t.Position {
+	case TextTop:
+		x, titleY, subtitleY = "(w-text_w)/2", "h*0.06", "h*0.06+h/14"
+	case TextCenter:
+		x, titleY, subtitleY = "(w-text_w)/2", "h/2-h/14", "h/2+h/56"
+	default:
+		x, titleY, subtitleY = "w*0.05", "h*0.72", "h*0.72+h/14"
+	}
+
+	timing := fmt.Sprintf(":enable='between(t,0,%s)'", seconds(duration))
+	if t.Fade > 0 {
+		timing += ":alpha='" + fadeExpr(duration, t.Fade) + "'"
+	}
+
+	style := ":fontcolor=white:box=1:boxcolor=black@0.5:boxborderw=12"
+	chain := drawtext(fontDir, t.Font, t.Title, "h/16", x, titleY) + style + timing
+	if t.Subtitle != "" {
+		chain += "," + drawtext(fontDir, t.Font, t.Subtitle, "h/26", x, subtitleY) + style + timing
+	}
+	return chain
+}
+
+// TitleCard is a card showing a title, and optionally a subtitle, prepended to the clip
+type TitleCard struct {
+	Title    string
+	Subtitle string
+	// Font is the name of the font, one of Fonts(). By default DefaultFont is used.
+	Font string
+	// Duration is how long the card is shown, by default DefaultTitleCardDuration
+	Duration time.Duration
+	// Fade is how long the card takes to fade in from and out to its background, or zero for no fade
+	Fade time.Duration
+	// Background is the colour of the card, as understood by ffmpeg, by default black
+	Background string
+}
+
+// Validate returns an error if c is not a valid title card
+func (c *TitleCard) Validate() error {
+	if c.Title == "" {
+		return fmt.Errorf("title card title is required")
+	}
+	if strings.ContainsAny(c.Background, `:'\[],;=@`) {
+		return fmt.Errorf("invalid title card background %q", c.Background)
+	}
+	if err := validateFont(c.Font); err != nil {
+		return err
+	}
+	return validateTiming("title card", c.duration(), c.Fade)
+}
+
+func (c *TitleCard) duration() time.Duration {
+	if c.Duration == 0 {
+		return DefaultTitleCardDuration
+	}
+	return c.Duration
+}
+
+// filter returns a filter graph that renders the card to match video described by info, labelled [card]
+// and, if the video has audio, silence labelled [cardaudio]
+func (c *TitleCard) filter(fontDir string, info *mediaInfo) string {
+	d := seconds(c.duration())
+	bg := c.Background
+	if bg == "" {
+		bg = "black"
+	}
+
+	card := fmt.Sprintf("color=c=%s:s=%dx%d:r=%s:d=%s,setsar=%s,format=yuv420p,", bg, info.Width, info.Height, info.FrameRate, d, strings.Replace(info.SAR, ":", "/", 1))
+	card += drawtext(fontDir, c.Font, c.Title, "h/12", "(w-text_w)/2", "h/2-text_h") + ":fontcolor=white"
+	if c.Subtitle != "" {
+		card += "," + drawtext(fontDir, c.Font, c.Subtitle, "h/22", "(w-text_w)/2", "h/2+h/24") + ":fontcolor=white"
+	}
+	if c.Fade > 0 {
+		f := seconds(c.Fade)
+		card += fmt.Sprintf(",fade=t=in:d=%s:c=%s,fade=t=out:st=%s:d=%s:c=%s", f, bg, seconds(c.duration()-c.Fade), f, bg)
+	}
+	card += "[card]"
+
+	if !info.HasAudio {
+		return card
+	}
+	return fmt.Sprintf("%s;anullsrc=r=%d:cl=%s,atrim=duration=%s[cardaudio]", card, info.SampleRate, info.ChannelLayout, d)
+}
+
+// drawtext returns a drawtext filter that draws text at (x, y) in the given font and size
+func drawtext(fontDir, font, text, size, x, y string) string {
+	if font == "" {
+		font = DefaultFont
+	}
+	fontfile := filepath.Join(fontDir, fonts[font])
+	return fmt.Sprintf("drawtext=fontfile=%s:expansion=none:text=%s:fontsize=%s:x=%s:y=%s", escapeFilterValue(fontfile), escapeFilterValue(text), size, x, y)
+}
+
+// fadeExpr returns an expression for an opacity that fades in from 0 and out to 0 over duration
+func fadeExpr(duration, fade time.Duration) string {
+	d, f := seconds(duration), seconds(fade)
+	return fmt.Sprintf("if(lt(t,%[2]s),t/%[2]s,if(lt(t,%[1]s-%[2]s),1,(%[1]s-t)/%[2]s))", d, f)
+}
+
+func validateFont(font string) error {
+	if _, ok := fonts[font]; font != "" && !ok {
+		return fmt.Errorf("unknown font %q: must be one of %s", font, strings.Join(Fonts(), ", "))
+	}
+	return nil
+}
+
+func validateTiming(what string, duration, fade time.Duration) error {
+	if duration < 0 || fade < 0 {
+		return fmt.Errorf("%s duration and fade must not be negative", what)
+	}
+	if 2*fade > duration {
+		return fmt.Errorf("%s fade of %s is too long for a duration of %s", what, fade, duration)
+	}
+	return nil
+}
+
+// seconds formats d as a number of seconds for use in ffmpeg expressions
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%g", d.Seconds())
+}
+
+// escapeFilterValue escapes s for use as an option value in a filter graph.
+// Values are parsed twice, first by the filter graph parser and then by the filter's option parser,
+// so special characters are escaped for the option parser and the result escaped again for the graph parser.
+func escapeFilterValue(s string) string {
+	return backslashEscape(backslashEscape(s, `\':`), `\'[],;`)
+}
+
+func backslashEscape(s, special string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(special, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
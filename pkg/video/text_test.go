@@ -0,0 +1,92 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package video
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEscapeFilterValue(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{"Beethoven 7 – II. Allegretto", "Beethoven 7 – II. Allegretto"},
+		{"NOCCO, March 2020", `NOCCO\, March 2020`},
+		{"Time: 10:30", `Time\\: 10\\:30`},
+		{"Bach's [Air]", `Bach\\\'s \[Air\]`},
+		{`C:\fonts`, `C\\:\\\\fonts`},
+	}
+	for _, test := range cases {
+		t.Run(test.input, func(t *testing.T) {
+			if diff := cmp.Diff(test.expected, escapeFilterValue(test.input)); diff != "" {
+				t.Error("Escaped value different than expected (-want +got):", diff)
+			}
+		})
+	}
+}
+
+func TestTextOverlay_Filter(t *testing.T) {
+	text := TextOverlay{
+		Title:    "Beethoven 7",
+		Subtitle: "NOCCO, March 2020",
+		Position: TextTop,
+		Font:     "serif",
+		Duration: 4 * time.Second,
+		Fade:     time.Second,
+	}
+	timing := ":fontcolor=white:box=1:boxcolor=black@0.5:boxborderw=12:enable='between(t,0,4)':alpha='if(lt(t,1),t/1,if(lt(t,4-1),1,(4-t)/1))'"
+	expected := "drawtext=fontfile=/fonts/DejaVuSerif.ttf:expansion=none:text=Beethoven 7:fontsize=h/16:x=(w-text_w)/2:y=h*0.06" + timing +
+		`,drawtext=fontfile=/fonts/DejaVuSerif.ttf:expansion=none:text=NOCCO\, March 2020:fontsize=h/26:x=(w-text_w)/2:y=h*0.06+h/14` + timing
+
+	if diff := cmp.Diff(expected, text.filter("/fonts")); diff != "" {
+		t.Error("Filter different than expected (-want +got):", diff)
+	}
+}
+
+func TestTextOverlay_Validate(t *testing.T) {
+	invalid := []TextOverlay{
+		{},
+		{Title: "t", Position: "bottom"},
+		{Title: "t", Font: "comic-sans"},
+		{Title: "t", Duration: 2 * time.Second, Fade: 1500 * time.Millisecond},
+		{Title: "t", Fade: -time.Second},
+	}
+	for _, text := range invalid {
+		if err := text.Validate(); err == nil {
+			t.Errorf("Expected error validating %+v", text)
+		}
+	}
+
+	if err := (&TextOverlay{Title: "t", Font: "sans-bold", Fade: time.Second}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTitleCard_FilterWithoutAudio(t *testing.T) {
+	card := TitleCard{Title: "NOCCO", Background: "navy", Fade: 500 * time.Millisecond}
+	info := &mediaInfo{HasVideo: true, Width: 640, Height: 480, FrameRate: "30/1", SAR: "4:3"}
+
+	expected := "color=c=navy:s=640x480:r=30/1:d=3,setsar=4/3,format=yuv420p," +
+		"drawtext=fontfile=/fonts/DejaVuSans.ttf:expansion=none:text=NOCCO:fontsize=h/12:x=(w-text_w)/2:y=h/2-text_h:fontcolor=white," +
+		"fade=t=in:d=0.5:c=navy,fade=t=out:st=2.5:d=0.5:c=navy[card]"
+
+	if diff := cmp.Diff(expected, card.filter("/fonts", info)); diff != "" {
+		t.Error("Filter different than expected (-want +got):", diff)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// ErrForbiddenDestination is returned when a notification would be sent to an address that is not publicly routable,
+// such as a loopback, private or link-local address, which could expose services only reachable from this server
+var ErrForbiddenDestination = errors.New("webhook destination is not a public address")
+
+// privateNetworks are the ranges, other than loopback, link-local, multicast and unspecified addresses,
+// that are not reachable over the internet
+var privateNetworks = mustParseCIDRs(
+	"0.0.0.0/8",      // "this" network
+	"10.0.0.0/8",     // RFC 1918
+	"100.64.0.0/10",  // carrier-grade NAT
+	"172.16.0.0/12",  // RFC 1918
+	"192.168.0.0/16", // RFC 1918
+	"fc00::/7",       // unique local addresses
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+	return nets
+}
+
+// CheckDestination returns an error wrapping ErrForbiddenDestination if ip is not publicly routable
+func CheckDestination(ip net.IP) error {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("%w: %s", ErrForbiddenDestination, ip)
+	}
+	for _, n := range privateNetworks {
+		if n.Contains(ip) {
+			return fmt.Errorf("%w: %s", ErrForbiddenDestination, ip)
+		}
+	}
+	return nil
+}
+
+// checkDialedAddress is a net.Dialer Control function that refuses connections to addresses rejected by CheckDestination.
+// It runs after the destination's host name has been resolved, for every connection including those of redirects,
+// so a callback URL cannot reach internal services through its DNS records.
+func checkDialedAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("%w: %s", ErrForbiddenDestination, host)
+	}
+	return CheckDestination(ip)
+}
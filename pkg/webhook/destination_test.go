@@ -0,0 +1,52 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestCheckDestination(t *testing.T) {
+	tests := []struct {
+		ip        string
+		forbidden bool
+	}{
+		{ip: "93.184.216.34"},
+		{ip: "2606:2800:220:1:248:1893:25c8:1946"},
+		{ip: "127.0.0.1", forbidden: true},
+		{ip: "::1", forbidden: true},
+		{ip: "10.1.2.3", forbidden: true},
+		{ip: "172.20.0.1", forbidden: true},
+		{ip: "192.168.1.1", forbidden: true},
+		{ip: "100.64.0.1", forbidden: true},
+		{ip: "169.254.169.254", forbidden: true},
+		{ip: "fe80::1", forbidden: true},
+		{ip: "fd00::1", forbidden: true},
+		{ip: "0.0.0.0", forbidden: true},
+		{ip: "224.0.0.1", forbidden: true},
+		{ip: "::ffff:127.0.0.1", forbidden: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.ip, func(t *testing.T) {
+			err := CheckDestination(net.ParseIP(test.ip))
+			if got := errors.Is(err, ErrForbiddenDestination); got != test.forbidden {
+				t.Errorf("CheckDestination(%s) = %v, want forbidden %t", test.ip, err, test.forbidden)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import "sync"
+
+// Store keeps the most recent deliveries in memory, identified by a key such as the ID of the job they report,
+// so that their attempts can be inspected after the fact. It is safe for concurrent use.
+// Once it holds its maximum number of deliveries, recording another one forgets the oldest.
+type Store struct {
+	max int
+
+	mu         sync.Mutex
+	keys       []string // in the order they were first recorded
+	deliveries map[string]Delivery
+}
+
+// NewStore creates an empty Store that holds at most max deliveries
+func NewStore(max int) *Store {
+	if max < 1 {
+		max = 1
+	}
+	return &Store{max: max, deliveries: make(map[string]Delivery)}
+}
+
+// Record stores d under key, replacing any delivery already recorded under it
+func (s *Store) Record(key string, d Delivery) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.deliveries[key]; !ok {
+		s.keys = append(s.keys, key)
+	}
+	s.deliveries[key] = d
+	for len(s.keys) > s.max {
+		delete(s.deliveries, s.keys[0])
+		s.keys = s.keys[1:]
+	}
+}
+
+// Get returns the delivery recorded under key, and whether there is one
+func (s *Store) Get(key string) (Delivery, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.deliveries[key]
+	return d, ok
+}
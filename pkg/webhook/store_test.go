@@ -0,0 +1,52 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStore(t *testing.T) {
+	s := NewStore(2)
+	s.Record("job1", Delivery{ID: "d1"})
+	s.Record("job2", Delivery{ID: "d2"})
+	s.Record("job1", Delivery{ID: "d1", Succeeded: true})
+	s.Record("job3", Delivery{ID: "d3"})
+
+	tests := []struct {
+		key    string
+		want   Delivery
+		wantOK bool
+	}{
+		{key: "job1", wantOK: false},
+		{key: "job2", want: Delivery{ID: "d2"}, wantOK: true},
+		{key: "job3", want: Delivery{ID: "d3"}, wantOK: true},
+		{key: "job4", wantOK: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.key, func(t *testing.T) {
+			got, ok := s.Get(test.key)
+			if ok != test.wantOK {
+				t.Errorf("Get(%q) ok = %t, want %t", test.key, ok, test.wantOK)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Get(%q) different than expected (-want +got): %s", test.key, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package webhook delivers signed notifications to client-provided callback URLs
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ssmall/nocco-video-extractor/pkg/logging"
+	"go.opentelemetry.io/otel/api/global"
+)
+
+// SignatureHeader is the header containing the HMAC-SHA256 signature of the TimestampHeader and the request body
+const SignatureHeader = "X-Nocco-Signature-256"
+
+// TimestampHeader is the header containing the time at which a delivery attempt was made, in Unix seconds.
+// It is covered by the signature, so that receivers can reject requests that were captured and replayed later.
+const TimestampHeader = "X-Nocco-Timestamp"
+
+// DeliveryHeader is the header containing the unique ID of a delivery.
+// It is the same across all attempts of a single delivery.
+const DeliveryHeader = "X-Nocco-Delivery"
+
+// DefaultTolerance is how far from the time it is received a request's TimestampHeader should be allowed to be.
+// Receivers should reject requests outside this window and, to also prevent replays within it,
+// ignore a DeliveryHeader they have already processed.
+const DefaultTolerance = 5 * time.Minute
+
+const defaultMaxAttempts = 5
+const defaultInitialBackoff = 1 * time.Second
+const defaultAttemptTimeout = 30 * time.Second
+
+// Sender delivers webhook notifications
+type Sender interface {
+	// Send POSTs the given JSON payload to url, retrying with exponential backoff on failure.
+	// The returned Delivery records every attempt that was made, even if an error is returned.
+	Send(ctx context.Context, url string, payload []byte) (*Delivery, error)
+}
+
+// Delivery records the attempts made to deliver a single notification
+type Delivery struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Attempts  []Attempt `json:"attempts"`
+	Succeeded bool      `json:"succeeded"`
+}
+
+// Attempt records the outcome of a single delivery attempt
+type Attempt struct {
+	Time       time.Time     `json:"time"`
+	Duration   time.Duration `json:"duration"`
+	StatusCode int           `json:"statusCode,omitempty"`
+	Error      string        `json:"error,omitempty"`
+}
+
+type hmacSender struct {
+	secret         []byte
+	client         *http.Client
+	maxAttempts    int
+	initialBackoff time.Duration
+}
+
+// NewSender creates a new Sender that signs each payload with the given secret.
+// It refuses to connect to addresses that are not publicly routable, failing such deliveries with ErrForbiddenDestination.
+func NewSender(secret []byte) Sender {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would be dialed in place of the destination, bypassing the check of its address
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   checkDialedAddress,
+	}).DialContext
+
+	return &hmacSender{
+		secret:         secret,
+		client:         &http.Client{Timeout: defaultAttemptTimeout, Transport: transport},
+		maxAttempts:    defaultMaxAttempts,
+		initialBackoff: defaultInitialBackoff,
+	}
+}
+
+// Sign computes the value of the SignatureHeader for the given TimestampHeader value and payload.
+// The signed message is the timestamp, a dot and the payload.
+func Sign(secret []byte, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is a valid SignatureHeader value for the given TimestampHeader value and payload,
+// and whether the timestamp is within tolerance of the current time. Receivers should use DefaultTolerance.
+func Verify(secret, payload []byte, timestamp, signature string, tolerance time.Duration) bool {
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if d := time.Since(time.Unix(sec, 0)); d > tolerance || d < -tolerance {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, timestamp, payload)), []byte(signature))
+}
+
+func (s *hmacSender) Send(ctx context.Context, url string, payload []byte) (*Delivery, error) {
+	id, err := newDeliveryID()
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Delivery{ID: id, URL: url}
+	backoff := s.initialBackoff
+
+	for i := 1; i <= s.maxAttempts; i++ {
+		a, retry, err := s.attempt(ctx, d.ID, url, payload)
+		d.Attempts = append(d.Attempts, a)
+		logging.FromContext(ctx).WithFields(logging.Fields{
+			"deliveryId": d.ID,
+			"attempt":    i,
+			"statusCode": a.StatusCode,
+			"error":      a.Error,
+		}).Infof("Webhook delivery %s attempt %d/%d to %s", d.ID, i, s.maxAttempts, url)
+
+		if a.Error == "" {
+			d.Succeeded = true
+			return d, nil
+		}
+		if errors.Is(err, ErrForbiddenDestination) {
+			return d, fmt.Errorf("webhook delivery %s to %s failed: %w", d.ID, url, err)
+		}
+
+		if !retry || i == s.maxAttempts {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return d, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+
+	return d, fmt.Errorf("webhook delivery %s to %s failed after %d attempt(s): %s", d.ID, url, len(d.Attempts), d.Attempts[len(d.Attempts)-1].Error)
+}
+
+// attempt makes a single delivery attempt and reports whether a failure is worth retrying.
+// err is the error of the request, if it could not be made; a.Error also describes unsuccessful responses.
+func (s *hmacSender) attempt(ctx context.Context, id, url string, payload []byte) (a Attempt, retry bool, err error) {
+	a.Time = time.Now()
+	defer func() {
+		a.Duration = time.Since(a.Time)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		a.Error = err.Error()
+		return a, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	timestamp := strconv.FormatInt(a.Time.Unix(), 10)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(s.secret, timestamp, payload))
+	req.Header.Set(DeliveryHeader, id)
+	global.TextMapPropagator().Inject(ctx, req.Header)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		a.Error = err.Error()
+		return a, !errors.Is(err, ErrForbiddenDestination), err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	a.StatusCode = resp.StatusCode
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return a, false, nil
+	}
+
+	a.Error = resp.Status
+	return a, resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout, nil
+}
+
+func newDeliveryID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,194 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func testSender(secret string) *hmacSender {
+	return &hmacSender{
+		secret:         []byte(secret),
+		client:         http.DefaultClient,
+		maxAttempts:    3,
+		initialBackoff: time.Millisecond,
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	secret := []byte("secret")
+	payload := []byte(`{"jobId":"123"}`)
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	signature := Sign(secret, timestamp, payload)
+
+	if !Verify(secret, payload, timestamp, signature, DefaultTolerance) {
+		t.Errorf("Verify(%q) = false, want true", signature)
+	}
+
+	if Verify([]byte("wrong secret"), payload, timestamp, signature, DefaultTolerance) {
+		t.Error("Verify with wrong secret = true, want false")
+	}
+
+	if Verify(secret, []byte(`{"jobId":"456"}`), timestamp, signature, DefaultTolerance) {
+		t.Error("Verify with modified payload = true, want false")
+	}
+
+	if Verify(secret, payload, strconv.FormatInt(time.Now().Unix()+1, 10), signature, DefaultTolerance) {
+		t.Error("Verify with modified timestamp = true, want false")
+	}
+
+	old := strconv.FormatInt(time.Now().Add(-2*DefaultTolerance).Unix(), 10)
+	if Verify(secret, payload, old, Sign(secret, old, payload), DefaultTolerance) {
+		t.Error("Verify with expired timestamp = true, want false")
+	}
+}
+
+func TestSend_HappyPath(t *testing.T) {
+	payload := []byte(`{"jobId":"123"}`)
+	var gotBody []byte
+	var gotSignature, gotTimestamp, gotDelivery string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = ioutil.ReadAll(r.Body)
+		gotSignature = r.Header.Get(SignatureHeader)
+		gotTimestamp = r.Header.Get(TimestampHeader)
+		gotDelivery = r.Header.Get(DeliveryHeader)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	d, err := testSender("secret").Send(context.Background(), srv.URL, payload)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(payload, gotBody); diff != "" {
+		t.Error("Body different than expected (-want +got):", diff)
+	}
+
+	if !Verify([]byte("secret"), payload, gotTimestamp, gotSignature, DefaultTolerance) {
+		t.Errorf("Invalid signature %q of timestamp %q", gotSignature, gotTimestamp)
+	}
+
+	if gotDelivery != d.ID {
+		t.Errorf("got delivery header %q, want %q", gotDelivery, d.ID)
+	}
+
+	if !d.Succeeded || len(d.Attempts) != 1 || d.Attempts[0].StatusCode != http.StatusNoContent {
+		t.Errorf("Unexpected delivery record: %+v", d)
+	}
+}
+
+func TestSend_RetriesServerErrors(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	d, err := testSender("secret").Send(context.Background(), srv.URL, []byte("{}"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var codes []int
+	for _, a := range d.Attempts {
+		codes = append(codes, a.StatusCode)
+	}
+
+	if diff := cmp.Diff([]int{503, 503, 200}, codes); diff != "" {
+		t.Error("Attempts different than expected (-want +got):", diff)
+	}
+}
+
+func TestSend_Error(t *testing.T) {
+	cases := []struct {
+		name             string
+		status           int
+		expectedAttempts int
+	}{
+		{
+			name:             "Client error is not retried",
+			status:           http.StatusBadRequest,
+			expectedAttempts: 1,
+		},
+		{
+			name:             "Server error is retried until attempts are exhausted",
+			status:           http.StatusInternalServerError,
+			expectedAttempts: 3,
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+			}))
+			defer srv.Close()
+
+			d, err := testSender("secret").Send(context.Background(), srv.URL, []byte("{}"))
+
+			if err == nil {
+				t.Error("Expected error")
+			}
+
+			if d.Succeeded {
+				t.Error("Delivery marked as succeeded")
+			}
+
+			if diff := cmp.Diff(test.expectedAttempts, len(d.Attempts)); diff != "" {
+				t.Error("Different number of attempts than expected (-want +got):", diff)
+			}
+		})
+	}
+}
+
+func TestSend_ForbiddenDestination(t *testing.T) {
+	var hit bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit = true
+	}))
+	defer srv.Close()
+
+	d, err := NewSender([]byte("secret")).Send(context.Background(), srv.URL, []byte(`{}`))
+
+	if !errors.Is(err, ErrForbiddenDestination) {
+		t.Errorf("got error %v, want %v", err, ErrForbiddenDestination)
+	}
+	if hit {
+		t.Error("Notification was delivered to a loopback address")
+	}
+	if d == nil || len(d.Attempts) != 1 {
+		t.Errorf("got delivery %+v, want a single attempt", d)
+	}
+}
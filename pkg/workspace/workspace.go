@@ -0,0 +1,275 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package workspace manages the local disk used for temporary files while processing jobs
+package workspace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/ssmall/nocco-video-extractor/pkg/logging"
+)
+
+// jobDirPrefix is the prefix of the per-job directories created in the workspace root
+const jobDirPrefix = "job-"
+
+// ErrInsufficientSpace is returned when a job's reservation does not currently fit in the workspace
+var ErrInsufficientSpace = errors.New("insufficient disk space for job, try again later")
+
+// ErrTooLarge is returned when a job's reservation could never fit in the workspace's budget
+var ErrTooLarge = errors.New("job requires more disk space than the configured budget")
+
+var (
+	reservedBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "nocco",
+		Name:      "workspace_reserved_bytes",
+		Help:      "Disk space currently reserved by jobs in the workspace.",
+	})
+	usageBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "nocco",
+		Name:      "temp_disk_usage_bytes",
+		Help:      "Bytes currently used by files in the workspace.",
+	})
+	sweptFiles = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "nocco",
+		Name:      "workspace_swept_total",
+		Help:      "Number of stale files and directories removed from the workspace.",
+	})
+)
+
+// Workspace is a directory in which jobs create their temporary files,
+// with an optional budget limiting the disk space that jobs may reserve
+type Workspace struct {
+	root   string
+	budget int64
+
+	mu       sync.Mutex
+	reserved int64
+	active   map[string]bool
+}
+
+// New creates a Workspace rooted at dir, creating dir if necessary.
+// If budget is greater than zero, the disk space reserved by concurrent jobs may not exceed it.
+// dir should be dedicated to the workspace, since Sweep removes anything stale in it.
+func New(dir string, budget int64) (*Workspace, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Workspace{root: dir, budget: budget, active: make(map[string]bool)}, nil
+}
+
+// Default returns an unbudgeted Workspace rooted at the system temp directory
+func Default() *Workspace {
+	return &Workspace{root: os.TempDir(), active: make(map[string]bool)}
+}
+
+// Root returns the directory in which job directories are created
+func (w *Workspace) Root() string {
+	return w.root
+}
+
+// Job is a per-job directory holding a reservation of disk space
+type Job struct {
+	// Dir is the directory in which the job should create its temporary files
+	Dir string
+
+	w    *Workspace
+	size int64
+	once sync.Once
+}
+
+// NewJob reserves size bytes of disk space and creates a directory for a job.
+// Returns ErrTooLarge if size exceeds the budget, or ErrInsufficientSpace if the
+// space is not currently available. The returned Job must be closed when the job completes.
+func (w *Workspace) NewJob(size int64) (*Job, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.budget > 0 {
+		if size > w.budget {
+			return nil, fmt.Errorf("%w: need %d bytes, budget is %d", ErrTooLarge, size, w.budget)
+		}
+		if w.reserved+size > w.budget {
+			return nil, fmt.Errorf("%w: need %d bytes, %d of %d reserved", ErrInsufficientSpace, size, w.reserved, w.budget)
+		}
+	}
+
+	free, pending, err := w.availableLocked()
+	if err != nil {
+		return nil, err
+	}
+	if uint64(size)+pending > free {
+		return nil, fmt.Errorf("%w: need %d bytes, %d free on disk of which %d reserved", ErrInsufficientSpace, size, free, pending)
+	}
+
+	dir, err := ioutil.TempDir(w.root, jobDirPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+
+	w.reserved += size
+	w.active[dir] = true
+	reservedBytes.Set(float64(w.reserved))
+
+	return &Job{Dir: dir, w: w, size: size}, nil
+}
+
+// Reserve grows the job's reservation to size bytes, for a job that turns out to need more disk
+// space than it reserved initially. Returns the same errors as NewJob; on error the existing
+// reservation is kept.
+func (j *Job) Reserve(size int64) error {
+	w := j.w
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	extra := size - j.size
+	if extra <= 0 {
+		return nil
+	}
+
+	if w.budget > 0 {
+		if size > w.budget {
+			return fmt.Errorf("%w: need %d bytes, budget is %d", ErrTooLarge, size, w.budget)
+		}
+		if w.reserved+extra > w.budget {
+			return fmt.Errorf("%w: need %d more bytes, %d of %d reserved", ErrInsufficientSpace, extra, w.reserved, w.budget)
+		}
+	}
+
+	free, pending, err := w.availableLocked()
+	if err != nil {
+		return err
+	}
+	if uint64(extra)+pending > free {
+		return fmt.Errorf("%w: need %d more bytes, %d free on disk of which %d reserved", ErrInsufficientSpace, extra, free, pending)
+	}
+
+	j.size = size
+	w.reserved += extra
+	reservedBytes.Set(float64(w.reserved))
+	return nil
+}
+
+// Close removes the job's directory and everything in it, and releases its reservation
+func (j *Job) Close() error {
+	var err error
+	j.once.Do(func() {
+		err = os.RemoveAll(j.Dir)
+
+		j.w.mu.Lock()
+		j.w.reserved -= j.size
+		delete(j.w.active, j.Dir)
+		reservedBytes.Set(float64(j.w.reserved))
+		j.w.mu.Unlock()
+
+		usageBytes.Set(float64(diskUsage(j.w.root)))
+	})
+	return err
+}
+
+// freeSpace returns the free space on the filesystem containing a directory, replaced in tests
+var freeSpace = FreeSpace
+
+// availableLocked returns the free space on disk and the part of it that is reserved by active jobs
+// but not yet used by their files. w.mu must be held.
+func (w *Workspace) availableLocked() (free, pending uint64, err error) {
+	if free, err = freeSpace(w.root); err != nil {
+		return 0, 0, err
+	}
+	var used int64
+	for dir := range w.active {
+		used += diskUsage(dir)
+	}
+	if w.reserved > used {
+		pending = uint64(w.reserved - used)
+	}
+	return free, pending, nil
+}
+
+// Sweep removes files and directories in the workspace root that were last modified more than
+// maxAge ago and do not belong to an active job, such as those left behind by a crashed process.
+// Returns the number of entries removed.
+func (w *Workspace) Sweep(ctx context.Context, maxAge time.Duration) (int, error) {
+	entries, err := ioutil.ReadDir(w.root)
+	if err != nil {
+		return 0, err
+	}
+
+	logger := logging.FromContext(ctx)
+	cutoff := time.Now().Add(-maxAge)
+	removed := 0
+	var usage int64
+	for _, fi := range entries {
+		path := filepath.Join(w.root, fi.Name())
+
+		w.mu.Lock()
+		active := w.active[path]
+		w.mu.Unlock()
+
+		if active || fi.ModTime().After(cutoff) {
+			usage += diskUsage(path)
+			continue
+		}
+
+		if err := os.RemoveAll(path); err != nil {
+			logger.WithError(err).Warnf("Error sweeping %s", path)
+			continue
+		}
+		logger.Infof("Swept stale workspace entry %s (last modified %s)", path, fi.ModTime().Format(time.RFC3339))
+		sweptFiles.Inc()
+		removed++
+	}
+	usageBytes.Set(float64(usage))
+
+	return removed, nil
+}
+
+// StartSweeper sweeps the workspace every interval until ctx is done, removing entries older than maxAge
+func (w *Workspace) StartSweeper(ctx context.Context, interval, maxAge time.Duration) {
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				if _, err := w.Sweep(ctx, maxAge); err != nil {
+					logging.FromContext(ctx).WithError(err).Errorf("Error sweeping workspace %s", w.root)
+				}
+			}
+		}
+	}()
+}
+
+// diskUsage returns the total size of the regular files at or beneath path
+func diskUsage(path string) int64 {
+	var total int64
+	filepath.Walk(path, func(_ string, fi os.FileInfo, err error) error {
+		if err == nil && fi.Mode().IsRegular() {
+			total += fi.Size()
+		}
+		return nil
+	})
+	return total
+}
@@ -0,0 +1,198 @@
+// Copyright 2020 Spencer Small
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNewJob_Budget(t *testing.T) {
+	w, err := New(t.TempDir(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.NewJob(101); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("got error %v, want %v", err, ErrTooLarge)
+	}
+
+	first, err := w.NewJob(60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.NewJob(60); !errors.Is(err, ErrInsufficientSpace) {
+		t.Errorf("got error %v, want %v", err, ErrInsufficientSpace)
+	}
+
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := w.NewJob(60)
+	if err != nil {
+		t.Fatalf("Expected reservation to be released when job closed, got %v", err)
+	}
+	defer second.Close()
+}
+
+func TestNewJob_FreeSpace(t *testing.T) {
+	free := uint64(100)
+	defer func(f func(string) (uint64, error)) { freeSpace = f }(freeSpace)
+	freeSpace = func(string) (uint64, error) { return free, nil }
+
+	w, err := New(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := w.NewJob(60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	if _, err := w.NewJob(60); !errors.Is(err, ErrInsufficientSpace) {
+		t.Errorf("got error %v, want %v", err, ErrInsufficientSpace)
+	}
+	if err := first.Reserve(110); !errors.Is(err, ErrInsufficientSpace) {
+		t.Errorf("got error %v, want %v", err, ErrInsufficientSpace)
+	}
+
+	// Space the first job has already written to no longer counts as pending
+	if err := ioutil.WriteFile(filepath.Join(first.Dir, "file"), make([]byte, 50), 0644); err != nil {
+		t.Fatal(err)
+	}
+	free = 50
+
+	second, err := w.NewJob(40)
+	if err != nil {
+		t.Fatalf("Expected written reservation to be excluded, got %v", err)
+	}
+	second.Close()
+}
+
+func TestJob_Reserve(t *testing.T) {
+	w, err := New(t.TempDir(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := w.NewJob(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := w.NewJob(30)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := job.Reserve(101); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("got error %v, want %v", err, ErrTooLarge)
+	}
+	if err := job.Reserve(80); !errors.Is(err, ErrInsufficientSpace) {
+		t.Errorf("got error %v, want %v", err, ErrInsufficientSpace)
+	}
+	if err := job.Reserve(70); err != nil {
+		t.Fatal(err)
+	}
+
+	other.Close()
+	job.Close()
+
+	if w.reserved != 0 {
+		t.Errorf("got %d bytes reserved after jobs closed, want 0", w.reserved)
+	}
+}
+
+func TestJob_Close(t *testing.T) {
+	w, err := New(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := w.NewJob(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if filepath.Dir(j.Dir) != w.Root() {
+		t.Errorf("Job directory %s is not in workspace root %s", j.Dir, w.Root())
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(j.Dir, "file"), []byte("contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(j.Dir); !os.IsNotExist(err) {
+		t.Errorf("Expected job directory to be removed, got %v", err)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	w, err := New(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	active, err := w.NewJob(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer active.Close()
+
+	stale := filepath.Join(w.Root(), "download-stale.mp4")
+	fresh := filepath.Join(w.Root(), "download-fresh.mp4")
+	for _, f := range []string{stale, fresh} {
+		if err := ioutil.WriteFile(f, []byte("contents"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	for _, f := range []string{stale, active.Dir} {
+		if err := os.Chtimes(f, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := w.Sweep(context.Background(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(1, removed); diff != "" {
+		t.Error("Number of removed entries different than expected (-want +got):", diff)
+	}
+
+	for f, shouldExist := range map[string]bool{stale: false, fresh: true, active.Dir: true} {
+		_, err := os.Stat(f)
+		if exists := err == nil; exists != shouldExist {
+			t.Errorf("got exists(%s) = %t, want %t", f, exists, shouldExist)
+		}
+	}
+}